@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/driver"
+	"github.com/jeremydelacruz/go-bookings/internal/forms"
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/render"
+	"github.com/jeremydelacruz/go-bookings/internal/repository"
+	"github.com/jeremydelacruz/go-bookings/internal/repository/dbrepo"
+	"github.com/jeremydelacruz/go-bookings/internal/seed"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// command is a subcommand of the go-bookings binary
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, out io.Writer) error
+}
+
+// commands is filled in by init, because the help command refers back to it
+var commands []command
+
+// stdin is where create-admin reads a password that was not given as a flag
+var stdin io.Reader = os.Stdin
+
+func init() {
+	commands = []command{
+		{"serve", "start the web server (the default)", runServe},
+		{"migrate", "apply or roll back migrations: migrate up|down|status|redo", runMigrate},
+		{"seed", "add missing rooms and restriction types; -demo also books sample reservations", runSeed},
+		{"create-admin", "create a user with access to the admin back office", runCreateAdmin},
+		{"check", "validate the configuration, database connection and templates", runCheck},
+		{"help", "list the commands", runHelp},
+	}
+}
+
+// runCommand runs the command named by the first argument, or serve when there is none,
+// so that flags alone still start the server
+func runCommand(args []string, out io.Writer) error {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			err := c.run(args, out)
+			// -h has already printed the flags
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	runHelp(nil, out)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// runHelp lists the commands
+func runHelp(args []string, out io.Writer) error {
+	fmt.Fprintln(out, "usage: go-bookings [command] [flags]")
+	fmt.Fprintln(out)
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-13s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "every command accepts the configuration flags; run a command with -h to list them")
+	return nil
+}
+
+// openRepo connects to the configured Postgres database, which commands that change data require
+func openRepo(cfg *config.AppConfig) (repository.DatabaseRepo, *driver.DB, error) {
+	if !cfg.DB.Configured() {
+		return nil, nil, errors.New("no database configured (set -db-name, BOOKINGS_DB_NAME, or a database.yml)")
+	}
+
+	db, err := driver.ConnectSQL(cfg.DB.DSN())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed connecting to database: %w", err)
+	}
+
+	return dbrepo.NewPostgresRepo(db.SQL, cfg), db, nil
+}
+
+// runSeed runs the seed command
+func runSeed(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	demo := fs.Bool("demo", false, "also book sample reservations over the coming weeks")
+
+	var cfg config.AppConfig
+	if err := config.LoadFlagSet(&cfg, fs, args, os.Getenv); err != nil {
+		return err
+	}
+
+	repo, db, err := openRepo(&cfg)
+	if err != nil {
+		return fmt.Errorf("seed: %w", err)
+	}
+	defer db.SQL.Close()
+
+	ctx := context.Background()
+	restrictions, rooms, err := seed.ReferenceData(ctx, repo)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "added %d restriction types and %d rooms\n", restrictions, rooms)
+
+	if *demo {
+		booked, err := seed.Demo(ctx, repo, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "booked %d sample reservations\n", booked)
+	}
+
+	return nil
+}
+
+// runCreateAdmin runs the create-admin command
+func runCreateAdmin(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := fs.String("email", "", "email address the admin logs in with")
+	firstName := fs.String("first-name", "Admin", "first name")
+	lastName := fs.String("last-name", "User", "last name")
+	password := fs.String("password", "", "password (default: BOOKINGS_ADMIN_PASSWORD, or read from standard input)")
+
+	var cfg config.AppConfig
+	if err := config.LoadFlagSet(&cfg, fs, args, os.Getenv); err != nil {
+		return err
+	}
+
+	if *password == "" {
+		*password = os.Getenv("BOOKINGS_ADMIN_PASSWORD")
+	}
+	if *password == "" {
+		fmt.Fprint(out, "password: ")
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	form := forms.New(url.Values{
+		"email":      {*email},
+		"first_name": {*firstName},
+		"last_name":  {*lastName},
+		"password":   {*password},
+	})
+	form.Required("email", "first_name", "last_name", "password")
+	form.IsEmail("email")
+	form.MinLength("password", 8)
+	if !form.Valid() {
+		var problems []string
+		for _, field := range []string{"email", "first_name", "last_name", "password"} {
+			if msg := form.Errors.Get(field); msg != "" {
+				problems = append(problems, fmt.Sprintf("%s: %s", field, msg))
+			}
+		}
+		return fmt.Errorf("create-admin: %s", strings.Join(problems, "; "))
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	repo, db, err := openRepo(&cfg)
+	if err != nil {
+		return fmt.Errorf("create-admin: %w", err)
+	}
+	defer db.SQL.Close()
+
+	id, err := repo.InsertUser(context.Background(), models.User{
+		FirstName:   *firstName,
+		LastName:    *lastName,
+		Email:       *email,
+		Password:    string(hash),
+		AccessLevel: models.AccessLevelAdmin,
+	})
+	if err != nil {
+		return fmt.Errorf("create-admin: %w", err)
+	}
+
+	fmt.Fprintf(out, "created admin %s with id %d\n", *email, id)
+	return nil
+}
+
+// runCheck runs the check command, reporting every problem rather than stopping at the first
+func runCheck(args []string, out io.Writer) error {
+	var cfg config.AppConfig
+	if err := config.Load(&cfg, args, os.Getenv); err != nil {
+		fmt.Fprintln(out, "config     FAIL")
+		return err
+	}
+	fmt.Fprintf(out, "config     ok (%s environment)\n", cfg.Env)
+
+	var errs []error
+
+	if cfg.DB.Configured() {
+		_, db, err := openRepo(&cfg)
+		if err != nil {
+			fmt.Fprintln(out, "database   FAIL")
+			errs = append(errs, err)
+		} else {
+			db.SQL.Close()
+			fmt.Fprintln(out, "database   ok")
+		}
+	} else {
+		fmt.Fprintln(out, "database   none configured, the server would use an in-memory database")
+	}
+
+	tc, err := render.CreateTemplateCache()
+	switch {
+	case err != nil:
+		fmt.Fprintln(out, "templates  FAIL")
+		errs = append(errs, fmt.Errorf("parsing templates: %w", err))
+	case len(tc) == 0:
+		fmt.Fprintln(out, "templates  FAIL")
+		errs = append(errs, errors.New("no page templates found in ./templates, run from the repository root"))
+	default:
+		fmt.Fprintf(out, "templates  ok (%d pages)\n", len(tc))
+	}
+
+	ec, err := render.CreateEmailTemplateCache()
+	switch {
+	case err != nil:
+		fmt.Fprintln(out, "emails     FAIL")
+		errs = append(errs, fmt.Errorf("parsing email templates: %w", err))
+	case len(ec) == 0:
+		fmt.Fprintln(out, "emails     FAIL")
+		errs = append(errs, errors.New("no email templates found in ./templates/email"))
+	default:
+		fmt.Fprintf(out, "emails     ok (%d templates)\n", len(ec))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	t.Setenv("BOOKINGS_DB_NAME", "")
+	t.Setenv("BOOKINGS_DB_URL", "")
+	t.Setenv("BOOKINGS_ADMIN_PASSWORD", "")
+
+	var commandTests = []struct {
+		name           string
+		args           []string
+		stdin          string
+		expectedErr    string
+		expectedOutput string
+	}{
+		{"help", []string{"help"}, "", "", "create-admin"},
+		{"help flag", []string{"check", "-h"}, "", "", ""},
+		{"unknown command", []string{"bogus"}, "", `unknown command "bogus"`, "usage: go-bookings"},
+		{"bad serve flags", []string{"-port", "0"}, "", "port must be between", ""},
+		{"check without templates", []string{"check"}, "", "no page templates found", "in-memory database"},
+		{"seed without database", []string{"seed", "-demo"}, "", "no database configured", ""},
+		{"admin bad email", []string{"create-admin", "-email", "nope", "-password", "s3cret-pass"}, "", "email: Invalid email address", ""},
+		{"admin short password", []string{"create-admin", "-email", "me@here.ca", "-password", "short"}, "", "at least 8 characters", ""},
+		{"admin password from stdin", []string{"create-admin", "-email", "me@here.ca"}, "s3cret-pass\n", "no database configured", "password:"},
+	}
+
+	for _, test := range commandTests {
+		stdin = strings.NewReader(test.stdin)
+		var out bytes.Buffer
+
+		err := runCommand(test.args, &out)
+
+		switch {
+		case test.expectedErr == "" && err != nil:
+			t.Errorf("for %s, unexpected error: %v", test.name, err)
+		case test.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), test.expectedErr)):
+			t.Errorf("for %s, expected error containing %q but got %v", test.name, test.expectedErr, err)
+		}
+		if !strings.Contains(out.String(), test.expectedOutput) {
+			t.Errorf("for %s, expected output containing %q but got %q", test.name, test.expectedOutput, out.String())
+		}
+	}
+}
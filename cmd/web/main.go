@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -13,43 +18,106 @@ import (
 	"github.com/jeremydelacruz/go-bookings/internal/driver"
 	"github.com/jeremydelacruz/go-bookings/internal/handlers"
 	"github.com/jeremydelacruz/go-bookings/internal/helpers"
+	"github.com/jeremydelacruz/go-bookings/internal/mail"
 	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/payments"
 	"github.com/jeremydelacruz/go-bookings/internal/render"
+	"github.com/jeremydelacruz/go-bookings/internal/scheduler"
+	"github.com/jeremydelacruz/go-bookings/internal/tokens"
 )
 
-const portNumber = ":8080"
+// mailQueueSize is how many emails can wait for a worker before new ones are dropped
+const mailQueueSize = 100
 
 var app config.AppConfig
 var session *scs.SessionManager
 var infoLog *log.Logger
 var errorLog *log.Logger
+var mailWorkers *mail.Workers
 
 // main is the application entrypoint
 func main() {
+	if err := runCommand(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runServe runs the serve command: it starts the web server and blocks until it has shut down
+func runServe(args []string, out io.Writer) error {
+	err := config.Load(&app, args, os.Getenv)
+	if err != nil {
+		return err
+	}
+
 	db, err := run()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer db.SQL.Close()
 
-	log.Printf("starting application on port %s\n", portNumber)
+	// the whole shutdown, draining requests and then the mail queue, shares one deadline
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	shutdownCtx, cancelShutdown := shutdownContext(ctx, app.ShutdownTimeout)
+	defer cancelShutdown()
+
+	// drained stays true unless serve returns with requests still running, which may yet queue
+	// mail, so the queue must then be left open
+	drained := true
+	defer func() {
+		log.Println("stopping background workers")
+
+		if drained {
+			// the mail workers finish sending what was queued, by the shutdown deadline
+			close(app.MailChan)
+			if werr := mailWorkers.Wait(shutdownCtx); werr != nil {
+				log.Println(werr)
+			}
+		} else {
+			log.Println("requests still running, leaving queued mail unsent")
+		}
+
+		if db != nil {
+			log.Println("closing database connections")
+			db.SQL.Close()
+		}
+	}()
+	// stopping the signal context starts the shutdown deadline when serve fails by itself
+	defer stop()
 
 	srv := &http.Server{
-		Addr:    portNumber,
+		Addr:    fmt.Sprintf(":%d", app.Port),
 		Handler: routes(&app),
 	}
 
-	err = srv.ListenAndServe()
+	jobs, err := newScheduler(handlers.Repo, app.Reminders)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	// scheduled jobs queue mail too, so they must stop before the queue is closed above
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Run(jobsCtx)
+		close(jobsDone)
+	}()
+	defer func() {
+		stopJobs()
+		<-jobsDone
+	}()
+
+	log.Printf("starting application on port %d\n", app.Port)
+	err = serve(ctx, srv, ln, shutdownCtx)
+	drained = err == nil
+	return err
 }
 
 // run configures the app config, session, and handlers
 func run() (*driver.DB, error) {
-	// change this to true in prod
-	app.InProduction = false
-
 	// configure loggers
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -70,24 +138,90 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
-	log.Println("connecting to database...")
-	db, err := driver.ConnectSQL("host=localhost port=5432 dbname=bookings user=jdelacruz password=")
-	if err != nil {
-		return nil, fmt.Errorf("run: failed connecting to database: %w", err)
-	}
-	log.Println("connected to database")
-
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		return nil, fmt.Errorf("run: failed creating template cache: %w", err)
 	}
 
 	app.TemplateCache = tc
-	app.UseCache = false
 
-	repo := handlers.NewRepo(&app, db)
+	ec, err := render.CreateEmailTemplateCache()
+	if err != nil {
+		return nil, fmt.Errorf("run: failed creating email template cache: %w", err)
+	}
+
+	app.EmailCache = ec
+
+	// manage links signed with a random key stop working when the app restarts, which is
+	// only acceptable in development; production refuses to start without a link secret
+	if app.LinkSecret == "" {
+		key, err := tokens.NewKey()
+		if err != nil {
+			return nil, fmt.Errorf("run: failed creating link secret: %w", err)
+		}
+		app.LinkSecret = string(key)
+		log.Println("no link secret configured, guest manage links will not survive a restart")
+	}
+
+	gateway, err := payments.New(app.Payments.Provider, app.Payments.WebhookSecret)
+	if err != nil {
+		return nil, fmt.Errorf("run: %w", err)
+	}
+
+	sender, err := newMailSender(app.Mail)
+	if err != nil {
+		return nil, fmt.Errorf("run: %w", err)
+	}
+	app.MailChan = make(chan mail.MailData, mailQueueSize)
+	mailWorkers = mail.Start(app.MailChan, sender, app.Mail.Workers, errorLog)
+
+	// without a configured database the app runs against an in-memory one,
+	// which is handy for development but loses everything on restart
+	var db *driver.DB
+	var repo *handlers.Repository
+	if app.DB.Configured() {
+		log.Println("connecting to database...")
+		db, err = driver.ConnectSQL(app.DB.DSN())
+		if err != nil {
+			return nil, fmt.Errorf("run: failed connecting to database: %w", err)
+		}
+		log.Println("connected to database")
+		repo = handlers.NewRepo(&app, db, gateway)
+	} else {
+		log.Println("no database configured, using in-memory database")
+		repo = handlers.NewMemoryRepo(&app, gateway)
+	}
 	handlers.NewHandlers(repo)
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 	return db, nil
 }
+
+// newScheduler returns the scheduler running the app's background jobs, which take their locks
+// from the repository's database so that only one instance runs each job
+func newScheduler(repo *handlers.Repository, c config.RemindersConfig) (*scheduler.Scheduler, error) {
+	s := scheduler.New(repo.DB, infoLog, errorLog)
+
+	if err := s.Add("arrival-reminders", c.Schedule, repo.SendArrivalReminders); err != nil {
+		return nil, fmt.Errorf("newScheduler: %w", err)
+	}
+	if err := s.Add("follow-ups", c.Schedule, repo.SendFollowUps); err != nil {
+		return nil, fmt.Errorf("newScheduler: %w", err)
+	}
+
+	return s, nil
+}
+
+// newMailSender returns the sender for the configured mail transport
+func newMailSender(c config.MailConfig) (mail.Sender, error) {
+	switch c.Transport {
+	case "smtp":
+		return mail.NewSMTPSender(c.Host, c.Port, c.Username, c.Password), nil
+	case "file":
+		return mail.NewFileSender(c.Dir), nil
+	case "memory":
+		return mail.NewMemorySender(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", c.Transport)
+	}
+}
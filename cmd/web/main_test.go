@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/handlers"
+)
 
 func TestRun(t *testing.T) {
 	_, err := run()
@@ -8,3 +16,52 @@ func TestRun(t *testing.T) {
 		t.Error("failed run()")
 	}
 }
+
+func TestRunServe_ListenFails(t *testing.T) {
+	t.Setenv("BOOKINGS_DB_NAME", "")
+	t.Setenv("BOOKINGS_DB_URL", "")
+
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	if err = runServe([]string{"-port", strconv.Itoa(port)}, io.Discard); err == nil {
+		t.Fatal("expected an error when the port is taken")
+	}
+
+	// the mail queue is closed and its workers stopped even though the server never started
+	select {
+	case _, ok := <-app.MailChan:
+		if ok {
+			t.Error("expected the mail queue to be closed")
+		}
+	default:
+		t.Error("expected the mail queue to be closed")
+	}
+}
+
+func TestNewMailSender(t *testing.T) {
+	for _, transport := range []string{"smtp", "file", "memory"} {
+		if _, err := newMailSender(config.MailConfig{Transport: transport}); err != nil {
+			t.Errorf("for %s, unexpected error %v", transport, err)
+		}
+	}
+
+	if _, err := newMailSender(config.MailConfig{Transport: "pigeon"}); err == nil {
+		t.Error("expected an error for an unknown transport")
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	repo := handlers.NewTestRepo(&config.AppConfig{})
+
+	if _, err := newScheduler(repo, config.RemindersConfig{Schedule: "0 9 * * *"}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := newScheduler(repo, config.RemindersConfig{Schedule: "0 9 30 2 *"}); err == nil {
+		t.Error("expected an error for a schedule that never runs")
+	}
+}
@@ -2,13 +2,22 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/jeremydelacruz/go-bookings/internal/handlers"
+	"github.com/jeremydelacruz/go-bookings/internal/helpers"
 	"github.com/justinas/nosurf"
 )
 
-// NoSurf adds CSRF protection to all POST requests
+// NoSurf adds CSRF protection to all POST requests except the payment webhook, which is
+// called by the payment provider and verified by its signature instead, and the JSON API,
+// whose clients send an API key rather than the session cookie
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	csrfHandler.ExemptPath(handlers.PaymentWebhookPath)
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, handlers.APIPrefix+"/")
+	})
 
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
@@ -20,7 +29,37 @@ func NoSurf(next http.Handler) http.Handler {
 	return csrfHandler
 }
 
+// LimitRequestBody caps request bodies before NoSurf parses forms, so oversized uploads are not read in full.
+// A body declared too large is refused with 413 straight away; one sent without a length fails once it is read past the cap.
+func LimitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > handlers.MaxUploadSize {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, handlers.MaxUploadSize)
+		next.ServeHTTP(w, r)
+	})
+}
+
 // SessionLoad loads and saves the session on every request
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
+
+// Auth redirects to the login page unless the session has a logged in user, and refuses users
+// without the admin access level the back office needs
+func Auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.IsAuthenticated(r) {
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		if !helpers.IsAdmin(r) {
+			helpers.ClientError(w, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
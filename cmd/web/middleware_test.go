@@ -1,8 +1,19 @@
 package main
 
 import (
+	"context"
+	"io"
+	"log"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/handlers"
+	"github.com/jeremydelacruz/go-bookings/internal/helpers"
+	"github.com/jeremydelacruz/go-bookings/internal/models"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -16,6 +27,27 @@ func TestNoSurf(t *testing.T) {
 	}
 }
 
+func TestNoSurf_PaymentWebhook(t *testing.T) {
+	var paths = []struct {
+		path         string
+		expectedCode int
+	}{
+		{handlers.PaymentWebhookPath, http.StatusOK},
+		{handlers.APIPrefix + "/reservations", http.StatusOK},
+		{handlers.APIPrefix + "/reservations/ABCD2345EFGH/cancel", http.StatusOK},
+		{"/make-reservation", http.StatusBadRequest},
+	}
+
+	h := NoSurf(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, test := range paths {
+		resRecorder := httptest.NewRecorder()
+		h.ServeHTTP(resRecorder, httptest.NewRequest("POST", test.path, strings.NewReader("{}")))
+		if resRecorder.Code != test.expectedCode {
+			t.Errorf("for POST %s without a CSRF token, expected %d but got %d", test.path, test.expectedCode, resRecorder.Code)
+		}
+	}
+}
+
 func TestSessionLoad(t *testing.T) {
 	var mHandler mockHandler
 	h := SessionLoad(&mHandler)
@@ -26,3 +58,86 @@ func TestSessionLoad(t *testing.T) {
 		t.Error("return type is not http.Handler")
 	}
 }
+
+func TestAuth(t *testing.T) {
+	var mHandler mockHandler
+	h := Auth(&mHandler)
+	switch h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error("return type is not http.Handler")
+	}
+}
+
+func TestAuth_AccessLevel(t *testing.T) {
+	session = scs.New()
+	app.Session = session
+	app.InfoLog = log.New(io.Discard, "", 0)
+	helpers.NewHelpers(&app)
+
+	var accessTests = []struct {
+		name         string
+		userID       int
+		accessLevel  int
+		expectedCode int
+	}{
+		{"logged out", 0, 0, http.StatusSeeOther},
+		{"user", 2, models.AccessLevelUser, http.StatusForbidden},
+		{"admin", 1, models.AccessLevelAdmin, http.StatusOK},
+	}
+
+	for _, test := range accessTests {
+		ctx, err := session.Load(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.userID != 0 {
+			session.Put(ctx, "user_id", test.userID)
+			session.Put(ctx, "access_level", test.accessLevel)
+		}
+		req := httptest.NewRequest("GET", "/admin/api-keys", nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		Auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+		if rr.Code != test.expectedCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	var readErr error
+	h := LimitRequestBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", handlers.MaxUploadSize)))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if readErr != nil {
+		t.Errorf("expected a body of the maximum size to be read, got %v", readErr)
+	}
+
+	// without a declared length the body is cut off once read past the cap
+	req = httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", handlers.MaxUploadSize+1)))
+	req.ContentLength = -1
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if readErr == nil {
+		t.Error("expected reading an oversized body to fail")
+	}
+}
+
+func TestLimitRequestBody_ImageUpload(t *testing.T) {
+	session = scs.New()
+	mux := routes(&config.AppConfig{})
+
+	// an oversized upload is refused before NoSurf reads it, even without a CSRF token or a login
+	req := httptest.NewRequest("POST", "/admin/rooms/1/images", strings.NewReader(strings.Repeat("x", handlers.MaxUploadSize+1)))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d but got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+}
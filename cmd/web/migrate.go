@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/migrate"
+	"github.com/jeremydelacruz/go-bookings/migrations"
+)
+
+const migrateUsage = "usage: go-bookings migrate up|down|status|redo [flags]"
+
+// runMigrate runs the migrate subcommand; args are the action followed by the usual config flags
+func runMigrate(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	action := args[0]
+	switch action {
+	case "up", "down", "status", "redo":
+	default:
+		return fmt.Errorf("unknown migrate action %q\n%s", action, migrateUsage)
+	}
+
+	var cfg config.AppConfig
+	if err := config.Load(&cfg, args[1:], os.Getenv); err != nil {
+		return err
+	}
+	_, db, err := openRepo(&cfg)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	defer db.SQL.Close()
+
+	m, err := migrate.New(db.SQL, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch action {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			fmt.Fprintf(out, "applied %s_%s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "database is up to date")
+		}
+		return err
+	case "down", "redo":
+		run := m.Down
+		verb := "rolled back"
+		if action == "redo" {
+			run = m.Redo
+			verb = "redid"
+		}
+		mig, err := run(ctx)
+		if err != nil {
+			return err
+		}
+		if mig == nil {
+			fmt.Fprintln(out, "no migrations applied")
+			return nil
+		}
+		fmt.Fprintf(out, "%s %s_%s\n", verb, mig.Version, mig.Name)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(out, "%-8s %s_%s\n", state, s.Version, s.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRunMigrate_Errors(t *testing.T) {
+	t.Setenv("BOOKINGS_DB_NAME", "")
+	t.Setenv("BOOKINGS_DB_URL", "")
+
+	var migrateTests = []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"no action", nil, "usage: go-bookings migrate"},
+		{"unknown action", []string{"sideways"}, `unknown migrate action "sideways"`},
+		{"bad flags", []string{"up", "-port", "0"}, "port must be between"},
+		{"no database", []string{"status"}, "no database configured"},
+	}
+
+	for _, test := range migrateTests {
+		err := runMigrate(test.args, io.Discard)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("for %s, expected error containing %q but got %v", test.name, test.expected, err)
+		}
+	}
+}
@@ -2,23 +2,31 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jeremydelacruz/go-bookings/internal/config"
 	"github.com/jeremydelacruz/go-bookings/internal/handlers"
+	"github.com/jeremydelacruz/go-bookings/internal/images"
+	"github.com/jeremydelacruz/go-bookings/internal/models"
 )
 
 // TODO: try replacing chi? (fiber? gin? other alternative?)
 func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
-	mux.Use(middleware.Recoverer, NoSurf, SessionLoad)
+	mux.Use(middleware.Recoverer, LimitRequestBody, NoSurf, SessionLoad)
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+
+	// the room pages used to live at the top level, so links to them are sent on
+	for _, slug := range []string{"generals-quarters", "majors-suite"} {
+		mux.Get("/"+slug, http.RedirectHandler("/rooms/"+slug, http.StatusMovedPermanently).ServeHTTP)
+	}
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
@@ -30,10 +38,93 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
+	mux.Get("/manage/{code}/{sig}", handlers.Repo.ManageReservation)
+	mux.Post("/manage/{code}/{sig}/cancel", handlers.Repo.PostCancelReservation)
+	mux.Post("/manage/{code}/{sig}/dates", handlers.Repo.PostChangeReservationDates)
+
+	mux.Post(handlers.PaymentWebhookPath, handlers.Repo.PaymentWebhook)
+
+	mux.Route(handlers.APIPrefix, func(mux chi.Router) {
+		mux.Use(handlers.Repo.APIAuth)
+		mux.NotFound(handlers.Repo.APINotFound)
+		mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+		mux.With(handlers.RequireScope(models.ScopeReadAvailability)).Group(func(mux chi.Router) {
+			mux.Get("/rooms", handlers.Repo.APIRooms)
+			mux.Get("/rooms/{id}", handlers.Repo.APIRoom)
+			mux.Get("/rooms/{id}/availability", handlers.Repo.APIRoomAvailability)
+			mux.Get("/rooms/{id}/quote", handlers.Repo.APIQuote)
+			mux.Get("/availability", handlers.Repo.APIAvailability)
+		})
+		mux.With(handlers.RequireScope(models.ScopeWriteReservations)).Group(func(mux chi.Router) {
+			mux.Post("/reservations", handlers.Repo.APICreateReservation)
+			mux.Get("/reservations/{code}", handlers.Repo.APIReservation)
+			mux.Post("/reservations/{code}/cancel", handlers.Repo.APICancelReservation)
+		})
+		mux.With(handlers.RequireScope(models.ScopeAdmin)).Get("/reservations", handlers.Repo.APIReservations)
+	})
+
 	mux.Get("/contact", handlers.Repo.Contact)
 
+	mux.Get("/user/login", handlers.Repo.ShowLogin)
+	mux.Post("/user/login", handlers.Repo.PostShowLogin)
+	mux.Get("/user/logout", handlers.Repo.Logout)
+
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(Auth)
+
+		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
+		mux.Post("/reservations/{src}/{id}", handlers.Repo.PostAdminShowReservation)
+		mux.Post("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
+		mux.Post("/delete-reservation/{src}/{id}", handlers.Repo.AdminDeleteReservation)
+		mux.Post("/confirm-reservation/{src}/{id}", handlers.Repo.AdminConfirmReservation)
+		mux.Post("/check-in-reservation/{src}/{id}", handlers.Repo.AdminCheckInReservation)
+		mux.Post("/check-out-reservation/{src}/{id}", handlers.Repo.AdminCheckOutReservation)
+		mux.Post("/no-show-reservation/{src}/{id}", handlers.Repo.AdminNoShowReservation)
+		mux.Post("/cancel-reservation/{src}/{id}", handlers.Repo.AdminCancelReservation)
+		mux.Post("/capture-payment/{src}/{id}", handlers.Repo.AdminCapturePayment)
+		mux.Post("/void-payment/{src}/{id}", handlers.Repo.AdminVoidPayment)
+		mux.Post("/refund-payment/{src}/{id}", handlers.Repo.AdminRefundPayment)
+		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+
+		mux.Get("/rooms", handlers.Repo.AdminRooms)
+		mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+		mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+		mux.Post("/rooms/{id}", handlers.Repo.PostAdminShowRoom)
+		mux.Post("/rooms/{id}/deactivate", handlers.Repo.AdminDeactivateRoom)
+		mux.Post("/rooms/{id}/activate", handlers.Repo.AdminActivateRoom)
+		mux.Post("/rooms/{id}/move", handlers.Repo.AdminMoveRoom)
+		mux.Post("/rooms/{id}/images", handlers.Repo.AdminUploadRoomImage)
+		mux.Post("/rooms/{id}/images/delete", handlers.Repo.AdminDeleteRoomImage)
+
+		mux.Get("/pricing", handlers.Repo.AdminPricing)
+		mux.Post("/pricing/overrides", handlers.Repo.AdminPostRateOverride)
+		mux.Post("/pricing/overrides/{id}/delete", handlers.Repo.AdminDeleteRateOverride)
+		mux.Post("/pricing/discounts", handlers.Repo.AdminPostStayDiscount)
+		mux.Post("/pricing/discounts/{id}/delete", handlers.Repo.AdminDeleteStayDiscount)
+
+		mux.Get("/promo-codes", handlers.Repo.AdminPromoCodes)
+		mux.Post("/promo-codes", handlers.Repo.AdminPostPromoCode)
+		mux.Post("/promo-codes/{id}/delete", handlers.Repo.AdminDeletePromoCode)
+
+		mux.Get("/api-keys", handlers.Repo.AdminAPIKeys)
+		mux.Post("/api-keys", handlers.Repo.AdminPostAPIKey)
+		mux.Post("/api-keys/{id}/delete", handlers.Repo.AdminDeleteAPIKey)
+
+		mux.Get("/emails", handlers.Repo.AdminEmails)
+		mux.Get("/emails/{name}", handlers.Repo.AdminPreviewEmail)
+	})
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	uploadServer := http.FileServer(http.Dir(app.UploadDir))
+	mux.Handle(images.URLPrefix+"*", http.StripPrefix(strings.TrimSuffix(images.URLPrefix, "/"), uploadServer))
+
 	return mux
 }
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 	"github.com/jeremydelacruz/go-bookings/internal/config"
 )
@@ -19,3 +22,17 @@ func TestRoutes(t *testing.T) {
 		t.Error("return type is not *chi.Mux")
 	}
 }
+
+func TestRoutes_OldRoomPages(t *testing.T) {
+	session = scs.New()
+	mux := routes(&config.AppConfig{})
+
+	for _, slug := range []string{"generals-quarters", "majors-suite"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", "/"+slug, nil))
+
+		if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/rooms/"+slug {
+			t.Errorf("for %s, expected a permanent redirect to /rooms/%s, got %d to %q", slug, slug, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServe_DrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: mux}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shutdownCtx, cancelShutdown := shutdownContext(ctx, 5*time.Second)
+	defer cancelShutdown()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, srv, ln, shutdownCtx)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			resCh <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		resCh <- result{body: string(b), err: err}
+	}()
+
+	// signal shutdown while the slow request is still being handled
+	<-started
+	cancel()
+
+	res := <-resCh
+	if res.err != nil {
+		t.Fatalf("in-flight request failed during shutdown: %v", res.err)
+	}
+	if res.body != "done" {
+		t.Errorf("expected body done, got %q", res.body)
+	}
+
+	if err := <-serveErr; err != nil {
+		t.Errorf("serve returned an error: %v", err)
+	}
+
+	// the listener must be closed once serve returns
+	if _, err := net.DialTimeout("tcp", ln.Addr().String(), 100*time.Millisecond); err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
+
+func TestServe_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stuck", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: mux}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shutdownCtx, cancelShutdown := shutdownContext(ctx, 50*time.Millisecond)
+	defer cancelShutdown()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, srv, ln, shutdownCtx)
+	}()
+
+	go http.Get("http://" + ln.Addr().String() + "/stuck")
+
+	<-started
+	cancel()
+
+	if err := <-serveErr; err == nil {
+		t.Error("expected an error when requests outlive the shutdown timeout")
+	}
+}
+
+func TestShutdownContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdownCtx, cancelShutdown := shutdownContext(ctx, 50*time.Millisecond)
+	defer cancelShutdown()
+
+	// the deadline only starts once ctx is done
+	select {
+	case <-shutdownCtx.Done():
+		t.Fatal("shutdown context done before shutdown began")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-shutdownCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("shutdown context not done after its timeout")
+	}
+}
@@ -3,16 +3,41 @@ package config
 import (
 	"html/template"
 	"log"
+	texttemplate "text/template"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/jeremydelacruz/go-bookings/internal/mail"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
+	UseCache        bool
+	TemplateCache   map[string]*template.Template
+	EmailCache      map[string]*EmailTemplate
+	InfoLog         *log.Logger
+	ErrorLog        *log.Logger
+	InProduction    bool
+	Session         *scs.SessionManager
+	Port            int
+	Env             string
+	ShutdownTimeout time.Duration
+	DBQueryTimeout  time.Duration
+	DB              DBConfig
+	UploadDir       string
+	TaxRate         float64
+	MaxStayNights   int
+	Payments        PaymentsConfig
+	LinkSecret      string
+	BaseURL         string
+	Mail            MailConfig
+	Reminders       RemindersConfig
+	MailChan        chan mail.MailData
+}
+
+// EmailTemplate is an email template parsed twice: as HTML for its HTML part and as text, without
+// HTML escaping, for its plain text part
+type EmailTemplate struct {
+	HTML *template.Template
+	Text *texttemplate.Template
 }
@@ -0,0 +1,791 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/scheduler"
+	"gopkg.in/yaml.v3"
+)
+
+// DBConfig holds the database connection settings
+type DBConfig struct {
+	Host     string
+	Port     int
+	Name     string
+	User     string
+	Password string
+	SSLMode  string
+	URL      string
+}
+
+// Configured reports whether a database has been configured at all
+func (d DBConfig) Configured() bool {
+	return d.URL != "" || d.Name != ""
+}
+
+// DSN returns the connection string for the database, preferring URL when set
+func (d DBConfig) DSN() string {
+	if d.URL != "" {
+		return d.URL
+	}
+
+	var parts []string
+	add := func(key, value string) {
+		if value != "" {
+			parts = append(parts, fmt.Sprintf("%s=%s", key, quoteDSNValue(value)))
+		}
+	}
+	add("host", d.Host)
+	if d.Port != 0 {
+		add("port", strconv.Itoa(d.Port))
+	}
+	add("dbname", d.Name)
+	add("user", d.User)
+	add("password", d.Password)
+	add("sslmode", d.SSLMode)
+
+	return strings.Join(parts, " ")
+}
+
+// PaymentsConfig holds the payment provider settings. DepositRate is the percentage of a
+// stay's total authorised when booking; no deposit is taken when it is 0.
+type PaymentsConfig struct {
+	Provider      string
+	Currency      string
+	DepositRate   float64
+	WebhookSecret string
+}
+
+// MailConfig holds the outgoing email settings. Transport is smtp, which sends through the
+// server at Host:Port, file, which writes each message to Dir, or memory, which only keeps
+// them. Booking alerts go to OwnerAddress.
+type MailConfig struct {
+	Transport    string
+	Host         string
+	Port         int
+	Username     string
+	Password     string
+	From         string
+	OwnerAddress string
+	Dir          string
+	Workers      int
+}
+
+// RemindersConfig holds the scheduled guest emails. Schedule is the cron expression the jobs
+// sending them run on; arriving guests are reminded DaysBefore days ahead, and departed ones
+// thanked FollowUpDays days after they leave.
+type RemindersConfig struct {
+	Schedule     string
+	DaysBefore   int
+	FollowUpDays int
+}
+
+// quoteDSNValue quotes a key/value connection string value when it contains spaces or quotes
+func quoteDSNValue(v string) string {
+	if !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+const (
+	defaultPort             = 8080
+	defaultShutdownTimeout  = 15 * time.Second
+	defaultDBQueryTimeout   = 3 * time.Second
+	defaultEnv              = "development"
+	defaultUploadDir        = "uploads"
+	defaultMaxStayNights    = 30
+	defaultPaymentProvider  = "fake"
+	defaultCurrency         = "usd"
+	defaultDepositRate      = 20
+	defaultMailTransport    = "file"
+	defaultMailFrom         = "bookings@localhost"
+	defaultMailOwner        = "owner@localhost"
+	defaultMailDir          = "mail"
+	defaultMailWorkers      = 2
+	defaultReminderSchedule = "0 9 * * *"
+	defaultReminderDays     = 2
+	defaultFollowUpDays     = 1
+	defaultDBConfigFile     = "database.yml"
+	envPrefix               = "BOOKINGS_"
+)
+
+var validEnvs = []string{"development", "test", "production"}
+var validSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+var validPaymentProviders = []string{"fake"}
+var validMailTransports = []string{"smtp", "file", "memory"}
+var currencyPattern = regexp.MustCompile(`^[a-z]{3}$`)
+
+// dbFileConfig is a database connection as written in a config file, using the same keys as soda's database.yml
+type dbFileConfig struct {
+	Dialect  string            `yaml:"dialect"`
+	Host     string            `yaml:"host"`
+	Port     string            `yaml:"port"`
+	Database string            `yaml:"database"`
+	User     string            `yaml:"user"`
+	Password string            `yaml:"password"`
+	URL      string            `yaml:"url"`
+	Options  map[string]string `yaml:"options"`
+}
+
+// fileConfig is the layout of the optional YAML config file
+type fileConfig struct {
+	Port            *int         `yaml:"port"`
+	Env             *string      `yaml:"env"`
+	InProduction    *bool        `yaml:"in_production"`
+	UseCache        *bool        `yaml:"use_cache"`
+	ShutdownTimeout *string      `yaml:"shutdown_timeout"`
+	DBQueryTimeout  *string      `yaml:"db_timeout"`
+	UploadDir       *string      `yaml:"upload_dir"`
+	TaxRate         *float64     `yaml:"tax_rate"`
+	MaxStayNights   *int         `yaml:"max_stay_nights"`
+	LinkSecret      *string      `yaml:"link_secret"`
+	BaseURL         *string      `yaml:"base_url"`
+	Database        dbFileConfig `yaml:"database"`
+	Payments        struct {
+		Provider      *string  `yaml:"provider"`
+		Currency      *string  `yaml:"currency"`
+		DepositRate   *float64 `yaml:"deposit_rate"`
+		WebhookSecret *string  `yaml:"webhook_secret"`
+	} `yaml:"payments"`
+	Mail struct {
+		Transport    *string `yaml:"transport"`
+		Host         *string `yaml:"host"`
+		Port         *int    `yaml:"port"`
+		Username     *string `yaml:"username"`
+		Password     *string `yaml:"password"`
+		From         *string `yaml:"from"`
+		OwnerAddress *string `yaml:"owner"`
+		Dir          *string `yaml:"dir"`
+		Workers      *int    `yaml:"workers"`
+	} `yaml:"mail"`
+	Reminders struct {
+		Schedule     *string `yaml:"schedule"`
+		DaysBefore   *int    `yaml:"days_before"`
+		FollowUpDays *int    `yaml:"follow_up_days"`
+	} `yaml:"reminders"`
+}
+
+// Load fills app from, in increasing order of precedence: defaults, the environment's
+// section of the soda database.yml, the YAML config file, BOOKINGS_* environment
+// variables and command-line flags. The result is validated before returning.
+func Load(app *AppConfig, args []string, getenv func(string) string) error {
+	return LoadFlagSet(app, flag.NewFlagSet("go-bookings", flag.ContinueOnError), args, getenv)
+}
+
+// LoadFlagSet is Load for commands with flags of their own: the config flags are added
+// to fs, which is then parsed along with any flags the caller defined on it
+func LoadFlagSet(app *AppConfig, fs *flag.FlagSet, args []string, getenv func(string) string) error {
+	configFile := fs.String("config", "", "path to a YAML config file")
+	dbConfigFile := fs.String("db-config", "", "path to a soda database.yml (default \"database.yml\" when present)")
+	env := fs.String("env", "", "environment section of the database.yml to use (development, test, production)")
+	port := fs.Int("port", 0, "port to listen on")
+	inProduction := fs.Bool("production", false, "run in production mode")
+	useCache := fs.Bool("cache", false, "use the template cache")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long to wait for in-flight requests on shutdown")
+	dbHost := fs.String("db-host", "", "database host")
+	dbPort := fs.Int("db-port", 0, "database port")
+	dbName := fs.String("db-name", "", "database name")
+	dbUser := fs.String("db-user", "", "database user")
+	dbPassword := fs.String("db-password", "", "database password")
+	dbSSLMode := fs.String("db-sslmode", "", "database sslmode")
+	dbURL := fs.String("db-url", "", "database URL, overrides the other database settings")
+	dbTimeout := fs.Duration("db-timeout", 0, "maximum duration of a single database query")
+	uploadDir := fs.String("upload-dir", "", "directory uploaded room images are stored in (default \"uploads\")")
+	taxRate := fs.Float64("tax-rate", 0, "tax charged on room prices, as a percentage")
+	maxStayNights := fs.Int("max-stay-nights", 0, "longest stay guests can book, in nights (default 30)")
+	paymentProvider := fs.String("payment-provider", "", "payment provider deposits are taken with (default \"fake\")")
+	currency := fs.String("currency", "", "ISO 4217 code of the currency prices are charged in (default \"usd\")")
+	depositRate := fs.Float64("deposit-rate", 0, "part of the total authorised when booking, as a percentage (default 20)")
+	webhookSecret := fs.String("payment-webhook-secret", "", "secret the payment provider signs webhooks with")
+	linkSecret := fs.String("link-secret", "", "secret guests' reservation links are signed with (random on each start when empty)")
+	baseURL := fs.String("base-url", "", "URL the site is reached at, used for links in emails (default \"http://localhost:<port>\")")
+	mailTransport := fs.String("mail-transport", "", "how email is sent: smtp, file or memory (default \"file\")")
+	smtpHost := fs.String("smtp-host", "", "SMTP server host (default \"localhost\")")
+	smtpPort := fs.Int("smtp-port", 0, "SMTP server port (default 1025)")
+	smtpUser := fs.String("smtp-user", "", "SMTP username, when the server requires one")
+	smtpPassword := fs.String("smtp-password", "", "SMTP password")
+	mailFrom := fs.String("mail-from", "", "address email is sent from (default \"bookings@localhost\")")
+	mailOwner := fs.String("mail-owner", "", "address booking alerts are sent to (default \"owner@localhost\")")
+	mailDir := fs.String("mail-dir", "", "directory the file transport writes email to (default \"mail\")")
+	mailWorkers := fs.Int("mail-workers", 0, "number of goroutines sending email (default 2)")
+	reminderSchedule := fs.String("reminder-schedule", "", "cron expression the guest reminder jobs run on (default \"0 9 * * *\")")
+	reminderDays := fs.Int("reminder-days", 0, "days before arrival guests are reminded (default 2)")
+	followUpDays := fs.Int("follow-up-days", 0, "days after departure guests are thanked (default 1)")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	// defaults
+	app.Port = defaultPort
+	app.Env = defaultEnv
+	app.InProduction = false
+	app.UseCache = false
+	app.ShutdownTimeout = defaultShutdownTimeout
+	app.DBQueryTimeout = defaultDBQueryTimeout
+	app.DB = DBConfig{Host: "localhost", Port: 5432}
+	app.UploadDir = defaultUploadDir
+	app.TaxRate = 0
+	app.MaxStayNights = defaultMaxStayNights
+	app.Payments = PaymentsConfig{Provider: defaultPaymentProvider, Currency: defaultCurrency, DepositRate: defaultDepositRate}
+	app.LinkSecret = ""
+	app.BaseURL = ""
+	app.Mail = MailConfig{
+		Transport:    defaultMailTransport,
+		Host:         "localhost",
+		Port:         1025,
+		From:         defaultMailFrom,
+		OwnerAddress: defaultMailOwner,
+		Dir:          defaultMailDir,
+		Workers:      defaultMailWorkers,
+	}
+	app.Reminders = RemindersConfig{Schedule: defaultReminderSchedule, DaysBefore: defaultReminderDays, FollowUpDays: defaultFollowUpDays}
+
+	// the config file and environment decide which files are read, so resolve them first
+	if !setFlags["config"] {
+		*configFile = getenv(envPrefix + "CONFIG")
+	}
+
+	var fc fileConfig
+	if *configFile != "" {
+		b, err := os.ReadFile(*configFile)
+		if err != nil {
+			return fmt.Errorf("config: reading config file: %w", err)
+		}
+		if err = yaml.Unmarshal(b, &fc); err != nil {
+			return fmt.Errorf("config: parsing config file %s: %w", *configFile, err)
+		}
+	}
+
+	switch {
+	case setFlags["env"]:
+		app.Env = *env
+	case getenv(envPrefix+"ENV") != "":
+		app.Env = getenv(envPrefix + "ENV")
+	case fc.Env != nil:
+		app.Env = *fc.Env
+	}
+
+	// database.yml is optional unless it was asked for explicitly
+	dbFile, dbFileRequired := defaultDBConfigFile, false
+	if v := getenv(envPrefix + "DB_CONFIG"); v != "" {
+		dbFile, dbFileRequired = v, true
+	}
+	if setFlags["db-config"] {
+		dbFile, dbFileRequired = *dbConfigFile, true
+	}
+
+	dbc, err := loadDBConfigFile(dbFile, app.Env, getenv)
+	if errors.Is(err, os.ErrNotExist) && !dbFileRequired {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	// database.yml, then the config file
+	errs = append(errs, applyDBFileConfig(&app.DB, dbc, dbFile)...)
+	if *configFile != "" {
+		errs = append(errs, applyDBFileConfig(&app.DB, fc.Database, *configFile)...)
+	}
+	if fc.Port != nil {
+		app.Port = *fc.Port
+	}
+	if fc.InProduction != nil {
+		app.InProduction = *fc.InProduction
+	}
+	if fc.UseCache != nil {
+		app.UseCache = *fc.UseCache
+	}
+	if fc.UploadDir != nil {
+		app.UploadDir = *fc.UploadDir
+	}
+	if fc.TaxRate != nil {
+		app.TaxRate = *fc.TaxRate
+	}
+	if fc.MaxStayNights != nil {
+		app.MaxStayNights = *fc.MaxStayNights
+	}
+	if fc.LinkSecret != nil {
+		app.LinkSecret = *fc.LinkSecret
+	}
+	if fc.BaseURL != nil {
+		app.BaseURL = *fc.BaseURL
+	}
+	if fc.Payments.Provider != nil {
+		app.Payments.Provider = *fc.Payments.Provider
+	}
+	if fc.Payments.Currency != nil {
+		app.Payments.Currency = *fc.Payments.Currency
+	}
+	if fc.Payments.DepositRate != nil {
+		app.Payments.DepositRate = *fc.Payments.DepositRate
+	}
+	if fc.Payments.WebhookSecret != nil {
+		app.Payments.WebhookSecret = *fc.Payments.WebhookSecret
+	}
+	fileString := func(v *string, dst *string) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	fileString(fc.Mail.Transport, &app.Mail.Transport)
+	fileString(fc.Mail.Host, &app.Mail.Host)
+	fileString(fc.Mail.Username, &app.Mail.Username)
+	fileString(fc.Mail.Password, &app.Mail.Password)
+	fileString(fc.Mail.From, &app.Mail.From)
+	fileString(fc.Mail.OwnerAddress, &app.Mail.OwnerAddress)
+	fileString(fc.Mail.Dir, &app.Mail.Dir)
+	if fc.Mail.Port != nil {
+		app.Mail.Port = *fc.Mail.Port
+	}
+	if fc.Mail.Workers != nil {
+		app.Mail.Workers = *fc.Mail.Workers
+	}
+	fileString(fc.Reminders.Schedule, &app.Reminders.Schedule)
+	if fc.Reminders.DaysBefore != nil {
+		app.Reminders.DaysBefore = *fc.Reminders.DaysBefore
+	}
+	if fc.Reminders.FollowUpDays != nil {
+		app.Reminders.FollowUpDays = *fc.Reminders.FollowUpDays
+	}
+	fileDuration := func(name string, v *string, dst *time.Duration) {
+		if v == nil {
+			return
+		}
+		d, err := time.ParseDuration(*v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s must be a duration such as 15s, got %q", *configFile, name, *v))
+			return
+		}
+		*dst = d
+	}
+	fileDuration("shutdown_timeout", fc.ShutdownTimeout, &app.ShutdownTimeout)
+	fileDuration("db_timeout", fc.DBQueryTimeout, &app.DBQueryTimeout)
+
+	// environment variables
+	envInt := func(name string, dst *int) {
+		if v := getenv(envPrefix + name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s must be a number, got %q", envPrefix, name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	envBool := func(name string, dst *bool) {
+		if v := getenv(envPrefix + name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s must be true or false, got %q", envPrefix, name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	envDuration := func(name string, dst *time.Duration) {
+		if v := getenv(envPrefix + name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s must be a duration such as 15s, got %q", envPrefix, name, v))
+				return
+			}
+			*dst = d
+		}
+	}
+	envFloat := func(name string, dst *float64) {
+		if v := getenv(envPrefix + name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s must be a number, got %q", envPrefix, name, v))
+				return
+			}
+			*dst = f
+		}
+	}
+	envString := func(name string, dst *string) {
+		if v := getenv(envPrefix + name); v != "" {
+			*dst = v
+		}
+	}
+	envInt("PORT", &app.Port)
+	envBool("IN_PRODUCTION", &app.InProduction)
+	envBool("USE_CACHE", &app.UseCache)
+	envDuration("SHUTDOWN_TIMEOUT", &app.ShutdownTimeout)
+	envDuration("DB_TIMEOUT", &app.DBQueryTimeout)
+	envString("DB_HOST", &app.DB.Host)
+	envInt("DB_PORT", &app.DB.Port)
+	envString("DB_NAME", &app.DB.Name)
+	envString("DB_USER", &app.DB.User)
+	envString("DB_PASSWORD", &app.DB.Password)
+	envString("DB_SSLMODE", &app.DB.SSLMode)
+	envString("DB_URL", &app.DB.URL)
+	envString("UPLOAD_DIR", &app.UploadDir)
+	envFloat("TAX_RATE", &app.TaxRate)
+	envInt("MAX_STAY_NIGHTS", &app.MaxStayNights)
+	envString("PAYMENT_PROVIDER", &app.Payments.Provider)
+	envString("CURRENCY", &app.Payments.Currency)
+	envFloat("DEPOSIT_RATE", &app.Payments.DepositRate)
+	envString("PAYMENT_WEBHOOK_SECRET", &app.Payments.WebhookSecret)
+	envString("LINK_SECRET", &app.LinkSecret)
+	envString("BASE_URL", &app.BaseURL)
+	envString("MAIL_TRANSPORT", &app.Mail.Transport)
+	envString("SMTP_HOST", &app.Mail.Host)
+	envInt("SMTP_PORT", &app.Mail.Port)
+	envString("SMTP_USER", &app.Mail.Username)
+	envString("SMTP_PASSWORD", &app.Mail.Password)
+	envString("MAIL_FROM", &app.Mail.From)
+	envString("MAIL_OWNER", &app.Mail.OwnerAddress)
+	envString("MAIL_DIR", &app.Mail.Dir)
+	envInt("MAIL_WORKERS", &app.Mail.Workers)
+	envString("REMINDER_SCHEDULE", &app.Reminders.Schedule)
+	envInt("REMINDER_DAYS", &app.Reminders.DaysBefore)
+	envInt("FOLLOW_UP_DAYS", &app.Reminders.FollowUpDays)
+
+	// command-line flags
+	if setFlags["port"] {
+		app.Port = *port
+	}
+	if setFlags["production"] {
+		app.InProduction = *inProduction
+	}
+	if setFlags["cache"] {
+		app.UseCache = *useCache
+	}
+	if setFlags["shutdown-timeout"] {
+		app.ShutdownTimeout = *shutdownTimeout
+	}
+	if setFlags["db-host"] {
+		app.DB.Host = *dbHost
+	}
+	if setFlags["db-port"] {
+		app.DB.Port = *dbPort
+	}
+	if setFlags["db-name"] {
+		app.DB.Name = *dbName
+	}
+	if setFlags["db-user"] {
+		app.DB.User = *dbUser
+	}
+	if setFlags["db-password"] {
+		app.DB.Password = *dbPassword
+	}
+	if setFlags["db-sslmode"] {
+		app.DB.SSLMode = *dbSSLMode
+	}
+	if setFlags["db-url"] {
+		app.DB.URL = *dbURL
+	}
+	if setFlags["db-timeout"] {
+		app.DBQueryTimeout = *dbTimeout
+	}
+	if setFlags["upload-dir"] {
+		app.UploadDir = *uploadDir
+	}
+	if setFlags["tax-rate"] {
+		app.TaxRate = *taxRate
+	}
+	if setFlags["max-stay-nights"] {
+		app.MaxStayNights = *maxStayNights
+	}
+	if setFlags["payment-provider"] {
+		app.Payments.Provider = *paymentProvider
+	}
+	if setFlags["currency"] {
+		app.Payments.Currency = *currency
+	}
+	if setFlags["deposit-rate"] {
+		app.Payments.DepositRate = *depositRate
+	}
+	if setFlags["payment-webhook-secret"] {
+		app.Payments.WebhookSecret = *webhookSecret
+	}
+	if setFlags["link-secret"] {
+		app.LinkSecret = *linkSecret
+	}
+	if setFlags["base-url"] {
+		app.BaseURL = *baseURL
+	}
+	if setFlags["mail-transport"] {
+		app.Mail.Transport = *mailTransport
+	}
+	if setFlags["smtp-host"] {
+		app.Mail.Host = *smtpHost
+	}
+	if setFlags["smtp-port"] {
+		app.Mail.Port = *smtpPort
+	}
+	if setFlags["smtp-user"] {
+		app.Mail.Username = *smtpUser
+	}
+	if setFlags["smtp-password"] {
+		app.Mail.Password = *smtpPassword
+	}
+	if setFlags["mail-from"] {
+		app.Mail.From = *mailFrom
+	}
+	if setFlags["mail-owner"] {
+		app.Mail.OwnerAddress = *mailOwner
+	}
+	if setFlags["mail-dir"] {
+		app.Mail.Dir = *mailDir
+	}
+	if setFlags["mail-workers"] {
+		app.Mail.Workers = *mailWorkers
+	}
+	if setFlags["reminder-schedule"] {
+		app.Reminders.Schedule = *reminderSchedule
+	}
+	if setFlags["reminder-days"] {
+		app.Reminders.DaysBefore = *reminderDays
+	}
+	if setFlags["follow-up-days"] {
+		app.Reminders.FollowUpDays = *followUpDays
+	}
+
+	// links in emails point at the local server unless told otherwise
+	if app.BaseURL == "" {
+		app.BaseURL = fmt.Sprintf("http://localhost:%d", app.Port)
+	}
+	app.BaseURL = strings.TrimSuffix(app.BaseURL, "/")
+
+	errs = append(errs, validate(app)...)
+	if len(errs) > 0 {
+		return fmt.Errorf("config: invalid configuration:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// loadDBConfigFile reads the section for env from a soda database.yml, expanding its
+// {{env "X"}} and {{envOr "X" "default"}} template calls
+func loadDBConfigFile(path, env string, getenv func(string) string) (dbFileConfig, error) {
+	var dbc dbFileConfig
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return dbc, fmt.Errorf("config: reading database config: %w", err)
+	}
+
+	funcs := template.FuncMap{
+		"env": getenv,
+		"envOr": func(key, def string) string {
+			if v := getenv(key); v != "" {
+				return v
+			}
+			return def
+		},
+	}
+
+	t, err := template.New(path).Funcs(funcs).Parse(string(b))
+	if err != nil {
+		return dbc, fmt.Errorf("config: parsing database config %s: %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, nil); err != nil {
+		return dbc, fmt.Errorf("config: parsing database config %s: %w", path, err)
+	}
+
+	sections := map[string]dbFileConfig{}
+	if err = yaml.Unmarshal(buf.Bytes(), &sections); err != nil {
+		return dbc, fmt.Errorf("config: parsing database config %s: %w", path, err)
+	}
+
+	dbc, ok := sections[env]
+	if !ok {
+		return dbc, fmt.Errorf("config: database config %s has no %q section", path, env)
+	}
+
+	return dbc, nil
+}
+
+// applyDBFileConfig copies the settings present in dbc onto db
+func applyDBFileConfig(db *DBConfig, dbc dbFileConfig, source string) []error {
+	var errs []error
+
+	if dbc.Dialect != "" && dbc.Dialect != "postgres" {
+		errs = append(errs, fmt.Errorf("%s: unsupported dialect %q, only postgres is supported", source, dbc.Dialect))
+	}
+	if dbc.Host != "" {
+		db.Host = dbc.Host
+	}
+	if dbc.Port != "" {
+		n, err := strconv.Atoi(dbc.Port)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: database port must be a number, got %q", source, dbc.Port))
+		} else {
+			db.Port = n
+		}
+	}
+	if dbc.Database != "" {
+		db.Name = dbc.Database
+	}
+	if dbc.User != "" {
+		db.User = dbc.User
+	}
+	if dbc.Password != "" {
+		db.Password = dbc.Password
+	}
+	if v := dbc.Options["sslmode"]; v != "" {
+		db.SSLMode = v
+	}
+	if dbc.URL != "" {
+		db.URL = dbc.URL
+	}
+
+	return errs
+}
+
+// validate checks the loaded values, returning one error per problem found
+func validate(app *AppConfig) []error {
+	var errs []error
+
+	if app.Port < 1 || app.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", app.Port))
+	}
+	if app.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown timeout must be positive, got %s", app.ShutdownTimeout))
+	}
+	if app.DBQueryTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("database query timeout must be positive, got %s", app.DBQueryTimeout))
+	}
+	if !contains(validEnvs, app.Env) {
+		errs = append(errs, fmt.Errorf("env must be one of %s, got %q", strings.Join(validEnvs, ", "), app.Env))
+	}
+	if app.UploadDir == "" {
+		errs = append(errs, errors.New("upload dir cannot be empty"))
+	}
+	if app.TaxRate < 0 || app.TaxRate > 100 {
+		errs = append(errs, fmt.Errorf("tax rate must be a percentage between 0 and 100, got %g", app.TaxRate))
+	}
+	if app.MaxStayNights < 1 || app.MaxStayNights > 365 {
+		errs = append(errs, fmt.Errorf("max stay nights must be between 1 and 365, got %d", app.MaxStayNights))
+	}
+	if !contains(validPaymentProviders, app.Payments.Provider) {
+		errs = append(errs, fmt.Errorf("payment provider must be one of %s, got %q", strings.Join(validPaymentProviders, ", "), app.Payments.Provider))
+	}
+	if !currencyPattern.MatchString(app.Payments.Currency) {
+		errs = append(errs, fmt.Errorf("currency must be a lowercase three letter code such as usd, got %q", app.Payments.Currency))
+	}
+	if app.Payments.DepositRate < 0 || app.Payments.DepositRate > 100 {
+		errs = append(errs, fmt.Errorf("deposit rate must be a percentage between 0 and 100, got %g", app.Payments.DepositRate))
+	}
+	if app.InProduction && app.Payments.Provider == "fake" && app.Payments.DepositRate > 0 {
+		// the fake provider approves test cards without charging anyone and forgets its payments
+		// on restart, so it must never be what takes real guests' deposits
+		errs = append(errs, errors.New("the fake payment provider cannot take deposits in production (plug in a real payment gateway, or set -deposit-rate 0)"))
+	}
+	if app.InProduction && app.Payments.WebhookSecret == "" {
+		errs = append(errs, errors.New("a payment webhook secret is required in production (set -payment-webhook-secret or BOOKINGS_PAYMENT_WEBHOOK_SECRET)"))
+	}
+	if app.InProduction && len(app.LinkSecret) < 32 {
+		errs = append(errs, errors.New("a link secret of at least 32 characters is required in production (set -link-secret or BOOKINGS_LINK_SECRET)"))
+	}
+	if u, err := url.Parse(app.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("base url must be an http:// or https:// URL, got %q", app.BaseURL))
+	}
+	if u, err := url.Parse(app.BaseURL); app.InProduction && err == nil && isLocalHost(u.Hostname()) {
+		// links in guests' emails would point at their own machines
+		errs = append(errs, errors.New("a public base url is required in production (set -base-url or BOOKINGS_BASE_URL)"))
+	}
+	if !contains(validMailTransports, app.Mail.Transport) {
+		errs = append(errs, fmt.Errorf("mail transport must be one of %s, got %q", strings.Join(validMailTransports, ", "), app.Mail.Transport))
+	}
+	if app.InProduction && app.Mail.Transport != "smtp" {
+		errs = append(errs, fmt.Errorf("email must be sent with the smtp transport in production, got %q", app.Mail.Transport))
+	}
+	if app.Mail.Transport == "smtp" && (app.Mail.Port < 1 || app.Mail.Port > 65535) {
+		errs = append(errs, fmt.Errorf("smtp port must be between 1 and 65535, got %d", app.Mail.Port))
+	}
+	if app.Mail.Transport == "file" && app.Mail.Dir == "" {
+		errs = append(errs, errors.New("mail dir cannot be empty with the file transport"))
+	}
+	if a, err := mail.ParseAddress(app.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("mail from must be an email address, got %q", app.Mail.From))
+	} else if app.InProduction && isLocalAddress(a.Address) {
+		errs = append(errs, fmt.Errorf("a public mail from address is required in production (set -mail-from or BOOKINGS_MAIL_FROM), got %q", app.Mail.From))
+	}
+	if a, err := mail.ParseAddress(app.Mail.OwnerAddress); err != nil {
+		errs = append(errs, fmt.Errorf("mail owner must be an email address, got %q", app.Mail.OwnerAddress))
+	} else if app.InProduction && isLocalAddress(a.Address) {
+		errs = append(errs, fmt.Errorf("a public mail owner address is required in production (set -mail-owner or BOOKINGS_MAIL_OWNER), got %q", app.Mail.OwnerAddress))
+	}
+	if app.Mail.Workers < 1 {
+		errs = append(errs, fmt.Errorf("mail workers must be at least 1, got %d", app.Mail.Workers))
+	}
+	if _, err := scheduler.Parse(app.Reminders.Schedule); err != nil {
+		errs = append(errs, fmt.Errorf("reminder schedule must be a cron expression: %w", err))
+	}
+	if app.Reminders.DaysBefore < 1 || app.Reminders.DaysBefore > 60 {
+		errs = append(errs, fmt.Errorf("reminder days must be between 1 and 60, got %d", app.Reminders.DaysBefore))
+	}
+	if app.Reminders.FollowUpDays < 0 || app.Reminders.FollowUpDays > 60 {
+		errs = append(errs, fmt.Errorf("follow-up days must be between 0 and 60, got %d", app.Reminders.FollowUpDays))
+	}
+
+	if app.DB.URL != "" {
+		u, err := url.Parse(app.DB.URL)
+		if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			errs = append(errs, errors.New("database url must be a postgres:// or postgresql:// URL"))
+		}
+		return errs
+	}
+
+	if app.InProduction && !app.DB.Configured() {
+		errs = append(errs, errors.New("a database is required in production (set -db-name, BOOKINGS_DB_NAME, or a database.yml)"))
+	}
+	if app.DB.Port < 1 || app.DB.Port > 65535 {
+		errs = append(errs, fmt.Errorf("database port must be between 1 and 65535, got %d", app.DB.Port))
+	}
+	if app.DB.SSLMode != "" && !contains(validSSLModes, app.DB.SSLMode) {
+		errs = append(errs, fmt.Errorf("database sslmode must be one of %s, got %q", strings.Join(validSSLModes, ", "), app.DB.SSLMode))
+	}
+
+	return errs
+}
+
+// isLocalHost reports whether host names this machine, as the development defaults do
+func isLocalHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+// isLocalAddress reports whether the email address addr is at a local host
+func isLocalAddress(addr string) bool {
+	return isLocalHost(addr[strings.LastIndex(addr, "@")+1:])
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -3,11 +3,23 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 )
 
+// slugPattern matches lowercase words joined by single hyphens
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// codePattern matches a promo code such as "SUMMER10"
+var codePattern = regexp.MustCompile(`^[A-Z0-9-]{3,32}$`)
+
+// amountPattern matches an amount of money in dollars, with at most two decimal places
+var amountPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+
 // Form defines a custom form struct
 type Form struct {
 	url.Values
@@ -57,3 +69,39 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// IsInt checks for a whole number between min and max inclusive
+func (f *Form) IsInt(field string, min, max int) {
+	n, err := strconv.Atoi(strings.TrimSpace(f.Get(field)))
+	if err != nil || n < min || n > max {
+		f.Errors.Add(field, fmt.Sprintf("This field must be a whole number from %d to %d", min, max))
+	}
+}
+
+// IsSlug checks for a URL slug such as "majors-suite"
+func (f *Form) IsSlug(field string) {
+	if !slugPattern.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use only lowercase letters and numbers, separated by single hyphens")
+	}
+}
+
+// IsAmount checks for an amount of money such as "120" or "99.50"
+func (f *Form) IsAmount(field string) {
+	if !amountPattern.MatchString(strings.TrimSpace(f.Get(field))) {
+		f.Errors.Add(field, "Enter an amount such as 120 or 99.50")
+	}
+}
+
+// IsDate checks for a date in YYYY-MM-DD format
+func (f *Form) IsDate(field string) {
+	if _, err := time.Parse("2006-01-02", strings.TrimSpace(f.Get(field))); err != nil {
+		f.Errors.Add(field, "Enter a date in YYYY-MM-DD format")
+	}
+}
+
+// IsCode checks for a promo code of 3 to 32 upper case letters, numbers and hyphens
+func (f *Form) IsCode(field string) {
+	if !codePattern.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use 3 to 32 letters, numbers and hyphens")
+	}
+}
@@ -118,3 +118,116 @@ func TestForm_Email(t *testing.T) {
 		t.Error("form shows invalid email for valid email format")
 	}
 }
+
+func TestForm_IsInt(t *testing.T) {
+	var intTests = []struct {
+		value string
+		valid bool
+	}{
+		{"1", true},
+		{" 20 ", true},
+		{"0", false},
+		{"21", false},
+		{"2.5", false},
+		{"two", false},
+		{"", false},
+	}
+
+	for _, test := range intTests {
+		form := New(url.Values{"x": {test.value}})
+		form.IsInt("x", 1, 20)
+		if form.Valid() != test.valid {
+			t.Errorf("for %q, expected valid to be %t", test.value, test.valid)
+		}
+	}
+}
+
+func TestForm_IsSlug(t *testing.T) {
+	var slugTests = []struct {
+		value string
+		valid bool
+	}{
+		{"majors-suite", true},
+		{"room-2", true},
+		{"Majors-Suite", false},
+		{"majors--suite", false},
+		{"-majors", false},
+		{"majors suite", false},
+		{"", false},
+	}
+
+	for _, test := range slugTests {
+		form := New(url.Values{"x": {test.value}})
+		form.IsSlug("x")
+		if form.Valid() != test.valid {
+			t.Errorf("for %q, expected valid to be %t", test.value, test.valid)
+		}
+	}
+}
+
+func TestForm_IsAmount(t *testing.T) {
+	var amountTests = []struct {
+		value string
+		valid bool
+	}{
+		{"120", true},
+		{"99.50", true},
+		{"99.5", true},
+		{" 0 ", true},
+		{"99.", false},
+		{"99.505", false},
+		{"-5", false},
+		{"1,200", false},
+		{"", false},
+	}
+
+	for _, test := range amountTests {
+		form := New(url.Values{"x": {test.value}})
+		form.IsAmount("x")
+		if form.Valid() != test.valid {
+			t.Errorf("for %q, expected valid to be %t", test.value, test.valid)
+		}
+	}
+}
+
+func TestForm_IsDate(t *testing.T) {
+	var dateTests = []struct {
+		value string
+		valid bool
+	}{
+		{"2050-02-01", true},
+		{"2050-02-30", false},
+		{"02/01/2050", false},
+		{"", false},
+	}
+
+	for _, test := range dateTests {
+		form := New(url.Values{"x": {test.value}})
+		form.IsDate("x")
+		if form.Valid() != test.valid {
+			t.Errorf("for %q, expected valid to be %t", test.value, test.valid)
+		}
+	}
+}
+
+func TestForm_IsCode(t *testing.T) {
+	var codeTests = []struct {
+		value string
+		valid bool
+	}{
+		{"SUMMER10", true},
+		{"EARLY-BIRD", true},
+		{"summer10", false},
+		{"AB", false},
+		{"SUMMER 10", false},
+		{"", false},
+	}
+
+	for _, test := range codeTests {
+		form := New(url.Values{"x": {test.value}})
+		form.IsCode("x")
+		if form.Valid() != test.valid {
+			t.Errorf("for %q, expected valid to be %t", test.value, test.valid)
+		}
+	}
+}
@@ -0,0 +1,759 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/forms"
+	"github.com/jeremydelacruz/go-bookings/internal/lifecycle"
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/payments"
+	"github.com/jeremydelacruz/go-bookings/internal/pricing"
+	"github.com/jeremydelacruz/go-bookings/internal/repository"
+	"github.com/jeremydelacruz/go-bookings/internal/tokens"
+)
+
+// APIPrefix is where version 1 of the JSON API is served
+const APIPrefix = "/api/v1"
+
+// limits of the page size of API lists, chosen with the per_page parameter
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// maxAPIBody caps the JSON bodies the API reads
+const maxAPIBody = 1 << 20
+
+// lastUsedInterval is how stale the last use of an API key may get before APIAuth records a
+// new one, saving a write on every request
+const lastUsedInterval = time.Minute
+
+// apiKeyContextKey is the context key APIAuth keeps the request's API key under
+type apiKeyContextKey struct{}
+
+// apiError is the body of every API error response, such as
+// {"error": {"code": "not_found", "message": "No such room"}}. Code is meant for programs and
+// does not change; Fields holds the problems with each field of the request, if any.
+type apiError struct {
+	Error struct {
+		Code    string              `json:"code"`
+		Message string              `json:"message"`
+		Fields  map[string][]string `json:"fields,omitempty"`
+	} `json:"error"`
+}
+
+// apiData is the body of a successful API response; lists also have their pagination
+type apiData struct {
+	Data       interface{}    `json:"data"`
+	Pagination *apiPagination `json:"pagination,omitempty"`
+}
+
+// apiPagination says which part of a list a response holds. Pages are numbered from 1.
+type apiPagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// apiRoom is a room as the API shows it; prices are in cents of Currency
+type apiRoom struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description"`
+	Capacity    int      `json:"capacity"`
+	Amenities   []string `json:"amenities"`
+	Images      []string `json:"images"`
+	NightlyRate int      `json:"nightly_rate"`
+	Currency    string   `json:"currency"`
+}
+
+// apiAvailability answers whether a room is free for a stay
+type apiAvailability struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Available bool   `json:"available"`
+}
+
+// apiQuote is the price of a stay in a room
+type apiQuote struct {
+	RoomID    int          `json:"room_id"`
+	StartDate string       `json:"start_date"`
+	EndDate   string       `json:"end_date"`
+	Currency  string       `json:"currency"`
+	Price     models.Quote `json:"price"`
+}
+
+// apiReservation is a reservation as the API shows it. It is found by its confirmation code,
+// which only the guest and the client that booked it know.
+type apiReservation struct {
+	ConfirmationCode string       `json:"confirmation_code"`
+	Status           string       `json:"status"`
+	RoomID           int          `json:"room_id"`
+	RoomName         string       `json:"room_name"`
+	StartDate        string       `json:"start_date"`
+	EndDate          string       `json:"end_date"`
+	FirstName        string       `json:"first_name"`
+	LastName         string       `json:"last_name"`
+	Email            string       `json:"email"`
+	Phone            string       `json:"phone"`
+	Currency         string       `json:"currency"`
+	Price            models.Quote `json:"price"`
+	ManageURL        string       `json:"manage_url"`
+	CreatedAt        time.Time    `json:"created_at"`
+	CancelledAt      *time.Time   `json:"cancelled_at,omitempty"`
+}
+
+// apiReservationRequest is the body of a request to book a room. PaymentToken stands for the
+// card the deposit is held on, and is only needed when the stay has a deposit.
+type apiReservationRequest struct {
+	RoomID       int    `json:"room_id"`
+	StartDate    string `json:"start_date"`
+	EndDate      string `json:"end_date"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Email        string `json:"email"`
+	Phone        string `json:"phone"`
+	PromoCode    string `json:"promo_code"`
+	PaymentToken string `json:"payment_token"`
+}
+
+// APIRooms lists the rooms guests can book, a page at a time
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	page, perPage, form := apiPage(r.URL.Query())
+	if !form.Valid() {
+		apiFieldErrors(w, http.StatusBadRequest, "bad_request", form)
+		return
+	}
+
+	rooms, err := m.DB.AllActiveRooms(r.Context())
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	m.writeRoomPage(w, rooms, page, perPage)
+}
+
+// APIRoom shows the room whose ID is in the URL, if guests can book it
+func (m *Repository) APIRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoom(w, r)
+	if !ok {
+		return
+	}
+
+	writeAPI(w, http.StatusOK, apiData{Data: m.toAPIRoom(room)})
+}
+
+// APIAvailability lists the rooms free for the stay from start_date to end_date, a page at a time
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start, end, form := m.apiStay(query)
+	page, perPage, pageForm := apiPage(query)
+	for field, messages := range pageForm.Errors {
+		form.Errors[field] = messages
+	}
+	if !form.Valid() {
+		apiFieldErrors(w, http.StatusBadRequest, "bad_request", form)
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), start, end)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	m.writeRoomPage(w, rooms, page, perPage)
+}
+
+// APIRoomAvailability says whether the room whose ID is in the URL is free for the stay from
+// start_date to end_date
+func (m *Repository) APIRoomAvailability(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoom(w, r)
+	if !ok {
+		return
+	}
+
+	start, end, form := m.apiStay(r.URL.Query())
+	if !form.Valid() {
+		apiFieldErrors(w, http.StatusBadRequest, "bad_request", form)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), start, end, room.ID, 0)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	writeAPI(w, http.StatusOK, apiData{Data: apiAvailability{
+		RoomID:    room.ID,
+		StartDate: start.Format("2006-01-02"),
+		EndDate:   end.Format("2006-01-02"),
+		Available: available,
+	}})
+}
+
+// APIQuote prices the stay from start_date to end_date in the room whose ID is in the URL, with
+// the promo code in promo_code if there is one. It does not check the room is free.
+func (m *Repository) APIQuote(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoom(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	start, end, form := m.apiStay(query)
+	if !form.Valid() {
+		apiFieldErrors(w, http.StatusBadRequest, "bad_request", form)
+		return
+	}
+
+	promo, err := m.checkPromoCode(r.Context(), form, models.Reservation{
+		RoomID:    room.ID,
+		StartDate: start,
+		EndDate:   end,
+		PromoCode: pricing.NormalizeCode(query.Get("promo_code")),
+	})
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	if !form.Valid() {
+		apiFieldErrors(w, http.StatusUnprocessableEntity, "validation_failed", form)
+		return
+	}
+
+	price, err := m.quote(r.Context(), room, start, end, promo)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	writeAPI(w, http.StatusOK, apiData{Data: apiQuote{
+		RoomID:    room.ID,
+		StartDate: start.Format("2006-01-02"),
+		EndDate:   end.Format("2006-01-02"),
+		Currency:  m.App.Payments.Currency,
+		Price:     price,
+	}})
+}
+
+// APICreateReservation books a room as PostReservation does, from a JSON body, answering with
+// the new reservation
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	var req apiReservationRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("The body must be a reservation in JSON: %v", err))
+		return
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "The body must hold a single JSON object")
+		return
+	}
+
+	// the fields are checked as the reservation form's are
+	form := forms.New(url.Values{
+		"start_date": {req.StartDate},
+		"end_date":   {req.EndDate},
+		"first_name": {req.FirstName},
+		"last_name":  {req.LastName},
+		"email":      {req.Email},
+	})
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+	start, end := m.checkStay(form)
+
+	room, err := m.DB.GetRoomByID(r.Context(), req.RoomID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		form.Errors.Add("room_id", "No such room")
+	} else if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName: strings.TrimSpace(req.FirstName),
+		LastName:  strings.TrimSpace(req.LastName),
+		Email:     strings.TrimSpace(req.Email),
+		Phone:     strings.TrimSpace(req.Phone),
+		StartDate: start,
+		EndDate:   end,
+		RoomID:    room.ID,
+		Room:      room,
+		PromoCode: pricing.NormalizeCode(req.PromoCode),
+		APIKeyID:  requestAPIKey(r).ID,
+	}
+
+	if form.Valid() {
+		promo, err := m.checkPromoCode(r.Context(), form, reservation)
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+
+		reservation.Price, err = m.quote(r.Context(), room, start, end, promo)
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+		if reservation.Price.Deposit > 0 && req.PaymentToken == "" {
+			form.Errors.Add("payment_token", "A card is needed for the deposit")
+		}
+	}
+	if !form.Valid() {
+		apiFieldErrors(w, http.StatusUnprocessableEntity, "validation_failed", form)
+		return
+	}
+
+	// no deposit is held for a room that is already taken
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), start, end, room.ID, 0)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	if !available {
+		writeAPIError(w, http.StatusConflict, "room_unavailable", "The room is not available for those dates")
+		return
+	}
+
+	reservation.ConfirmationCode, err = tokens.NewConfirmationCode()
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	if reservation.Price.Deposit > 0 {
+		reservation.Payment, err = m.authorizeDeposit(r.Context(), reservation, req.PaymentToken)
+		if errors.Is(err, payments.ErrDeclined) {
+			writeAPIError(w, http.StatusPaymentRequired, "payment_declined", "The card was declined")
+			return
+		}
+		if err != nil {
+			m.App.ErrorLog.Println("authorizing deposit:", err)
+			writeAPIError(w, http.StatusBadGateway, "payment_failed", "The deposit cannot be taken, please try again later")
+			return
+		}
+	}
+
+	id, err := m.DB.CreateReservation(r.Context(), reservation)
+	if err != nil {
+		m.releaseDeposit(reservation.Payment)
+	}
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		writeAPIError(w, http.StatusConflict, "room_unavailable", "The room is not available for those dates")
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		// the room was taken off the site since it was checked above
+		writeAPIError(w, http.StatusConflict, "room_unavailable", "The room can no longer be booked")
+		return
+	}
+	if errors.Is(err, repository.ErrPromoCodeUnavailable) {
+		writeAPIError(w, http.StatusConflict, "promo_code_unavailable", "The promo code has just been used up")
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	reservation.ID = id
+
+	m.sendReservationEmails(reservation)
+
+	// the stored reservation has the status and times the database gave it
+	if saved, err := m.DB.GetReservationByID(r.Context(), id); err == nil {
+		reservation = saved
+	} else {
+		m.App.ErrorLog.Printf("reading back reservation %d: %v", id, err)
+		reservation.Status = models.ReservationPending
+	}
+
+	w.Header().Set("Location", APIPrefix+"/reservations/"+reservation.ConfirmationCode)
+	writeAPI(w, http.StatusCreated, apiData{Data: m.toAPIReservation(reservation)})
+}
+
+// APIReservation shows the reservation whose confirmation code is in the URL, when the request's
+// API key booked it or has the admin scope
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservation(w, r)
+	if !ok {
+		return
+	}
+
+	writeAPI(w, http.StatusOK, apiData{Data: m.toAPIReservation(res)})
+}
+
+// APICancelReservation cancels the reservation whose confirmation code is in the URL, as the
+// guest would from their manage link, when the request's API key booked it or has the admin scope
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservation(w, r)
+	if !ok {
+		return
+	}
+
+	if stayStarted(res) {
+		writeAPIError(w, http.StatusConflict, "not_cancellable", "This reservation can no longer be cancelled")
+		return
+	}
+
+	err := m.changeStatus(r.Context(), res, models.ReservationCancelled, lifecycle.Guest)
+	if errors.Is(err, lifecycle.ErrIllegalTransition) || errors.Is(err, repository.ErrStatusConflict) {
+		writeAPIError(w, http.StatusConflict, "not_cancellable", "This reservation can no longer be cancelled")
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	res, err = m.DB.GetReservationByID(r.Context(), res.ID)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	writeAPI(w, http.StatusOK, apiData{Data: m.toAPIReservation(res)})
+}
+
+// APIReservations lists every reservation, newest first, a page at a time
+func (m *Repository) APIReservations(w http.ResponseWriter, r *http.Request) {
+	page, perPage, form := apiPage(r.URL.Query())
+	if !form.Valid() {
+		apiFieldErrors(w, http.StatusBadRequest, "bad_request", form)
+		return
+	}
+
+	reservations, err := m.DB.AllReservations(r.Context())
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	from, to := pageBounds(len(reservations), page, perPage)
+
+	data := make([]apiReservation, 0, to-from)
+	for _, res := range reservations[from:to] {
+		data = append(data, m.toAPIReservation(res))
+	}
+
+	writeAPI(w, http.StatusOK, apiData{
+		Data:       data,
+		Pagination: newPagination(len(reservations), page, perPage),
+	})
+}
+
+// APINotFound answers requests for API paths that do not exist
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, "not_found", "No such API endpoint")
+}
+
+// APIMethodNotAllowed answers requests to API paths with a method they do not take
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("%s is not allowed here", r.Method))
+}
+
+// APIAuth lets through requests carrying a current API key as a bearer token, as in
+// "Authorization: Bearer bk_...", answering the others with 401. The key is recorded as used,
+// at most once every lastUsedInterval, and put in the request's context for RequireScope.
+func (m *Repository) APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, key, _ := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+		key = strings.TrimSpace(key)
+		if !strings.EqualFold(scheme, "Bearer") || key == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "An API key is required")
+			return
+		}
+
+		k, err := m.DB.GetAPIKeyByHash(r.Context(), tokens.HashAPIKey(key))
+		now := time.Now()
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "The API key is unknown, revoked or expired")
+			return
+		}
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+
+		if now.Sub(k.LastUsedAt) >= lastUsedInterval {
+			err = m.DB.UpdateLastUsedForAPIKey(r.Context(), k.ID, now)
+			if err != nil {
+				m.App.ErrorLog.Printf("recording use of API key %d: %v", k.ID, err)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)))
+	})
+}
+
+// RequireScope answers requests with 403 unless the API key APIAuth found has scope, or the
+// admin scope, which allows everything
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasScope(requestAPIKey(r), scope) {
+				writeAPIError(w, http.StatusForbidden, "insufficient_scope", fmt.Sprintf("The API key needs the %s scope", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestAPIKey returns the API key APIAuth found for r
+func requestAPIKey(r *http.Request) models.APIKey {
+	k, _ := r.Context().Value(apiKeyContextKey{}).(models.APIKey)
+	return k
+}
+
+// hasScope reports whether k has scope, or the admin scope, which allows everything
+func hasScope(k models.APIKey, scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == models.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// apiRoom looks up the bookable room whose ID follows "rooms" in the URL, answering with an
+// error when there is none
+func (m *Repository) apiRoom(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(apiPathParam(r, "rooms"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No such room")
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No such room")
+		return models.Room{}, false
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return models.Room{}, false
+	}
+
+	return room, true
+}
+
+// apiReservation looks up the reservation whose confirmation code follows "reservations" in the
+// URL, answering with an error when there is none or the request's API key may not see it
+func (m *Repository) apiReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	code := apiPathParam(r, "reservations")
+	if len(code) != tokens.CodeLength {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No such reservation")
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByCode(r.Context(), code)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		m.apiServerError(w, err)
+		return models.Reservation{}, false
+	}
+
+	// reservations made on the site or by another client look as if they do not exist
+	k := requestAPIKey(r)
+	owned := k.ID != 0 && res.APIKeyID == k.ID
+	if err != nil || (!owned && !hasScope(k, models.ScopeAdmin)) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No such reservation")
+		return models.Reservation{}, false
+	}
+
+	return res, true
+}
+
+// apiPathParam returns the part of the URL path following the part named after
+func apiPathParam(r *http.Request, after string) string {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, APIPrefix+"/"), "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == after {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// apiPage reads the page and per_page parameters of a list, returning a form with any problems
+func apiPage(query url.Values) (int, int, *forms.Form) {
+	form := forms.New(query)
+	page, perPage := 1, defaultPageSize
+
+	if form.Has("page") {
+		form.IsInt("page", 1, 1<<20)
+		page, _ = strconv.Atoi(form.Get("page"))
+	}
+	if form.Has("per_page") {
+		form.IsInt("per_page", 1, maxPageSize)
+		perPage, _ = strconv.Atoi(form.Get("per_page"))
+	}
+
+	return page, perPage, form
+}
+
+// apiStay reads the start_date and end_date parameters of a stay, returning a form with any
+// problems
+func (m *Repository) apiStay(query url.Values) (time.Time, time.Time, *forms.Form) {
+	form := forms.New(query)
+	start, end := m.checkStay(form)
+	return start, end, form
+}
+
+// checkStay checks the start_date and end_date fields of form are a stay guests may book, as
+// validateStay decides, and returns its dates
+func (m *Repository) checkStay(form *forms.Form) (time.Time, time.Time) {
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+	if !form.Valid() {
+		return time.Time{}, time.Time{}
+	}
+
+	start, _ := time.Parse("2006-01-02", strings.TrimSpace(form.Get("start_date")))
+	end, _ := time.Parse("2006-01-02", strings.TrimSpace(form.Get("end_date")))
+	addStayError(form, m.validateStay(start, end))
+
+	return start, end
+}
+
+// writeRoomPage answers with page of rooms, perPage to a page
+func (m *Repository) writeRoomPage(w http.ResponseWriter, rooms []models.Room, page, perPage int) {
+	from, to := pageBounds(len(rooms), page, perPage)
+
+	data := make([]apiRoom, 0, to-from)
+	for _, room := range rooms[from:to] {
+		data = append(data, m.toAPIRoom(room))
+	}
+
+	writeAPI(w, http.StatusOK, apiData{
+		Data:       data,
+		Pagination: newPagination(len(rooms), page, perPage),
+	})
+}
+
+// pageBounds returns where page of a list of total items, perPage to a page, starts and ends
+func pageBounds(total, page, perPage int) (int, int) {
+	from := (page - 1) * perPage
+	if from > total {
+		from = total
+	}
+	to := from + perPage
+	if to > total {
+		to = total
+	}
+	return from, to
+}
+
+// newPagination describes page of a list of total items, perPage to a page
+func newPagination(total, page, perPage int) *apiPagination {
+	return &apiPagination{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: (total + perPage - 1) / perPage,
+	}
+}
+
+// toAPIRoom returns room as the API shows it, with absolute image URLs
+func (m *Repository) toAPIRoom(room models.Room) apiRoom {
+	images := make([]string, 0, len(room.Images))
+	for _, img := range room.Images {
+		if strings.HasPrefix(img, "/") {
+			img = m.App.BaseURL + img
+		}
+		images = append(images, img)
+	}
+	amenities := append([]string{}, room.Amenities...)
+
+	return apiRoom{
+		ID:          room.ID,
+		Name:        room.RoomName,
+		Slug:        room.Slug,
+		Description: room.Description,
+		Capacity:    room.Capacity,
+		Amenities:   amenities,
+		Images:      images,
+		NightlyRate: room.NightlyRate,
+		Currency:    m.App.Payments.Currency,
+	}
+}
+
+// toAPIReservation returns res as the API shows it
+func (m *Repository) toAPIReservation(res models.Reservation) apiReservation {
+	out := apiReservation{
+		ConfirmationCode: res.ConfirmationCode,
+		Status:           res.Status,
+		RoomID:           res.RoomID,
+		RoomName:         res.Room.RoomName,
+		StartDate:        res.StartDate.Format("2006-01-02"),
+		EndDate:          res.EndDate.Format("2006-01-02"),
+		FirstName:        res.FirstName,
+		LastName:         res.LastName,
+		Email:            res.Email,
+		Phone:            res.Phone,
+		Currency:         m.App.Payments.Currency,
+		Price:            res.Price,
+		ManageURL:        m.App.BaseURL + m.manageURL(res.ConfirmationCode),
+		CreatedAt:        res.CreatedAt,
+	}
+	if !res.CancelledAt.IsZero() {
+		out.CancelledAt = &res.CancelledAt
+	}
+	return out
+}
+
+// apiServerError logs err and answers with an internal error, hiding its details
+func (m *Repository) apiServerError(w http.ResponseWriter, err error) {
+	m.App.ErrorLog.Printf("%s\n%s", err.Error(), debug.Stack())
+	writeAPIError(w, http.StatusInternalServerError, "internal_error", "Something went wrong, please try again later")
+}
+
+// apiFieldErrors answers that the request's fields have the problems found in form
+func apiFieldErrors(w http.ResponseWriter, status int, code string, form *forms.Form) {
+	var body apiError
+	body.Error.Code = code
+	body.Error.Message = "Some fields are invalid"
+	body.Error.Fields = form.Errors
+	writeAPI(w, status, body)
+}
+
+// writeAPIError answers with an error of status with code and message
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	var body apiError
+	body.Error.Code = code
+	body.Error.Message = message
+	writeAPI(w, status, body)
+}
+
+// writeAPI answers with body in JSON
+func writeAPI(w http.ResponseWriter, status int, body interface{}) {
+	out, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/payments"
+	"github.com/jeremydelacruz/go-bookings/internal/tokens"
+)
+
+// apiResponse is an API response body, with the data left for the test to decode
+type apiResponse struct {
+	Data       json.RawMessage `json:"data"`
+	Pagination *apiPagination  `json:"pagination"`
+	Error      *struct {
+		Code    string              `json:"code"`
+		Message string              `json:"message"`
+		Fields  map[string][]string `json:"fields"`
+	} `json:"error"`
+}
+
+// callAPI sends a request to the API through the routes with testAPIKey, checking the answer
+// is JSON
+func callAPI(t *testing.T, method, path, body string) (*httptest.ResponseRecorder, apiResponse) {
+	t.Helper()
+	return callAPIWithKey(t, testAPIKey, method, path, body)
+}
+
+// callAPIWithKey is callAPI with key instead, sending no key when it is empty
+func callAPIWithKey(t *testing.T, key, method, path, body string) (*httptest.ResponseRecorder, apiResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resRecorder := httptest.NewRecorder()
+	getRoutes().ServeHTTP(resRecorder, req)
+
+	var res apiResponse
+	if ct := resRecorder.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("for %s %s, expected a JSON answer, got %s", method, path, ct)
+	}
+	if err := json.Unmarshal(resRecorder.Body.Bytes(), &res); err != nil {
+		t.Errorf("for %s %s, failed to parse json %q", method, path, resRecorder.Body.String())
+	}
+	return resRecorder, res
+}
+
+func TestRepository_APIErrors(t *testing.T) {
+	var errorTests = []struct {
+		name          string
+		method        string
+		url           string
+		body          string
+		failOn        string
+		expectedCode  int
+		expectedError string
+		expectedField string
+	}{
+		{"unknown path", "GET", "/api/v1/nope", "", "", http.StatusNotFound, "not_found", ""},
+		{"wrong method", "DELETE", "/api/v1/rooms", "", "", http.StatusMethodNotAllowed, "method_not_allowed", ""},
+		{"bad page", "GET", "/api/v1/rooms?page=0", "", "", http.StatusBadRequest, "bad_request", "page"},
+		{"page too large", "GET", "/api/v1/rooms?per_page=500", "", "", http.StatusBadRequest, "bad_request", "per_page"},
+		{"rooms database error", "GET", "/api/v1/rooms", "", "AllActiveRooms", http.StatusInternalServerError, "internal_error", ""},
+		{"unknown room", "GET", "/api/v1/rooms/999", "", "", http.StatusNotFound, "not_found", ""},
+		{"room id not a number", "GET", "/api/v1/rooms/general", "", "", http.StatusNotFound, "not_found", ""},
+		{"room database error", "GET", "/api/v1/rooms/1", "", "GetRoomByID", http.StatusInternalServerError, "internal_error", ""},
+		{"missing dates", "GET", "/api/v1/availability", "", "", http.StatusBadRequest, "bad_request", "start_date"},
+		{"dates backwards", "GET", "/api/v1/availability?start_date=2050-03-03&end_date=2050-03-01", "", "", http.StatusBadRequest, "bad_request", "end_date"},
+		{"stay in the past", "GET", "/api/v1/rooms/1/availability?start_date=2001-03-01&end_date=2001-03-03", "", "", http.StatusBadRequest, "bad_request", "start_date"},
+		{"stay too long", "GET", "/api/v1/rooms/1/quote?start_date=2050-03-01&end_date=9999-12-31", "", "", http.StatusBadRequest, "bad_request", "end_date"},
+		{"reservation too long", "POST", "/api/v1/reservations", `{"room_id": 1, "start_date": "2086-01-01", "end_date": "2086-03-01", "first_name": "Jane", "last_name": "Doe", "email": "jane@doe.com"}`, "", http.StatusUnprocessableEntity, "validation_failed", "end_date"},
+		{"availability database error", "GET", "/api/v1/availability?start_date=2050-03-01&end_date=2050-03-03", "", "SearchAvailabilityForAllRooms", http.StatusInternalServerError, "internal_error", ""},
+		{"unknown promo code", "GET", "/api/v1/rooms/1/quote?start_date=2050-03-01&end_date=2050-03-03&promo_code=nope", "", "", http.StatusUnprocessableEntity, "validation_failed", "promo_code"},
+		{"body not json", "POST", "/api/v1/reservations", "first_name=Jane", "", http.StatusBadRequest, "bad_request", ""},
+		{"unknown field", "POST", "/api/v1/reservations", `{"room": 1}`, "", http.StatusBadRequest, "bad_request", ""},
+		{"two objects", "POST", "/api/v1/reservations", `{} {}`, "", http.StatusBadRequest, "bad_request", ""},
+		{"invalid reservation", "POST", "/api/v1/reservations", `{"room_id": 999, "start_date": "2086-01-01", "end_date": "2086-01-03", "first_name": "Jane"}`, "", http.StatusUnprocessableEntity, "validation_failed", "room_id"},
+		{"room taken", "POST", "/api/v1/reservations", `{"room_id": 1, "start_date": "2050-02-02", "end_date": "2050-02-03", "first_name": "Jane", "last_name": "Doe", "email": "jane@doe.com"}`, "", http.StatusConflict, "room_unavailable", ""},
+		{"unknown reservation", "GET", "/api/v1/reservations/ABCD2345EFGH", "", "", http.StatusNotFound, "not_found", ""},
+		{"malformed code", "GET", "/api/v1/reservations/abc", "", "", http.StatusNotFound, "not_found", ""},
+	}
+
+	for _, test := range errorTests {
+		if test.failOn != "" {
+			failOn(test.failOn)
+		}
+		resRecorder, res := callAPI(t, test.method, test.url, test.body)
+		clearFailures()
+
+		if resRecorder.Code != test.expectedCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedCode, resRecorder.Code)
+		}
+		if res.Error == nil || res.Error.Code != test.expectedError || res.Error.Message == "" {
+			t.Errorf("for %s, expected a %s error, got %s", test.name, test.expectedError, resRecorder.Body.String())
+			continue
+		}
+		if test.expectedField != "" && len(res.Error.Fields[test.expectedField]) == 0 {
+			t.Errorf("for %s, expected a problem with %s, got %v", test.name, test.expectedField, res.Error.Fields)
+		}
+	}
+}
+
+func TestRepository_APIRooms(t *testing.T) {
+	rooms, err := Repo.DB.AllActiveRooms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resRecorder, res := callAPI(t, "GET", "/api/v1/rooms?page=2&per_page=1", "")
+	if resRecorder.Code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, resRecorder.Code)
+	}
+	var page []apiRoom
+	if err = json.Unmarshal(res.Data, &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].ID != rooms[1].ID || page[0].Currency != "usd" {
+		t.Errorf("expected the second room, got %+v", page)
+	}
+	expected := apiPagination{Page: 2, PerPage: 1, Total: len(rooms), TotalPages: len(rooms)}
+	if res.Pagination == nil || *res.Pagination != expected {
+		t.Errorf("expected pagination %+v, got %+v", expected, res.Pagination)
+	}
+
+	// a page past the end is empty rather than missing
+	_, res = callAPI(t, "GET", "/api/v1/rooms?page=99", "")
+	if string(res.Data) != "[]" {
+		t.Errorf("expected an empty page, got %s", res.Data)
+	}
+
+	_, res = callAPI(t, "GET", "/api/v1/rooms/1", "")
+	var room apiRoom
+	if err = json.Unmarshal(res.Data, &room); err != nil {
+		t.Fatal(err)
+	}
+	if room.ID != 1 || room.Name != rooms[0].RoomName || room.Amenities == nil {
+		t.Errorf("unexpected room %+v", room)
+	}
+}
+
+func TestRepository_APIAvailability(t *testing.T) {
+	// rooms 1 and 2 are booked from 2050-02-01 to 2050-02-05
+	_, res := callAPI(t, "GET", "/api/v1/availability?start_date=2050-02-02&end_date=2050-02-03", "")
+	var rooms []apiRoom
+	if err := json.Unmarshal(res.Data, &rooms); err != nil {
+		t.Fatal(err)
+	}
+	for _, room := range rooms {
+		if room.ID == 1 || room.ID == 2 {
+			t.Errorf("expected room %d not to be available", room.ID)
+		}
+	}
+
+	var availabilityTests = []struct {
+		url       string
+		available bool
+	}{
+		{"/api/v1/rooms/1/availability?start_date=2050-02-02&end_date=2050-02-03", false},
+		{"/api/v1/rooms/1/availability?start_date=2050-02-05&end_date=2050-02-07", true},
+	}
+	for _, test := range availabilityTests {
+		resRecorder, res := callAPI(t, "GET", test.url, "")
+		var a apiAvailability
+		if err := json.Unmarshal(res.Data, &a); err != nil {
+			t.Fatal(err)
+		}
+		if resRecorder.Code != http.StatusOK || a.Available != test.available || a.RoomID != 1 {
+			t.Errorf("for %s, expected available %t, got %d %+v", test.url, test.available, resRecorder.Code, a)
+		}
+	}
+
+	resRecorder, res := callAPI(t, "GET", "/api/v1/rooms/1/quote?start_date=2050-03-01&end_date=2050-03-03", "")
+	var q apiQuote
+	if err := json.Unmarshal(res.Data, &q); err != nil {
+		t.Fatal(err)
+	}
+	if resRecorder.Code != http.StatusOK || len(q.Price.Nights) != 2 || q.Price.Total == 0 || q.StartDate != "2050-03-01" {
+		t.Errorf("unexpected quote %d %+v", resRecorder.Code, q)
+	}
+}
+
+func TestRepository_APIReservations(t *testing.T) {
+	drainMail()
+	body := `{"room_id": 1, "start_date": "2086-01-01", "end_date": "2086-01-03", "first_name": "Jane", "last_name": "Doe", "email": "jane@doe.com", "phone": "555-1234"}`
+
+	resRecorder, res := callAPI(t, "POST", "/api/v1/reservations", body)
+	if resRecorder.Code != http.StatusCreated {
+		t.Fatalf("expected %d but got %d: %s", http.StatusCreated, resRecorder.Code, resRecorder.Body.String())
+	}
+	var created apiReservation
+	if err := json.Unmarshal(res.Data, &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Status != models.ReservationPending || created.RoomName == "" || created.Price.Total == 0 || created.StartDate != "2086-01-01" {
+		t.Errorf("unexpected reservation %+v", created)
+	}
+	location := "/api/v1/reservations/" + created.ConfirmationCode
+	if resRecorder.Header().Get("Location") != location {
+		t.Errorf("expected the reservation's location %s, got %s", location, resRecorder.Header().Get("Location"))
+	}
+	if !strings.HasPrefix(created.ManageURL, "http://localhost:8080/manage/"+created.ConfirmationCode+"/") {
+		t.Errorf("expected the guest's manage link, got %s", created.ManageURL)
+	}
+	if len(drainMail()) != 2 {
+		t.Error("expected the booking emails to be sent")
+	}
+
+	// the same stay cannot be booked twice
+	if resRecorder, _ = callAPI(t, "POST", "/api/v1/reservations", body); resRecorder.Code != http.StatusConflict {
+		t.Errorf("expected a second booking to conflict, got %d", resRecorder.Code)
+	}
+
+	resRecorder, res = callAPI(t, "GET", location, "")
+	var fetched apiReservation
+	if err := json.Unmarshal(res.Data, &fetched); err != nil {
+		t.Fatal(err)
+	}
+	if resRecorder.Code != http.StatusOK || fetched.ConfirmationCode != created.ConfirmationCode || fetched.Phone != "555-1234" {
+		t.Errorf("unexpected reservation %d %+v", resRecorder.Code, fetched)
+	}
+
+	if resRecorder, _ = callAPI(t, "GET", location+"/cancel", ""); resRecorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected cancelling to need a POST, got %d", resRecorder.Code)
+	}
+	resRecorder, res = callAPI(t, "POST", location+"/cancel", "")
+	var cancelled apiReservation
+	if err := json.Unmarshal(res.Data, &cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if resRecorder.Code != http.StatusOK || cancelled.Status != models.ReservationCancelled || cancelled.CancelledAt == nil {
+		t.Errorf("expected the reservation to be cancelled, got %d %+v", resRecorder.Code, cancelled)
+	}
+	if resRecorder, res = callAPI(t, "POST", location+"/cancel", ""); resRecorder.Code != http.StatusConflict || res.Error.Code != "not_cancellable" {
+		t.Errorf("expected a cancelled reservation not to be cancelled again, got %d", resRecorder.Code)
+	}
+
+	// the dates are free again once cancelled
+	if resRecorder, _ = callAPI(t, "POST", "/api/v1/reservations", body); resRecorder.Code != http.StatusCreated {
+		t.Errorf("expected the freed dates to be booked, got %d", resRecorder.Code)
+	}
+	drainMail()
+}
+
+func TestRepository_APIReservationDeposit(t *testing.T) {
+	app.Payments.DepositRate = 20
+	defer func() { app.Payments.DepositRate = 0 }()
+
+	body := func(token string) string {
+		return `{"room_id": 2, "start_date": "2086-02-01", "end_date": "2086-02-03", "first_name": "Jane", "last_name": "Doe", "email": "jane@doe.com", "payment_token": "` + token + `"}`
+	}
+
+	var depositTests = []struct {
+		name          string
+		token         string
+		expectedCode  int
+		expectedError string
+	}{
+		{"no card", "", http.StatusUnprocessableEntity, "validation_failed"},
+		{"declined", payments.FakeCardDeclined, http.StatusPaymentRequired, "payment_declined"},
+		{"paid", payments.FakeCardOK, http.StatusCreated, ""},
+	}
+
+	for _, test := range depositTests {
+		resRecorder, res := callAPI(t, "POST", "/api/v1/reservations", body(test.token))
+		if resRecorder.Code != test.expectedCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedCode, resRecorder.Code)
+		}
+		if test.expectedError != "" && (res.Error == nil || res.Error.Code != test.expectedError) {
+			t.Errorf("for %s, expected a %s error, got %s", test.name, test.expectedError, resRecorder.Body.String())
+		}
+	}
+	drainMail()
+}
+
+func TestRepository_APIReservationOwner(t *testing.T) {
+	ctx := context.Background()
+	bookingKey, bookingKeyID := addAPIKey(t, time.Time{}, models.ScopeWriteReservations)
+	otherKey, _ := addAPIKey(t, time.Time{}, models.ScopeWriteReservations)
+
+	body := `{"room_id": 2, "start_date": "2086-03-01", "end_date": "2086-03-03", "first_name": "Jane", "last_name": "Doe", "email": "jane@doe.com"}`
+	resRecorder, res := callAPIWithKey(t, bookingKey, "POST", "/api/v1/reservations", body)
+	if resRecorder.Code != http.StatusCreated {
+		t.Fatalf("expected %d but got %d: %s", http.StatusCreated, resRecorder.Code, resRecorder.Body.String())
+	}
+	drainMail()
+	var created apiReservation
+	if err := json.Unmarshal(res.Data, &created); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Repo.DB.GetReservationByCode(ctx, created.ConfirmationCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.APIKeyID != bookingKeyID {
+		t.Errorf("expected the reservation to record the key %d that made it, got %d", bookingKeyID, saved.APIKeyID)
+	}
+	location := "/api/v1/reservations/" + created.ConfirmationCode
+
+	// a reservation made on the site belongs to no key
+	site, err := Repo.DB.GetReservationByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	siteLocation := "/api/v1/reservations/" + site.ConfirmationCode
+
+	var ownerTests = []struct {
+		name         string
+		key          string
+		method       string
+		url          string
+		expectedCode int
+	}{
+		{"other key", otherKey, "GET", location, http.StatusNotFound},
+		{"other key cancelling", otherKey, "POST", location + "/cancel", http.StatusNotFound},
+		{"booking key", bookingKey, "GET", location, http.StatusOK},
+		{"admin key", testAPIKey, "GET", location, http.StatusOK},
+		{"site reservation", bookingKey, "GET", siteLocation, http.StatusNotFound},
+		{"site reservation cancelling", bookingKey, "POST", siteLocation + "/cancel", http.StatusNotFound},
+		{"admin key, site reservation", testAPIKey, "GET", siteLocation, http.StatusOK},
+		{"booking key cancelling", bookingKey, "POST", location + "/cancel", http.StatusOK},
+	}
+
+	for _, test := range ownerTests {
+		resRecorder, res := callAPIWithKey(t, test.key, test.method, test.url, "")
+		if resRecorder.Code != test.expectedCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedCode, resRecorder.Code)
+		}
+		if test.expectedCode == http.StatusNotFound && (res.Error == nil || res.Error.Code != "not_found") {
+			t.Errorf("for %s, expected a not_found error, got %s", test.name, resRecorder.Body.String())
+		}
+	}
+
+	if site, _ = Repo.DB.GetReservationByID(ctx, 1); site.Status == models.ReservationCancelled {
+		t.Error("expected the site reservation not to be cancelled through the API")
+	}
+	drainMail()
+}
+
+// addAPIKey issues a key with scopes directly, returning it and its ID
+func addAPIKey(t *testing.T, expiresAt time.Time, scopes ...string) (string, int) {
+	key, err := tokens.NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := Repo.DB.InsertAPIKey(context.Background(), models.APIKey{
+		Name:      "Test key",
+		Prefix:    key[:tokens.APIKeyDisplayLength],
+		Hash:      tokens.HashAPIKey(key),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, id
+}
+
+func TestRepository_APIAuth(t *testing.T) {
+	ctx := context.Background()
+	readKey, _ := addAPIKey(t, time.Time{}, models.ScopeReadAvailability)
+	expiredKey, _ := addAPIKey(t, time.Now().Add(-time.Hour), models.ScopeAdmin)
+
+	var authTests = []struct {
+		name          string
+		key           string
+		method        string
+		url           string
+		failOn        string
+		expectedCode  int
+		expectedError string
+	}{
+		{"no key", "", "GET", "/api/v1/rooms", "", http.StatusUnauthorized, "unauthorized"},
+		{"unknown key", "bk_nope", "GET", "/api/v1/rooms", "", http.StatusUnauthorized, "unauthorized"},
+		{"expired key", expiredKey, "GET", "/api/v1/rooms", "", http.StatusUnauthorized, "unauthorized"},
+		{"key lookup error", readKey, "GET", "/api/v1/rooms", "GetAPIKeyByHash", http.StatusInternalServerError, "internal_error"},
+		{"read scope", readKey, "GET", "/api/v1/rooms/1", "", http.StatusOK, ""},
+		{"read scope booking", readKey, "POST", "/api/v1/reservations", "", http.StatusForbidden, "insufficient_scope"},
+		{"read scope listing reservations", readKey, "GET", "/api/v1/reservations", "", http.StatusForbidden, "insufficient_scope"},
+		{"admin scope listing reservations", testAPIKey, "GET", "/api/v1/reservations", "", http.StatusOK, ""},
+	}
+
+	for _, test := range authTests {
+		if test.failOn != "" {
+			failOn(test.failOn)
+		}
+		resRecorder, res := callAPIWithKey(t, test.key, test.method, test.url, "")
+		clearFailures()
+
+		if resRecorder.Code != test.expectedCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedCode, resRecorder.Code)
+		}
+		if test.expectedError != "" && (res.Error == nil || res.Error.Code != test.expectedError) {
+			t.Errorf("for %s, expected a %s error, got %s", test.name, test.expectedError, resRecorder.Body.String())
+		}
+		if test.expectedCode == http.StatusUnauthorized && !strings.HasPrefix(resRecorder.Header().Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("for %s, expected a bearer challenge, got %q", test.name, resRecorder.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	k, err := Repo.DB.GetAPIKeyByHash(ctx, tokens.HashAPIKey(readKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(k.LastUsedAt) > time.Minute {
+		t.Errorf("expected the use of the key to be recorded, got %v", k.LastUsedAt)
+	}
+
+	// listing reservations is paginated like rooms
+	resRecorder, res := callAPI(t, "GET", "/api/v1/reservations?per_page=1", "")
+	var reservations []apiReservation
+	if err := json.Unmarshal(res.Data, &reservations); err != nil {
+		t.Fatal(err)
+	}
+	if resRecorder.Code != http.StatusOK || len(reservations) != 1 || res.Pagination == nil || res.Pagination.Total < 2 {
+		t.Errorf("expected one reservation of several, got %d %s", resRecorder.Code, resRecorder.Body.String())
+	}
+}
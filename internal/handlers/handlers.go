@@ -1,8 +1,15 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -11,36 +18,57 @@ import (
 	"github.com/jeremydelacruz/go-bookings/internal/driver"
 	"github.com/jeremydelacruz/go-bookings/internal/forms"
 	"github.com/jeremydelacruz/go-bookings/internal/helpers"
+	"github.com/jeremydelacruz/go-bookings/internal/images"
+	"github.com/jeremydelacruz/go-bookings/internal/lifecycle"
+	"github.com/jeremydelacruz/go-bookings/internal/mail"
 	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/payments"
+	"github.com/jeremydelacruz/go-bookings/internal/pricing"
 	"github.com/jeremydelacruz/go-bookings/internal/render"
 	"github.com/jeremydelacruz/go-bookings/internal/repository"
 	"github.com/jeremydelacruz/go-bookings/internal/repository/dbrepo"
+	"github.com/jeremydelacruz/go-bookings/internal/tokens"
 )
 
 // Repository is the repository type
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App      *config.AppConfig
+	DB       repository.DatabaseRepo
+	Payments payments.Gateway
 }
 
 // Repo the repository used by the handlers
 var Repo *Repository
 
+// PaymentWebhookPath is where the payment provider sends its webhooks
+const PaymentWebhookPath = "/payments/webhook"
+
 // TODO: handle returned err from render.RenderTemplate(...)
 
 // NewRepo creates a new repository
-func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
+func NewRepo(a *config.AppConfig, db *driver.DB, gw payments.Gateway) *Repository {
+	return &Repository{
+		App:      a,
+		DB:       dbrepo.NewPostgresRepo(db.SQL, a),
+		Payments: gw,
+	}
+}
+
+// NewMemoryRepo creates a new repository backed by an in-memory database
+func NewMemoryRepo(a *config.AppConfig, gw payments.Gateway) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		App:      a,
+		DB:       dbrepo.NewMemoryRepo(a),
+		Payments: gw,
 	}
 }
 
-// NewTestRepo creates a new testing repository
+// NewTestRepo creates a new testing repository, taking payments with the fake gateway
 func NewTestRepo(a *config.AppConfig) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewTestingRepo(a),
+		App:      a,
+		DB:       dbrepo.NewTestingRepo(a),
+		Payments: payments.NewFakeGateway(a.Payments.WebhookSecret),
 	}
 }
 
@@ -64,14 +92,43 @@ func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// Rooms renders the list of rooms guests can book
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllActiveRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// Room renders the page of the room named by the slug at the end of the URL
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.URL.Path, "/")
+	slug := exploded[len(exploded)-1]
+
+	room, err := m.DB.GetRoomBySlug(r.Context(), slug)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
 // Availability renders the search availability page
@@ -91,19 +148,14 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	start := r.Form.Get("start")
 	end := r.Form.Get("end")
 
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
-	}
-	endDate, err := time.Parse(layout, end)
+	startDate, endDate, err := m.parseStay(start, end)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -115,8 +167,18 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	quotes := make(map[int]models.Quote, len(rooms))
+	for _, room := range rooms {
+		quotes[room.ID], err = m.quote(r.Context(), room, startDate, endDate, nil)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["quotes"] = quotes
 
 	res := models.Reservation{
 		StartDate: startDate,
@@ -138,18 +200,14 @@ type jsonResponse struct {
 	EndDate   string `json:"end_date"`
 }
 
-// AvailabilityJSON handles the request for availability and returns JSON
+// AvailabilityJSON handles the request for availability and returns JSON. Problems with the
+// request and the database are reported with ok false and an error status, so the room page can
+// read every answer as JSON.
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	// parsing/validating request body helps testability here
 	err := r.ParseForm()
 	if err != nil {
-		res := jsonResponse{
-			Ok:      false,
-			Message: "Internal server error",
-		}
-		out, _ := json.MarshalIndent(res, "", "  ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		writeAvailabilityJSON(w, http.StatusBadRequest, jsonResponse{Message: "Invalid request"})
 		return
 	}
 
@@ -159,41 +217,51 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, start)
 	if err != nil {
-		helpers.ServerError(w, err)
+		writeAvailabilityJSON(w, http.StatusBadRequest, jsonResponse{Message: "Invalid start date"})
 		return
 	}
 	endDate, err := time.Parse(layout, end)
 	if err != nil {
-		helpers.ServerError(w, err)
+		writeAvailabilityJSON(w, http.StatusBadRequest, jsonResponse{Message: "Invalid end date"})
 		return
 	}
 	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		writeAvailabilityJSON(w, http.StatusBadRequest, jsonResponse{Message: "Invalid room"})
+		return
+	}
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		writeAvailabilityJSON(w, http.StatusNotFound, jsonResponse{Message: "No such room"})
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		writeAvailabilityJSON(w, http.StatusInternalServerError, jsonResponse{Message: "Error connecting to database"})
 		return
 	}
 
-	isAvailable, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	isAvailable, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID, 0)
 	if err != nil {
-		res := jsonResponse{
-			Ok:      false,
-			Message: "Error connecting to database",
-		}
-		out, _ := json.MarshalIndent(res, "", "  ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		m.App.ErrorLog.Println(err)
+		writeAvailabilityJSON(w, http.StatusInternalServerError, jsonResponse{Message: "Error connecting to database"})
 		return
 	}
 
-	res := jsonResponse{
+	writeAvailabilityJSON(w, http.StatusOK, jsonResponse{
 		Ok:        isAvailable,
 		Message:   "",
 		StartDate: start,
 		EndDate:   end,
 		RoomID:    strconv.Itoa(roomID),
-	}
+	})
+}
+
+// writeAvailabilityJSON answers an availability request with res
+func writeAvailabilityJSON(w http.ResponseWriter, status int, res jsonResponse) {
 	out, _ := json.MarshalIndent(res, "", "  ")
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(out)
 }
 
@@ -206,7 +274,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "cannot find room")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -215,6 +283,13 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	res.Room.RoomName = room.RoomName
 
+	res.Price, err = m.quote(r.Context(), room, res.StartDate, res.EndDate, nil)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot price this stay")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	sd := res.StartDate.Format("2006-01-02")
@@ -251,52 +326,369 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Email = r.Form.Get("email")
 	reservation.Phone = r.Form.Get("phone")
+	reservation.PromoCode = pricing.NormalizeCode(r.Form.Get("promo_code"))
 
 	form := forms.New(r.PostForm)
 	form.Required("first_name", "last_name", "email")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
 
+	promo, err := m.checkPromoCode(r.Context(), form, reservation)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot check promo code")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
 	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
-		http.Error(w, "invalid form", http.StatusSeeOther)
-		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
-		})
+		renderInvalidReservation(w, r, reservation, form)
 		return
 	}
 
-	// NOTE: potential for bad state. E.g., successful reservation insert, but unsuccessful roomRestriction insert
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	// price the stay again so the snapshot uses the rates in force when it was booked
+	room, err := m.DB.GetRoomByID(r.Context(), reservation.RoomID)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "error saving reservation into database")
+		m.App.Session.Put(r.Context(), "error", "cannot find room")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	reservation.Price, err = m.quote(r.Context(), room, reservation.StartDate, reservation.EndDate, promo)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot price this stay")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
+	// the code is made here rather than by the database so the summary can show it
+	reservation.ConfirmationCode, err = tokens.NewConfirmationCode()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the deposit is held before booking, so no reservation is confirmed without one
+	if reservation.Price.Deposit > 0 {
+		token := r.Form.Get("payment_token")
+		if token == "" {
+			form.Errors.Add("payment_token", "Please enter your card details")
+			renderInvalidReservation(w, r, reservation, form)
+			return
+		}
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+		reservation.Payment, err = m.authorizeDeposit(r.Context(), reservation, token)
+		if errors.Is(err, payments.ErrDeclined) {
+			form.Errors.Add("payment_token", "Your card was declined, please try another one")
+			renderInvalidReservation(w, r, reservation, form)
+			return
+		}
+		if err != nil {
+			m.App.ErrorLog.Println("authorizing deposit:", err)
+			m.App.Session.Put(r.Context(), "error", "cannot take deposit, please try again later")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	// the reservation, its room restriction, the promo code's redemption and the deposit are
+	// written in a single transaction
+	newReservationID, err := m.DB.CreateReservation(r.Context(), reservation)
+	if err != nil {
+		m.releaseDeposit(reservation.Payment)
+	}
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room was just booked for those dates. Please try different dates.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		m.roomNotBookable(w, r)
+		return
+	}
+	if errors.Is(err, repository.ErrPromoCodeUnavailable) {
+		form.Errors.Add("promo_code", "Sorry, this code has just been used up")
+		renderInvalidReservation(w, r, reservation, form)
+		return
+	}
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "error saving room restriction into database")
+		m.App.Session.Put(r.Context(), "error", "error saving reservation into database")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	reservation.ID = newReservationID
+
+	m.sendReservationEmails(reservation)
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
+// sendReservationEmails queues the guest's confirmation and the owner's alert for a new
+// reservation. The booking has been made by now, so a full queue only loses the emails.
+func (m *Repository) sendReservationEmails(res models.Reservation) {
+	data := m.emailData(res)
+
+	for _, e := range []struct{ tmpl, to string }{
+		{"reservation-confirmation.email.tmpl", res.Email},
+		{"reservation-notification.email.tmpl", m.App.Mail.OwnerAddress},
+	} {
+		msg, err := render.Email(e.tmpl, data)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+		msg.To = e.to
+		msg.From = m.App.Mail.From
+
+		if !mail.Queue(m.App.MailChan, msg) {
+			m.App.ErrorLog.Printf("mail queue full, dropping %q to %s", msg.Subject, msg.To)
+		}
+	}
+}
+
+// emailData is the data email templates about res are rendered with; links in emails are
+// absolute, starting with the base URL
+func (m *Repository) emailData(res models.Reservation) *models.TemplateData {
+	return &models.TemplateData{
+		Data: map[string]interface{}{"reservation": res},
+		StringMap: map[string]string{
+			"base_url":   m.App.BaseURL,
+			"manage_url": m.App.BaseURL + m.manageURL(res.ConfirmationCode),
+			"admin_url":  fmt.Sprintf("%s/admin/reservations/new/%d", m.App.BaseURL, res.ID),
+		},
+	}
+}
+
+// followUpLookback is how many days late a follow-up may still be sent, so that guests who left
+// while the scheduler was not running are thanked once it is back
+const followUpLookback = 7
+
+// SendArrivalReminders emails check-in instructions to the guests arriving within the next
+// App.Reminders.DaysBefore days. The scheduler runs it; each guest is reminded only once however
+// often it runs.
+func (m *Repository) SendArrivalReminders(ctx context.Context) error {
+	today := localToday()
+	arriving, err := m.DB.GetReservationsArrivingBetween(ctx, today.AddDate(0, 0, 1), today.AddDate(0, 0, m.App.Reminders.DaysBefore+1))
+	if err != nil {
+		return err
+	}
+
+	return m.sendGuestMessages(ctx, arriving, models.MessageArrivalReminder, "arrival-reminder.email.tmpl")
+}
+
+// SendFollowUps thanks the guests who left App.Reminders.FollowUpDays days ago and asks them for
+// a review. The scheduler runs it; each guest is thanked only once however often it runs.
+func (m *Repository) SendFollowUps(ctx context.Context) error {
+	due := localToday().AddDate(0, 0, -m.App.Reminders.FollowUpDays)
+	departed, err := m.DB.GetReservationsDepartingBetween(ctx, due.AddDate(0, 0, -followUpLookback), due.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	return m.sendGuestMessages(ctx, departed, models.MessageFollowUp, "follow-up.email.tmpl")
+}
+
+// sendGuestMessages emails the message kind rendered from tmpl to the guest of each reservation
+// not sent one already. The message is recorded as sent before it is queued, so an instance
+// running alongside this one does not send it too; the record is removed again if the message
+// cannot be queued or sent, so a later run tries once more.
+func (m *Repository) sendGuestMessages(ctx context.Context, reservations []models.Reservation, kind, tmpl string) error {
+	for _, res := range reservations {
+		msg, err := render.Email(tmpl, m.emailData(res))
+		if err != nil {
+			return err
+		}
+		msg.To = res.Email
+		msg.From = m.App.Mail.From
+
+		recorded, err := m.DB.RecordSentMessage(ctx, res.ID, kind)
+		if err != nil {
+			return err
+		}
+		if !recorded {
+			continue
+		}
+
+		resID := res.ID
+		msg.Failed = func(error) { m.forgetSentMessage(resID, kind) }
+		if err = mail.QueueWait(ctx, m.App.MailChan, msg); err != nil {
+			m.forgetSentMessage(resID, kind)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forgetSentMessage removes the record of the message kind sent about a reservation when it
+// never went out. It does not use the job's context, which may be what stopped the message.
+func (m *Repository) forgetSentMessage(reservationID int, kind string) {
+	if err := m.DB.DeleteSentMessage(context.Background(), reservationID, kind); err != nil {
+		m.App.ErrorLog.Printf("forgetting %s for reservation %d: %v", kind, reservationID, err)
+	}
+}
+
+// localToday is the current date where the server runs, at midnight UTC as dates of stays are
+// stored
+func localToday() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// stayError is a problem with the dates of a stay, naming the form field it concerns
+type stayError struct {
+	field, message string
+}
+
+func (e *stayError) Error() string {
+	return e.message
+}
+
+// validateStay checks guests may book a stay from start to end: it must end after it starts,
+// not start in the past and last no more than the configured number of nights, so a stay is
+// never priced night by night for years. The problem found, if any, is a *stayError.
+func (m *Repository) validateStay(start, end time.Time) error {
+	switch {
+	case !end.After(start):
+		return &stayError{"end_date", "The departure date must be after the arrival date"}
+	case start.Before(localToday()):
+		return &stayError{"start_date", "The arrival date cannot be in the past"}
+	case end.After(start.AddDate(0, 0, m.App.MaxStayNights)):
+		return &stayError{"end_date", fmt.Sprintf("Stays can be at most %d nights", m.App.MaxStayNights)}
+	}
+	return nil
+}
+
+// addStayError records the problem validateStay found, if any, against its field of form
+func addStayError(form *forms.Form, err error) {
+	var se *stayError
+	if errors.As(err, &se) {
+		form.Errors.Add(se.field, se.message)
+	}
+}
+
+// parseStay reads the dates of a stay given as yyyy-mm-dd and checks them with validateStay
+func (m *Repository) parseStay(start, end string) (time.Time, time.Time, error) {
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, start)
+	if err != nil {
+		return time.Time{}, time.Time{}, &stayError{"start_date", "Invalid arrival date"}
+	}
+	endDate, err := time.Parse(layout, end)
+	if err != nil {
+		return time.Time{}, time.Time{}, &stayError{"end_date", "Invalid departure date"}
+	}
+	return startDate, endDate, m.validateStay(startDate, endDate)
+}
+
+// renderInvalidReservation shows the reservation form again with the problems found in form
+func renderInvalidReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	stringMap := map[string]string{
+		"start_date": res.StartDate.Format("2006-01-02"),
+		"end_date":   res.EndDate.Format("2006-01-02"),
+	}
+
+	http.Error(w, "invalid form", http.StatusSeeOther)
+	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// promoCodeMessages are shown to guests for the errors returned by pricing.CheckPromo
+var promoCodeMessages = map[error]string{
+	pricing.ErrPromoNotStarted: "This code cannot be used yet",
+	pricing.ErrPromoExpired:    "This code has expired",
+	pricing.ErrPromoUsedUp:     "This code has been used up",
+	pricing.ErrPromoRoom:       "This code cannot be used for this room",
+	pricing.ErrPromoMinNights:  "This code needs a longer stay",
+}
+
+// checkPromoCode looks up the promo code entered for res, if any. When the code does not exist
+// or cannot be used for the stay it adds an error to form and returns nil.
+func (m *Repository) checkPromoCode(ctx context.Context, form *forms.Form, res models.Reservation) (*models.PromoCode, error) {
+	if res.PromoCode == "" {
+		return nil, nil
+	}
+
+	p, err := m.DB.GetPromoCodeByCode(ctx, res.PromoCode)
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("promo_code", "This code is not valid")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = pricing.CheckPromo(p, res.RoomID, pricing.Nights(res.StartDate, res.EndDate), time.Now())
+	if err != nil {
+		form.Errors.Add("promo_code", promoCodeMessages[err])
+		return nil, nil
+	}
+
+	return &p, nil
+}
+
+// authorizeDeposit holds the deposit quoted for res on the card behind token
+func (m *Repository) authorizeDeposit(ctx context.Context, res models.Reservation, token string) (models.PaymentIntent, error) {
+	p, err := m.Payments.Authorize(ctx, payments.AuthorizeRequest{
+		Amount:      res.Price.Deposit,
+		Currency:    m.App.Payments.Currency,
+		Source:      token,
+		Description: fmt.Sprintf("Deposit for %s, %s to %s", res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")),
+	})
+	if err != nil {
+		return models.PaymentIntent{}, err
+	}
+
+	return models.PaymentIntent{
+		Provider:    m.Payments.Name(),
+		ProviderRef: p.Ref,
+		Status:      p.Status,
+		Amount:      p.Amount,
+		Currency:    m.App.Payments.Currency,
+	}, nil
+}
+
+// releaseDeposit voids the hold of a deposit whose booking could not be made. It does not use
+// the request's context, as the hold must be released even when the guest has gone.
+func (m *Repository) releaseDeposit(p models.PaymentIntent) {
+	if p.ProviderRef == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := m.Payments.Void(ctx, p.ProviderRef); err != nil {
+		m.App.ErrorLog.Printf("voiding deposit %s after a failed booking: %v", p.ProviderRef, err)
+	}
+}
+
+// quote prices a stay in room using the rates in the database, the configured tax and deposit
+// rates and promo, which may be nil
+func (m *Repository) quote(ctx context.Context, room models.Room, start, end time.Time, promo *models.PromoCode) (models.Quote, error) {
+	overrides, err := m.DB.GetRateOverridesForRoom(ctx, room.ID, start, end)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	discounts, err := m.DB.AllStayDiscounts(ctx)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	return pricing.Quote(room, start, end, pricing.Rates{
+		Overrides:   overrides,
+		Discounts:   discounts,
+		TaxRate:     int(math.Round(m.App.TaxRate * 100)),
+		Promo:       promo,
+		DepositRate: int(math.Round(m.App.Payments.DepositRate * 100)),
+	})
+}
+
 // ReservationSummary displays the reservation summary page
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
@@ -315,6 +707,9 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	sd := reservation.StartDate.Format("2006-01-02")
 	ed := reservation.EndDate.Format("2006-01-02")
 	stringMap := map[string]string{"start_date": sd, "end_date": ed}
+	if reservation.ConfirmationCode != "" {
+		stringMap["manage_url"] = m.manageURL(reservation.ConfirmationCode)
+	}
 
 	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
 		Data:      data,
@@ -322,60 +717,1951 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-// ChooseRoom displays list of available rooms
-func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
-	roomID, err := strconv.Atoi(exploded[len(exploded)-1])
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "missing url parameter")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+// ManageReservation shows guests the reservation behind their manage link, with forms to
+// cancel it or change its dates
+func (m *Repository) ManageReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
 		return
 	}
 
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	m.renderManageReservation(w, r, res, forms.New(nil))
+}
+
+// PostCancelReservation cancels the reservation behind a manage link, freeing its dates
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
 	if !ok {
-		helpers.ServerError(w, err)
 		return
 	}
 
-	res.RoomID = roomID
-	m.App.Session.Put(r.Context(), "reservation", res)
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
-}
+	manageURL := m.manageURL(res.ConfirmationCode)
+	if stayStarted(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
 
-// BookRoom takes URL params, builds session reservation, takes user to make reservation page
-func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
-	roomID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	err := m.changeStatus(r.Context(), res, models.ReservationCancelled, lifecycle.Guest)
+	if errors.Is(err, lifecycle.ErrIllegalTransition) || errors.Is(err, repository.ErrStatusConflict) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
-	start := r.URL.Query().Get("s")
-	end := r.URL.Query().Get("e")
 
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, manageURL, http.StatusSeeOther)
+}
+
+// changeStatus moves res to status to on behalf of by, through the reservation lifecycle.
+// Deposits still only held for a cancelled reservation are released; captured ones are left
+// for the owner to refund.
+func (m *Repository) changeStatus(ctx context.Context, res models.Reservation, to string, by lifecycle.Actor) error {
+	change, err := lifecycle.Reservations.Transition(res, to, by, time.Now())
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		return err
 	}
-	endDate, err := time.Parse(layout, end)
+
+	err = m.DB.UpdateReservationStatus(ctx, change)
 	if err != nil {
-		helpers.ServerError(w, err)
+		return err
+	}
+
+	if to == models.ReservationCancelled {
+		// the cancellation stands even when a hold cannot be released; the failure is logged
+		_ = m.releaseDeposits(ctx, res.ID)
+	}
+
+	return nil
+}
+
+// releaseDeposits voids the deposits of reservation resID that are still only held, logging
+// any that cannot be. The error is that of the first failure.
+func (m *Repository) releaseDeposits(ctx context.Context, resID int) error {
+	intents, err := m.DB.GetPaymentIntentsForReservation(ctx, resID)
+	if err != nil {
+		m.App.ErrorLog.Printf("listing deposits of reservation %d: %v", resID, err)
+		return err
+	}
+
+	var firstErr error
+	for _, p := range intents {
+		if p.Status != models.PaymentAuthorized {
+			continue
+		}
+		gp, err := m.Payments.Void(ctx, p.ProviderRef)
+		if err != nil {
+			m.App.ErrorLog.Printf("voiding deposit %s of reservation %d: %v", p.ProviderRef, resID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		applyPayment(&p, gp)
+		if err = m.DB.UpdatePaymentIntent(ctx, p); err != nil {
+			m.App.ErrorLog.Printf("saving voided deposit %s: %v", p.ProviderRef, err)
+		}
+	}
+
+	return firstErr
+}
+
+// PostChangeReservationDates moves the reservation behind a manage link to new dates, when
+// the room is free for them once the reservation's own dates are left out
+func (m *Repository) PostChangeReservationDates(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
 		return
 	}
-	room, err := m.DB.GetRoomByID(roomID)
+
+	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	var res models.Reservation
-	res.RoomID = roomID
-	res.StartDate = startDate
-	res.EndDate = endDate
-	res.Room.RoomName = room.RoomName
+	manageURL := m.manageURL(res.ConfirmationCode)
+	if !lifecycle.Changeable(res.Status) || stayStarted(res) {
+		m.App.Session.Put(r.Context(), "error", "The dates of this reservation can no longer be changed")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+	form := forms.New(r.PostForm)
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+
+	start, _ := time.Parse("2006-01-02", strings.TrimSpace(r.Form.Get("start_date")))
+	end, _ := time.Parse("2006-01-02", strings.TrimSpace(r.Form.Get("end_date")))
+	if form.Valid() {
+		addStayError(form, m.validateStay(start, end))
+	}
+
+	if form.Valid() {
+		available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), start, end, res.RoomID, res.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !available {
+			form.Errors.Add("start_date", "Sorry, the room is not available for those dates")
+		}
+	}
+
+	var price models.Quote
+	if form.Valid() {
+		price, err = m.requote(r.Context(), form, res, start, end)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderManageReservation(w, r, res, form)
+		return
+	}
+
+	err = m.DB.ChangeReservationDates(r.Context(), res.ID, start, end, price)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		// booked by someone else since the check above
+		form.Errors.Add("start_date", "Sorry, the room is not available for those dates")
+		m.renderManageReservation(w, r, res, form)
+		return
+	}
+	if errors.Is(err, repository.ErrStatusConflict) {
+		m.App.Session.Put(r.Context(), "error", "The dates of this reservation can no longer be changed")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your dates have been changed")
+	http.Redirect(w, r, manageURL, http.StatusSeeOther)
+}
+
+// requote prices res again for new dates; the deposit already taken is kept. The promo code it
+// was booked with still applies if it exists, as long as the new stay qualifies for it. Its
+// dates and usage limit are not checked again, as it was redeemed when booking. When the stay no
+// longer qualifies an error is added to form and the change should be refused.
+func (m *Repository) requote(ctx context.Context, form *forms.Form, res models.Reservation, start, end time.Time) (models.Quote, error) {
+	room, err := m.DB.GetRoomByID(ctx, res.RoomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	var promo *models.PromoCode
+	if res.PromoCode != "" {
+		p, err := m.DB.GetPromoCodeByCode(ctx, res.PromoCode)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return models.Quote{}, err
+		}
+		if err == nil {
+			err = pricing.CheckPromoStay(p, res.RoomID, pricing.Nights(start, end))
+			if err != nil {
+				form.Errors.Add("end_date", fmt.Sprintf("The promo code %s this reservation was booked with does not apply to these dates. %s", res.PromoCode, promoCodeMessages[err]))
+				return models.Quote{}, nil
+			}
+			promo = &p
+		}
+	}
+
+	price, err := m.quote(ctx, room, start, end, promo)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	price.Deposit = res.Price.Deposit
+
+	return price, nil
+}
+
+// renderManageReservation shows the guest's reservation with the problems found in form
+func (m *Repository) renderManageReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["changeable"] = lifecycle.Changeable(res.Status) && !stayStarted(res)
+
+	stringMap := map[string]string{
+		"manage_url": m.manageURL(res.ConfirmationCode),
+		"start_date": res.StartDate.Format("2006-01-02"),
+		"end_date":   res.EndDate.Format("2006-01-02"),
+	}
+	if form.Has("start_date") || form.Has("end_date") {
+		stringMap["start_date"] = form.Get("start_date")
+		stringMap["end_date"] = form.Get("end_date")
+	}
+
+	render.Template(w, r, "manage-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// guestReservation loads the reservation named by a manage link, writing a 404 and returning
+// false when the link was not signed by us or the reservation no longer exists
+func (m *Repository) guestReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	code, sig, err := manageParams(r)
+	if err != nil || !m.linkSigner().Verify(code, sig) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByCode(r.Context(), code)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// manageURL returns the link guests use to manage the reservation with code. It is signed, so
+// knowing one code is not enough to guess the link to another.
+func (m *Repository) manageURL(code string) string {
+	return fmt.Sprintf("/manage/%s/%s", code, m.linkSigner().Sign(code))
+}
+
+// linkSigner signs and verifies manage links with the configured link secret
+func (m *Repository) linkSigner() *tokens.Signer {
+	return tokens.NewSigner([]byte(m.App.LinkSecret))
+}
+
+// manageParams extracts code and sig from paths of the form /manage/{code}/{sig}/...
+func manageParams(r *http.Request) (string, string, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 || exploded[1] != "manage" {
+		return "", "", fmt.Errorf("manageParams: malformed path %s", r.URL.Path)
+	}
+
+	return exploded[2], exploded[3], nil
+}
+
+// stayStarted reports whether the guest is due to have arrived, after which the booking
+// can no longer be changed online
+func stayStarted(res models.Reservation) bool {
+	return !res.StartDate.After(time.Now())
+}
+
+// ChooseRoom displays list of available rooms
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[len(exploded)-1])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		m.roomNotBookable(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res.RoomID = roomID
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// BookRoom takes URL params, builds session reservation, takes user to make reservation page
+func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	startDate, endDate, err := m.parseStay(r.URL.Query().Get("s"), r.URL.Query().Get("e"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		m.roomNotBookable(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var res models.Reservation
+	res.RoomID = roomID
+	res.StartDate = startDate
+	res.EndDate = endDate
+	res.Room.RoomName = room.RoomName
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// roomNotBookable sends the guest back to search when the room they chose is unknown or has
+// been taken off the site
+func (m *Repository) roomNotBookable(w http.ResponseWriter, r *http.Request) {
+	m.App.Session.Put(r.Context(), "error", "Sorry, this room cannot be booked. Please choose another.")
+	http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+}
+
+// ShowLogin renders the login page
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostShowLogin handles logging the user in
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	// renew the session token on every login attempt to prevent session fixation
+	_ = m.App.Session.RenewToken(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot parse form")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", id)
+	m.App.Session.Put(r.Context(), "access_level", user.AccessLevel)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Logout logs the user out
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminDashboard renders the admin dashboard
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	newReservations, err := m.DB.AllNewReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	allReservations, err := m.DB.AllReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	intMap := map[string]int{
+		"new_reservations": len(newReservations),
+		"all_reservations": len(allReservations),
+	}
+
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
+		IntMap: intMap,
+	})
+}
+
+// AdminNewReservations shows all unprocessed reservations in the admin tool
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllNewReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminAllReservations shows all reservations in the admin tool
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowReservation shows a single reservation in the admin tool
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	src, id, err := adminReservationParams(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	intents, err := m.DB.GetPaymentIntentsForReservation(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	changes, err := m.DB.GetStatusChangesForReservation(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	sent, err := m.DB.GetSentMessagesForReservation(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["payments"] = intents
+	data["status_changes"] = changes
+	data["sent_messages"] = sent
+	data["next_statuses"] = lifecycle.Reservations.Next(res.Status)
+
+	stringMap := adminReservationStringMap(src, r)
+	stringMap["manage_url"] = m.manageURL(res.ConfirmationCode)
+
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+// PostAdminShowReservation saves changes made to a reservation in the admin tool
+func (m *Repository) PostAdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src, id, err := adminReservationParams(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["reservation"] = res
+
+		render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+			StringMap: adminReservationStringMap(src, r),
+			Data:      data,
+			Form:      form,
+		})
+		return
+	}
+
+	err = m.DB.UpdateReservation(r.Context(), res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, adminReturnURL(src, r), http.StatusSeeOther)
+}
+
+// AdminProcessReservation marks a reservation as processed
+func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	src, id, err := adminReservationParams(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.UpdateProcessedForReservation(r.Context(), id, 1)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+	http.Redirect(w, r, adminReturnURL(src, r), http.StatusSeeOther)
+}
+
+// AdminDeleteReservation deletes a reservation, first releasing any deposit still held for it,
+// as its payments are deleted with it
+func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	src, id, err := adminReservationParams(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if err = m.releaseDeposits(r.Context(), id); err != nil {
+		m.App.Session.Put(r.Context(), "error", "The deposit held for this reservation could not be released, so it has not been deleted")
+		http.Redirect(w, r, adminReservationURL(src, id, r), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteReservation(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	http.Redirect(w, r, adminReturnURL(src, r), http.StatusSeeOther)
+}
+
+// AdminConfirmReservation confirms a pending reservation
+func (m *Repository) AdminConfirmReservation(w http.ResponseWriter, r *http.Request) {
+	m.adminChangeStatus(w, r, models.ReservationConfirmed, "Reservation confirmed")
+}
+
+// AdminCheckInReservation records that the guest of a confirmed reservation has arrived
+func (m *Repository) AdminCheckInReservation(w http.ResponseWriter, r *http.Request) {
+	m.adminChangeStatus(w, r, models.ReservationCheckedIn, "Guest checked in")
+}
+
+// AdminCheckOutReservation records that a checked in guest has left
+func (m *Repository) AdminCheckOutReservation(w http.ResponseWriter, r *http.Request) {
+	m.adminChangeStatus(w, r, models.ReservationCheckedOut, "Guest checked out")
+}
+
+// AdminNoShowReservation records that the guest of a confirmed reservation never arrived,
+// freeing the rest of their stay
+func (m *Repository) AdminNoShowReservation(w http.ResponseWriter, r *http.Request) {
+	m.adminChangeStatus(w, r, models.ReservationNoShow, "Reservation marked as a no-show")
+}
+
+// AdminCancelReservation cancels a reservation on the guest's behalf, keeping its record
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	m.adminChangeStatus(w, r, models.ReservationCancelled, "Reservation cancelled")
+}
+
+// adminChangeStatus moves the reservation in the path to status to as the logged in user, and
+// returns to the reservation
+func (m *Repository) adminChangeStatus(w http.ResponseWriter, r *http.Request, to, flash string) {
+	src, id, err := adminReservationParams(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	returnURL := adminReservationURL(src, id, r)
+
+	by := lifecycle.Staff(m.App.Session.GetInt(r.Context(), "user_id"))
+	err = m.changeStatus(r.Context(), res, to, by)
+	if errors.Is(err, lifecycle.ErrIllegalTransition) || errors.Is(err, repository.ErrStatusConflict) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A %s reservation cannot be changed to %s", strings.ToLower(render.StatusLabel(res.Status)), strings.ToLower(render.StatusLabel(to))))
+		http.Redirect(w, r, returnURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, returnURL, http.StatusSeeOther)
+}
+
+// AdminCapturePayment takes the whole of a deposit held for a reservation
+func (m *Repository) AdminCapturePayment(w http.ResponseWriter, r *http.Request) {
+	m.adminPayment(w, r, "Deposit captured", func(ctx context.Context, p models.PaymentIntent) (payments.Payment, error) {
+		return m.Payments.Capture(ctx, p.ProviderRef, p.Amount)
+	})
+}
+
+// AdminVoidPayment releases a deposit held for a reservation without taking it
+func (m *Repository) AdminVoidPayment(w http.ResponseWriter, r *http.Request) {
+	m.adminPayment(w, r, "Deposit released", func(ctx context.Context, p models.PaymentIntent) (payments.Payment, error) {
+		return m.Payments.Void(ctx, p.ProviderRef)
+	})
+}
+
+// AdminRefundPayment gives back what is left of a captured deposit
+func (m *Repository) AdminRefundPayment(w http.ResponseWriter, r *http.Request) {
+	m.adminPayment(w, r, "Deposit refunded", func(ctx context.Context, p models.PaymentIntent) (payments.Payment, error) {
+		return m.Payments.Refund(ctx, p.ProviderRef, p.Captured-p.Refunded)
+	})
+}
+
+// adminPayment applies op to the payment named by the payment_id field of the reservation in
+// the path, saves the result and returns to the reservation
+func (m *Repository) adminPayment(w http.ResponseWriter, r *http.Request, flash string, op func(context.Context, models.PaymentIntent) (payments.Payment, error)) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src, id, err := adminReservationParams(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	paymentID, err := strconv.Atoi(r.Form.Get("payment_id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	intents, err := m.DB.GetPaymentIntentsForReservation(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var p models.PaymentIntent
+	for _, x := range intents {
+		if x.ID == paymentID {
+			p = x
+		}
+	}
+	if p.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	returnURL := adminReservationURL(src, id, r)
+
+	updated, err := op(r.Context(), p)
+	if errors.Is(err, payments.ErrInvalidState) || errors.Is(err, payments.ErrInvalidAmount) {
+		m.App.Session.Put(r.Context(), "error", "This payment cannot be changed any more")
+		http.Redirect(w, r, returnURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	applyPayment(&p, updated)
+	err = m.DB.UpdatePaymentIntent(r.Context(), p)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, returnURL, http.StatusSeeOther)
+}
+
+// PaymentWebhook records changes the payment provider reports, such as a refund made from its
+// own dashboard. Requests not signed by the provider are refused.
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	e, err := m.Payments.VerifyWebhook(payload, r.Header)
+	if err != nil {
+		m.App.ErrorLog.Println("payment webhook:", err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	p, err := m.DB.GetPaymentIntentByRef(r.Context(), m.Payments.Name(), e.Payment.Ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the booking may have been deleted since; acknowledge the event so it is not sent again
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// events can arrive out of order, so one older than what is stored is ignored
+	if !stalePayment(p, e.Payment) {
+		applyPayment(&p, e.Payment)
+		err = m.DB.UpdatePaymentIntent(r.Context(), p)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// paymentStages orders payment statuses; a payment never goes back to an earlier stage
+var paymentStages = map[string]int{
+	models.PaymentAuthorized: 0,
+	models.PaymentCaptured:   1,
+	models.PaymentRefunded:   2,
+	models.PaymentVoided:     2,
+	models.PaymentFailed:     2,
+}
+
+// stalePayment reports whether the provider's view of a payment is older than p
+func stalePayment(p models.PaymentIntent, gp payments.Payment) bool {
+	return paymentStages[gp.Status] < paymentStages[p.Status] || gp.Refunded < p.Refunded
+}
+
+// applyPayment copies the provider's view of a payment onto p
+func applyPayment(p *models.PaymentIntent, gp payments.Payment) {
+	p.Status = gp.Status
+	p.Captured = gp.Captured
+	p.Refunded = gp.Refunded
+}
+
+// adminReturnURL returns the admin page a reservation was opened from
+func adminReturnURL(src string, r *http.Request) string {
+	if src == "cal" {
+		return fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", r.URL.Query().Get("y"), r.URL.Query().Get("m"))
+	}
+	return fmt.Sprintf("/admin/reservations-%s", src)
+}
+
+// adminReservationURL returns the admin page of reservation id opened from src
+func adminReservationURL(src string, id int, r *http.Request) string {
+	u := fmt.Sprintf("/admin/reservations/%s/%d", src, id)
+	if src == "cal" {
+		u += fmt.Sprintf("?y=%s&m=%s", r.URL.Query().Get("y"), r.URL.Query().Get("m"))
+	}
+	return u
+}
+
+// adminReservationStringMap holds the values the reservation page needs to link back to its source
+func adminReservationStringMap(src string, r *http.Request) map[string]string {
+	return map[string]string{
+		"src":        src,
+		"return_url": adminReturnURL(src, r),
+		"year":       r.URL.Query().Get("y"),
+		"month":      r.URL.Query().Get("m"),
+	}
+}
+
+// adminReservationParams extracts src and id from paths ending in /{src}/{id}
+func adminReservationParams(r *http.Request) (string, int, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 2 {
+		return "", 0, fmt.Errorf("adminReservationParams: malformed path %s", r.URL.Path)
+	}
+
+	id, err := strconv.Atoi(exploded[len(exploded)-1])
+	if err != nil {
+		return "", 0, err
+	}
+	src := exploded[len(exploded)-2]
+	if src != "new" && src != "all" && src != "cal" {
+		return "", 0, fmt.Errorf("adminReservationParams: unknown source %s", src)
+	}
+
+	return src, id, nil
+}
+
+// AdminReservationsCalendar displays the reservation calendar
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	// assume there is no month/year specified
+	now := time.Now()
+
+	if r.URL.Query().Get("y") != "" {
+		year, err := strconv.Atoi(r.URL.Query().Get("y"))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		month, err := strconv.Atoi(r.URL.Query().Get("m"))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	data := make(map[string]interface{})
+	data["now"] = now
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	stringMap := map[string]string{
+		"next_month":      next.Format("01"),
+		"next_month_year": next.Format("2006"),
+		"last_month":      last.Format("01"),
+		"last_month_year": last.Format("2006"),
+		"this_month":      now.Format("01"),
+		"this_month_year": now.Format("2006"),
+	}
+
+	// get the first and last days of the month
+	currentYear, currentMonth, _ := now.Date()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, time.UTC)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	intMap := map[string]int{"days_in_month": lastOfMonth.Day()}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["rooms"] = rooms
+
+	for _, x := range rooms {
+		// maps are keyed by YYYY-MM-DD, holding reservation IDs or block restriction IDs
+		reservationMap := make(map[string]int)
+		blockMap := make(map[string]int)
+
+		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
+			reservationMap[d.Format("2006-01-02")] = 0
+			blockMap[d.Format("2006-01-02")] = 0
+		}
+
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), x.ID, firstOfMonth, lastOfMonth.AddDate(0, 0, 1))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, y := range restrictions {
+			if y.ReservationID > 0 {
+				// a reservation occupies every night from arrival up to, but not including, departure
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					reservationMap[d.Format("2006-01-02")] = y.ReservationID
+				}
+			} else {
+				blockMap[y.StartDate.Format("2006-01-02")] = y.ID
+			}
+		}
+
+		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+	})
+}
+
+// AdminPostReservationsCalendar adds and removes owner blocks from the reservation calendar
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, err := strconv.Atoi(r.Form.Get("y"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	month, err := strconv.Atoi(r.Form.Get("m"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	// remove blocks that were shown ticked but have been unticked. Each block shown is posted as
+	// block_{roomID}_{YYYY-MM-DD} holding its ID; only those in the month the form showed count,
+	// so a form left open in another tab cannot remove blocks it never showed.
+	for name := range r.PostForm {
+		if !strings.HasPrefix(name, "block_") {
+			continue
+		}
+
+		exploded := strings.Split(name, "_")
+		if len(exploded) != 3 {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", exploded[2])
+		if err != nil || day.Year() != year || int(day.Month()) != month {
+			continue
+		}
+		roomID, err := strconv.Atoi(exploded[1])
+		if err != nil {
+			continue
+		}
+		blockID, err := strconv.Atoi(r.PostForm.Get(name))
+		if err != nil {
+			continue
+		}
+
+		if !form.Has("remove_" + name) {
+			// the block must be the one the field names, so an edited form cannot remove others
+			err = m.DB.DeleteBlockByID(r.Context(), blockID, roomID, day)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+		}
+	}
+
+	// add blocks for newly ticked days, named add_block_{roomID}_{YYYY-MM-DD}
+	for name := range r.PostForm {
+		if !strings.HasPrefix(name, "add_block") {
+			continue
+		}
+
+		exploded := strings.Split(name, "_")
+		if len(exploded) != 4 {
+			continue
+		}
+		roomID, err := strconv.Atoi(exploded[2])
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		startDate, err := time.Parse("2006-01-02", exploded[3])
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.DB.InsertBlockForRoom(r.Context(), roomID, startDate)
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Could not block %s, it is already reserved", exploded[3]))
+			continue
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+}
+
+// MaxUploadSize caps the size of a request body, room image uploads being the largest
+const MaxUploadSize = 16 << 20
+
+// AdminRooms lists every room, active or not, in display order
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewRoom shows the form for adding a room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["room"] = models.Room{Capacity: 2}
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostNewRoom adds a room
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var room models.Room
+	form := roomFromForm(r, &room)
+	if !form.Valid() {
+		renderRoomForm(w, r, room, form)
+		return
+	}
+
+	id, err := m.DB.InsertRoom(r.Context(), room)
+	if errors.Is(err, repository.ErrDuplicateSlug) {
+		form.Errors.Add("slug", "Another room already uses this slug")
+		renderRoomForm(w, r, room, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminShowRoom shows the form for editing a room and its images
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	renderRoomForm(w, r, room, forms.New(nil))
+}
+
+// PostAdminShowRoom saves changes made to a room's details
+func (m *Repository) PostAdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	form := roomFromForm(r, &room)
+	if !form.Valid() {
+		renderRoomForm(w, r, room, form)
+		return
+	}
+
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if errors.Is(err, repository.ErrDuplicateSlug) {
+		form.Errors.Add("slug", "Another room already uses this slug")
+		renderRoomForm(w, r, room, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminDeactivateRoom hides a room from guests, keeping its reservations
+func (m *Repository) AdminDeactivateRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomActive(w, r, false, "Room deactivated")
+}
+
+// AdminActivateRoom makes a deactivated room bookable again
+func (m *Repository) AdminActivateRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomActive(w, r, true, "Room activated")
+}
+
+// setRoomActive sets the active flag of the room in the URL and returns to the room list
+func (m *Repository) setRoomActive(w http.ResponseWriter, r *http.Request, active bool, flash string) {
+	id, err := adminRoomID(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateActiveForRoom(r.Context(), id, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminMoveRoom moves a room one place up or down the display order
+func (m *Repository) AdminMoveRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := adminRoomID(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	ids := make([]int, len(rooms))
+	pos := -1
+	for i, rm := range rooms {
+		ids[i] = rm.ID
+		if rm.ID == id {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	other := pos + 1
+	if r.Form.Get("direction") == "up" {
+		other = pos - 1
+	}
+	if other < 0 || other >= len(ids) {
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	ids[pos], ids[other] = ids[other], ids[pos]
+
+	err = m.DB.UpdateRoomOrder(r.Context(), ids)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminUploadRoomImage adds an uploaded picture, and its thumbnail, to the end of a room's images
+func (m *Repository) AdminUploadRoomImage(w http.ResponseWriter, r *http.Request) {
+	// the body has been capped by the LimitRequestBody middleware, and usually parsed by NoSurf
+	err := r.ParseMultipartForm(MaxUploadSize)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+	returnURL := fmt.Sprintf("/admin/rooms/%d", room.ID)
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose an image to upload")
+		http.Redirect(w, r, returnURL, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	store := images.NewStore(m.App.UploadDir)
+	url, err := store.Save(file)
+	if errors.Is(err, images.ErrUnsupported) || errors.Is(err, images.ErrTooLarge) {
+		m.App.Session.Put(r.Context(), "error", "Upload a JPEG, PNG or GIF image of at most 40 megapixels")
+		http.Redirect(w, r, returnURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room.Images = append(room.Images, url)
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if err != nil {
+		_ = store.Delete(url)
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Image uploaded")
+	http.Redirect(w, r, returnURL, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomImage removes a picture from a room, deleting the files of uploaded ones
+func (m *Repository) AdminDeleteRoomImage(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	image := r.Form.Get("image")
+	var kept []string
+	for _, x := range room.Images {
+		if x != image {
+			kept = append(kept, x)
+		}
+	}
+	if len(kept) == len(room.Images) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	room.Images = kept
+
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the room no longer refers to the files, so failing to delete them only wastes space
+	if err = images.NewStore(m.App.UploadDir).Delete(image); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Image removed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
+
+// adminRoom loads the room named in the URL, writing an error response and returning false when it cannot
+func (m *Repository) adminRoom(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := adminRoomID(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return room, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return room, false
+	}
+
+	return room, true
+}
+
+// adminRoomID extracts id from paths of the form /admin/rooms/{id}/...
+func adminRoomID(r *http.Request) (int, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 || exploded[2] != "rooms" {
+		return 0, fmt.Errorf("adminRoomID: malformed path %s", r.URL.Path)
+	}
+
+	return strconv.Atoi(exploded[3])
+}
+
+// nonSlugChars matches the runs of characters a slug replaces with a hyphen
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a room name such as "Major's Suite" into a slug such as "major-s-suite"
+func slugify(name string) string {
+	return strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// roomFromForm copies the posted room details onto room and validates them. A blank slug is
+// made from the room name. Amenities are entered one per line.
+func roomFromForm(r *http.Request, room *models.Room) *forms.Form {
+	if strings.TrimSpace(r.Form.Get("slug")) == "" {
+		r.PostForm.Set("slug", slugify(r.Form.Get("room_name")))
+		r.Form.Set("slug", r.PostForm.Get("slug"))
+	}
+
+	room.RoomName = strings.TrimSpace(r.Form.Get("room_name"))
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	room.Description = strings.TrimSpace(r.Form.Get("description"))
+	room.Capacity, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("capacity")))
+	room.NightlyRate, _ = pricing.ParseAmount(r.Form.Get("nightly_rate"))
+
+	room.Amenities = nil
+	for _, line := range strings.Split(r.Form.Get("amenities"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			room.Amenities = append(room.Amenities, line)
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name")
+	form.MinLength("room_name", 3)
+	form.IsSlug("slug")
+	form.IsInt("capacity", 1, 20)
+	form.IsAmount("nightly_rate")
+
+	return form
+}
+
+// renderRoomForm renders the page for adding or, when room has an ID, editing a room. A posted
+// nightly rate is shown as entered so that a mistake in it can be corrected.
+func renderRoomForm(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	rate := strings.ReplaceAll(pricing.FormatAmount(room.NightlyRate), ",", "")
+	if form.Values.Has("nightly_rate") {
+		rate = form.Get("nightly_rate")
+	}
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+		StringMap: map[string]string{
+			"amenities":    strings.Join(room.Amenities, "\n"),
+			"nightly_rate": rate,
+		},
+	})
+}
+
+// AdminPricing lists the rate overrides and length-of-stay discounts, with forms for adding more
+func (m *Repository) AdminPricing(w http.ResponseWriter, r *http.Request) {
+	m.renderPricing(w, r, forms.New(nil))
+}
+
+// AdminPostRateOverride adds a rate override for a room
+func (m *Repository) AdminPostRateOverride(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("override_name")
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+	form.IsAmount("nightly_rate")
+
+	o := models.RateOverride{Name: strings.TrimSpace(r.Form.Get("override_name"))}
+	o.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
+	o.StartDate, _ = time.Parse("2006-01-02", strings.TrimSpace(r.Form.Get("start_date")))
+	o.EndDate, _ = time.Parse("2006-01-02", strings.TrimSpace(r.Form.Get("end_date")))
+	o.NightlyRate, _ = pricing.ParseAmount(r.Form.Get("nightly_rate"))
+
+	if form.Valid() && !o.EndDate.After(o.StartDate) {
+		form.Errors.Add("end_date", "The end date must be after the start date")
+	}
+	for _, v := range r.Form["weekdays"] {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < int(time.Sunday) || d > int(time.Saturday) {
+			form.Errors.Add("weekdays", "Choose days of the week from the list")
+			break
+		}
+		o.Weekdays = append(o.Weekdays, time.Weekday(d))
+	}
+
+	_, err = m.DB.GetRoomByID(r.Context(), o.RoomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("room_id", "Choose a room")
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		m.renderPricing(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertRateOverride(r.Context(), o)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate override added")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// AdminDeleteRateOverride removes a rate override
+func (m *Repository) AdminDeleteRateOverride(w http.ResponseWriter, r *http.Request) {
+	id, err := adminPricingID(r, "overrides")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteRateOverride(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate override removed")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// AdminPostStayDiscount adds a length-of-stay discount
+func (m *Repository) AdminPostStayDiscount(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("discount_name")
+	form.IsInt("min_nights", 1, 365)
+	form.IsInt("percent", 1, 100)
+
+	if !form.Valid() {
+		m.renderPricing(w, r, form)
+		return
+	}
+
+	d := models.StayDiscount{Name: strings.TrimSpace(r.Form.Get("discount_name"))}
+	d.MinNights, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("min_nights")))
+	d.Percent, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("percent")))
+
+	_, err = m.DB.InsertStayDiscount(r.Context(), d)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Discount added")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// AdminDeleteStayDiscount removes a length-of-stay discount
+func (m *Repository) AdminDeleteStayDiscount(w http.ResponseWriter, r *http.Request) {
+	id, err := adminPricingID(r, "discounts")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteStayDiscount(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Discount removed")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// adminPricingID extracts id from paths of the form /admin/pricing/{kind}/{id}/...
+func adminPricingID(r *http.Request, kind string) (int, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 5 || exploded[2] != "pricing" || exploded[3] != kind {
+		return 0, fmt.Errorf("adminPricingID: malformed path %s", r.URL.Path)
+	}
+
+	return strconv.Atoi(exploded[4])
+}
+
+// renderPricing renders the pricing page, keeping any values posted with form
+func (m *Repository) renderPricing(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	overrides, err := m.DB.AllRateOverrides(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	discounts, err := m.DB.AllStayDiscounts(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	checked := make(map[string]bool)
+	for _, v := range form.Values["weekdays"] {
+		checked[v] = true
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["overrides"] = overrides
+	data["discounts"] = discounts
+	data["weekdays"] = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+	data["checked"] = checked
+
+	render.Template(w, r, "admin-pricing.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// emailPreview is an email template as listed on the admin emails page
+type emailPreview struct {
+	Name    string
+	Subject string
+}
+
+// AdminEmails lists the email templates, with links to preview each one
+func (m *Repository) AdminEmails(w http.ResponseWriter, r *http.Request) {
+	names, err := render.EmailTemplates()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := m.emailData(sampleReservation())
+	var previews []emailPreview
+	for _, name := range names {
+		msg, err := render.Email(name, data)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		previews = append(previews, emailPreview{Name: name, Subject: msg.Subject})
+	}
+
+	render.Template(w, r, "admin-emails.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{"emails": previews},
+	})
+}
+
+// AdminPreviewEmail renders the email template named in the path with a sample reservation,
+// showing its HTML part, or its plain text part when part=text
+func (m *Repository) AdminPreviewEmail(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.URL.Path, "/")
+	name := exploded[len(exploded)-1]
+
+	names, err := render.EmailTemplates()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	found := false
+	for _, n := range names {
+		found = found || n == name
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	msg, err := render.Email(name, m.emailData(sampleReservation()))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("part") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Subject: %s\n\n%s", msg.Subject, msg.Content)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, msg.HTML)
+}
+
+// sampleReservation is the made up booking email previews are rendered with
+func sampleReservation() models.Reservation {
+	start := time.Now().Truncate(24*time.Hour).AddDate(0, 0, 14)
+	return models.Reservation{
+		ID:               1,
+		FirstName:        "Jane",
+		LastName:         "Doe",
+		Email:            "jane@doe.com",
+		Phone:            "555-555-5555",
+		ConfirmationCode: "ABCD2345EFGH",
+		Status:           models.ReservationConfirmed,
+		StartDate:        start,
+		EndDate:          start.AddDate(0, 0, 2),
+		RoomID:           1,
+		Room:             models.Room{ID: 1, RoomName: "General's Quarters"},
+		Price: models.Quote{
+			Nights: []models.NightPrice{
+				{Date: start, Rate: 12000, Name: "Standard rate"},
+				{Date: start.AddDate(0, 0, 1), Rate: 15000, Name: "Weekend"},
+			},
+			Subtotal: 27000,
+			Total:    27000,
+			Deposit:  5400,
+		},
+	}
+}
+
+// AdminPromoCodes lists the promo codes and how often each has been used, with a form for adding more
+func (m *Repository) AdminPromoCodes(w http.ResponseWriter, r *http.Request) {
+	m.renderPromoCodes(w, r, forms.New(nil))
+}
+
+// AdminPostPromoCode adds a promo code
+func (m *Repository) AdminPostPromoCode(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// codes are stored upper case, so show the form again with the code as it will be saved
+	r.PostForm.Set("code", pricing.NormalizeCode(r.PostForm.Get("code")))
+	for _, field := range []string{"max_uses", "min_nights"} {
+		if strings.TrimSpace(r.PostForm.Get(field)) == "" {
+			r.PostForm.Set(field, "0")
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.IsCode("code")
+	form.IsDate("valid_from")
+	form.IsDate("valid_until")
+	form.IsInt("max_uses", 0, 1000000)
+	form.IsInt("min_nights", 0, 365)
+
+	p := models.PromoCode{
+		Code: form.Get("code"),
+		Kind: form.Get("kind"),
+	}
+	switch p.Kind {
+	case models.PromoPercent:
+		form.IsInt("amount", 1, 100)
+		p.Amount, _ = strconv.Atoi(strings.TrimSpace(form.Get("amount")))
+	case models.PromoFixed:
+		form.IsAmount("amount")
+		p.Amount, _ = pricing.ParseAmount(form.Get("amount"))
+		if form.Errors.Get("amount") == "" && p.Amount == 0 {
+			form.Errors.Add("amount", "The discount must be more than zero")
+		}
+	default:
+		form.Errors.Add("kind", "Choose a percentage or a fixed amount")
+	}
+
+	p.ValidFrom, _ = time.Parse("2006-01-02", strings.TrimSpace(form.Get("valid_from")))
+	p.ValidUntil, _ = time.Parse("2006-01-02", strings.TrimSpace(form.Get("valid_until")))
+	if form.Errors.Get("valid_from") == "" && form.Errors.Get("valid_until") == "" && p.ValidUntil.Before(p.ValidFrom) {
+		form.Errors.Add("valid_until", "The code cannot expire before it starts")
+	}
+	p.MaxUses, _ = strconv.Atoi(strings.TrimSpace(form.Get("max_uses")))
+	p.MinNights, _ = strconv.Atoi(strings.TrimSpace(form.Get("min_nights")))
+
+	for _, v := range form.Values["room_ids"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			form.Errors.Add("room_ids", "Choose rooms from the list")
+			break
+		}
+		p.RoomIDs = append(p.RoomIDs, id)
+	}
+
+	if !form.Valid() {
+		m.renderPromoCodes(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertPromoCode(r.Context(), p)
+	if errors.Is(err, repository.ErrDuplicateCode) {
+		form.Errors.Add("code", "Another promo code already uses this code")
+		m.renderPromoCodes(w, r, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code added")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminDeletePromoCode removes a promo code; reservations that used it keep their discount
+func (m *Repository) AdminDeletePromoCode(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 || exploded[2] != "promo-codes" {
+		helpers.ServerError(w, fmt.Errorf("AdminDeletePromoCode: malformed path %s", r.URL.Path))
+		return
+	}
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeletePromoCode(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code removed")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// renderPromoCodes renders the promo codes page, keeping any values posted with form
+func (m *Repository) renderPromoCodes(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	codes, err := m.DB.AllPromoCodes(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	roomNames := make(map[int]string, len(rooms))
+	for _, rm := range rooms {
+		roomNames[rm.ID] = rm.RoomName
+	}
+
+	checked := make(map[string]bool)
+	for _, v := range form.Values["room_ids"] {
+		checked[v] = true
+	}
+
+	data := make(map[string]interface{})
+	data["codes"] = codes
+	data["rooms"] = rooms
+	data["roomNames"] = roomNames
+	data["checked"] = checked
+
+	render.Template(w, r, "admin-promo-codes.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// apiKeyScopes are the scopes an API key can be given, with what each allows
+var apiKeyScopes = []struct{ Scope, Description string }{
+	{models.ScopeReadAvailability, "List rooms, search availability and get quotes"},
+	{models.ScopeWriteReservations, "Book, look up and cancel reservations"},
+	{models.ScopeAdmin, "Everything, including listing every reservation"},
+}
+
+// AdminAPIKeys lists the API keys, with a form for issuing more
+func (m *Repository) AdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	m.renderAPIKeys(w, r, forms.New(nil))
+}
+
+// AdminPostAPIKey issues an API key. The key itself is shown once, on the page the admin is sent
+// back to, as only its hash is stored.
+func (m *Repository) AdminPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	k := models.APIKey{
+		Name:   strings.TrimSpace(form.Get("name")),
+		UserID: m.App.Session.GetInt(r.Context(), "user_id"),
+	}
+
+	for _, scope := range form.Values["scopes"] {
+		known := false
+		for _, s := range apiKeyScopes {
+			known = known || s.Scope == scope
+		}
+		if !known {
+			form.Errors.Add("scopes", "Choose scopes from the list")
+			break
+		}
+		k.Scopes = append(k.Scopes, scope)
+	}
+	if len(form.Values["scopes"]) == 0 {
+		form.Errors.Add("scopes", "Choose at least one scope")
+	}
+
+	if strings.TrimSpace(form.Get("expires_on")) != "" {
+		form.IsDate("expires_on")
+		k.ExpiresAt, _ = time.Parse("2006-01-02", strings.TrimSpace(form.Get("expires_on")))
+		if form.Errors.Get("expires_on") == "" && !k.ExpiresAt.After(localToday()) {
+			form.Errors.Add("expires_on", "The key must expire after today")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAPIKeys(w, r, form)
+		return
+	}
+
+	key, err := tokens.NewAPIKey()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	k.Prefix = key[:tokens.APIKeyDisplayLength]
+	k.Hash = tokens.HashAPIKey(key)
+
+	_, err = m.DB.InsertAPIKey(r.Context(), k)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "api_key", key)
+	m.App.Session.Put(r.Context(), "flash", "API key issued")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminDeleteAPIKey revokes an API key; programs using it are refused from then on
+func (m *Repository) AdminDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 || exploded[2] != "api-keys" {
+		helpers.ServerError(w, fmt.Errorf("AdminDeleteAPIKey: malformed path %s", r.URL.Path))
+		return
+	}
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteAPIKey(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "API key revoked")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// renderAPIKeys renders the API keys page, keeping any values posted with form. A key issued
+// just before is shown this once.
+func (m *Repository) renderAPIKeys(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	keys, err := m.DB.AllAPIKeys(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	checked := make(map[string]bool)
+	for _, v := range form.Values["scopes"] {
+		checked[v] = true
+	}
+
+	data := make(map[string]interface{})
+	data["keys"] = keys
+	data["scopes"] = apiKeyScopes
+	data["checked"] = checked
+
+	render.Template(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{
+			"new_key": m.App.Session.PopString(r.Context(), "api_key"),
+			"today":   localToday().Format("2006-01-02"),
+		},
+		Data: data,
+		Form: form,
+	})
 }
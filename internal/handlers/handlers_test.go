@@ -1,18 +1,32 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
 	"log"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/jeremydelacruz/go-bookings/internal/images"
+	"github.com/jeremydelacruz/go-bookings/internal/lifecycle"
+	"github.com/jeremydelacruz/go-bookings/internal/mail"
 	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/payments"
+	"github.com/jeremydelacruz/go-bookings/internal/tokens"
 )
 
 type handlerTest struct {
@@ -26,9 +40,36 @@ var tests = []handlerTest{
 	{"home", "/", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
-	{"generals", "/generals-quarters", "GET", http.StatusOK},
-	{"majors", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"generals", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"majors", "/rooms/majors-suite", "GET", http.StatusOK},
+	{"old generals link", "/generals-quarters", "GET", http.StatusOK},
+	{"old majors link", "/majors-suite", "GET", http.StatusOK},
+	{"missing room", "/rooms/no-such-room", "GET", http.StatusNotFound},
 	{"search", "/search-availability", "GET", http.StatusOK},
+	{"login", "/user/login", "GET", http.StatusOK},
+	{"logout", "/user/logout", "GET", http.StatusOK},
+	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
+	{"new res", "/admin/reservations-new", "GET", http.StatusOK},
+	{"all res", "/admin/reservations-all", "GET", http.StatusOK},
+	{"show res", "/admin/reservations/new/1", "GET", http.StatusOK},
+	{"show missing res", "/admin/reservations/new/999", "GET", http.StatusNotFound},
+	{"show malformed res", "/admin/reservations/new/abc", "GET", http.StatusNotFound},
+	{"show cal res", "/admin/reservations/cal/1?y=2050&m=01", "GET", http.StatusOK},
+	{"calendar", "/admin/reservations-calendar", "GET", http.StatusOK},
+	{"calendar with params", "/admin/reservations-calendar?y=2050&m=01", "GET", http.StatusOK},
+	{"calendar bad params", "/admin/reservations-calendar?y=abc&m=01", "GET", http.StatusInternalServerError},
+	{"admin rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"new room", "/admin/rooms/new", "GET", http.StatusOK},
+	{"show room", "/admin/rooms/1", "GET", http.StatusOK},
+	{"show missing room", "/admin/rooms/999", "GET", http.StatusNotFound},
+	{"pricing", "/admin/pricing", "GET", http.StatusOK},
+	{"promo codes", "/admin/promo-codes", "GET", http.StatusOK},
+	{"emails", "/admin/emails", "GET", http.StatusOK},
+	{"api keys", "/admin/api-keys", "GET", http.StatusOK},
+	{"email preview", "/admin/emails/reservation-confirmation.email.tmpl", "GET", http.StatusOK},
+	{"email preview text", "/admin/emails/reservation-notification.email.tmpl?part=text", "GET", http.StatusOK},
+	{"missing email preview", "/admin/emails/missing.email.tmpl", "GET", http.StatusNotFound},
 }
 
 var urlEncoded = "application/x-www-form-urlencoded"
@@ -52,7 +93,9 @@ func TestHandlers(t *testing.T) {
 
 func TestRepository_Reservation(t *testing.T) {
 	reservation := models.Reservation{
-		RoomID: 1,
+		RoomID:    1,
+		StartDate: time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 3, 3, 0, 0, 0, 0, time.UTC),
 		Room: models.Room{
 			ID:       1,
 			RoomName: "General's Quarters",
@@ -83,6 +126,20 @@ func TestRepository_Reservation(t *testing.T) {
 		t.Errorf("Reservation handler returned unexpected response code: got %d, expected %d", resRecorder.Code, http.StatusTemporaryRedirect)
 	}
 
+	// test when the stay cannot be priced
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	resRecorder = httptest.NewRecorder()
+	session.Put(ctx, "reservation", reservation)
+
+	failOn("GetRateOverridesForRoom")
+	handler.ServeHTTP(resRecorder, req)
+	clearFailures()
+	if resRecorder.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Reservation handler returned unexpected response code: got %d, expected %d", resRecorder.Code, http.StatusTemporaryRedirect)
+	}
+
 	// test when room is non-existent
 	req, _ = http.NewRequest("GET", "/make-reservation", nil)
 	ctx = getCtx(req)
@@ -182,34 +239,293 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("PostReservation invalid form field unexpected response code: got %d, expected %d", resRecorder.Code, http.StatusSeeOther)
 	}
 
-	// test unsuccessful reservation DB insert
+	// test reservation for a room that does not exist
 	validReader.Seek(0, 0)
 	req, _ = http.NewRequest("POST", "/make-reservation", validReader)
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", urlEncoded)
-	badReservation.RoomID = 999
 	session.Put(ctx, "reservation", badReservation)
 	resRecorder = httptest.NewRecorder()
 
 	handler.ServeHTTP(resRecorder, req)
 	if resRecorder.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation unsuccessful reservation insert unexpected response code: got %d, expected %d", resRecorder.Code, http.StatusTemporaryRedirect)
+		t.Errorf("PostReservation non-existent room unexpected response code: got %d, expected %d", resRecorder.Code, http.StatusTemporaryRedirect)
 	}
 
-	// test unsuccessful room restriction DB insert
+	// test unsuccessful DB insert
 	validReader.Seek(0, 0)
 	req, _ = http.NewRequest("POST", "/make-reservation", validReader)
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", urlEncoded)
-	badReservation.RoomID = 1000
-	session.Put(ctx, "reservation", badReservation)
+	session.Put(ctx, "reservation", reservation)
 	resRecorder = httptest.NewRecorder()
 
+	failOn("CreateReservation")
 	handler.ServeHTTP(resRecorder, req)
+	clearFailures()
 	if resRecorder.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation unsuccessful room restriction insert unexpected response code: got %d, expected %d", resRecorder.Code, http.StatusTemporaryRedirect)
+		t.Errorf("PostReservation unsuccessful insert unexpected response code: got %d, expected %d", resRecorder.Code, http.StatusTemporaryRedirect)
+	}
+
+	// test room booked by someone else in the meantime
+	takenReservation := models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		RoomID:    1,
+		StartDate: time.Date(2087, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2087, 6, 3, 0, 0, 0, 0, time.UTC),
+	}
+	if _, err := Repo.DB.CreateReservation(context.Background(), takenReservation); err != nil {
+		t.Fatal(err)
+	}
+	validReader.Seek(0, 0)
+	req, _ = http.NewRequest("POST", "/make-reservation", validReader)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", urlEncoded)
+	session.Put(ctx, "reservation", models.Reservation{
+		RoomID:    takenReservation.RoomID,
+		StartDate: takenReservation.StartDate,
+		EndDate:   takenReservation.EndDate,
+	})
+	resRecorder = httptest.NewRecorder()
+
+	handler.ServeHTTP(resRecorder, req)
+	if resRecorder.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation double booking unexpected response code: got %d, expected %d", resRecorder.Code, http.StatusSeeOther)
+	}
+	if loc := resRecorder.Header().Get("Location"); loc != "/search-availability" {
+		t.Errorf("PostReservation double booking unexpected redirect: got %s, expected /search-availability", loc)
+	}
+}
+
+func TestRepository_PostReservationEmails(t *testing.T) {
+	date := func(day int) time.Time { return time.Date(2085, time.January, day, 0, 0, 0, 0, time.UTC) }
+	drainMail()
+
+	body := url.Values{}
+	body.Add("first_name", "Jane")
+	body.Add("last_name", "Doe")
+	body.Add("email", "jane@doe.com")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(body.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", urlEncoded)
+	session.Put(ctx, "reservation", models.Reservation{
+		RoomID:    1,
+		Room:      models.Room{RoomName: "General's Quarters"},
+		StartDate: date(1),
+		EndDate:   date(3),
+	})
+	resRecorder := httptest.NewRecorder()
+	Repo.PostReservation(resRecorder, req)
+	if resRecorder.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d but got %d", http.StatusSeeOther, resRecorder.Code)
+	}
+	res := session.Get(ctx, "reservation").(models.Reservation)
+
+	queued := drainMail()
+	if len(queued) != 2 {
+		t.Fatalf("expected a guest and an owner email, got %d emails", len(queued))
+	}
+
+	guest, owner := queued[0], queued[1]
+	if guest.To != "jane@doe.com" || guest.From != "bookings@here.ca" || !strings.Contains(guest.Subject, res.ConfirmationCode) {
+		t.Errorf("unexpected guest email %+v", guest)
+	}
+	manageURL := "http://localhost:8080" + Repo.manageURL(res.ConfirmationCode)
+	if !strings.Contains(guest.Content, manageURL) || !strings.Contains(guest.HTML, `href="`+manageURL+`"`) {
+		t.Errorf("expected both parts of the guest email to link to the manage page, got %q and %q", guest.Content, guest.HTML)
+	}
+	if owner.To != "owner@here.ca" || !strings.Contains(owner.Content, fmt.Sprintf("http://localhost:8080/admin/reservations/new/%d", res.ID)) {
+		t.Errorf("unexpected owner email %+v", owner)
+	}
+
+	// a full queue drops the emails rather than holding up the booking
+	for mail.Queue(app.MailChan, mail.MailData{}) {
+	}
+	Repo.sendReservationEmails(res)
+	if len(drainMail()) != cap(app.MailChan) {
+		t.Error("expected the emails to be dropped when the queue is full")
+	}
+}
+
+func TestRepository_ScheduledEmails(t *testing.T) {
+	ctx := context.Background()
+	today := localToday()
+	day := func(n int) time.Time { return today.AddDate(0, 0, n) }
+
+	var ids []int
+	reserve := func(email string, start, end time.Time) int {
+		id, err := Repo.DB.CreateReservation(ctx, models.Reservation{
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     email,
+			StartDate: start,
+			EndDate:   end,
+			RoomID:    2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		return id
+	}
+	defer func() {
+		for _, id := range ids {
+			_ = Repo.DB.DeleteReservation(ctx, id)
+		}
+	}()
+
+	departed := reserve("departed@doe.com", day(-4), day(-1))
+	reserve("leaving@doe.com", day(-1), day(0))
+	arriving := reserve("arriving@doe.com", day(2), day(4))
+	reserve("later@doe.com", day(5), day(6))
+
+	// sentTo keys the queued emails by recipient, ignoring other tests' reservations
+	sentTo := func() map[string]mail.MailData {
+		sent := map[string]mail.MailData{}
+		for _, m := range drainMail() {
+			sent[m.To] = m
+		}
+		return sent
+	}
+	drainMail()
+
+	if err := Repo.SendArrivalReminders(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sent := sentTo()
+	reminder, ok := sent["arriving@doe.com"]
+	if !ok {
+		t.Fatalf("expected the guest arriving in 2 days to be reminded, got %+v", sent)
+	}
+	if _, ok = sent["later@doe.com"]; ok {
+		t.Error("expected the guest arriving in 5 days not to be reminded yet")
+	}
+	res, _ := Repo.DB.GetReservationByID(ctx, arriving)
+	if reminder.From != "bookings@here.ca" || !strings.Contains(reminder.Content, "Check-in is from 3:00 pm") ||
+		!strings.Contains(reminder.HTML, "http://localhost:8080"+Repo.manageURL(res.ConfirmationCode)) {
+		t.Errorf("unexpected reminder %+v", reminder)
+	}
+
+	if err := Repo.SendFollowUps(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sent = sentTo()
+	followUp, ok := sent["departed@doe.com"]
+	if !ok {
+		t.Fatalf("expected the guest who left yesterday to be thanked, got %+v", sent)
+	}
+	if _, ok = sent["leaving@doe.com"]; ok {
+		t.Error("expected the guest leaving today not to be thanked yet")
+	}
+	if !strings.Contains(followUp.Content, "http://localhost:8080/contact") {
+		t.Errorf("expected the follow-up to ask for a review, got %q", followUp.Content)
+	}
+
+	// running the jobs again, as after a restart, sends nothing new
+	if err := Repo.SendArrivalReminders(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := Repo.SendFollowUps(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sent = sentTo()
+	for _, to := range []string{"arriving@doe.com", "departed@doe.com"} {
+		if _, ok = sent[to]; ok {
+			t.Errorf("expected %s to be emailed only once", to)
+		}
+	}
+
+	for _, id := range []int{arriving, departed} {
+		if messages, err := Repo.DB.GetSentMessagesForReservation(ctx, id); err != nil || len(messages) != 1 {
+			t.Errorf("expected one message recorded for reservation %d, got %+v (%v)", id, messages, err)
+		}
+	}
+
+	resRecorder := httptest.NewRecorder()
+	getRoutes().ServeHTTP(resRecorder, httptest.NewRequest("GET", fmt.Sprintf("/admin/reservations/all/%d", arriving), nil))
+	if !strings.Contains(resRecorder.Body.String(), "Arrival reminder") {
+		t.Error("expected the reservation page to list the reminder sent")
+	}
+
+	// a reminder that could not be sent is sent again on the next run
+	late := reserve("late@doe.com", day(1), day(2))
+	if err := Repo.SendArrivalReminders(ctx); err != nil {
+		t.Fatal(err)
+	}
+	failed, ok := sentTo()["late@doe.com"]
+	if !ok {
+		t.Fatal("expected the guest arriving tomorrow to be reminded")
+	}
+	failed.Failed(errors.New("connection refused"))
+	if err := Repo.SendArrivalReminders(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = sentTo()["late@doe.com"]; !ok {
+		t.Error("expected a reminder the worker could not send to be sent again")
+	}
+
+	// as is one that could not be queued before the job gave up
+	if err := Repo.DB.DeleteSentMessage(ctx, late, models.MessageArrivalReminder); err != nil {
+		t.Fatal(err)
+	}
+	for mail.Queue(app.MailChan, mail.MailData{}) {
+	}
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	err := Repo.SendArrivalReminders(timeout)
+	cancel()
+	drainMail()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the job to give up on a full queue, got %v", err)
+	}
+	if messages, _ := Repo.DB.GetSentMessagesForReservation(ctx, late); len(messages) != 0 {
+		t.Errorf("expected the reminder that was never queued to be forgotten, got %+v", messages)
+	}
+	if err = Repo.DB.DeleteReservation(ctx, late); err != nil {
+		t.Fatal(err)
+	}
+
+	failOn("RecordSentMessage")
+	reserve("failing@doe.com", day(1), day(2))
+	err = Repo.SendArrivalReminders(ctx)
+	clearFailures()
+	if err == nil {
+		t.Error("expected a database failure to fail the job")
+	}
+	if _, ok = sentTo()["failing@doe.com"]; ok {
+		t.Error("expected no email when it could not be recorded")
+	}
+}
+
+func TestRepository_AdminPreviewEmail(t *testing.T) {
+	var previewTests = []struct {
+		name                string
+		url                 string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{"html", "/admin/emails/reservation-confirmation.email.tmpl", "text/html; charset=utf-8", `<a class="button" href="http://localhost:8080/manage/ABCD2345EFGH/`},
+		{"text", "/admin/emails/reservation-confirmation.email.tmpl?part=text", "text/plain; charset=utf-8", "Subject: Reservation confirmation ABCD2345EFGH"},
+	}
+
+	for _, test := range previewTests {
+		resRecorder := httptest.NewRecorder()
+		getRoutes().ServeHTTP(resRecorder, httptest.NewRequest("GET", test.url, nil))
+		if resRecorder.Code != http.StatusOK {
+			t.Errorf("for %s, expected %d but got %d", test.name, http.StatusOK, resRecorder.Code)
+		}
+		if ct := resRecorder.Header().Get("Content-Type"); ct != test.expectedContentType {
+			t.Errorf("for %s, expected content type %s but got %s", test.name, test.expectedContentType, ct)
+		}
+		if !strings.Contains(resRecorder.Body.String(), test.expectedBody) {
+			t.Errorf("for %s, expected the preview to contain %q, got %q", test.name, test.expectedBody, resRecorder.Body.String())
+		}
 	}
 }
 
@@ -236,23 +552,108 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	}
 }
 
+func TestRepository_AvailabilityJSONErrors(t *testing.T) {
+	var errorTests = []struct {
+		name         string
+		start        string
+		roomID       string
+		failOn       string
+		expectedCode int
+	}{
+		{"bad start date", "tomorrow", "1", "", http.StatusBadRequest},
+		{"bad room", "2050-01-01", "one", "", http.StatusBadRequest},
+		{"unknown room", "2050-01-01", "999", "", http.StatusNotFound},
+		{"room database error", "2050-01-01", "1", "GetRoomByID", http.StatusInternalServerError},
+		{"database error", "2050-01-01", "1", "SearchAvailabilityByDatesByRoomID", http.StatusInternalServerError},
+	}
+
+	for _, test := range errorTests {
+		reqBody := url.Values{}
+		reqBody.Add("start", test.start)
+		reqBody.Add("end", "2050-01-02")
+		reqBody.Add("room_id", test.roomID)
+
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody.Encode()))
+		req.Header.Set("Content-Type", urlEncoded)
+		resRecorder := httptest.NewRecorder()
+
+		if test.failOn != "" {
+			failOn(test.failOn)
+		}
+		Repo.AvailabilityJSON(resRecorder, req)
+		clearFailures()
+
+		var res jsonResponse
+		if resRecorder.Code != test.expectedCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedCode, resRecorder.Code)
+		}
+		if err := json.Unmarshal(resRecorder.Body.Bytes(), &res); err != nil || res.Ok || res.Message == "" {
+			t.Errorf("for %s, expected a JSON error, got %q", test.name, resRecorder.Body.String())
+		}
+	}
+}
+
 func TestRepository_PostAvailability(t *testing.T) {
-	// initialize url-encoded form fields
+	var availabilityTests = []struct {
+		name               string
+		start              string
+		end                string
+		failOn             string
+		expectedStatusCode int
+	}{
+		{"rooms available", "2050-03-01", "2050-03-03", "", http.StatusOK},
+		{"fully booked", "2050-02-02", "2050-02-03", "", http.StatusSeeOther},
+		{"database error", "2050-03-01", "2050-03-03", "SearchAvailabilityForAllRooms", http.StatusInternalServerError},
+		{"pricing error", "2050-03-01", "2050-03-03", "AllStayDiscounts", http.StatusInternalServerError},
+		{"bad date", "2050-03-01", "soon", "", http.StatusSeeOther},
+		{"reversed dates", "2050-03-03", "2050-03-01", "", http.StatusSeeOther},
+		{"past dates", "2020-03-01", "2020-03-03", "", http.StatusSeeOther},
+		{"stay too long", "2050-03-01", "9999-12-31", "", http.StatusSeeOther},
+	}
+
+	handler := http.HandlerFunc(Repo.PostAvailability)
+
+	for _, test := range availabilityTests {
+		reqBody := url.Values{}
+		reqBody.Add("start", test.start)
+		reqBody.Add("end", test.end)
+
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", urlEncoded)
+		resRecorder := httptest.NewRecorder()
+
+		if test.failOn != "" {
+			failOn(test.failOn)
+		}
+		handler.ServeHTTP(resRecorder, req)
+		clearFailures()
+
+		if resRecorder.Code != test.expectedStatusCode {
+			t.Errorf("for %s, got status code: %d, expected: %d", test.name, resRecorder.Code, test.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_PostAvailabilityCancelled(t *testing.T) {
 	reqBody := url.Values{}
 	reqBody.Add("start", "2050-01-01")
-	reqBody.Add("end", "2050-01-01")
+	reqBody.Add("end", "2050-01-02")
 
 	handler := http.HandlerFunc(Repo.PostAvailability)
 
 	req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
-	ctx := getCtx(req)
+	ctx, cancel := context.WithCancel(getCtx(req))
+	cancel()
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", urlEncoded)
 	resRecorder := httptest.NewRecorder()
 
+	// the repository must see the request context, so a client disconnect aborts the query
 	handler.ServeHTTP(resRecorder, req)
-	if resRecorder.Code != http.StatusSeeOther {
-		t.Errorf("got status code: %d, expected: %d", resRecorder.Code, http.StatusSeeOther)
+	if resRecorder.Code != http.StatusInternalServerError {
+		t.Errorf("got status code: %d, expected: %d", resRecorder.Code, http.StatusInternalServerError)
 	}
 }
 
@@ -301,21 +702,1603 @@ func TestRepository_ChooseRoom(t *testing.T) {
 }
 
 func TestRepository_BookRoom(t *testing.T) {
-	id := "id=1"
-	start := "s=2050-01-01"
-	end := "e=2050-01-01"
-	reqURI := fmt.Sprintf("/book-room?%s&%s&%s", id, start, end)
+	var bookRoomTests = []struct {
+		name             string
+		query            string
+		expectedLocation string
+		expectedError    string
+	}{
+		{"valid stay", "id=1&s=2050-01-01&e=2050-01-03", "/make-reservation", ""},
+		{"bad date", "id=1&s=2050-01-01&e=soon", "/search-availability", "Invalid departure date"},
+		{"no nights", "id=1&s=2050-01-01&e=2050-01-01", "/search-availability", "The departure date must be after the arrival date"},
+		{"past dates", "id=1&s=2020-01-01&e=2020-01-03", "/search-availability", "The arrival date cannot be in the past"},
+		{"stay too long", "id=1&s=2050-01-01&e=9999-12-31", "/search-availability", "Stays can be at most 30 nights"},
+	}
 
 	handler := http.HandlerFunc(Repo.BookRoom)
 
-	req, _ := http.NewRequest("GET", reqURI, nil)
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
+	for _, test := range bookRoomTests {
+		req, _ := http.NewRequest("GET", "/book-room?"+test.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		resRecorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != http.StatusSeeOther {
+			t.Errorf("for %s, got status code: %d, expected: %d", test.name, resRecorder.Code, http.StatusSeeOther)
+		}
+		if location := resRecorder.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("for %s, redirected to %q, expected %q", test.name, location, test.expectedLocation)
+		}
+		if got := session.PopString(ctx, "error"); got != test.expectedError {
+			t.Errorf("for %s, got error %q, expected %q", test.name, got, test.expectedError)
+		}
+	}
+}
+
+func TestRepository_InactiveRoomNotBookable(t *testing.T) {
+	ctx := context.Background()
+	if err := Repo.DB.UpdateActiveForRoom(ctx, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	defer Repo.DB.UpdateActiveForRoom(ctx, 2, true)
+
+	var inactiveTests = []struct {
+		name    string
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"book room", "/book-room?id=2&s=2087-07-01&e=2087-07-03", Repo.BookRoom},
+		{"book unknown room", "/book-room?id=999&s=2087-07-01&e=2087-07-03", Repo.BookRoom},
+		{"choose room", "/choose-room/2", Repo.ChooseRoom},
+		{"make reservation", "/make-reservation", Repo.PostReservation},
+	}
+
+	for _, test := range inactiveTests {
+		body := url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"jane@doe.com"}}
+		req, _ := http.NewRequest("POST", test.path, strings.NewReader(body.Encode()))
+		req.RequestURI = test.path
+		req.Header.Set("Content-Type", urlEncoded)
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", models.Reservation{
+			RoomID:    2,
+			StartDate: time.Date(2087, 7, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2087, 7, 3, 0, 0, 0, 0, time.UTC),
+		})
+		req = req.WithContext(ctx)
+		resRecorder := httptest.NewRecorder()
+
+		test.handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != http.StatusSeeOther || resRecorder.Header().Get("Location") != "/search-availability" {
+			t.Errorf("for %s, expected to be sent back to search, got %d to %q", test.name, resRecorder.Code, resRecorder.Header().Get("Location"))
+		}
+		if session.GetString(ctx, "error") == "" {
+			t.Errorf("for %s, expected an error message", test.name)
+		}
+	}
+
+	reqBody := url.Values{"start": {"2087-07-01"}, "end": {"2087-07-03"}, "room_id": {"2"}}
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody.Encode()))
+	req.Header.Set("Content-Type", urlEncoded)
 	resRecorder := httptest.NewRecorder()
+	Repo.AvailabilityJSON(resRecorder, req)
+	if resRecorder.Code != http.StatusNotFound {
+		t.Errorf("expected an inactive room to be unknown to the availability check, got %d", resRecorder.Code)
+	}
+}
 
-	handler.ServeHTTP(resRecorder, req)
-	if resRecorder.Code != http.StatusSeeOther {
-		t.Errorf("got status code: %d, expected: %d", resRecorder.Code, http.StatusSeeOther)
+var loginTests = []struct {
+	name               string
+	email              string
+	password           string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"valid credentials", "me@here.ca", "password", http.StatusSeeOther, "/"},
+	{"invalid credentials", "jack@nimble.com", "password", http.StatusSeeOther, "/user/login"},
+	{"invalid form", "not-an-email", "", http.StatusOK, ""},
+}
+
+func TestRepository_PostShowLogin(t *testing.T) {
+	for _, test := range loginTests {
+		postedData := url.Values{}
+		postedData.Add("email", test.email)
+		postedData.Add("password", test.password)
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", urlEncoded)
+		resRecorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler.ServeHTTP(resRecorder, req)
+
+		if resRecorder.Code != test.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedStatusCode, resRecorder.Code)
+		}
+
+		if test.expectedLocation != "" {
+			if loc := resRecorder.Header().Get("Location"); loc != test.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", test.name, test.expectedLocation, loc)
+			}
+		}
+
+		if test.name == "valid credentials" && !session.Exists(ctx, "user_id") {
+			t.Errorf("for %s, expected user_id in session", test.name)
+		}
+		if test.name == "valid credentials" && session.GetInt(ctx, "access_level") != models.AccessLevelAdmin {
+			t.Errorf("for %s, expected the admin access level in session", test.name)
+		}
+	}
+}
+
+var adminPostTests = []struct {
+	name               string
+	path               string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	postedData         url.Values
+	failOn             string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name:    "valid update",
+		path:    "/admin/reservations/new/1",
+		handler: (*Repository).PostAdminShowReservation,
+		postedData: url.Values{
+			"first_name": {"Jane"},
+			"last_name":  {"Doe"},
+			"email":      {"jane@doe.com"},
+			"phone":      {"1234567890"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-new",
+	},
+	{
+		name:    "invalid update",
+		path:    "/admin/reservations/all/1",
+		handler: (*Repository).PostAdminShowReservation,
+		postedData: url.Values{
+			"first_name": {"J"},
+			"last_name":  {"Doe"},
+			"email":      {"jane"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:    "failed update",
+		path:    "/admin/reservations/all/1",
+		handler: (*Repository).PostAdminShowReservation,
+		postedData: url.Values{
+			"first_name": {"Jane"},
+			"last_name":  {"Doe"},
+			"email":      {"jane@doe.com"},
+		},
+		failOn:             "UpdateReservation",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "unknown source",
+		path:               "/admin/reservations/other/1",
+		handler:            (*Repository).PostAdminShowReservation,
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:    "update missing reservation",
+		path:    "/admin/reservations/all/999",
+		handler: (*Repository).PostAdminShowReservation,
+		postedData: url.Values{
+			"first_name": {"Jane"},
+			"last_name":  {"Doe"},
+			"email":      {"jane@doe.com"},
+		},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "process",
+		path:               "/admin/process-reservation/new/1",
+		handler:            (*Repository).AdminProcessReservation,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-new",
+	},
+	{
+		name:               "failed process",
+		path:               "/admin/process-reservation/new/1",
+		handler:            (*Repository).AdminProcessReservation,
+		failOn:             "UpdateProcessedForReservation",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "delete",
+		path:               "/admin/delete-reservation/all/1",
+		handler:            (*Repository).AdminDeleteReservation,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-all",
+	},
+	{
+		name:               "failed delete",
+		path:               "/admin/delete-reservation/all/1",
+		handler:            (*Repository).AdminDeleteReservation,
+		failOn:             "DeleteReservation",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "delete from calendar",
+		path:               "/admin/delete-reservation/cal/1?y=2050&m=01",
+		handler:            (*Repository).AdminDeleteReservation,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-calendar?y=2050&m=01",
+	},
+	{
+		name:    "add room",
+		path:    "/admin/rooms/new",
+		handler: (*Repository).AdminPostNewRoom,
+		postedData: url.Values{
+			"room_name":    {"Colonel's Cabin"},
+			"capacity":     {"3"},
+			"nightly_rate": {"150"},
+			"amenities":    {"Porch\r\nWood stove\r\n"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms/3",
+	},
+	{
+		name:               "invalid room",
+		path:               "/admin/rooms/new",
+		handler:            (*Repository).AdminPostNewRoom,
+		postedData:         url.Values{"room_name": {""}, "capacity": {"0"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "duplicate slug",
+		path:               "/admin/rooms/new",
+		handler:            (*Repository).AdminPostNewRoom,
+		postedData:         url.Values{"room_name": {"Another General"}, "slug": {"generals-quarters"}, "capacity": {"2"}, "nightly_rate": {"120"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "failed add room",
+		path:               "/admin/rooms/new",
+		handler:            (*Repository).AdminPostNewRoom,
+		postedData:         url.Values{"room_name": {"Captain's Cot"}, "capacity": {"1"}, "nightly_rate": {"80"}},
+		failOn:             "InsertRoom",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "edit room",
+		path:               "/admin/rooms/1",
+		handler:            (*Repository).PostAdminShowRoom,
+		postedData:         url.Values{"room_name": {"General's Quarters"}, "slug": {"generals-quarters"}, "capacity": {"2"}, "nightly_rate": {"120.00"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+	{
+		name:               "invalid edit room",
+		path:               "/admin/rooms/1",
+		handler:            (*Repository).PostAdminShowRoom,
+		postedData:         url.Values{"room_name": {"General's Quarters"}, "slug": {"Generals Quarters"}, "capacity": {"2"}, "nightly_rate": {"120"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "invalid nightly rate",
+		path:               "/admin/rooms/1",
+		handler:            (*Repository).PostAdminShowRoom,
+		postedData:         url.Values{"room_name": {"General's Quarters"}, "slug": {"generals-quarters"}, "capacity": {"2"}, "nightly_rate": {"$120"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "edit missing room",
+		path:               "/admin/rooms/999",
+		handler:            (*Repository).PostAdminShowRoom,
+		postedData:         url.Values{"room_name": {"Nowhere"}, "capacity": {"2"}},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "deactivate room",
+		path:               "/admin/rooms/3/deactivate",
+		handler:            (*Repository).AdminDeactivateRoom,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+	{
+		name:               "activate room",
+		path:               "/admin/rooms/3/activate",
+		handler:            (*Repository).AdminActivateRoom,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+	{
+		name:               "failed deactivate room",
+		path:               "/admin/rooms/3/deactivate",
+		handler:            (*Repository).AdminDeactivateRoom,
+		failOn:             "UpdateActiveForRoom",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "move room up",
+		path:               "/admin/rooms/3/move",
+		handler:            (*Repository).AdminMoveRoom,
+		postedData:         url.Values{"direction": {"up"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+	{
+		name:               "move room down",
+		path:               "/admin/rooms/3/move",
+		handler:            (*Repository).AdminMoveRoom,
+		postedData:         url.Values{"direction": {"down"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+	{
+		name:               "move last room down",
+		path:               "/admin/rooms/3/move",
+		handler:            (*Repository).AdminMoveRoom,
+		postedData:         url.Values{"direction": {"down"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+	{
+		name:               "failed move room",
+		path:               "/admin/rooms/3/move",
+		handler:            (*Repository).AdminMoveRoom,
+		postedData:         url.Values{"direction": {"up"}},
+		failOn:             "UpdateRoomOrder",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "move missing room",
+		path:               "/admin/rooms/999/move",
+		handler:            (*Repository).AdminMoveRoom,
+		postedData:         url.Values{"direction": {"up"}},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "remove unknown image",
+		path:               "/admin/rooms/1/images/delete",
+		handler:            (*Repository).AdminDeleteRoomImage,
+		postedData:         url.Values{"image": {"/static/images/nope.png"}},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "failed remove image",
+		path:               "/admin/rooms/1/images/delete",
+		handler:            (*Repository).AdminDeleteRoomImage,
+		postedData:         url.Values{"image": {"/static/images/generals-quarters.png"}},
+		failOn:             "UpdateRoom",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:    "invalid rate override",
+		path:    "/admin/pricing/overrides",
+		handler: (*Repository).AdminPostRateOverride,
+		postedData: url.Values{
+			"room_id":       {"1"},
+			"override_name": {"Backwards"},
+			"start_date":    {"2050-07-01"},
+			"end_date":      {"2050-06-01"},
+			"nightly_rate":  {"150"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:    "rate override for missing room",
+		path:    "/admin/pricing/overrides",
+		handler: (*Repository).AdminPostRateOverride,
+		postedData: url.Values{
+			"room_id":       {"999"},
+			"override_name": {"Summer"},
+			"start_date":    {"2050-06-01"},
+			"end_date":      {"2050-09-01"},
+			"nightly_rate":  {"150"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:    "bad weekday",
+		path:    "/admin/pricing/overrides",
+		handler: (*Repository).AdminPostRateOverride,
+		postedData: url.Values{
+			"room_id":       {"1"},
+			"override_name": {"Weekend"},
+			"start_date":    {"2050-06-01"},
+			"end_date":      {"2050-09-01"},
+			"nightly_rate":  {"150"},
+			"weekdays":      {"5", "7"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:    "failed add rate override",
+		path:    "/admin/pricing/overrides",
+		handler: (*Repository).AdminPostRateOverride,
+		postedData: url.Values{
+			"room_id":       {"1"},
+			"override_name": {"Summer"},
+			"start_date":    {"2050-06-01"},
+			"end_date":      {"2050-09-01"},
+			"nightly_rate":  {"150"},
+		},
+		failOn:             "InsertRateOverride",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "failed remove rate override",
+		path:               "/admin/pricing/overrides/1/delete",
+		handler:            (*Repository).AdminDeleteRateOverride,
+		failOn:             "DeleteRateOverride",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "invalid discount",
+		path:               "/admin/pricing/discounts",
+		handler:            (*Repository).AdminPostStayDiscount,
+		postedData:         url.Values{"discount_name": {"Too generous"}, "min_nights": {"7"}, "percent": {"150"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "failed add discount",
+		path:               "/admin/pricing/discounts",
+		handler:            (*Repository).AdminPostStayDiscount,
+		postedData:         url.Values{"discount_name": {"Weekly"}, "min_nights": {"7"}, "percent": {"10"}},
+		failOn:             "InsertStayDiscount",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:    "invalid promo code",
+		path:    "/admin/promo-codes",
+		handler: (*Repository).AdminPostPromoCode,
+		postedData: url.Values{
+			"code":        {"no spaces"},
+			"kind":        {"percent"},
+			"amount":      {"150"},
+			"valid_from":  {"2050-06-30"},
+			"valid_until": {"2050-06-01"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:    "unknown promo kind",
+		path:    "/admin/promo-codes",
+		handler: (*Repository).AdminPostPromoCode,
+		postedData: url.Values{
+			"code":        {"FREEBIE"},
+			"kind":        {"free"},
+			"amount":      {"10"},
+			"valid_from":  {"2050-06-01"},
+			"valid_until": {"2050-06-30"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:    "failed add promo code",
+		path:    "/admin/promo-codes",
+		handler: (*Repository).AdminPostPromoCode,
+		postedData: url.Values{
+			"code":        {"SUMMER10"},
+			"kind":        {"percent"},
+			"amount":      {"10"},
+			"valid_from":  {"2050-06-01"},
+			"valid_until": {"2050-08-31"},
+		},
+		failOn:             "InsertPromoCode",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "failed remove promo code",
+		path:               "/admin/promo-codes/1/delete",
+		handler:            (*Repository).AdminDeletePromoCode,
+		failOn:             "DeletePromoCode",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "failed remove discount",
+		path:               "/admin/pricing/discounts/1/delete",
+		handler:            (*Repository).AdminDeleteStayDiscount,
+		failOn:             "DeleteStayDiscount",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "invalid api key",
+		path:               "/admin/api-keys",
+		handler:            (*Repository).AdminPostAPIKey,
+		postedData:         url.Values{"name": {""}, "expires_on": {"2001-01-01"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "unknown api key scope",
+		path:               "/admin/api-keys",
+		handler:            (*Repository).AdminPostAPIKey,
+		postedData:         url.Values{"name": {"Channel manager"}, "scopes": {"everything"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "failed issue api key",
+		path:               "/admin/api-keys",
+		handler:            (*Repository).AdminPostAPIKey,
+		postedData:         url.Values{"name": {"Channel manager"}, "scopes": {models.ScopeReadAvailability}},
+		failOn:             "InsertAPIKey",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "failed revoke api key",
+		path:               "/admin/api-keys/1/delete",
+		handler:            (*Repository).AdminDeleteAPIKey,
+		failOn:             "DeleteAPIKey",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestRepository_AdminPostHandlers(t *testing.T) {
+	for _, test := range adminPostTests {
+		req, _ := http.NewRequest("POST", test.path, strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", urlEncoded)
+		resRecorder := httptest.NewRecorder()
+
+		if test.failOn != "" {
+			failOn(test.failOn)
+		}
+		test.handler(Repo, resRecorder, req)
+		clearFailures()
+
+		if resRecorder.Code != test.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedStatusCode, resRecorder.Code)
+		}
+
+		if test.expectedLocation != "" {
+			if loc := resRecorder.Header().Get("Location"); loc != test.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", test.name, test.expectedLocation, loc)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminRooms(t *testing.T) {
+	ctx := context.Background()
+
+	id, err := Repo.DB.InsertRoom(ctx, models.Room{RoomName: "Sergeant's Bunk", Slug: "sergeants-bunk", Capacity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rooms, err := Repo.DB.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rooms[len(rooms)-1].ID != id {
+		t.Fatal("expected the new room to be listed last")
+	}
+
+	// moving the new room up swaps it with the room before it
+	postAdmin(t, fmt.Sprintf("/admin/rooms/%d/move", id), url.Values{"direction": {"up"}}, Repo.AdminMoveRoom)
+	rooms, _ = Repo.DB.AllRooms(ctx)
+	if rooms[len(rooms)-2].ID != id {
+		t.Errorf("expected the room to move up one place, got order %v", roomIDs(rooms))
+	}
+
+	// a deactivated room disappears from the public pages and searches
+	postAdmin(t, fmt.Sprintf("/admin/rooms/%d/deactivate", id), nil, Repo.AdminDeactivateRoom)
+
+	req, _ := http.NewRequest("GET", "/rooms/sergeants-bunk", nil)
+	req = req.WithContext(getCtx(req))
+	resRecorder := httptest.NewRecorder()
+	Repo.Room(resRecorder, req)
+	if resRecorder.Code != http.StatusNotFound {
+		t.Errorf("expected a deactivated room page to be missing, got %d", resRecorder.Code)
+	}
+
+	available, err := Repo.DB.SearchAvailabilityForAllRooms(ctx, time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2060, 1, 3, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rm := range available {
+		if rm.ID == id {
+			t.Error("expected a deactivated room to be left out of searches")
+		}
+	}
+
+	// a blank slug is made from the name, and amenities are split by line
+	postAdmin(t, fmt.Sprintf("/admin/rooms/%d", id), url.Values{
+		"room_name":    {"Sergeant's Bunk House"},
+		"capacity":     {"2"},
+		"nightly_rate": {"45.50"},
+		"amenities":    {"Bunk beds\r\n\r\n Lockers "},
+	}, Repo.PostAdminShowRoom)
+	rm, err := Repo.DB.GetRoomByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rm.Slug != "sergeant-s-bunk-house" || strings.Join(rm.Amenities, ",") != "Bunk beds,Lockers" {
+		t.Errorf("unexpected slug %q and amenities %q", rm.Slug, rm.Amenities)
+	}
+	if rm.NightlyRate != 4550 {
+		t.Errorf("expected a nightly rate of 4550 cents, got %d", rm.NightlyRate)
+	}
+}
+
+func TestRepository_AdminUploadRoomImage(t *testing.T) {
+	defer func(dir string) { app.UploadDir = dir }(app.UploadDir)
+	app.UploadDir = t.TempDir()
+
+	ctx := context.Background()
+	id, err := Repo.DB.InsertRoom(ctx, models.Room{RoomName: "Private's Tent", Slug: "privates-tent", Capacity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/admin/rooms/%d/images", id)
+
+	var pngFile bytes.Buffer
+	if err = png.Encode(&pngFile, image.NewRGBA(image.Rect(0, 0, 800, 600))); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploadTests = []struct {
+		name               string
+		path               string
+		file               []byte
+		expectedStatusCode int
+		expectedImages     int
+		expectedSession    string
+	}{
+		{"image", path, pngFile.Bytes(), http.StatusSeeOther, 1, "flash"},
+		{"not an image", path, []byte("hello"), http.StatusSeeOther, 1, "error"},
+		{"no file", path, nil, http.StatusSeeOther, 1, "error"},
+		{"missing room", "/admin/rooms/999/images", pngFile.Bytes(), http.StatusNotFound, 1, ""},
+	}
+
+	for _, test := range uploadTests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		if test.file != nil {
+			fw, _ := mw.CreateFormFile("image", "upload.png")
+			fw.Write(test.file)
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", test.path, &body)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		resRecorder := httptest.NewRecorder()
+
+		Repo.AdminUploadRoomImage(resRecorder, req)
+
+		if resRecorder.Code != test.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedStatusCode, resRecorder.Code)
+		}
+		if test.expectedSession != "" && session.GetString(ctx, test.expectedSession) == "" {
+			t.Errorf("for %s, expected a %s message", test.name, test.expectedSession)
+		}
+
+		rm, _ := Repo.DB.GetRoomByID(context.Background(), id)
+		if len(rm.Images) != test.expectedImages {
+			t.Errorf("for %s, expected %d images but got %d", test.name, test.expectedImages, len(rm.Images))
+		}
+	}
+
+	// the upload is stored with a thumbnail, and both are deleted with the image
+	rm, _ := Repo.DB.GetRoomByID(ctx, id)
+	stored := filepath.Join(app.UploadDir, strings.TrimPrefix(rm.Images[0], images.URLPrefix))
+	thumb := filepath.Join(app.UploadDir, strings.TrimPrefix(images.ThumbnailURL(rm.Images[0]), images.URLPrefix))
+	for _, f := range []string{stored, thumb} {
+		if _, err = os.Stat(f); err != nil {
+			t.Errorf("expected %s to exist: %v", f, err)
+		}
+	}
+
+	postAdmin(t, fmt.Sprintf("/admin/rooms/%d/images/delete", id), url.Values{"image": {rm.Images[0]}}, Repo.AdminDeleteRoomImage)
+	if rm, _ = Repo.DB.GetRoomByID(ctx, id); len(rm.Images) != 0 {
+		t.Errorf("expected the image to be removed, got %v", rm.Images)
+	}
+	for _, f := range []string{stored, thumb} {
+		if _, err = os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted, got %v", f, err)
+		}
+	}
+
+	// a request that is not a multipart form is rejected
+	req, _ := http.NewRequest("POST", path, strings.NewReader("image=x"))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", urlEncoded)
+	resRecorder := httptest.NewRecorder()
+	Repo.AdminUploadRoomImage(resRecorder, req)
+	if resRecorder.Code != http.StatusBadRequest {
+		t.Errorf("expected a form without a file to be rejected, got %d", resRecorder.Code)
+	}
+}
+
+func TestRepository_Pricing(t *testing.T) {
+	defer func(rate float64) { app.TaxRate = rate }(app.TaxRate)
+	app.TaxRate = 13
+
+	ctx := context.Background()
+	room, err := Repo.DB.GetRoomByID(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fridays and Saturdays in January 2070 cost more, and stays of three nights or more get 10% off
+	postAdmin(t, "/admin/pricing/overrides", url.Values{
+		"room_id":       {"2"},
+		"override_name": {"Winter weekend"},
+		"start_date":    {"2070-01-01"},
+		"end_date":      {"2070-02-01"},
+		"weekdays":      {"5", "6"},
+		"nightly_rate":  {"250"},
+	}, Repo.AdminPostRateOverride)
+	postAdmin(t, "/admin/pricing/discounts", url.Values{
+		"discount_name": {"Long weekend"},
+		"min_nights":    {"3"},
+		"percent":       {"10"},
+	}, Repo.AdminPostStayDiscount)
+
+	// Thursday to Monday
+	reservation := models.Reservation{
+		RoomID:    room.ID,
+		StartDate: time.Date(2070, 1, 2, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2070, 1, 6, 0, 0, 0, 0, time.UTC),
+	}
+	body := url.Values{
+		"first_name": {"Jane"},
+		"last_name":  {"Doe"},
+		"email":      {"jane@doe.com"},
+	}
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(body.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", urlEncoded)
+	session.Put(ctx, "reservation", reservation)
+	resRecorder := httptest.NewRecorder()
+
+	Repo.PostReservation(resRecorder, req)
+	if resRecorder.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d but got %d", http.StatusSeeOther, resRecorder.Code)
+	}
+
+	saved, ok := session.Get(ctx, "reservation").(models.Reservation)
+	if !ok {
+		t.Fatal("expected the reservation in the session")
+	}
+	res, err := Repo.DB.GetReservationByID(context.Background(), saved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subtotal := 2*room.NightlyRate + 2*25000
+	discount := subtotal / 10
+	taxes := int(math.Round(float64(subtotal-discount) * 0.13))
+	price := res.Price
+	if len(price.Nights) != 4 || price.Nights[1].Name != "Winter weekend" || price.Nights[3].Rate != room.NightlyRate {
+		t.Errorf("unexpected nightly lines %+v", price.Nights)
+	}
+	if price.Subtotal != subtotal || price.Discount != discount || price.Taxes != taxes || price.Total != subtotal-discount+taxes {
+		t.Errorf("expected subtotal %d, discount %d and taxes %d, got %+v", subtotal, discount, taxes, price)
+	}
+	if saved.Price.Total != price.Total {
+		t.Errorf("expected the summary to show the stored total %d, got %d", price.Total, saved.Price.Total)
+	}
+
+	// removing the override and discount leaves the stored price alone
+	overrides, _ := Repo.DB.AllRateOverrides(context.Background())
+	for _, o := range overrides {
+		postAdmin(t, fmt.Sprintf("/admin/pricing/overrides/%d/delete", o.ID), nil, Repo.AdminDeleteRateOverride)
+	}
+	discounts, _ := Repo.DB.AllStayDiscounts(context.Background())
+	for _, d := range discounts {
+		postAdmin(t, fmt.Sprintf("/admin/pricing/discounts/%d/delete", d.ID), nil, Repo.AdminDeleteStayDiscount)
+	}
+
+	res, err = Repo.DB.GetReservationByID(context.Background(), saved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Price.Total != price.Total {
+		t.Errorf("expected the stored total %d to be kept, got %d", price.Total, res.Price.Total)
+	}
+}
+
+// postAdmin posts data to an admin handler, failing the test unless it redirects
+func TestRepository_PromoCodes(t *testing.T) {
+	today := time.Now().Format("2006-01-02")
+	nextYear := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+
+	// one use of 20% off room 1, for stays of two nights or more
+	postAdmin(t, "/admin/promo-codes", url.Values{
+		"code":        {" early-bird "},
+		"kind":        {"percent"},
+		"amount":      {"20"},
+		"valid_from":  {today},
+		"valid_until": {nextYear},
+		"max_uses":    {"1"},
+		"min_nights":  {"2"},
+		"room_ids":    {"1"},
+	}, Repo.AdminPostPromoCode)
+	postAdmin(t, "/admin/promo-codes", url.Values{
+		"code":        {"LATER"},
+		"kind":        {"fixed"},
+		"amount":      {"25.50"},
+		"valid_from":  {nextYear},
+		"valid_until": {nextYear},
+	}, Repo.AdminPostPromoCode)
+
+	p, err := Repo.DB.GetPromoCodeByCode(context.Background(), "EARLY-BIRD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Kind != "percent" || p.Amount != 20 || p.MaxUses != 1 || p.MinNights != 2 || fmt.Sprint(p.RoomIDs) != "[1]" {
+		t.Errorf("expected the promo code as posted, got %+v", p)
+	}
+	later, err := Repo.DB.GetPromoCodeByCode(context.Background(), "LATER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if later.Kind != "fixed" || later.Amount != 2550 || later.MaxUses != 0 {
+		t.Errorf("expected a fixed code of 2550 cents with no limit, got %+v", later)
+	}
+
+	// the same code cannot be added twice
+	req, _ := http.NewRequest("POST", "/admin/promo-codes", strings.NewReader(url.Values{
+		"code":        {"Early-Bird"},
+		"kind":        {"fixed"},
+		"amount":      {"5"},
+		"valid_from":  {today},
+		"valid_until": {today},
+	}.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", urlEncoded)
+	resRecorder := httptest.NewRecorder()
+	Repo.AdminPostPromoCode(resRecorder, req)
+	if resRecorder.Code != http.StatusOK || !strings.Contains(resRecorder.Body.String(), "Another promo code already uses this code") {
+		t.Errorf("expected the duplicate code to be refused, got %d", resRecorder.Code)
+	}
+
+	// book posts the reservation form, returning the response and the ID of the reservation it made, if any
+	book := func(roomID int, start, end time.Time, code string) (*httptest.ResponseRecorder, int) {
+		body := url.Values{
+			"first_name": {"Jane"},
+			"last_name":  {"Doe"},
+			"email":      {"jane@doe.com"},
+			"promo_code": {code},
+		}
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(body.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", urlEncoded)
+		session.Put(ctx, "reservation", models.Reservation{RoomID: roomID, StartDate: start, EndDate: end})
+		resRecorder := httptest.NewRecorder()
+
+		Repo.PostReservation(resRecorder, req)
+		saved, _ := session.Get(ctx, "reservation").(models.Reservation)
+		return resRecorder, saved.ID
+	}
+
+	var refusedTests = []struct {
+		name     string
+		roomID   int
+		nights   int
+		code     string
+		expected string
+	}{
+		{"unknown code", 1, 2, "NOPE", "This code is not valid"},
+		{"other room", 2, 2, "EARLY-BIRD", "This code cannot be used for this room"},
+		{"too short", 1, 1, "EARLY-BIRD", "This code needs a longer stay"},
+		{"not started", 1, 2, "LATER", "This code cannot be used yet"},
+	}
+	start := time.Date(2080, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range refusedTests {
+		resRecorder, id := book(test.roomID, start, start.AddDate(0, 0, test.nights), test.code)
+		if id != 0 || !strings.Contains(resRecorder.Body.String(), test.expected) {
+			t.Errorf("for %s, expected the form again with %q", test.name, test.expected)
+		}
+	}
+
+	resRecorder, id := book(1, start, start.AddDate(0, 0, 2), "early-bird")
+	if id == 0 {
+		t.Fatalf("expected the booking with a promo code to succeed, got %d", resRecorder.Code)
+	}
+	res, err := Repo.DB.GetReservationByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PromoCode != "EARLY-BIRD" || res.Price.PromoCode != "EARLY-BIRD" {
+		t.Errorf("expected the reservation to record its promo code, got %q and %q", res.PromoCode, res.Price.PromoCode)
+	}
+	discounted := res.Price.Subtotal - res.Price.Discount
+	if res.Price.PromoDiscount != discounted/5 || res.Price.Total != discounted-discounted/5+res.Price.Taxes {
+		t.Errorf("expected 20%% off %d, got %+v", discounted, res.Price)
+	}
+
+	// the one use has gone
+	resRecorder, _ = book(1, start.AddDate(0, 0, 10), start.AddDate(0, 0, 12), "EARLY-BIRD")
+	if !strings.Contains(resRecorder.Body.String(), "This code has been used up") {
+		t.Error("expected the used up code to be refused")
+	}
+
+	codes, _ := Repo.DB.AllPromoCodes(context.Background())
+	for _, c := range codes {
+		postAdmin(t, fmt.Sprintf("/admin/promo-codes/%d/delete", c.ID), nil, Repo.AdminDeletePromoCode)
+	}
+	if codes, _ = Repo.DB.AllPromoCodes(context.Background()); len(codes) != 0 {
+		t.Errorf("expected every promo code to be removed, got %d", len(codes))
+	}
+}
+
+func TestRepository_AdminAPIKeys(t *testing.T) {
+	nextYear := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+
+	req, _ := http.NewRequest("POST", "/admin/api-keys", strings.NewReader(url.Values{
+		"name":       {" Channel manager "},
+		"scopes":     {models.ScopeReadAvailability, models.ScopeWriteReservations},
+		"expires_on": {nextYear},
+	}.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", urlEncoded)
+	session.Put(ctx, "user_id", 1)
+	resRecorder := httptest.NewRecorder()
+
+	Repo.AdminPostAPIKey(resRecorder, req)
+	if resRecorder.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect after issuing a key, got %d", resRecorder.Code)
+	}
+	key := session.GetString(ctx, "api_key")
+	if !strings.HasPrefix(key, tokens.APIKeyPrefix) {
+		t.Fatalf("expected the new key in the session, got %q", key)
+	}
+
+	k, err := Repo.DB.GetAPIKeyByHash(context.Background(), tokens.HashAPIKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Name != "Channel manager" || k.Prefix != key[:tokens.APIKeyDisplayLength] || k.Hash == key || k.UserID != 1 ||
+		fmt.Sprint(k.Scopes) != "[availability:read reservations:write]" || k.ExpiresAt.Format("2006-01-02") != nextYear {
+		t.Errorf("expected the key as posted, got %+v", k)
+	}
+
+	// the key is shown once
+	for i, expected := range []bool{true, false} {
+		req, _ = http.NewRequest("GET", "/admin/api-keys", nil)
+		req = req.WithContext(ctx)
+		resRecorder = httptest.NewRecorder()
+		Repo.AdminAPIKeys(resRecorder, req)
+		if shown := strings.Contains(resRecorder.Body.String(), key); shown != expected {
+			t.Errorf("for view %d, expected the key shown to be %t", i+1, expected)
+		}
+	}
+
+	if resRecorder, _ = callAPIWithKey(t, key, "GET", "/api/v1/rooms", ""); resRecorder.Code != http.StatusOK {
+		t.Errorf("expected the issued key to be accepted, got %d", resRecorder.Code)
+	}
+
+	postAdmin(t, fmt.Sprintf("/admin/api-keys/%d/delete", k.ID), nil, Repo.AdminDeleteAPIKey)
+	if resRecorder, _ = callAPIWithKey(t, key, "GET", "/api/v1/rooms", ""); resRecorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected the revoked key to be refused, got %d", resRecorder.Code)
+	}
+}
+
+func TestRepository_Deposits(t *testing.T) {
+	app.Payments.DepositRate = 20
+	defer func() { app.Payments.DepositRate = 0 }()
+	gateway := Repo.Payments.(*payments.FakeGateway)
+
+	// book posts the reservation form paying with token, returning the response and the ID of
+	// the reservation it made, if any
+	book := func(start, end time.Time, token string) (*httptest.ResponseRecorder, int) {
+		body := url.Values{
+			"first_name":    {"Jane"},
+			"last_name":     {"Doe"},
+			"email":         {"jane@doe.com"},
+			"payment_token": {token},
+		}
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(body.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", urlEncoded)
+		session.Put(ctx, "reservation", models.Reservation{RoomID: 1, StartDate: start, EndDate: end})
+		resRecorder := httptest.NewRecorder()
+
+		Repo.PostReservation(resRecorder, req)
+		saved, _ := session.Get(ctx, "reservation").(models.Reservation)
+		return resRecorder, saved.ID
+	}
+
+	start := time.Date(2081, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+
+	var refusedTests = []struct {
+		name     string
+		token    string
+		expected string
+	}{
+		{"no card", "", "Please enter your card details"},
+		{"declined card", payments.FakeCardDeclined, "Your card was declined"},
+	}
+	for _, test := range refusedTests {
+		resRecorder, id := book(start, end, test.token)
+		if id != 0 || !strings.Contains(resRecorder.Body.String(), test.expected) {
+			t.Errorf("for %s, expected the form again with %q", test.name, test.expected)
+		}
+	}
+	available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(context.Background(), start, end, 1, 0)
+	if !available {
+		t.Error("expected no booking to be made without a deposit")
+	}
+
+	resRecorder, id := book(start, end, payments.FakeCardOK)
+	if id == 0 {
+		t.Fatalf("expected the booking to be made once the deposit was authorised, got %d", resRecorder.Code)
+	}
+	res, err := Repo.DB.GetReservationByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intents, err := Repo.DB.GetPaymentIntentsForReservation(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 1 {
+		t.Fatalf("expected one payment for the reservation, got %d", len(intents))
+	}
+	p := intents[0]
+	if p.Status != models.PaymentAuthorized || p.Amount != res.Price.Deposit || p.Amount != res.Price.Total/5 || p.Currency != "usd" {
+		t.Errorf("expected 20%% of %d to be authorised, got %+v", res.Price.Total, p)
+	}
+
+	// losing the room to another guest releases the hold, which is the fake gateway's next payment
+	resRecorder, _ = book(start, end, payments.FakeCardOK)
+	if resRecorder.Code != http.StatusSeeOther || resRecorder.Header().Get("Location") != "/search-availability" {
+		t.Fatalf("expected the taken room to be refused, got %d", resRecorder.Code)
+	}
+	n, _ := strconv.Atoi(strings.TrimPrefix(p.ProviderRef, "fake_pi_"))
+	if released, _ := gateway.Payment(fmt.Sprintf("fake_pi_%d", n+1)); released.Status != models.PaymentVoided {
+		t.Errorf("expected the hold of the refused booking to be voided, got %+v", released)
+	}
+
+	// capture, then refund from the admin tool
+	path := fmt.Sprintf("/admin/%%s-payment/all/%d", id)
+	form := url.Values{"payment_id": {strconv.Itoa(p.ID)}}
+	postAdmin(t, fmt.Sprintf(path, "capture"), form, Repo.AdminCapturePayment)
+	p, _ = Repo.DB.GetPaymentIntentByRef(context.Background(), payments.FakeProvider, p.ProviderRef)
+	if p.Status != models.PaymentCaptured || p.Captured != p.Amount {
+		t.Errorf("expected the deposit to be captured, got %+v", p)
+	}
+	postAdmin(t, fmt.Sprintf(path, "void"), form, Repo.AdminVoidPayment)
+	if p, _ = Repo.DB.GetPaymentIntentByRef(context.Background(), payments.FakeProvider, p.ProviderRef); p.Status != models.PaymentCaptured {
+		t.Errorf("expected a captured deposit not to be voided, got %+v", p)
+	}
+	postAdmin(t, fmt.Sprintf(path, "refund"), form, Repo.AdminRefundPayment)
+	if p, _ = Repo.DB.GetPaymentIntentByRef(context.Background(), payments.FakeProvider, p.ProviderRef); p.Status != models.PaymentRefunded || p.Refunded != p.Amount {
+		t.Errorf("expected the deposit to be refunded, got %+v", p)
+	}
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf(path, "capture"), strings.NewReader(url.Values{"payment_id": {"999"}}.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", urlEncoded)
+	resRecorder = httptest.NewRecorder()
+	Repo.AdminCapturePayment(resRecorder, req)
+	if resRecorder.Code != http.StatusNotFound {
+		t.Errorf("expected %d for another reservation's payment, got %d", http.StatusNotFound, resRecorder.Code)
+	}
+
+	resRecorder = httptest.NewRecorder()
+	getRoutes().ServeHTTP(resRecorder, httptest.NewRequest("GET", fmt.Sprintf("/admin/reservations/all/%d", id), nil))
+	if !strings.Contains(resRecorder.Body.String(), p.ProviderRef) {
+		t.Error("expected the reservation page to list its payments")
+	}
+
+	// deleting a reservation releases the deposit still held for it
+	_, id = book(start.AddDate(0, 1, 0), end.AddDate(0, 1, 0), payments.FakeCardOK)
+	intents, err = Repo.DB.GetPaymentIntentsForReservation(context.Background(), id)
+	if err != nil || len(intents) != 1 {
+		t.Fatalf("expected one payment for the reservation, got %d: %v", len(intents), err)
+	}
+	postAdmin(t, fmt.Sprintf("/admin/delete-reservation/all/%d", id), nil, Repo.AdminDeleteReservation)
+	if held, _ := gateway.Payment(intents[0].ProviderRef); held.Status != models.PaymentVoided {
+		t.Errorf("expected the hold of the deleted reservation to be voided, got %+v", held)
+	}
+	if _, err = Repo.DB.GetReservationByID(context.Background(), id); err == nil {
+		t.Error("expected the reservation to be deleted")
+	}
+}
+
+func TestRepository_PaymentWebhook(t *testing.T) {
+	gateway := Repo.Payments.(*payments.FakeGateway)
+	ctx := context.Background()
+
+	authorized, err := gateway.Authorize(ctx, payments.AuthorizeRequest{Amount: 5000, Source: payments.FakeCardOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := Repo.DB.CreateReservation(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: time.Date(2082, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2082, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+		Payment: models.PaymentIntent{
+			Provider:    payments.FakeProvider,
+			ProviderRef: authorized.Ref,
+			Status:      authorized.Status,
+			Amount:      authorized.Amount,
+			Currency:    "usd",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staleEvent, staleSig, err := gateway.Webhook(authorized.Ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the deposit is captured and partly refunded at the provider
+	if _, err = gateway.Capture(ctx, authorized.Ref, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = gateway.Refund(ctx, authorized.Ref, 2000); err != nil {
+		t.Fatal(err)
+	}
+	event, sig, err := gateway.Webhook(authorized.Ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var webhookTests = []struct {
+		name             string
+		payload          []byte
+		sig              string
+		expectedCode     int
+		expectedStatus   string
+		expectedRefunded int
+	}{
+		{"bad signature", event, "t=1,v1=00", http.StatusBadRequest, models.PaymentAuthorized, 0},
+		{"refund", event, sig, http.StatusOK, models.PaymentCaptured, 2000},
+		{"older event", staleEvent, staleSig, http.StatusOK, models.PaymentCaptured, 2000},
+	}
+
+	for _, test := range webhookTests {
+		req := httptest.NewRequest("POST", PaymentWebhookPath, bytes.NewReader(test.payload))
+		req.Header.Set(payments.FakeSignatureHeader, test.sig)
+		resRecorder := httptest.NewRecorder()
+		getRoutes().ServeHTTP(resRecorder, req)
+
+		if resRecorder.Code != test.expectedCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedCode, resRecorder.Code)
+		}
+		intents, err := Repo.DB.GetPaymentIntentsForReservation(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(intents) != 1 || intents[0].Status != test.expectedStatus || intents[0].Refunded != test.expectedRefunded {
+			t.Errorf("for %s, expected a %s payment with %d refunded, got %+v", test.name, test.expectedStatus, test.expectedRefunded, intents)
+		}
+	}
+
+	// events for payments that are not stored are acknowledged so they are not sent again
+	other, err := gateway.Authorize(ctx, payments.AuthorizeRequest{Amount: 1000, Source: payments.FakeCardOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, sig, _ = gateway.Webhook(other.Ref)
+	req := httptest.NewRequest("POST", PaymentWebhookPath, bytes.NewReader(event))
+	req.Header.Set(payments.FakeSignatureHeader, sig)
+	resRecorder := httptest.NewRecorder()
+	getRoutes().ServeHTTP(resRecorder, req)
+	if resRecorder.Code != http.StatusOK {
+		t.Errorf("expected an unknown payment to be acknowledged, got %d", resRecorder.Code)
+	}
+}
+
+func TestRepository_ManageReservation(t *testing.T) {
+	gateway := Repo.Payments.(*payments.FakeGateway)
+	ctx := context.Background()
+	date := func(month, day int) time.Time { return time.Date(2083, time.Month(month), day, 0, 0, 0, 0, time.UTC) }
+
+	// the guest's booking holds a deposit; another guest has the room from 3 March
+	authorized, err := gateway.Authorize(ctx, payments.AuthorizeRequest{Amount: 5000, Source: payments.FakeCardOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := Repo.DB.CreateReservation(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: date(2, 1),
+		EndDate:   date(2, 4),
+		RoomID:    1,
+		Payment: models.PaymentIntent{
+			Provider:    payments.FakeProvider,
+			ProviderRef: authorized.Ref,
+			Status:      authorized.Status,
+			Amount:      authorized.Amount,
+			Currency:    "usd",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Repo.DB.CreateReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: date(3, 3),
+		EndDate:   date(3, 6),
+		RoomID:    1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Repo.DB.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manageURL := Repo.manageURL(res.ConfirmationCode)
+
+	// post sends data to the manage link's action, returning the response
+	post := func(action string, data url.Values, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", manageURL+action, strings.NewReader(data.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", urlEncoded)
+		resRecorder := httptest.NewRecorder()
+		handler(resRecorder, req)
+		return resRecorder
+	}
+
+	var viewTests = []struct {
+		name         string
+		url          string
+		expectedCode int
+	}{
+		{"valid link", manageURL, http.StatusOK},
+		{"forged signature", fmt.Sprintf("/manage/%s/%s", res.ConfirmationCode, "AAAA"), http.StatusNotFound},
+		{"other code", strings.Replace(manageURL, res.ConfirmationCode, "ZZZZZZZZZZZZ", 1), http.StatusNotFound},
+		{"unknown code", Repo.manageURL("ZZZZZZZZZZZZ"), http.StatusNotFound},
+	}
+	for _, test := range viewTests {
+		resRecorder := httptest.NewRecorder()
+		getRoutes().ServeHTTP(resRecorder, httptest.NewRequest("GET", test.url, nil))
+		if resRecorder.Code != test.expectedCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedCode, resRecorder.Code)
+		}
+		if test.expectedCode == http.StatusOK && !strings.Contains(resRecorder.Body.String(), res.ConfirmationCode) {
+			t.Errorf("for %s, expected the page to show the confirmation code", test.name)
+		}
+	}
+
+	var dateTests = []struct {
+		name          string
+		start         string
+		end           string
+		expectedError string
+	}{
+		{"bad date", "soon", "2083-02-05", "Enter a date"},
+		{"in the past", "2001-01-01", "2001-01-03", "The arrival date cannot be in the past"},
+		{"backwards", "2083-02-05", "2083-02-02", "The departure date must be after the arrival date"},
+		{"too long", "2083-02-05", "2083-06-05", "Stays can be at most 30 nights"},
+		{"taken", "2083-03-01", "2083-03-04", "Sorry, the room is not available for those dates"},
+	}
+	for _, test := range dateTests {
+		resRecorder := post("/dates", url.Values{"start_date": {test.start}, "end_date": {test.end}}, Repo.PostChangeReservationDates)
+		if !strings.Contains(resRecorder.Body.String(), test.expectedError) {
+			t.Errorf("for %s, expected the form again with %q, got %d", test.name, test.expectedError, resRecorder.Code)
+		}
+	}
+
+	// moving over the booking's own dates is allowed
+	resRecorder := post("/dates", url.Values{"start_date": {"2083-02-02"}, "end_date": {"2083-02-06"}}, Repo.PostChangeReservationDates)
+	if resRecorder.Code != http.StatusSeeOther || resRecorder.Header().Get("Location") != manageURL {
+		t.Fatalf("expected the dates to be changed, got %d", resRecorder.Code)
+	}
+	moved, _ := Repo.DB.GetReservationByID(ctx, id)
+	if !moved.StartDate.Equal(date(2, 2)) || !moved.EndDate.Equal(date(2, 6)) || len(moved.Price.Nights) != 4 || moved.Price.Deposit != res.Price.Deposit {
+		t.Errorf("expected a 4 night stay from 2 February keeping the deposit, got %s to %s at %+v", moved.StartDate, moved.EndDate, moved.Price)
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, date(2, 1), date(2, 2), 1, 0); !available {
+		t.Error("expected the old first night to be freed")
+	}
+
+	// a booking made with a promo code keeps it only while the stay still qualifies; its one use
+	// went on the booking itself, which does not count against a change of dates
+	promoID, err := Repo.DB.InsertPromoCode(ctx, models.PromoCode{
+		Code:       "STAY3",
+		Kind:       models.PromoPercent,
+		Amount:     10,
+		ValidFrom:  time.Now(),
+		ValidUntil: time.Now(),
+		MaxUses:    1,
+		MinNights:  3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	promoResID, err := Repo.DB.CreateReservation(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: date(5, 1),
+		EndDate:   date(5, 4),
+		RoomID:    2,
+		PromoCode: "STAY3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	promoRes, _ := Repo.DB.GetReservationByID(ctx, promoResID)
+	manageURL = Repo.manageURL(promoRes.ConfirmationCode)
+	resRecorder = post("/dates", url.Values{"start_date": {"2083-05-01"}, "end_date": {"2083-05-03"}}, Repo.PostChangeReservationDates)
+	if !strings.Contains(resRecorder.Body.String(), "The promo code STAY3 this reservation was booked with does not apply to these dates. This code needs a longer stay") {
+		t.Errorf("expected a stay too short for the promo code to be refused, got %d", resRecorder.Code)
+	}
+	if resRecorder = post("/dates", url.Values{"start_date": {"2083-05-01"}, "end_date": {"2083-05-05"}}, Repo.PostChangeReservationDates); resRecorder.Code != http.StatusSeeOther {
+		t.Errorf("expected a longer stay to keep the promo code, got %d", resRecorder.Code)
+	}
+	if promoRes, _ = Repo.DB.GetReservationByID(ctx, promoResID); !promoRes.EndDate.Equal(date(5, 5)) || promoRes.Price.PromoCode != "STAY3" || promoRes.Price.PromoDiscount == 0 {
+		t.Errorf("expected four nights with the promo discount, got %s at %+v", promoRes.EndDate, promoRes.Price)
+	}
+	manageURL = Repo.manageURL(res.ConfirmationCode)
+	if err = Repo.DB.DeletePromoCode(ctx, promoID); err != nil {
+		t.Fatal(err)
+	}
+
+	if resRecorder = post("/cancel", nil, Repo.PostCancelReservation); resRecorder.Code != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be cancelled, got %d", resRecorder.Code)
+	}
+	cancelled, _ := Repo.DB.GetReservationByID(ctx, id)
+	if cancelled.CancelledAt.IsZero() {
+		t.Error("expected the cancellation to be recorded")
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, date(2, 2), date(2, 6), 1, 0); !available {
+		t.Error("expected the cancelled dates to be freed")
+	}
+	if p, _ := Repo.DB.GetPaymentIntentByRef(ctx, payments.FakeProvider, authorized.Ref); p.Status != models.PaymentVoided {
+		t.Errorf("expected the held deposit to be released, got %+v", p)
+	}
+
+	// a cancelled booking cannot be changed any more
+	resRecorder = post("/dates", url.Values{"start_date": {"2083-04-01"}, "end_date": {"2083-04-03"}}, Repo.PostChangeReservationDates)
+	if resRecorder.Code != http.StatusSeeOther {
+		t.Errorf("expected a cancelled reservation's dates to be refused, got %d", resRecorder.Code)
+	}
+	if moved, _ = Repo.DB.GetReservationByID(ctx, id); !moved.StartDate.Equal(date(2, 2)) {
+		t.Errorf("expected a cancelled reservation to keep its dates, got %s", moved.StartDate)
+	}
+
+	// nor can one whose stay has begun
+	startedID, err := Repo.DB.CreateReservation(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: time.Now().AddDate(0, 0, -1).Truncate(24 * time.Hour),
+		EndDate:   time.Now().AddDate(0, 0, 2).Truncate(24 * time.Hour),
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, _ := Repo.DB.GetReservationByID(ctx, startedID)
+	manageURL = Repo.manageURL(started.ConfirmationCode)
+	post("/cancel", nil, Repo.PostCancelReservation)
+	if started, _ = Repo.DB.GetReservationByID(ctx, startedID); !started.CancelledAt.IsZero() {
+		t.Error("expected a stay that has begun not to be cancelled online")
+	}
+}
+
+func TestRepository_ReservationLifecycle(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2084, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 3)
+
+	reserve := func() int {
+		id, err := Repo.DB.CreateReservation(ctx, models.Reservation{
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     "jane@doe.com",
+			StartDate: start,
+			EndDate:   end,
+			RoomID:    3,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// post sends an admin action for reservation id as the seeded admin user
+	post := func(action string, id int, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/%s-reservation/all/%d", action, id), nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		req = req.WithContext(ctx)
+		resRecorder := httptest.NewRecorder()
+		handler(resRecorder, req)
+		return resRecorder
+	}
+
+	id := reserve()
+	var steps = []struct {
+		name           string
+		action         string
+		handler        http.HandlerFunc
+		expectedStatus string
+	}{
+		{"check in before confirming", "check-in", Repo.AdminCheckInReservation, models.ReservationPending},
+		{"confirm", "confirm", Repo.AdminConfirmReservation, models.ReservationConfirmed},
+		{"check in", "check-in", Repo.AdminCheckInReservation, models.ReservationCheckedIn},
+		{"cancel after arriving", "cancel", Repo.AdminCancelReservation, models.ReservationCheckedIn},
+		{"check out", "check-out", Repo.AdminCheckOutReservation, models.ReservationCheckedOut},
+		{"check in again", "check-in", Repo.AdminCheckInReservation, models.ReservationCheckedOut},
+	}
+	for _, step := range steps {
+		resRecorder := post(step.action, id, step.handler)
+		if resRecorder.Code != http.StatusSeeOther || resRecorder.Header().Get("Location") != fmt.Sprintf("/admin/reservations/all/%d", id) {
+			t.Errorf("for %s, expected a redirect to the reservation, got %d", step.name, resRecorder.Code)
+		}
+		if res, _ := Repo.DB.GetReservationByID(ctx, id); res.Status != step.expectedStatus {
+			t.Errorf("for %s, expected the reservation to be %s, got %s", step.name, step.expectedStatus, res.Status)
+		}
+	}
+
+	changes, err := Repo.DB.GetStatusChangesForReservation(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected only the 3 legal changes to be recorded, got %+v", changes)
+	}
+	for _, c := range changes {
+		if c.Actor != lifecycle.ActorStaff || c.UserID != 1 || c.CreatedAt.IsZero() {
+			t.Errorf("expected the admin user to be recorded against %+v", c)
+		}
+	}
+	// checked out guests keep their dates
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, start, end, 3, 0); available {
+		t.Error("expected a completed stay to keep holding its room")
+	}
+
+	resRecorder := httptest.NewRecorder()
+	getRoutes().ServeHTTP(resRecorder, httptest.NewRequest("GET", fmt.Sprintf("/admin/reservations/all/%d", id), nil))
+	if body := resRecorder.Body.String(); !strings.Contains(body, "Checked out") || !strings.Contains(body, "History") {
+		t.Error("expected the reservation page to show its status and history")
+	}
+
+	// a no-show gives the room back but keeps the reservation
+	if err = Repo.DB.DeleteReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	id = reserve()
+	post("confirm", id, Repo.AdminConfirmReservation)
+	post("no-show", id, Repo.AdminNoShowReservation)
+	if res, err := Repo.DB.GetReservationByID(ctx, id); err != nil || res.Status != models.ReservationNoShow {
+		t.Errorf("expected the reservation to be kept as a no-show, got %q (%v)", res.Status, err)
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, start, end, 3, 0); !available {
+		t.Error("expected a no-show to free the room")
+	}
+
+	if resRecorder = post("confirm", 9999, Repo.AdminConfirmReservation); resRecorder.Code != http.StatusNotFound {
+		t.Errorf("expected %d for a missing reservation, got %d", http.StatusNotFound, resRecorder.Code)
+	}
+	id = reserve()
+	failOn("UpdateReservationStatus")
+	resRecorder = post("confirm", id, Repo.AdminConfirmReservation)
+	clearFailures()
+	if resRecorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected %d when the change cannot be saved, got %d", http.StatusInternalServerError, resRecorder.Code)
+	}
+}
+
+func postAdmin(t *testing.T, path string, data url.Values, handler http.HandlerFunc) {
+	t.Helper()
+
+	req, _ := http.NewRequest("POST", path, strings.NewReader(data.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", urlEncoded)
+	resRecorder := httptest.NewRecorder()
+
+	handler(resRecorder, req)
+	if resRecorder.Code != http.StatusSeeOther {
+		t.Fatalf("POST %s: expected %d but got %d", path, http.StatusSeeOther, resRecorder.Code)
+	}
+}
+
+// roomIDs returns the IDs of rooms in order
+func roomIDs(rooms []models.Room) []int {
+	var ids []int
+	for _, rm := range rooms {
+		ids = append(ids, rm.ID)
+	}
+	return ids
+}
+
+func TestRepository_AdminPostReservationsCalendar(t *testing.T) {
+	var calendarTests = []struct {
+		name               string
+		postedData         url.Values
+		failOn             string
+		expectedStatusCode int
+	}{
+		{"no changes", url.Values{"y": {"2050"}, "m": {"01"}}, "", http.StatusSeeOther},
+		{"add and keep blocks", url.Values{
+			"y":                         {"2050"},
+			"m":                         {"01"},
+			"add_block_1_2050-01-10":    {"1"},
+			"block_1_2050-01-06":        {"2"},
+			"remove_block_1_2050-01-06": {"2"},
+		}, "", http.StatusSeeOther},
+		{"remove block", url.Values{"y": {"2050"}, "m": {"01"}, "block_1_2050-01-06": {"2"}}, "", http.StatusSeeOther},
+		{"failed remove block", url.Values{"y": {"2050"}, "m": {"01"}, "block_1_2050-01-06": {"2"}}, "DeleteBlockByID", http.StatusInternalServerError},
+		{"block of another month", url.Values{"y": {"2050"}, "m": {"01"}, "block_1_2050-02-06": {"2"}}, "DeleteBlockByID", http.StatusSeeOther},
+		{"failed add block", url.Values{"y": {"2050"}, "m": {"01"}, "add_block_999_2050-01-10": {"1"}}, "", http.StatusInternalServerError},
+		{"bad block date", url.Values{"y": {"2050"}, "m": {"01"}, "add_block_1_2050-13-10": {"1"}}, "", http.StatusInternalServerError},
+		{"missing year", url.Values{"m": {"01"}}, "", http.StatusInternalServerError},
+	}
+
+	for _, test := range calendarTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", urlEncoded)
+		resRecorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		if test.failOn != "" {
+			failOn(test.failOn)
+		}
+		handler.ServeHTTP(resRecorder, req)
+		clearFailures()
+
+		if resRecorder.Code != test.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", test.name, test.expectedStatusCode, resRecorder.Code)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendarMonths(t *testing.T) {
+	ctx := context.Background()
+	march := time.Date(2087, 3, 10, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2087, 4, 10, 0, 0, 0, 0, time.UTC)
+	for _, d := range []time.Time{march, april} {
+		if err := Repo.DB.InsertBlockForRoom(ctx, 1, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks := func() []models.RoomRestriction {
+		restrictions, err := Repo.DB.GetRestrictionsForRoomByDate(ctx, 1, march.AddDate(0, 0, -9), april.AddDate(0, 1, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return restrictions
+	}
+	shown := blocks()
+	if len(shown) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(shown))
+	}
+
+	// the March calendar is saved with nothing ticked, after April was opened in another tab
+	// and a block added on the 20th of March since the form was shown. The form has also been
+	// edited to name the April block under March days.
+	if err := Repo.DB.InsertBlockForRoom(ctx, 1, march.AddDate(0, 0, 10)); err != nil {
+		t.Fatal(err)
+	}
+	postAdmin(t, "/admin/reservations-calendar", url.Values{
+		"y":                  {"2087"},
+		"m":                  {"3"},
+		"block_1_2087-03-10": {strconv.Itoa(shown[0].ID)},
+		"block_2_2087-03-11": {strconv.Itoa(shown[1].ID)},
+		"block_1_2087-03-12": {strconv.Itoa(shown[1].ID)},
+	}, Repo.AdminPostReservationsCalendar)
+
+	var left []string
+	for _, b := range blocks() {
+		left = append(left, b.StartDate.Format("2006-01-02"))
+	}
+	if strings.Join(left, " ") != "2087-03-20 2087-04-10" {
+		t.Errorf("expected only the unticked March block to be removed, got %v", left)
 	}
 }
 
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -9,21 +11,42 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/helpers"
+	"github.com/jeremydelacruz/go-bookings/internal/images"
+	"github.com/jeremydelacruz/go-bookings/internal/mail"
 	"github.com/jeremydelacruz/go-bookings/internal/models"
 	"github.com/jeremydelacruz/go-bookings/internal/render"
+	"github.com/jeremydelacruz/go-bookings/internal/repository"
+	"github.com/jeremydelacruz/go-bookings/internal/repository/dbrepo"
+	"github.com/jeremydelacruz/go-bookings/internal/tokens"
 	"github.com/justinas/nosurf"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
 
+// testAPIKey is an API key with the admin scope, sent by callAPI
+var testAPIKey string
+var functions = template.FuncMap{
+	"humanDate":  render.HumanDate,
+	"iterate":    render.Iterate,
+	"add":        render.Add,
+	"formatDate": render.FormatDate,
+	"thumbnail":  images.ThumbnailURL,
+	"money":      render.Money,
+	"percent":    render.Percent,
+	"status":     render.StatusLabel,
+}
+
 func TestMain(m *testing.M) {
 	// change this to true in prod
 	app.InProduction = false
@@ -53,13 +76,101 @@ func TestMain(m *testing.M) {
 	app.TemplateCache = tc
 	app.UseCache = true
 
+	ec, err := CreateTestEmailTemplateCache()
+	if err != nil {
+		log.Fatal("failed creating email template cache")
+	}
+
+	app.EmailCache = ec
+
+	app.Payments.Currency = "usd"
+	app.Payments.WebhookSecret = "test-secret"
+	app.LinkSecret = "test-link-secret"
+	app.BaseURL = "http://localhost:8080"
+	app.MaxStayNights = 30
+	app.Mail.From = "bookings@here.ca"
+	app.Mail.OwnerAddress = "owner@here.ca"
+	app.MailChan = make(chan mail.MailData, 100)
+	app.Reminders.DaysBefore = 2
+	app.Reminders.FollowUpDays = 1
+
 	repo := NewTestRepo(&app)
+	err = seedTestDB(repo.DB)
+	if err != nil {
+		log.Fatal("failed seeding test database: ", err)
+	}
 	NewHandlers(repo)
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
+// seedTestDB adds the user, API key and reservations the handler tests rely on
+func seedTestDB(db repository.DatabaseRepo) error {
+	ctx := context.Background()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	_, err = db.InsertUser(ctx, models.User{
+		FirstName:   "Admin",
+		LastName:    "User",
+		Email:       "me@here.ca",
+		Password:    string(hash),
+		AccessLevel: 3,
+	})
+	if err != nil {
+		return err
+	}
+
+	testAPIKey, err = tokens.NewAPIKey()
+	if err != nil {
+		return err
+	}
+	_, err = db.InsertAPIKey(ctx, models.APIKey{
+		Name:   "Tests",
+		Prefix: testAPIKey[:tokens.APIKeyDisplayLength],
+		Hash:   tokens.HashAPIKey(testAPIKey),
+		Scopes: []string{models.ScopeAdmin},
+		UserID: 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	// reservation 1 is used by the admin tests, and together with the second booking
+	// leaves no room free between 2050-02-01 and 2050-02-05
+	for _, roomID := range []int{1, 2} {
+		_, err = db.CreateReservation(ctx, models.Reservation{
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			StartDate: time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 2, 5, 0, 0, 0, 0, time.UTC),
+			RoomID:    roomID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var errInjected = errors.New("injected failure")
+
+// failOn makes the test repository return errInjected from method until clearFailures is called
+func failOn(method string) {
+	Repo.DB.(dbrepo.FailureInjector).FailOn(method, errInjected)
+}
+
+// clearFailures removes the failures added by failOn
+func clearFailures() {
+	Repo.DB.(dbrepo.FailureInjector).ClearFailures()
+}
+
 // same logic as run()
 func getRoutes() http.Handler {
 	mux := chi.NewRouter()
@@ -67,8 +178,11 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	for _, slug := range []string{"generals-quarters", "majors-suite"} {
+		mux.Get("/"+slug, http.RedirectHandler("/rooms/"+slug, http.StatusMovedPermanently).ServeHTTP)
+	}
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -78,8 +192,63 @@ func getRoutes() http.Handler {
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/manage/{code}/{sig}", Repo.ManageReservation)
+	mux.Post("/manage/{code}/{sig}/cancel", Repo.PostCancelReservation)
+	mux.Post("/manage/{code}/{sig}/dates", Repo.PostChangeReservationDates)
+
+	mux.Post(PaymentWebhookPath, Repo.PaymentWebhook)
+
+	mux.Route(APIPrefix, func(mux chi.Router) {
+		mux.Use(Repo.APIAuth)
+		mux.NotFound(Repo.APINotFound)
+		mux.MethodNotAllowed(Repo.APIMethodNotAllowed)
+
+		mux.With(RequireScope(models.ScopeReadAvailability)).Group(func(mux chi.Router) {
+			mux.Get("/rooms", Repo.APIRooms)
+			mux.Get("/rooms/{id}", Repo.APIRoom)
+			mux.Get("/rooms/{id}/availability", Repo.APIRoomAvailability)
+			mux.Get("/rooms/{id}/quote", Repo.APIQuote)
+			mux.Get("/availability", Repo.APIAvailability)
+		})
+		mux.With(RequireScope(models.ScopeWriteReservations)).Group(func(mux chi.Router) {
+			mux.Post("/reservations", Repo.APICreateReservation)
+			mux.Get("/reservations/{code}", Repo.APIReservation)
+			mux.Post("/reservations/{code}/cancel", Repo.APICancelReservation)
+		})
+		mux.With(RequireScope(models.ScopeAdmin)).Get("/reservations", Repo.APIReservations)
+	})
+
 	mux.Get("/contact", Repo.Contact)
 
+	mux.Get("/user/login", Repo.ShowLogin)
+	mux.Post("/user/login", Repo.PostShowLogin)
+	mux.Get("/user/logout", Repo.Logout)
+
+	mux.Get("/admin/dashboard", Repo.AdminDashboard)
+	mux.Get("/admin/reservations-new", Repo.AdminNewReservations)
+	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
+	mux.Get("/admin/reservations/{src}/{id}", Repo.AdminShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}", Repo.PostAdminShowReservation)
+	mux.Post("/admin/process-reservation/{src}/{id}", Repo.AdminProcessReservation)
+	mux.Post("/admin/delete-reservation/{src}/{id}", Repo.AdminDeleteReservation)
+	mux.Post("/admin/confirm-reservation/{src}/{id}", Repo.AdminConfirmReservation)
+	mux.Post("/admin/check-in-reservation/{src}/{id}", Repo.AdminCheckInReservation)
+	mux.Post("/admin/check-out-reservation/{src}/{id}", Repo.AdminCheckOutReservation)
+	mux.Post("/admin/no-show-reservation/{src}/{id}", Repo.AdminNoShowReservation)
+	mux.Post("/admin/cancel-reservation/{src}/{id}", Repo.AdminCancelReservation)
+	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
+	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/new", Repo.AdminNewRoom)
+	mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+	mux.Get("/admin/pricing", Repo.AdminPricing)
+	mux.Get("/admin/promo-codes", Repo.AdminPromoCodes)
+	mux.Get("/admin/api-keys", Repo.AdminAPIKeys)
+	mux.Post("/admin/api-keys", Repo.AdminPostAPIKey)
+	mux.Post("/admin/api-keys/{id}/delete", Repo.AdminDeleteAPIKey)
+	mux.Get("/admin/emails", Repo.AdminEmails)
+	mux.Get("/admin/emails/{name}", Repo.AdminPreviewEmail)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -105,7 +274,7 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 	// iterate through each page, also parsing all layouts with each page
 	for _, page := range pages {
 		name := filepath.Base(page)
-		templateSet, err := template.New(name).ParseFiles(page)
+		templateSet, err := template.New(name).Funcs(functions).ParseFiles(page)
 		if err != nil {
 			return templateCache, err
 		}
@@ -123,6 +292,44 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 	return templateCache, nil
 }
 
+func CreateTestEmailTemplateCache() (map[string]*config.EmailTemplate, error) {
+	log.Println("creating email template cache")
+	emailCache := map[string]*config.EmailTemplate{}
+
+	dir := filepath.Join(pathToTemplates, "email")
+	emails, err := filepath.Glob(filepath.Join(dir, "*.email.tmpl"))
+	if err != nil {
+		return emailCache, err
+	}
+	layouts := filepath.Join(dir, "*.layout.tmpl")
+
+	for _, email := range emails {
+		name := filepath.Base(email)
+
+		htmlTemplate, err := template.New(name).Funcs(functions).ParseFiles(email)
+		if err != nil {
+			return emailCache, err
+		}
+		htmlTemplate, err = htmlTemplate.ParseGlob(layouts)
+		if err != nil {
+			return emailCache, err
+		}
+
+		textTemplate, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(functions)).ParseFiles(email)
+		if err != nil {
+			return emailCache, err
+		}
+		textTemplate, err = textTemplate.ParseGlob(layouts)
+		if err != nil {
+			return emailCache, err
+		}
+
+		emailCache[name] = &config.EmailTemplate{HTML: htmlTemplate, Text: textTemplate}
+	}
+
+	return emailCache, nil
+}
+
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 
@@ -139,3 +346,16 @@ func NoSurf(next http.Handler) http.Handler {
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
+
+// drainMail empties the mail queue, returning what was on it
+func drainMail() []mail.MailData {
+	var queued []mail.MailData
+	for {
+		select {
+		case m := <-app.MailChan:
+			queued = append(queued, m)
+		default:
+			return queued
+		}
+	}
+}
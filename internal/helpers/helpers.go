@@ -6,6 +6,7 @@ import (
 	"runtime/debug"
 
 	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/models"
 )
 
 var app *config.AppConfig
@@ -25,3 +26,13 @@ func ServerError(w http.ResponseWriter, err error) {
 	app.ErrorLog.Println(trace)
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
+
+// IsAuthenticated returns true if a user is logged in
+func IsAuthenticated(r *http.Request) bool {
+	return app.Session.Exists(r.Context(), "user_id")
+}
+
+// IsAdmin returns true if the logged in user has the access level the back office needs
+func IsAdmin(r *http.Request) bool {
+	return IsAuthenticated(r) && app.Session.GetInt(r.Context(), "access_level") >= models.AccessLevelAdmin
+}
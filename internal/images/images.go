@@ -0,0 +1,227 @@
+// Package images stores uploaded room pictures on disk, together with a smaller thumbnail of each
+package images
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// URLPrefix is the path the upload directory is served under
+const URLPrefix = "/uploads/"
+
+// thumbDir is the subdirectory of the upload directory holding thumbnails
+const thumbDir = "thumbs"
+
+// thumbnails fit within ThumbnailWidth by ThumbnailHeight pixels, keeping the aspect ratio of the image
+const (
+	ThumbnailWidth  = 400
+	ThumbnailHeight = 300
+)
+
+// maxPixels guards against images that are small files but huge once decoded
+const maxPixels = 40_000_000
+
+// ErrUnsupported is returned when an upload is not a JPEG, PNG or GIF image
+var ErrUnsupported = errors.New("images: only JPEG, PNG and GIF images can be uploaded")
+
+// ErrTooLarge is returned when an image has too many pixels to be decoded safely
+var ErrTooLarge = errors.New("images: image dimensions are too large")
+
+// storedName matches the names Save gives files, so only those are ever deleted
+var storedName = regexp.MustCompile(`^[0-9a-f]{32}\.(jpg|png|gif)$`)
+
+// extensions maps the formats registered with the image package to file extensions
+var extensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+}
+
+// Store keeps uploaded images in Dir, which is served at URLPrefix
+type Store struct {
+	Dir string
+}
+
+// NewStore returns a store for the directory dir, which is created on the first upload
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// Save decodes the image read from r, writes it and its thumbnail under a random name, and
+// returns the URL of the image. Images are re-encoded, which drops any metadata such as the
+// location a photo was taken, and only the first frame of an animated GIF is kept.
+func (s *Store) Save(r io.Reader) (string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return "", ErrUnsupported
+	}
+	ext, ok := extensions[format]
+	if !ok {
+		return "", ErrUnsupported
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return "", ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return "", ErrUnsupported
+	}
+
+	name, err := randomName()
+	if err != nil {
+		return "", err
+	}
+	name += ext
+
+	if err = os.MkdirAll(filepath.Join(s.Dir, thumbDir), 0o755); err != nil {
+		return "", err
+	}
+
+	if err = writeImage(filepath.Join(s.Dir, name), img, format); err != nil {
+		return "", err
+	}
+	err = writeImage(filepath.Join(s.Dir, thumbDir, name), Thumbnail(img, ThumbnailWidth, ThumbnailHeight), format)
+	if err != nil {
+		os.Remove(filepath.Join(s.Dir, name))
+		return "", err
+	}
+
+	return URLPrefix + name, nil
+}
+
+// Delete removes an image saved by Save and its thumbnail. URLs of images the store does not
+// manage, such as those under /static, are left alone.
+func (s *Store) Delete(url string) error {
+	name, ok := storedFile(url)
+	if !ok {
+		return nil
+	}
+
+	var errs []error
+	for _, path := range []string{filepath.Join(s.Dir, name), filepath.Join(s.Dir, thumbDir, name)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ThumbnailURL returns the URL of the thumbnail of an uploaded image, or url itself for
+// images that have no thumbnail
+func ThumbnailURL(url string) string {
+	name, ok := storedFile(url)
+	if !ok {
+		return url
+	}
+	return URLPrefix + thumbDir + "/" + name
+}
+
+// storedFile returns the file name of an image URL created by Save
+func storedFile(url string) (string, bool) {
+	name, ok := strings.CutPrefix(url, URLPrefix)
+	if !ok || !storedName.MatchString(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// randomName returns a name that cannot be guessed from other uploads
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeImage encodes img in format to a new file at path
+func writeImage(path string, img image.Image, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
+	case "png":
+		err = png.Encode(f, img)
+	case "gif":
+		err = gif.Encode(f, img, nil)
+	default:
+		err = fmt.Errorf("images: cannot encode %s", format)
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+// Thumbnail scales img down to fit within maxWidth by maxHeight, averaging the pixels that
+// each thumbnail pixel covers. Images that already fit are returned unchanged.
+func Thumbnail(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+
+	// scale by whichever side is furthest over its limit
+	dw, dh := maxWidth, h*maxWidth/w
+	if h*maxWidth > w*maxHeight {
+		dw, dh = w*maxHeight/h, maxHeight
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
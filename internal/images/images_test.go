@@ -0,0 +1,179 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPNG encodes a w by h image filled with c
+func testPNG(t *testing.T, w, h int, c color.Color) *bytes.Buffer {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestStore_Save(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	url, err := s.Save(testPNG(t, 1200, 600, color.RGBA{R: 200, A: 255}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, URLPrefix) || !strings.HasSuffix(url, ".png") {
+		t.Fatalf("unexpected url %s", url)
+	}
+
+	name := strings.TrimPrefix(url, URLPrefix)
+	f, err := os.Open(filepath.Join(s.Dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 1200 || cfg.Height != 600 {
+		t.Errorf("expected the image to keep its size, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	thumbURL := ThumbnailURL(url)
+	if thumbURL != URLPrefix+"thumbs/"+name {
+		t.Errorf("unexpected thumbnail url %s", thumbURL)
+	}
+	f, err = os.Open(filepath.Join(s.Dir, "thumbs", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err = image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 400 || cfg.Height != 200 {
+		t.Errorf("expected a 400x200 thumbnail, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	if err = s.Delete(url); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(s.Dir, name), filepath.Join(s.Dir, "thumbs", name)} {
+		if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be deleted, got %v", path, err)
+		}
+	}
+}
+
+func TestStore_SaveErrors(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	if _, err := s.Save(strings.NewReader("not an image")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+
+	// a header claiming enormous dimensions is rejected before decoding
+	huge := testPNG(t, 1, 1, color.White).Bytes()
+	copy(huge[16:24], []byte{0, 0, 0x27, 0x10, 0, 0, 0x27, 0x10})
+	binary.BigEndian.PutUint32(huge[29:33], crc32.ChecksumIEEE(huge[12:29]))
+	if _, err := s.Save(bytes.NewReader(huge)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(s.Dir)
+	if len(entries) != 0 {
+		t.Errorf("expected rejected uploads to write nothing, found %d entries", len(entries))
+	}
+}
+
+func TestStore_DeleteUnmanaged(t *testing.T) {
+	dir := t.TempDir()
+	keep := filepath.Join(dir, "keep.png")
+	if err := os.WriteFile(keep, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(filepath.Join(dir, "uploads"))
+	for _, url := range []string{"/static/images/generals-quarters.png", URLPrefix + "../keep.png", URLPrefix + "keep.png"} {
+		if err := s.Delete(url); err != nil {
+			t.Errorf("Delete(%s): %v", url, err)
+		}
+		if ThumbnailURL(url) != url {
+			t.Errorf("expected %s to have no thumbnail", url)
+		}
+	}
+
+	if _, err := os.Stat(keep); err != nil {
+		t.Errorf("expected files outside the store to be left alone, got %v", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	var thumbnailTests = []struct {
+		name           string
+		width, height  int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"small", 100, 50, 100, 50},
+		{"wide", 800, 200, 400, 100},
+		{"tall", 300, 900, 100, 300},
+		{"exact ratio", 1600, 1200, 400, 300},
+		{"sliver", 10000, 2, 400, 1},
+	}
+
+	for _, test := range thumbnailTests {
+		img := image.NewGray(image.Rect(0, 0, test.width, test.height))
+		b := Thumbnail(img, ThumbnailWidth, ThumbnailHeight).Bounds()
+		if b.Dx() != test.expectedWidth || b.Dy() != test.expectedHeight {
+			t.Errorf("for %s, expected %dx%d but got %dx%d", test.name, test.expectedWidth, test.expectedHeight, b.Dx(), b.Dy())
+		}
+	}
+
+	// alternating black and white columns average out to grey
+	img := image.NewGray(image.Rect(0, 0, 800, 10))
+	for x := 0; x < 800; x += 2 {
+		for y := 0; y < 10; y++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	r, _, _, _ := Thumbnail(img, 400, 300).At(10, 2).RGBA()
+	if r>>8 < 120 || r>>8 > 135 {
+		t.Errorf("expected a mid grey thumbnail pixel, got %d", r>>8)
+	}
+}
+
+func TestStore_SaveJPEG(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 20, 20)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := s.Save(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(url, ".jpg") {
+		t.Errorf("expected a .jpg url, got %s", url)
+	}
+}
@@ -0,0 +1,94 @@
+// Package lifecycle is the state machine a reservation moves through, from booking until the
+// guest leaves. Every move is checked against the legal transitions and returned as a
+// models.StatusChange recording who made it and when, for the repository to save.
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+)
+
+// ErrIllegalTransition is returned for a move the machine does not allow
+var ErrIllegalTransition = errors.New("lifecycle: illegal status transition")
+
+// kinds of actor that change a reservation's status
+const (
+	ActorGuest  = "guest"
+	ActorStaff  = "staff"
+	ActorSystem = "system"
+)
+
+// Actor is who changes a reservation's status; UserID is set for staff only
+type Actor struct {
+	Kind   string
+	UserID int
+}
+
+// Guest is the guest, acting through their manage link
+var Guest = Actor{Kind: ActorGuest}
+
+// System is the application itself, such as a scheduled job
+var System = Actor{Kind: ActorSystem}
+
+// Staff is the logged in user userID, acting from the admin tool
+func Staff(userID int) Actor {
+	return Actor{Kind: ActorStaff, UserID: userID}
+}
+
+// Machine maps each status to the statuses a reservation in it may move to; a status
+// without any is final
+type Machine map[string][]string
+
+// Reservations is the lifecycle of a reservation. Bookings start pending until the owner
+// confirms them, and either may be cancelled. A confirmed guest then checks in, then out, or is
+// marked as a no-show.
+var Reservations = Machine{
+	models.ReservationPending:   {models.ReservationConfirmed, models.ReservationCancelled},
+	models.ReservationConfirmed: {models.ReservationCheckedIn, models.ReservationNoShow, models.ReservationCancelled},
+	models.ReservationCheckedIn: {models.ReservationCheckedOut},
+}
+
+// Can reports whether a reservation may move from status from to status to
+func (m Machine) Can(from, to string) bool {
+	for _, s := range m[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the statuses a reservation in status may move to
+func (m Machine) Next(status string) []string {
+	return m[status]
+}
+
+// Transition checks res may move to status to, returning the change made by by at t
+func (m Machine) Transition(res models.Reservation, to string, by Actor, at time.Time) (models.StatusChange, error) {
+	if !m.Can(res.Status, to) {
+		return models.StatusChange{}, fmt.Errorf("%w from %q to %q", ErrIllegalTransition, res.Status, to)
+	}
+
+	return models.StatusChange{
+		ReservationID: res.ID,
+		FromStatus:    res.Status,
+		ToStatus:      to,
+		Actor:         by.Kind,
+		UserID:        by.UserID,
+		CreatedAt:     at,
+	}, nil
+}
+
+// Blocking reports whether a reservation in status holds its room. Cancelled bookings and
+// no-shows give their dates back, though the reservation itself is kept.
+func Blocking(status string) bool {
+	return status != models.ReservationCancelled && status != models.ReservationNoShow
+}
+
+// Changeable reports whether the guest may still change or cancel a reservation in status
+func Changeable(status string) bool {
+	return status == models.ReservationPending || status == models.ReservationConfirmed
+}
@@ -0,0 +1,76 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+)
+
+func TestMachine_Can(t *testing.T) {
+	var canTests = []struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{models.ReservationPending, models.ReservationConfirmed, true},
+		{models.ReservationPending, models.ReservationCancelled, true},
+		{models.ReservationPending, models.ReservationCheckedIn, false},
+		{models.ReservationConfirmed, models.ReservationCheckedIn, true},
+		{models.ReservationConfirmed, models.ReservationNoShow, true},
+		{models.ReservationConfirmed, models.ReservationCancelled, true},
+		{models.ReservationConfirmed, models.ReservationCheckedOut, false},
+		{models.ReservationCheckedIn, models.ReservationCheckedOut, true},
+		{models.ReservationCheckedIn, models.ReservationCancelled, false},
+		{models.ReservationCancelled, models.ReservationConfirmed, false},
+		{models.ReservationCheckedOut, models.ReservationCheckedIn, false},
+		{models.ReservationNoShow, models.ReservationCheckedIn, false},
+		{"", models.ReservationConfirmed, false},
+	}
+
+	for _, test := range canTests {
+		if got := Reservations.Can(test.from, test.to); got != test.expected {
+			t.Errorf("for %q to %q, expected %v but got %v", test.from, test.to, test.expected, got)
+		}
+	}
+}
+
+func TestMachine_Transition(t *testing.T) {
+	at := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	res := models.Reservation{ID: 7, Status: models.ReservationConfirmed}
+
+	change, err := Reservations.Transition(res, models.ReservationCheckedIn, Staff(3), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := models.StatusChange{
+		ReservationID: 7,
+		FromStatus:    models.ReservationConfirmed,
+		ToStatus:      models.ReservationCheckedIn,
+		Actor:         ActorStaff,
+		UserID:        3,
+		CreatedAt:     at,
+	}
+	if change != expected {
+		t.Errorf("expected %+v, got %+v", expected, change)
+	}
+
+	res.Status = models.ReservationCancelled
+	if _, err = Reservations.Transition(res, models.ReservationCheckedIn, Guest, at); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected ErrIllegalTransition, got %v", err)
+	}
+}
+
+func TestBlocking(t *testing.T) {
+	for _, status := range []string{models.ReservationPending, models.ReservationConfirmed, models.ReservationCheckedIn, models.ReservationCheckedOut} {
+		if !Blocking(status) {
+			t.Errorf("expected a %s reservation to hold its room", status)
+		}
+	}
+	for _, status := range []string{models.ReservationCancelled, models.ReservationNoShow} {
+		if Blocking(status) {
+			t.Errorf("expected a %s reservation to free its room", status)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package mail sends the application's email in the background. Handlers put a MailData on the
+// app's mail channel with Queue, which never waits, and worker goroutines started with Start
+// take messages off the channel and hand them to a Sender.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sendTimeout bounds how long a worker spends on a single message
+const sendTimeout = 30 * time.Second
+
+// MailData is an email to send; Content is its plain text body and HTML, when set, an
+// alternative HTML version of it. Failed, when set, is called by the worker that could not
+// send it.
+type MailData struct {
+	To      string
+	From    string
+	Subject string
+	Content string
+	HTML    string
+	Failed  func(err error)
+}
+
+// Sender delivers email, such as through an SMTP server
+type Sender interface {
+	Send(ctx context.Context, m MailData) error
+}
+
+// Queue puts m on ch without waiting, returning false when the queue is full
+func Queue(ch chan<- MailData, m MailData) bool {
+	select {
+	case ch <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// QueueWait puts m on ch, waiting for room until ctx is done. Background jobs use it, as
+// unlike a request they can afford to wait for the workers to catch up.
+func QueueWait(ctx context.Context, ch chan<- MailData, m MailData) error {
+	select {
+	case ch <- m:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Workers are the goroutines sending the messages queued on a channel
+type Workers struct {
+	wg sync.WaitGroup
+}
+
+// Start runs n workers sending the messages on ch with sender until ch is closed. Failed
+// messages are logged to errorLog, handed to their Failed func and dropped.
+func Start(ch <-chan MailData, sender Sender, n int, errorLog *log.Logger) *Workers {
+	w := &Workers{}
+	for i := 0; i < n; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for m := range ch {
+				ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+				if err := sender.Send(ctx, m); err != nil {
+					errorLog.Printf("sending mail %q to %s: %v", m.Subject, m.To, err)
+					if m.Failed != nil {
+						m.Failed(err)
+					}
+				}
+				cancel()
+			}
+		}()
+	}
+	return w
+}
+
+// Wait blocks until the workers have sent everything queued before their channel was closed,
+// or until ctx is done
+func (w *Workers) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("mail: waiting for workers: %w", ctx.Err())
+	}
+}
+
+// Message returns m as an RFC 5322 message, ready to be sent by SMTP or saved as a .eml file
+func (m MailData) Message() []byte {
+	var b bytes.Buffer
+
+	header := func(name, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	header("From", headerValue(m.From))
+	header("To", headerValue(m.To))
+	header("Subject", mime.QEncoding.Encode("utf-8", headerValue(m.Subject)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		writeQuotedPrintable(&b, m.Content)
+		return b.Bytes()
+	}
+
+	// the parts go from plainest to richest, so clients show the last one they can display
+	mw := multipart.NewWriter(&b)
+	header("Content-Type", `multipart/alternative; boundary="`+mw.Boundary()+`"`)
+	b.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Content},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		writeQuotedPrintable(w, part.body)
+	}
+	mw.Close()
+
+	return b.Bytes()
+}
+
+// writeQuotedPrintable writes body to w quoted-printable encoded, with CRLF line endings
+func writeQuotedPrintable(w io.Writer, body string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+}
+
+// headerValue removes line breaks from v, so a value cannot add headers of its own
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
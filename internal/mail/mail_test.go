@@ -0,0 +1,321 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpServer is a minimal SMTP server in the manner of MailHog, keeping what it receives
+type smtpServer struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	messages []received
+}
+
+// received is a message as the server saw it
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+
+	var msg received
+	tp.PrintfLine("220 localhost fake SMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			msg.from = strings.Trim(strings.TrimPrefix(line[4:], " FROM:"), "<>")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(line[4:], " TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			b, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			msg.data = string(b)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			msg = received{}
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpServer) received() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.messages...)
+}
+
+func (s *smtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+var testMessage = MailData{
+	To:      "Jane Doe <jane@doe.com>",
+	From:    "bookings@here.ca",
+	Subject: "Your reservation\r\nBcc: evil@example.com",
+	Content: "Dear Jane,\nSee you soon — with a non-ASCII dash.",
+}
+
+func TestMailData_Message(t *testing.T) {
+	msg := string(testMessage.Message())
+
+	header, body, ok := strings.Cut(msg, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("expected headers and a body, got %q", msg)
+	}
+	if strings.Contains(header, "\r\nBcc:") {
+		t.Error("expected line breaks in the subject to be removed")
+	}
+	for _, h := range []string{"From: bookings@here.ca", "To: Jane Doe <jane@doe.com>", "Content-Type: text/plain; charset=utf-8"} {
+		if !strings.Contains(header, h) {
+			t.Errorf("expected header %q in %q", h, header)
+		}
+	}
+
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "Dear Jane,\r\nSee you soon — with a non-ASCII dash." {
+		t.Errorf("unexpected body %q", decoded)
+	}
+}
+
+func TestMailData_MessageWithHTML(t *testing.T) {
+	m := testMessage
+	m.HTML = "<p>Dear Jane,</p>"
+
+	msg, err := netmail.ReadMessage(bytes.NewReader(m.Message()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected a multipart/alternative message, got %q", msg.Header.Get("Content-Type"))
+	}
+
+	// the multipart reader undoes the quoted-printable encoding of each part
+	var parts []string
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, p.Header.Get("Content-Type")+": "+string(body))
+	}
+
+	expected := []string{
+		"text/plain; charset=utf-8: Dear Jane,\r\nSee you soon — with a non-ASCII dash.",
+		"text/html; charset=utf-8: <p>Dear Jane,</p>",
+	}
+	if strings.Join(parts, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected parts %q, got %q", expected, parts)
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	server := newSMTPServer(t)
+	sender := NewSMTPSender("127.0.0.1", server.port(), "", "")
+
+	if err := sender.Send(context.Background(), testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	got := server.received()
+	if len(got) != 1 {
+		t.Fatalf("expected one message, got %d", len(got))
+	}
+	if got[0].from != "bookings@here.ca" || len(got[0].to) != 1 || got[0].to[0] != "jane@doe.com" {
+		t.Errorf("unexpected envelope %+v", got[0])
+	}
+	if !strings.Contains(got[0].data, "Dear Jane,") {
+		t.Errorf("expected the body to be sent, got %q", got[0].data)
+	}
+
+	bad := testMessage
+	bad.To = "not an address"
+	if err := sender.Send(context.Background(), bad); err == nil {
+		t.Error("expected an invalid address to be refused")
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	sender := NewFileSender(dir)
+
+	for i := 0; i < 2; i++ {
+		if err := sender.Send(context.Background(), testMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected a file per message, got %v", files)
+	}
+	b, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(b), "To: Jane Doe <jane@doe.com>") {
+		t.Errorf("expected the message to be written, got %q", b)
+	}
+}
+
+// failingSender fails every message sent to a failing address
+type failingSender struct {
+	*MemorySender
+}
+
+func (s failingSender) Send(ctx context.Context, m MailData) error {
+	if strings.HasPrefix(m.To, "fail") {
+		return fmt.Errorf("refused %s", m.To)
+	}
+	return s.MemorySender.Send(ctx, m)
+}
+
+func TestWorkers(t *testing.T) {
+	sender := failingSender{NewMemorySender()}
+	ch := make(chan MailData, 10)
+	var logged strings.Builder
+	var logMu sync.Mutex
+	errorLog := log.New(writerFunc(func(p []byte) (int, error) {
+		logMu.Lock()
+		defer logMu.Unlock()
+		return logged.Write(p)
+	}), "", 0)
+
+	w := Start(ch, sender, 3, errorLog)
+	for i := 0; i < 5; i++ {
+		if !Queue(ch, MailData{To: fmt.Sprintf("guest%d@here.ca", i)}) {
+			t.Fatal("expected the message to be queued")
+		}
+	}
+	failed := make(chan error, 1)
+	Queue(ch, MailData{To: "fail@here.ca", Subject: "Doomed", Failed: func(err error) { failed <- err }})
+	close(ch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(sender.Sent()); n != 5 {
+		t.Errorf("expected every queued message to be sent before Wait returned, got %d", n)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	if !strings.Contains(logged.String(), `"Doomed" to fail@here.ca`) {
+		t.Errorf("expected the failure to be logged, got %q", logged.String())
+	}
+	select {
+	case err := <-failed:
+		if err == nil || !strings.Contains(err.Error(), "refused fail@here.ca") {
+			t.Errorf("expected the message to be told why it failed, got %v", err)
+		}
+	default:
+		t.Error("expected the failed message's Failed func to be called")
+	}
+}
+
+func TestQueue_Full(t *testing.T) {
+	ch := make(chan MailData, 1)
+	if !Queue(ch, MailData{}) {
+		t.Fatal("expected the first message to be queued")
+	}
+	if Queue(ch, MailData{}) {
+		t.Error("expected Queue not to wait for room on a full channel")
+	}
+}
+
+func TestQueueWait(t *testing.T) {
+	ch := make(chan MailData, 1)
+	if err := QueueWait(context.Background(), ch, MailData{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := QueueWait(ctx, ch, MailData{}); err != context.DeadlineExceeded {
+		t.Errorf("expected QueueWait to give up when ctx is done, got %v", err)
+	}
+
+	go func() { <-ch }()
+	if err := QueueWait(context.Background(), ch, MailData{}); err != nil {
+		t.Errorf("expected QueueWait to wait for room, got %v", err)
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
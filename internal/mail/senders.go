@@ -0,0 +1,155 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SMTPSender sends email through an SMTP server, using STARTTLS when the server offers it
+// and authenticating when a username is set
+type SMTPSender struct {
+	host     string
+	addr     string
+	username string
+	password string
+}
+
+// NewSMTPSender returns a sender using the SMTP server at host:port
+func NewSMTPSender(host string, port int, username, password string) *SMTPSender {
+	return &SMTPSender{
+		host:     host,
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		username: username,
+		password: password,
+	}
+}
+
+// Send delivers m, giving up when ctx is done
+func (s *SMTPSender) Send(ctx context.Context, m MailData) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("mail: from address: %w", err)
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("mail: to address: %w", err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("mail: connecting to %s: %w", s.addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mail: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("mail: starting TLS: %w", err)
+		}
+	}
+	if s.username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("mail: authenticating: %w", err)
+		}
+	}
+
+	if err = c.Mail(from.Address); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	if err = c.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	if _, err = w.Write(m.Message()); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+
+	return c.Quit()
+}
+
+// FileSender writes each message to a .eml file in a directory, for development
+type FileSender struct {
+	dir string
+
+	mu sync.Mutex
+	n  int
+}
+
+// NewFileSender returns a sender writing to dir, which is created when the first message is sent
+func NewFileSender(dir string) *FileSender {
+	return &FileSender{dir: dir}
+}
+
+// Send writes m to a new file named after the time it was sent
+func (s *FileSender) Send(ctx context.Context, m MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+
+	s.mu.Lock()
+	s.n++
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102-150405"), s.n)
+	s.mu.Unlock()
+
+	if err := os.WriteFile(filepath.Join(s.dir, name), m.Message(), 0o644); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	return nil
+}
+
+// MemorySender keeps the messages it is given, for tests and development
+type MemorySender struct {
+	mu   sync.Mutex
+	sent []MailData
+}
+
+// NewMemorySender returns an empty MemorySender
+func NewMemorySender() *MemorySender {
+	return &MemorySender{}
+}
+
+// Send records m
+func (s *MemorySender) Send(ctx context.Context, m MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first
+func (s *MemorySender) Sent() []MailData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MailData(nil), s.sent...)
+}
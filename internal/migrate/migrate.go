@@ -0,0 +1,276 @@
+// Package migrate applies the SQL migrations in migrations/ without needing soda installed.
+// Applied versions are kept in soda's schema_migration table, so the two tools can be mixed.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+)
+
+// Migration is a single schema change, read from a pair of up and down SQL files
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration together with whether it has been applied
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies migrations to a Postgres database
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// fileName matches soda's SQL migration names: version_name[.dialect].up|down.sql
+var fileName = regexp.MustCompile(`^(\d+)_([^.]+)(?:\.(\w+))?\.(up|down)\.sql$`)
+
+// lockID is the Postgres advisory lock that keeps two migrators from running at once
+const lockID = 7253830195
+
+// Load reads the migrations for Postgres from fsys, ordered by version. Files for
+// other dialects are skipped, and every migration must have an up file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]*Migration)
+	for _, name := range names {
+		match := fileName.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("migrate: %s is not named version_name[.dialect].up|down.sql", name)
+		}
+		version, migrationName, dialect, direction := match[1], match[2], match[3], match[4]
+		if dialect != "" && dialect != "postgres" && dialect != "all" {
+			continue
+		}
+
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = m
+		}
+		if m.Name != migrationName {
+			return nil, fmt.Errorf("migrate: version %s is used by both %s and %s", version, m.Name, migrationName)
+		}
+
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrate: %s_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// New returns a Migrator for the migrations in fsys
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Up applies every pending migration in order, returning the ones applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.Migrations {
+			if applied[mig.Version] {
+				continue
+			}
+			if err = apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the most recently applied migration, returning nil when nothing is applied
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var rolledBack *Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		mig, err := m.latest(ctx, conn)
+		if err != nil || mig == nil {
+			return err
+		}
+		if err = apply(ctx, conn, *mig, false); err != nil {
+			return err
+		}
+		rolledBack = mig
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// Redo rolls back the most recently applied migration and applies it again
+func (m *Migrator) Redo(ctx context.Context) (*Migration, error) {
+	var redone *Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		mig, err := m.latest(ctx, conn)
+		if err != nil || mig == nil {
+			return err
+		}
+		if err = apply(ctx, conn, *mig, false); err != nil {
+			return err
+		}
+		if err = apply(ctx, conn, *mig, true); err != nil {
+			return err
+		}
+		redone = mig
+		return nil
+	})
+
+	return redone, err
+}
+
+// Status lists every migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.Migrations {
+			statuses = append(statuses, Status{Migration: mig, Applied: applied[mig.Version]})
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+// withLock runs fn on a single connection holding the migration advisory lock,
+// after making sure the schema_migration table exists
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("migrate: taking lock: %w", err)
+	}
+	// use a fresh context so the lock is released even if ctx was cancelled
+	defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockID)
+
+	// the same table and index soda creates
+	_, err = conn.ExecContext(ctx, `create table if not exists schema_migration (version varchar(14) not null);
+		create unique index if not exists schema_migration_version_idx on schema_migration (version)`)
+	if err != nil {
+		return fmt.Errorf("migrate: creating schema_migration: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// latest returns the most recently applied migration, or nil when none is applied
+func (m *Migrator) latest(ctx context.Context, conn *sql.Conn) (*Migration, error) {
+	var version string
+	err := conn.QueryRowContext(ctx, `select version from schema_migration order by version desc limit 1`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range m.Migrations {
+		if m.Migrations[i].Version == version {
+			return &m.Migrations[i], nil
+		}
+	}
+	return nil, fmt.Errorf("migrate: applied version %s has no migration file", version)
+}
+
+// appliedVersions returns the set of versions recorded in schema_migration
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[string]bool, error) {
+	rows, err := conn.QueryContext(ctx, `select version from schema_migration`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// apply runs one direction of mig and records it in schema_migration, all in one transaction
+func apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	stmt, record := mig.Up, `insert into schema_migration (version) values ($1)`
+	if !up {
+		if mig.Down == "" {
+			return fmt.Errorf("migrate: %s_%s has no down file", mig.Version, mig.Name)
+		}
+		stmt, record = mig.Down, `delete from schema_migration where version = $1`
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("migrate: %s_%s: %w", mig.Version, mig.Name, err)
+	}
+	if _, err = tx.ExecContext(ctx, record, mig.Version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
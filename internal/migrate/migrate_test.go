@@ -0,0 +1,76 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jeremydelacruz/go-bookings/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20230102000000_second.postgres.up.sql":   {Data: []byte("create table b ();")},
+		"20230102000000_second.postgres.down.sql": {Data: []byte("drop table b;")},
+		"20230101000000_first.up.sql":             {Data: []byte("create table a ();")},
+		"20230101000000_first.down.sql":           {Data: []byte("drop table a;")},
+		"20230103000000_mysql_only.mysql.up.sql":  {Data: []byte("create table c ();")},
+		"20230104000000_seed.postgres.up.sql":     {Data: []byte("insert into a values ();")},
+	}
+
+	loaded, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != 3 {
+		t.Fatalf("expected 3 migrations, got %d", len(loaded))
+	}
+	if loaded[0].Name != "first" || loaded[1].Name != "second" || loaded[2].Name != "seed" {
+		t.Errorf("expected migrations ordered by version, got %s, %s, %s", loaded[0].Name, loaded[1].Name, loaded[2].Name)
+	}
+	if loaded[1].Up != "create table b ();" || loaded[1].Down != "drop table b;" {
+		t.Errorf("unexpected contents for second: %+v", loaded[1])
+	}
+	if loaded[2].Down != "" {
+		t.Errorf("expected seed to have no down, got %q", loaded[2].Down)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	var loadTests = []struct {
+		name     string
+		fsys     fstest.MapFS
+		expected string
+	}{
+		{"bad name", fstest.MapFS{"create_users.sql": {}}, "is not named"},
+		{"missing up", fstest.MapFS{"20230101000000_first.down.sql": {Data: []byte("drop table a;")}}, "has no up file"},
+		{"duplicate version", fstest.MapFS{
+			"20230101000000_first.up.sql":  {Data: []byte("create table a ();")},
+			"20230101000000_second.up.sql": {Data: []byte("create table b ();")},
+		}, "is used by both"},
+	}
+
+	for _, test := range loadTests {
+		_, err := Load(test.fsys)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("for %s, expected error containing %q but got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestLoad_Embedded(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) == 0 {
+		t.Fatal("expected embedded migrations")
+	}
+	for _, m := range loaded {
+		if m.Down == "" {
+			t.Errorf("%s_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
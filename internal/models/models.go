@@ -2,6 +2,12 @@ package models
 
 import "time"
 
+// access levels of a user; staff need AccessLevelAdmin for the back office
+const (
+	AccessLevelUser  = 1
+	AccessLevelAdmin = 3
+)
+
 // User is the user model
 type User struct {
 	ID          int
@@ -14,12 +20,22 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
-// Room is the room model
+// Room is the room model; Images holds the URLs of its pictures, the first being the main one.
+// Inactive rooms are hidden from guests but keep their reservations, and rooms are listed by SortOrder.
+// NightlyRate is the base price of a night in cents.
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID          int
+	RoomName    string
+	Slug        string
+	Description string
+	Capacity    int
+	Amenities   []string
+	Images      []string
+	Active      bool
+	SortOrder   int
+	NightlyRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
 // Restrictions is the restriction model
@@ -30,19 +46,89 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
-// Reservations is the reservation model
+// Reservations is the reservation model. ConfirmationCode is the random code guests use to
+// manage their booking, Status is one of the Reservation* statuses, and CancelledAt is zero
+// unless the reservation has been cancelled.
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Room      Room
+	ID               int
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	StartDate        time.Time
+	EndDate          time.Time
+	RoomID           int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Room             Room
+	Processed        int
+	Price            Quote
+	PromoCode        string
+	Payment          PaymentIntent
+	ConfirmationCode string
+	Status           string
+	CancelledAt      time.Time
+	APIKeyID         int
+}
+
+// Reservation statuses; the moves allowed between them are in package lifecycle
+const (
+	ReservationPending    = "pending"
+	ReservationConfirmed  = "confirmed"
+	ReservationCancelled  = "cancelled"
+	ReservationCheckedIn  = "checked_in"
+	ReservationCheckedOut = "checked_out"
+	ReservationNoShow     = "no_show"
+)
+
+// StatusChange records a reservation moving from one status to another. Actor is the kind of
+// actor who moved it, and UserID the staff member when it was one.
+type StatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    string
+	ToStatus      string
+	Actor         string
+	UserID        int
+	CreatedAt     time.Time
+}
+
+// Kinds of scheduled message sent about a reservation
+const (
+	MessageArrivalReminder = "arrival_reminder"
+	MessageFollowUp        = "follow_up"
+)
+
+// SentMessage records that a scheduled message of Kind was sent about a reservation, so it is
+// sent only once
+type SentMessage struct {
+	ID            int
+	ReservationID int
+	Kind          string
+	CreatedAt     time.Time
+}
+
+// API key scopes say which parts of the JSON API a key may use; ScopeAdmin allows all of them
+const (
+	ScopeReadAvailability  = "availability:read"
+	ScopeWriteReservations = "reservations:write"
+	ScopeAdmin             = "admin"
+)
+
+// APIKey lets a program use the JSON API. Only a hash of the key is stored, with Prefix, its
+// first characters, kept so staff can tell keys apart. ExpiresAt is zero for a key that never
+// expires and LastUsedAt for one never used; UserID is the staff member who issued it.
+type APIKey struct {
+	ID         int
+	Name       string
+	Prefix     string
+	Hash       string
+	Scopes     []string
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	UserID     int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 // RoomRestrictions is the room restriction model
@@ -59,3 +145,103 @@ type RoomRestriction struct {
 	Reservation   Reservation
 	Restriction   Restriction
 }
+
+// RateOverride replaces the nightly rate of a room, in cents, on the nights from StartDate up
+// to but not including EndDate. When Weekdays is not empty it only applies on those days.
+type RateOverride struct {
+	ID          int
+	RoomID      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	Weekdays    []time.Weekday
+	NightlyRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+}
+
+// StayDiscount takes Percent off the price of stays of at least MinNights nights
+type StayDiscount struct {
+	ID        int
+	Name      string
+	MinNights int
+	Percent   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Quote is the itemised price of a stay; amounts are in cents and TaxRate is in basis points.
+// Reservations keep the quote they were booked at, so later rate changes do not alter them.
+type Quote struct {
+	Nights          []NightPrice `json:"nights"`
+	Subtotal        int          `json:"subtotal"`
+	DiscountName    string       `json:"discount_name,omitempty"`
+	DiscountPercent int          `json:"discount_percent,omitempty"`
+	Discount        int          `json:"discount"`
+	PromoCode       string       `json:"promo_code,omitempty"`
+	PromoDiscount   int          `json:"promo_discount,omitempty"`
+	TaxRate         int          `json:"tax_rate"`
+	Taxes           int          `json:"taxes"`
+	Total           int          `json:"total"`
+	Deposit         int          `json:"deposit,omitempty"`
+}
+
+// NightPrice is the price of a single night of a quote, and the name of the rate it came from
+type NightPrice struct {
+	Date time.Time `json:"date"`
+	Rate int       `json:"rate"`
+	Name string    `json:"name"`
+}
+
+// PromoCode kinds say how a promo code's Amount is taken off a stay
+const (
+	PromoPercent = "percent"
+	PromoFixed   = "fixed"
+)
+
+// PromoCode is a code guests can enter when booking for money off their stay. Amount is a
+// percentage for PromoPercent codes and cents for PromoFixed ones. The code can be redeemed
+// from ValidFrom to ValidUntil inclusive, at most MaxUses times (0 for no limit), for stays of
+// at least MinNights in any of RoomIDs (empty for every room).
+type PromoCode struct {
+	ID         int
+	Code       string
+	Kind       string
+	Amount     int
+	ValidFrom  time.Time
+	ValidUntil time.Time
+	MaxUses    int
+	Uses       int
+	RoomIDs    []int
+	MinNights  int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// PaymentIntent statuses follow a payment from the hold placed at booking time until the money
+// is taken or given back
+const (
+	PaymentAuthorized = "authorized"
+	PaymentCaptured   = "captured"
+	PaymentRefunded   = "refunded"
+	PaymentVoided     = "voided"
+	PaymentFailed     = "failed"
+)
+
+// PaymentIntent is a deposit taken for a reservation through a payment provider. Amount is
+// what was authorised, in cents of Currency, of which Captured has been taken and Refunded
+// given back. ProviderRef is the provider's own id for the payment.
+type PaymentIntent struct {
+	ID            int
+	ReservationID int
+	Provider      string
+	ProviderRef   string
+	Status        string
+	Amount        int
+	Captured      int
+	Refunded      int
+	Currency      string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
@@ -0,0 +1,227 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+)
+
+// FakeProvider is the name of the in-process gateway used in development and tests
+const FakeProvider = "fake"
+
+// Card tokens understood by the fake gateway; any other token is declined
+const (
+	FakeCardOK       = "tok_visa"
+	FakeCardDeclined = "tok_chargeDeclined"
+)
+
+// FakeSignatureHeader carries the signature of fake webhook requests, in the form
+// t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<payload>">
+const FakeSignatureHeader = "Fake-Signature"
+
+// fakeWebhookTolerance is how old a signed webhook may be before it is refused as a replay
+const fakeWebhookTolerance = 5 * time.Minute
+
+// FakeGateway is a Gateway that keeps payments in memory and never talks to a real provider
+type FakeGateway struct {
+	mu        sync.Mutex
+	secret    []byte
+	payments  map[string]Payment
+	nextID    int
+	nextEvent int
+	now       func() time.Time
+}
+
+// NewFakeGateway creates an empty fake gateway signing webhooks with secret
+func NewFakeGateway(secret string) *FakeGateway {
+	return &FakeGateway{
+		secret:   []byte(secret),
+		payments: map[string]Payment{},
+		now:      time.Now,
+	}
+}
+
+// Name returns FakeProvider
+func (g *FakeGateway) Name() string {
+	return FakeProvider
+}
+
+// Authorize places a hold for req.Amount, which succeeds only for FakeCardOK. Payments are
+// numbered in order, as fake_pi_1, fake_pi_2 and so on.
+func (g *FakeGateway) Authorize(ctx context.Context, req AuthorizeRequest) (Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return Payment{}, err
+	}
+	if req.Amount <= 0 {
+		return Payment{}, ErrInvalidAmount
+	}
+	if req.Source != FakeCardOK {
+		return Payment{}, ErrDeclined
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.nextID++
+	p := Payment{
+		Ref:    fmt.Sprintf("fake_pi_%d", g.nextID),
+		Status: models.PaymentAuthorized,
+		Amount: req.Amount,
+	}
+	g.payments[p.Ref] = p
+
+	return p, nil
+}
+
+// Capture takes amount, at most the authorised amount, of an authorised payment
+func (g *FakeGateway) Capture(ctx context.Context, ref string, amount int) (Payment, error) {
+	return g.update(ctx, ref, func(p *Payment) error {
+		if p.Status != models.PaymentAuthorized {
+			return ErrInvalidState
+		}
+		if amount <= 0 || amount > p.Amount {
+			return ErrInvalidAmount
+		}
+		p.Captured = amount
+		p.Status = models.PaymentCaptured
+		return nil
+	})
+}
+
+// Refund gives back amount of what was captured; the payment is refunded once all of it has been
+func (g *FakeGateway) Refund(ctx context.Context, ref string, amount int) (Payment, error) {
+	return g.update(ctx, ref, func(p *Payment) error {
+		if p.Status != models.PaymentCaptured {
+			return ErrInvalidState
+		}
+		if amount <= 0 || amount > p.Captured-p.Refunded {
+			return ErrInvalidAmount
+		}
+		p.Refunded += amount
+		if p.Refunded == p.Captured {
+			p.Status = models.PaymentRefunded
+		}
+		return nil
+	})
+}
+
+// Void releases the hold of an authorised payment
+func (g *FakeGateway) Void(ctx context.Context, ref string) (Payment, error) {
+	return g.update(ctx, ref, func(p *Payment) error {
+		if p.Status != models.PaymentAuthorized {
+			return ErrInvalidState
+		}
+		p.Status = models.PaymentVoided
+		return nil
+	})
+}
+
+// update applies fn to the payment ref under the lock, keeping the change only when fn succeeds
+func (g *FakeGateway) update(ctx context.Context, ref string, fn func(p *Payment) error) (Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return Payment{}, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[ref]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+	if err := fn(&p); err != nil {
+		return Payment{}, err
+	}
+	g.payments[ref] = p
+
+	return p, nil
+}
+
+// Payment returns the fake gateway's record of payment ref
+func (g *FakeGateway) Payment(ref string) (Payment, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[ref]
+	return p, ok
+}
+
+// Webhook returns a signed webhook request body and signature header value announcing the
+// current state of payment ref, as the provider would send after changing it
+func (g *FakeGateway) Webhook(ref string) ([]byte, string, error) {
+	g.mu.Lock()
+	p, ok := g.payments[ref]
+	g.nextEvent++
+	id := fmt.Sprintf("fake_evt_%d", g.nextEvent)
+	g.mu.Unlock()
+
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+
+	payload, err := json.Marshal(Event{ID: id, Type: "payment." + p.Status, Payment: p})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return payload, g.Sign(payload, g.now()), nil
+}
+
+// Sign returns the FakeSignatureHeader value for payload sent at t
+func (g *FakeGateway) Sign(payload []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(g.mac(ts, payload)))
+}
+
+// VerifyWebhook checks the FakeSignatureHeader of a webhook request, refusing ones signed
+// with another secret or too long ago
+func (g *FakeGateway) VerifyWebhook(payload []byte, header http.Header) (Event, error) {
+	var ts, sig string
+	for _, part := range strings.Split(header.Get(FakeSignatureHeader), ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return Event{}, ErrInvalidSignature
+	}
+	if age := g.now().Sub(time.Unix(unix, 0)); age > fakeWebhookTolerance || age < -fakeWebhookTolerance {
+		return Event{}, ErrInvalidSignature
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, g.mac(ts, payload)) {
+		return Event{}, ErrInvalidSignature
+	}
+
+	var e Event
+	if err = json.Unmarshal(payload, &e); err != nil {
+		return Event{}, fmt.Errorf("payments: decoding webhook: %w", err)
+	}
+
+	return e, nil
+}
+
+// mac is the HMAC-SHA256 of "<ts>.<payload>" with the webhook secret
+func (g *FakeGateway) mac(ts string, payload []byte) []byte {
+	h := hmac.New(sha256.New, g.secret)
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return h.Sum(nil)
+}
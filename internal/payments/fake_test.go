@@ -0,0 +1,155 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+)
+
+func TestNew(t *testing.T) {
+	g, err := New(FakeProvider, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Name() != FakeProvider {
+		t.Errorf("expected the %s gateway, got %s", FakeProvider, g.Name())
+	}
+
+	if _, err = New("cheque", "secret"); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
+
+func TestFakeGateway_Authorize(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakeGateway("secret")
+
+	p, err := g.Authorize(ctx, AuthorizeRequest{Amount: 5000, Currency: "usd", Source: FakeCardOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Ref == "" || p.Status != models.PaymentAuthorized || p.Amount != 5000 {
+		t.Errorf("unexpected payment %+v", p)
+	}
+
+	if _, err = g.Authorize(ctx, AuthorizeRequest{Amount: 5000, Source: FakeCardDeclined}); !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected ErrDeclined for the declined card, got %v", err)
+	}
+	if _, err = g.Authorize(ctx, AuthorizeRequest{Amount: 5000}); !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected ErrDeclined without a card, got %v", err)
+	}
+	if _, err = g.Authorize(ctx, AuthorizeRequest{Amount: 0, Source: FakeCardOK}); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected ErrInvalidAmount, got %v", err)
+	}
+}
+
+func TestFakeGateway_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakeGateway("secret")
+
+	authorize := func() string {
+		p, err := g.Authorize(ctx, AuthorizeRequest{Amount: 5000, Source: FakeCardOK})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Ref
+	}
+	captured := authorize()
+	voided := authorize()
+
+	var steps = []struct {
+		name             string
+		op               func() (Payment, error)
+		expectedErr      error
+		expectedStatus   string
+		expectedCaptured int
+		expectedRefunded int
+	}{
+		{"refund before capture", func() (Payment, error) { return g.Refund(ctx, captured, 1000) }, ErrInvalidState, "", 0, 0},
+		{"capture too much", func() (Payment, error) { return g.Capture(ctx, captured, 5001) }, ErrInvalidAmount, "", 0, 0},
+		{"capture part", func() (Payment, error) { return g.Capture(ctx, captured, 4000) }, nil, models.PaymentCaptured, 4000, 0},
+		{"capture twice", func() (Payment, error) { return g.Capture(ctx, captured, 1000) }, ErrInvalidState, "", 0, 0},
+		{"void after capture", func() (Payment, error) { return g.Void(ctx, captured) }, ErrInvalidState, "", 0, 0},
+		{"partial refund", func() (Payment, error) { return g.Refund(ctx, captured, 1500) }, nil, models.PaymentCaptured, 4000, 1500},
+		{"refund more than is left", func() (Payment, error) { return g.Refund(ctx, captured, 3000) }, ErrInvalidAmount, "", 0, 0},
+		{"refund the rest", func() (Payment, error) { return g.Refund(ctx, captured, 2500) }, nil, models.PaymentRefunded, 4000, 4000},
+		{"void", func() (Payment, error) { return g.Void(ctx, voided) }, nil, models.PaymentVoided, 0, 0},
+		{"capture after void", func() (Payment, error) { return g.Capture(ctx, voided, 5000) }, ErrInvalidState, "", 0, 0},
+		{"unknown payment", func() (Payment, error) { return g.Void(ctx, "fake_pi_99") }, ErrNotFound, "", 0, 0},
+	}
+
+	for _, step := range steps {
+		p, err := step.op()
+		if !errors.Is(err, step.expectedErr) {
+			t.Errorf("for %s, expected error %v but got %v", step.name, step.expectedErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if p.Status != step.expectedStatus || p.Captured != step.expectedCaptured || p.Refunded != step.expectedRefunded {
+			t.Errorf("for %s, unexpected payment %+v", step.name, p)
+		}
+	}
+}
+
+func TestFakeGateway_VerifyWebhook(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := NewFakeGateway("secret")
+	g.now = func() time.Time { return now }
+
+	p, err := g.Authorize(ctx, AuthorizeRequest{Amount: 5000, Source: FakeCardOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Capture(ctx, p.Ref, 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, sig, err := g.Webhook(p.Ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := func(sig string) http.Header {
+		h := http.Header{}
+		h.Set(FakeSignatureHeader, sig)
+		return h
+	}
+
+	e, err := g.VerifyWebhook(payload, header(sig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventCaptured || e.Payment.Ref != p.Ref || e.Payment.Captured != 5000 {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	var badTests = []struct {
+		name    string
+		payload []byte
+		sig     string
+	}{
+		{"tampered payload", append([]byte(" "), payload...), sig},
+		{"other secret", payload, NewFakeGateway("other").Sign(payload, now)},
+		{"too old", payload, g.Sign(payload, now.Add(-time.Hour))},
+		{"from the future", payload, g.Sign(payload, now.Add(time.Hour))},
+		{"no signature", payload, ""},
+		{"garbage", payload, "t=abc,v1=xyz"},
+	}
+
+	for _, test := range badTests {
+		if _, err := g.VerifyWebhook(test.payload, header(test.sig)); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("for %s, expected ErrInvalidSignature, got %v", test.name, err)
+		}
+	}
+
+	if _, _, err = g.Webhook("fake_pi_99"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown payment, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Package payments takes deposits through a payment provider. Providers are used through the
+// Gateway interface, so the handlers do not depend on any one of them.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors returned by gateways
+var (
+	ErrDeclined         = errors.New("payments: card declined")
+	ErrNotFound         = errors.New("payments: no such payment")
+	ErrInvalidAmount    = errors.New("payments: invalid amount")
+	ErrInvalidState     = errors.New("payments: operation not allowed in the payment's current state")
+	ErrInvalidSignature = errors.New("payments: invalid webhook signature")
+)
+
+// Event types sent to the webhook; each is "payment." followed by the payment's new status
+const (
+	EventAuthorized = "payment.authorized"
+	EventCaptured   = "payment.captured"
+	EventRefunded   = "payment.refunded"
+	EventVoided     = "payment.voided"
+	EventFailed     = "payment.failed"
+)
+
+// AuthorizeRequest asks for a hold of Amount cents of Currency on the card behind Source, which
+// is the token the provider's card form produced in the guest's browser
+type AuthorizeRequest struct {
+	Amount      int
+	Currency    string
+	Source      string
+	Description string
+}
+
+// Payment is a gateway's view of a payment; Status is one of the models.Payment* statuses and
+// the amounts are in cents
+type Payment struct {
+	Ref      string `json:"ref"`
+	Status   string `json:"status"`
+	Amount   int    `json:"amount"`
+	Captured int    `json:"captured"`
+	Refunded int    `json:"refunded"`
+}
+
+// Event is a verified webhook notification about a change to Payment made at the provider
+type Event struct {
+	ID      string  `json:"id"`
+	Type    string  `json:"type"`
+	Payment Payment `json:"payment"`
+}
+
+// Gateway is a payment provider. A deposit is authorised when booking, then either captured,
+// possibly in part, or voided; captured money can be refunded.
+type Gateway interface {
+	// Name identifies the provider in stored payment intents
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (Payment, error)
+	Capture(ctx context.Context, ref string, amount int) (Payment, error)
+	Refund(ctx context.Context, ref string, amount int) (Payment, error)
+	Void(ctx context.Context, ref string) (Payment, error)
+	// VerifyWebhook checks a webhook request was sent by the provider and decodes its event
+	VerifyWebhook(payload []byte, header http.Header) (Event, error)
+}
+
+// New returns the gateway for provider, signing or verifying webhooks with webhookSecret
+func New(provider, webhookSecret string) (Gateway, error) {
+	switch provider {
+	case FakeProvider:
+		return NewFakeGateway(webhookSecret), nil
+	default:
+		return nil, fmt.Errorf("payments: unknown provider %q", provider)
+	}
+}
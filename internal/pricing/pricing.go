@@ -0,0 +1,256 @@
+// Package pricing works out what a stay costs from a room's nightly rate, its rate overrides,
+// length-of-stay discounts and tax
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+)
+
+// StandardRate names the nights charged at a room's own nightly rate
+const StandardRate = "Standard rate"
+
+// ErrInvalidStay is returned when a stay does not end after it starts
+var ErrInvalidStay = errors.New("pricing: departure must be after arrival")
+
+// Errors returned by CheckPromo
+var (
+	ErrPromoNotStarted = errors.New("pricing: promo code is not valid yet")
+	ErrPromoExpired    = errors.New("pricing: promo code has expired")
+	ErrPromoUsedUp     = errors.New("pricing: promo code has been used up")
+	ErrPromoRoom       = errors.New("pricing: promo code does not apply to this room")
+	ErrPromoMinNights  = errors.New("pricing: stay is too short for promo code")
+)
+
+// Rates are the prices a quote is worked out from, besides the room's own nightly rate
+type Rates struct {
+	Overrides []models.RateOverride
+	Discounts []models.StayDiscount
+	// TaxRate is in basis points, so 1300 is 13%
+	TaxRate int
+	// Promo, when set, is taken off after any length-of-stay discount. Quote does not check
+	// that the code may be used for the stay; see CheckPromo.
+	Promo *models.PromoCode
+	// DepositRate is the part of the total taken when booking, in basis points
+	DepositRate int
+}
+
+// Quote prices each night of a stay in room from start up to but not including end, takes off
+// the best length-of-stay discount, adds tax and works out the deposit due when booking.
+// Overrides for other rooms are ignored.
+//
+// When several overrides cover a night, one limited to certain weekdays beats one that applies
+// every night, then the one starting latest wins, so a holiday week inside a season takes
+// precedence over the season. Remaining ties go to the newest override.
+func Quote(room models.Room, start, end time.Time, rates Rates) (models.Quote, error) {
+	start, end = dateOnly(start), dateOnly(end)
+	if !end.After(start) {
+		return models.Quote{}, ErrInvalidStay
+	}
+
+	var q models.Quote
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := models.NightPrice{Date: d, Rate: room.NightlyRate, Name: StandardRate}
+		if o, ok := overrideFor(rates.Overrides, room.ID, d); ok {
+			night.Rate, night.Name = o.NightlyRate, o.Name
+		}
+		q.Nights = append(q.Nights, night)
+		q.Subtotal += night.Rate
+	}
+
+	if d, ok := discountFor(rates.Discounts, len(q.Nights)); ok {
+		q.DiscountName = d.Name
+		q.DiscountPercent = d.Percent
+		q.Discount = percentOf(q.Subtotal, d.Percent*100)
+	}
+
+	if p := rates.Promo; p != nil {
+		q.PromoCode = p.Code
+		q.PromoDiscount = promoDiscount(*p, q.Subtotal-q.Discount)
+	}
+
+	taxable := q.Subtotal - q.Discount - q.PromoDiscount
+	q.TaxRate = rates.TaxRate
+	q.Taxes = percentOf(taxable, rates.TaxRate)
+	q.Total = taxable + q.Taxes
+	q.Deposit = percentOf(q.Total, rates.DepositRate)
+
+	return q, nil
+}
+
+// overrideFor returns the override that sets the rate of roomID on night d, if any
+func overrideFor(overrides []models.RateOverride, roomID int, d time.Time) (models.RateOverride, bool) {
+	var best models.RateOverride
+	var found bool
+
+	for _, o := range overrides {
+		if o.RoomID != roomID || d.Before(dateOnly(o.StartDate)) || !d.Before(dateOnly(o.EndDate)) {
+			continue
+		}
+		if len(o.Weekdays) > 0 && !containsWeekday(o.Weekdays, d.Weekday()) {
+			continue
+		}
+		if !found || beats(o, best) {
+			best, found = o, true
+		}
+	}
+
+	return best, found
+}
+
+// beats reports whether override a takes precedence over b
+func beats(a, b models.RateOverride) bool {
+	if (len(a.Weekdays) > 0) != (len(b.Weekdays) > 0) {
+		return len(a.Weekdays) > 0
+	}
+	if !a.StartDate.Equal(b.StartDate) {
+		return a.StartDate.After(b.StartDate)
+	}
+	return a.ID > b.ID
+}
+
+// discountFor returns the discount for the longest minimum stay that nights reaches, if any
+func discountFor(discounts []models.StayDiscount, nights int) (models.StayDiscount, bool) {
+	var best models.StayDiscount
+	var found bool
+
+	for _, d := range discounts {
+		if d.MinNights > nights {
+			continue
+		}
+		if !found || d.MinNights > best.MinNights || (d.MinNights == best.MinNights && d.Percent > best.Percent) {
+			best, found = d, true
+		}
+	}
+
+	return best, found
+}
+
+// promoDiscount returns what p takes off amount; a fixed amount never takes it below zero
+func promoDiscount(p models.PromoCode, amount int) int {
+	if p.Kind == models.PromoPercent {
+		return percentOf(amount, p.Amount*100)
+	}
+	if p.Amount > amount {
+		return amount
+	}
+	return p.Amount
+}
+
+// CheckPromo returns an error if p cannot be redeemed on day today for a stay of nights in roomID.
+// The usage count is only checked against p as loaded; the repository enforces the limit when
+// the reservation is saved.
+func CheckPromo(p models.PromoCode, roomID, nights int, today time.Time) error {
+	today = dateOnly(today)
+
+	switch {
+	case today.Before(dateOnly(p.ValidFrom)):
+		return ErrPromoNotStarted
+	case today.After(dateOnly(p.ValidUntil)):
+		return ErrPromoExpired
+	case p.MaxUses > 0 && p.Uses >= p.MaxUses:
+		return ErrPromoUsedUp
+	}
+
+	return CheckPromoStay(p, roomID, nights)
+}
+
+// CheckPromoStay returns an error if a stay of nights in roomID does not qualify for p. Unlike
+// CheckPromo it leaves out the dates and usage limit of p, which only matter when it is redeemed.
+func CheckPromoStay(p models.PromoCode, roomID, nights int) error {
+	switch {
+	case len(p.RoomIDs) > 0 && !containsInt(p.RoomIDs, roomID):
+		return ErrPromoRoom
+	case nights < p.MinNights:
+		return ErrPromoMinNights
+	}
+
+	return nil
+}
+
+// NormalizeCode returns code as it is stored, trimmed and upper case, so guests can type it in any case
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Nights returns the number of nights from start up to end
+func Nights(start, end time.Time) int {
+	return int(dateOnly(end).Sub(dateOnly(start)).Hours() / 24)
+}
+
+// percentOf returns amount times basisPoints / 10000, rounded half up
+func percentOf(amount, basisPoints int) int {
+	return (amount*basisPoints + 5000) / 10000
+}
+
+// containsWeekday reports whether days holds d
+func containsWeekday(days []time.Weekday, d time.Weekday) bool {
+	for _, x := range days {
+		if x == d {
+			return true
+		}
+	}
+	return false
+}
+
+// containsInt reports whether ids holds id
+func containsInt(ids []int, id int) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// dateOnly drops the time of day, as the database does for date columns
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// FormatAmount formats cents as a decimal amount with thousands separators, such as "1,234.50"
+func FormatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+
+	whole := strconv.Itoa(cents / 100)
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+
+	return fmt.Sprintf("%s%s.%02d", sign, whole, cents%100)
+}
+
+// ParseAmount parses a decimal amount such as "120" or "99.50" into cents
+func ParseAmount(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || (hasFrac && (len(frac) == 0 || len(frac) > 2)) {
+		return 0, fmt.Errorf("pricing: invalid amount %q", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	w, err := strconv.ParseUint(whole, 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("pricing: invalid amount %q", s)
+	}
+	f, err := strconv.ParseUint(frac, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("pricing: invalid amount %q", s)
+	}
+
+	cents := int(w)*100 + int(f)
+	if cents > 1<<31-1 {
+		return 0, fmt.Errorf("pricing: amount %q is too large", s)
+	}
+	return cents, nil
+}
@@ -0,0 +1,301 @@
+package pricing
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+)
+
+// date returns midnight UTC on the given day of June 2050, which starts on a Wednesday
+func date(day int) time.Time {
+	return time.Date(2050, 6, day, 0, 0, 0, 0, time.UTC)
+}
+
+var room = models.Room{ID: 1, RoomName: "General's Quarters", NightlyRate: 10000}
+
+func TestQuote(t *testing.T) {
+	summer := models.RateOverride{ID: 1, RoomID: 1, Name: "Summer", StartDate: date(1), EndDate: date(30), NightlyRate: 15000}
+	weekend := models.RateOverride{ID: 2, RoomID: 1, Name: "Weekend", StartDate: date(1), EndDate: date(30), Weekdays: []time.Weekday{time.Friday, time.Saturday}, NightlyRate: 20000}
+	festival := models.RateOverride{ID: 3, RoomID: 1, Name: "Festival", StartDate: date(10), EndDate: date(12), NightlyRate: 25000}
+	otherRoom := models.RateOverride{ID: 4, RoomID: 2, Name: "Other room", StartDate: date(1), EndDate: date(30), NightlyRate: 99900}
+	weekly := models.StayDiscount{ID: 1, Name: "Weekly", MinNights: 7, Percent: 10}
+	threeNights := models.StayDiscount{ID: 2, Name: "Three nights", MinNights: 3, Percent: 5}
+
+	var quoteTests = []struct {
+		name           string
+		start, end     time.Time
+		rates          Rates
+		expectedNights []string
+		expectedRates  []int
+		expectedQuote  models.Quote
+	}{
+		{
+			name:           "base rate",
+			start:          date(1),
+			end:            date(3),
+			expectedNights: []string{StandardRate, StandardRate},
+			expectedRates:  []int{10000, 10000},
+			expectedQuote:  models.Quote{Subtotal: 20000, Total: 20000},
+		},
+		{
+			name:           "season",
+			start:          date(29),
+			end:            time.Date(2050, 7, 2, 0, 0, 0, 0, time.UTC),
+			rates:          Rates{Overrides: []models.RateOverride{summer}},
+			expectedNights: []string{"Summer", StandardRate, StandardRate},
+			expectedRates:  []int{15000, 10000, 10000},
+			expectedQuote:  models.Quote{Subtotal: 35000, Total: 35000},
+		},
+		{
+			name:           "weekend beats season",
+			start:          date(2),
+			end:            date(5),
+			rates:          Rates{Overrides: []models.RateOverride{weekend, summer, otherRoom}},
+			expectedNights: []string{"Summer", "Weekend", "Weekend"},
+			expectedRates:  []int{15000, 20000, 20000},
+			expectedQuote:  models.Quote{Subtotal: 55000, Total: 55000},
+		},
+		{
+			name:           "later start beats season",
+			start:          date(9),
+			end:            date(12),
+			rates:          Rates{Overrides: []models.RateOverride{festival, summer}},
+			expectedNights: []string{"Summer", "Festival", "Festival"},
+			expectedRates:  []int{15000, 25000, 25000},
+			expectedQuote:  models.Quote{Subtotal: 65000, Total: 65000},
+		},
+		{
+			name:           "longest qualifying discount",
+			start:          date(1),
+			end:            date(8),
+			rates:          Rates{Discounts: []models.StayDiscount{threeNights, weekly}},
+			expectedNights: []string{StandardRate, StandardRate, StandardRate, StandardRate, StandardRate, StandardRate, StandardRate},
+			expectedRates:  []int{10000, 10000, 10000, 10000, 10000, 10000, 10000},
+			expectedQuote:  models.Quote{Subtotal: 70000, DiscountName: "Weekly", DiscountPercent: 10, Discount: 7000, Total: 63000},
+		},
+		{
+			name:           "discount and tax",
+			start:          date(1),
+			end:            date(4),
+			rates:          Rates{Discounts: []models.StayDiscount{threeNights, weekly}, TaxRate: 1300},
+			expectedNights: []string{StandardRate, StandardRate, StandardRate},
+			expectedRates:  []int{10000, 10000, 10000},
+			expectedQuote:  models.Quote{Subtotal: 30000, DiscountName: "Three nights", DiscountPercent: 5, Discount: 1500, TaxRate: 1300, Taxes: 3705, Total: 32205},
+		},
+		{
+			name:           "percent promo after stay discount",
+			start:          date(1),
+			end:            date(4),
+			rates:          Rates{Discounts: []models.StayDiscount{threeNights}, TaxRate: 1000, Promo: &models.PromoCode{Code: "SUMMER10", Kind: models.PromoPercent, Amount: 10}},
+			expectedNights: []string{StandardRate, StandardRate, StandardRate},
+			expectedRates:  []int{10000, 10000, 10000},
+			expectedQuote:  models.Quote{Subtotal: 30000, DiscountName: "Three nights", DiscountPercent: 5, Discount: 1500, PromoCode: "SUMMER10", PromoDiscount: 2850, TaxRate: 1000, Taxes: 2565, Total: 28215},
+		},
+		{
+			name:           "fixed promo",
+			start:          date(1),
+			end:            date(3),
+			rates:          Rates{Promo: &models.PromoCode{Code: "TAKE25", Kind: models.PromoFixed, Amount: 2500}},
+			expectedNights: []string{StandardRate, StandardRate},
+			expectedRates:  []int{10000, 10000},
+			expectedQuote:  models.Quote{Subtotal: 20000, PromoCode: "TAKE25", PromoDiscount: 2500, Total: 17500},
+		},
+		{
+			name:           "fixed promo larger than the stay",
+			start:          date(1),
+			end:            date(2),
+			rates:          Rates{TaxRate: 1300, Promo: &models.PromoCode{Code: "FREE", Kind: models.PromoFixed, Amount: 50000}},
+			expectedNights: []string{StandardRate},
+			expectedRates:  []int{10000},
+			expectedQuote:  models.Quote{Subtotal: 10000, PromoCode: "FREE", PromoDiscount: 10000, TaxRate: 1300, Total: 0},
+		},
+		{
+			name:           "tax rounds half up",
+			start:          date(1),
+			end:            date(2),
+			rates:          Rates{TaxRate: 1250, Overrides: []models.RateOverride{{RoomID: 1, Name: "Odd", StartDate: date(1), EndDate: date(2), NightlyRate: 9999}}},
+			expectedNights: []string{"Odd"},
+			expectedRates:  []int{9999},
+			expectedQuote:  models.Quote{Subtotal: 9999, TaxRate: 1250, Taxes: 1250, Total: 11249},
+		},
+		{
+			name:           "deposit of the total",
+			start:          date(1),
+			end:            date(3),
+			rates:          Rates{TaxRate: 1300, DepositRate: 2500},
+			expectedNights: []string{StandardRate, StandardRate},
+			expectedRates:  []int{10000, 10000},
+			expectedQuote:  models.Quote{Subtotal: 20000, TaxRate: 1300, Taxes: 2600, Total: 22600, Deposit: 5650},
+		},
+	}
+
+	for _, test := range quoteTests {
+		q, err := Quote(room, test.start, test.end, test.rates)
+		if err != nil {
+			t.Errorf("for %s, unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if len(q.Nights) != len(test.expectedNights) {
+			t.Errorf("for %s, expected %d nights but got %d", test.name, len(test.expectedNights), len(q.Nights))
+			continue
+		}
+		for i, n := range q.Nights {
+			if n.Name != test.expectedNights[i] || n.Rate != test.expectedRates[i] {
+				t.Errorf("for %s, night %d: expected %s at %d but got %s at %d", test.name, i, test.expectedNights[i], test.expectedRates[i], n.Name, n.Rate)
+			}
+			if !n.Date.Equal(test.start.AddDate(0, 0, i)) {
+				t.Errorf("for %s, night %d: unexpected date %s", test.name, i, n.Date)
+			}
+		}
+
+		q.Nights = nil
+		if !reflect.DeepEqual(q, test.expectedQuote) {
+			t.Errorf("for %s, expected %+v but got %+v", test.name, test.expectedQuote, q)
+		}
+	}
+}
+
+func TestQuote_InvalidStay(t *testing.T) {
+	for _, end := range []time.Time{date(1), date(1).Add(20 * time.Hour), time.Date(2050, 5, 31, 0, 0, 0, 0, time.UTC)} {
+		if _, err := Quote(room, date(1), end, Rates{}); !errors.Is(err, ErrInvalidStay) {
+			t.Errorf("for departure %s, expected ErrInvalidStay, got %v", end, err)
+		}
+	}
+}
+
+func TestCheckPromo(t *testing.T) {
+	summer := models.PromoCode{
+		Code:       "SUMMER10",
+		Kind:       models.PromoPercent,
+		Amount:     10,
+		ValidFrom:  date(1),
+		ValidUntil: date(30),
+		MaxUses:    100,
+		Uses:       99,
+		RoomIDs:    []int{1, 2},
+		MinNights:  2,
+	}
+
+	var promoTests = []struct {
+		name     string
+		promo    func(p *models.PromoCode)
+		roomID   int
+		nights   int
+		today    time.Time
+		expected error
+	}{
+		{"valid", nil, 1, 2, date(1), nil},
+		{"last day, late in the day", nil, 2, 2, date(30).Add(23 * time.Hour), nil},
+		{"before the window", nil, 1, 2, time.Date(2050, 5, 31, 0, 0, 0, 0, time.UTC), ErrPromoNotStarted},
+		{"after the window", nil, 1, 2, time.Date(2050, 7, 1, 0, 0, 0, 0, time.UTC), ErrPromoExpired},
+		{"used up", func(p *models.PromoCode) { p.Uses = 100 }, 1, 2, date(10), ErrPromoUsedUp},
+		{"no usage limit", func(p *models.PromoCode) { p.MaxUses, p.Uses = 0, 5000 }, 1, 2, date(10), nil},
+		{"other room", nil, 3, 2, date(10), ErrPromoRoom},
+		{"every room", func(p *models.PromoCode) { p.RoomIDs = nil }, 3, 2, date(10), nil},
+		{"too short", nil, 1, 1, date(10), ErrPromoMinNights},
+	}
+
+	for _, test := range promoTests {
+		p := summer
+		if test.promo != nil {
+			test.promo(&p)
+		}
+		if err := CheckPromo(p, test.roomID, test.nights, test.today); err != test.expected {
+			t.Errorf("for %s, expected %v but got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestCheckPromoStay(t *testing.T) {
+	summer := models.PromoCode{
+		Code:      "SUMMER10",
+		MaxUses:   1,
+		Uses:      1,
+		RoomIDs:   []int{1, 2},
+		MinNights: 2,
+	}
+
+	var stayTests = []struct {
+		name     string
+		roomID   int
+		nights   int
+		expected error
+	}{
+		{"used up by this booking", 1, 2, nil},
+		{"other room", 3, 2, ErrPromoRoom},
+		{"too short", 2, 1, ErrPromoMinNights},
+	}
+
+	for _, test := range stayTests {
+		if err := CheckPromoStay(summer, test.roomID, test.nights); err != test.expected {
+			t.Errorf("for %s, expected %v but got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	if code := NormalizeCode("  summer10 "); code != "SUMMER10" {
+		t.Errorf("expected SUMMER10, got %q", code)
+	}
+}
+
+func TestNights(t *testing.T) {
+	if n := Nights(date(1), date(8)); n != 7 {
+		t.Errorf("expected 7 nights, got %d", n)
+	}
+	if n := Nights(date(1).Add(15*time.Hour), date(2)); n != 1 {
+		t.Errorf("expected times of day to be ignored, got %d nights", n)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	var formatTests = map[int]string{
+		0:         "0.00",
+		5:         "0.05",
+		12000:     "120.00",
+		123456:    "1,234.56",
+		100000000: "1,000,000.00",
+		-2550:     "-25.50",
+	}
+
+	for cents, expected := range formatTests {
+		if got := FormatAmount(cents); got != expected {
+			t.Errorf("FormatAmount(%d): expected %s but got %s", cents, expected, got)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	var parseTests = []struct {
+		input    string
+		expected int
+		valid    bool
+	}{
+		{"120", 12000, true},
+		{" 99.5 ", 9950, true},
+		{"0.05", 5, true},
+		{"", 0, false},
+		{"12.", 0, false},
+		{".5", 0, false},
+		{"1.234", 0, false},
+		{"-5", 0, false},
+		{"1,200", 0, false},
+		{"abc", 0, false},
+		{"99999999999", 0, false},
+	}
+
+	for _, test := range parseTests {
+		got, err := ParseAmount(test.input)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseAmount(%q): expected valid to be %t, got error %v", test.input, test.valid, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("ParseAmount(%q): expected %d but got %d", test.input, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/mail"
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+)
+
+// Email renders the email template tmpl with data. Each template defines a "subject", a
+// "text-content" and an "html-content", which the email layout wraps into the "text" and "html"
+// parts. The CSS of the HTML part is inlined, as many email clients ignore style sheets. The
+// returned message has no sender or recipient yet.
+func Email(tmpl string, data *models.TemplateData) (mail.MailData, error) {
+	cache, err := emailCache()
+	if err != nil {
+		return mail.MailData{}, err
+	}
+
+	t, ok := cache[tmpl]
+	if !ok {
+		return mail.MailData{}, fmt.Errorf("Email: no email template %s", tmpl)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err = t.Text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return mail.MailData{}, fmt.Errorf("Email: failed executing subject of %s: %w", tmpl, err)
+	}
+	if err = t.Text.ExecuteTemplate(&text, "text", data); err != nil {
+		return mail.MailData{}, fmt.Errorf("Email: failed executing text part of %s: %w", tmpl, err)
+	}
+	if err = t.HTML.ExecuteTemplate(&html, "html", data); err != nil {
+		return mail.MailData{}, fmt.Errorf("Email: failed executing html part of %s: %w", tmpl, err)
+	}
+
+	inlined, err := InlineCSS(html.String())
+	if err != nil {
+		return mail.MailData{}, fmt.Errorf("Email: %s: %w", tmpl, err)
+	}
+
+	return mail.MailData{
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Content: strings.TrimSpace(text.String()) + "\n",
+		HTML:    inlined,
+	}, nil
+}
+
+// EmailTemplates returns the names of the email templates, sorted
+func EmailTemplates() ([]string, error) {
+	cache, err := emailCache()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(cache))
+	for name := range cache {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// emailCache returns the app's email template cache, or a fresh one when caching is off
+func emailCache() (map[string]*config.EmailTemplate, error) {
+	if app.UseCache {
+		return app.EmailCache, nil
+	}
+
+	cache, err := CreateEmailTemplateCache()
+	if err != nil {
+		return nil, fmt.Errorf("failed creating email template cache: %w", err)
+	}
+	return cache, nil
+}
+
+// CreateEmailTemplateCache parses the *.email.tmpl files in the email subdirectory of the
+// templates, each with the layouts found there
+func CreateEmailTemplateCache() (map[string]*config.EmailTemplate, error) {
+	log.Println("creating email template cache")
+	emailCache := map[string]*config.EmailTemplate{}
+
+	dir := filepath.Join(pathToTemplates, "email")
+	emails, err := filepath.Glob(filepath.Join(dir, "*.email.tmpl"))
+	if err != nil {
+		return emailCache, err
+	}
+	layouts := filepath.Join(dir, "*.layout.tmpl")
+
+	for _, email := range emails {
+		name := filepath.Base(email)
+
+		htmlTemplate, err := template.New(name).Funcs(functions).ParseFiles(email)
+		if err != nil {
+			return emailCache, err
+		}
+		htmlTemplate, err = htmlTemplate.ParseGlob(layouts)
+		if err != nil {
+			return emailCache, err
+		}
+
+		// the plain text part is parsed again with text/template, which does not escape HTML
+		textTemplate, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(functions)).ParseFiles(email)
+		if err != nil {
+			return emailCache, err
+		}
+		textTemplate, err = textTemplate.ParseGlob(layouts)
+		if err != nil {
+			return emailCache, err
+		}
+
+		emailCache[name] = &config.EmailTemplate{HTML: htmlTemplate, Text: textTemplate}
+	}
+
+	return emailCache, nil
+}
@@ -0,0 +1,151 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	styleElement = regexp.MustCompile(`(?is)<style[^>]*>(.*?)</style>\s*`)
+	cssComment   = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssSelector  = regexp.MustCompile(`^([a-z][a-z0-9]*)?((?:\.[A-Za-z0-9_-]+)*)$`)
+	startTag     = regexp.MustCompile(`<([A-Za-z][A-Za-z0-9]*)((?:\s[^<>]*?)?)(/?)>`)
+	classAttr    = regexp.MustCompile(`\sclass="([^"]*)"`)
+	styleAttr    = regexp.MustCompile(`\sstyle="([^"]*)"`)
+)
+
+// cssRule is a rule of an inlined style sheet with one simple selector
+type cssRule struct {
+	tag          string
+	classes      []string
+	declarations []string
+}
+
+// specificity ranks rules as CSS does for these selectors: classes outweigh a tag name
+func (r cssRule) specificity() int {
+	s := 10 * len(r.classes)
+	if r.tag != "" {
+		s++
+	}
+	return s
+}
+
+// matches reports whether the rule applies to an element named tag with classes
+func (r cssRule) matches(tag string, classes []string) bool {
+	if r.tag != "" && r.tag != tag {
+		return false
+	}
+	for _, c := range r.classes {
+		found := false
+		for _, have := range classes {
+			if have == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// InlineCSS moves the rules of the <style> elements of page into the style attribute of every
+// element they match, ahead of any style the element already has. Only selectors made of a tag
+// name and classes, such as "td", ".muted" or "a.button", are supported. page must be markup
+// rendered by html/template, where every "<" outside of tags has been escaped.
+func InlineCSS(page string) (string, error) {
+	var rules []cssRule
+	for _, m := range styleElement.FindAllStringSubmatch(page, -1) {
+		parsed, err := parseCSS(m[1])
+		if err != nil {
+			return "", err
+		}
+		rules = append(rules, parsed...)
+	}
+	if len(rules) == 0 {
+		return page, nil
+	}
+	page = styleElement.ReplaceAllString(page, "")
+
+	// later rules win among equally specific ones, so sort by specificity then source order
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].specificity() < rules[j].specificity()
+	})
+
+	return startTag.ReplaceAllStringFunc(page, func(tag string) string {
+		m := startTag.FindStringSubmatch(tag)
+		name, attrs, selfClosing := strings.ToLower(m[1]), m[2], m[3]
+
+		var classes []string
+		if c := classAttr.FindStringSubmatch(attrs); c != nil {
+			classes = strings.Fields(c[1])
+		}
+
+		var declarations []string
+		for _, r := range rules {
+			if r.matches(name, classes) {
+				declarations = append(declarations, r.declarations...)
+			}
+		}
+		if len(declarations) == 0 {
+			return tag
+		}
+		if s := styleAttr.FindStringSubmatch(attrs); s != nil {
+			declarations = append(declarations, splitDeclarations(html.UnescapeString(s[1]))...)
+			attrs = styleAttr.ReplaceAllString(attrs, "")
+		}
+
+		style := html.EscapeString(strings.Join(declarations, "; "))
+		return fmt.Sprintf(`<%s%s style="%s"%s>`, m[1], attrs, style, selfClosing)
+	}), nil
+}
+
+// parseCSS splits a style sheet into one rule per selector
+func parseCSS(css string) ([]cssRule, error) {
+	var rules []cssRule
+
+	css = cssComment.ReplaceAllString(css, "")
+	blocks := strings.Split(css, "}")
+	if strings.TrimSpace(blocks[len(blocks)-1]) != "" {
+		return nil, fmt.Errorf("InlineCSS: unterminated rule %q", strings.TrimSpace(blocks[len(blocks)-1]))
+	}
+
+	for _, block := range blocks[:len(blocks)-1] {
+		selectors, body, ok := strings.Cut(block, "{")
+		if !ok {
+			return nil, fmt.Errorf("InlineCSS: malformed rule %q", strings.TrimSpace(block))
+		}
+		declarations := splitDeclarations(body)
+
+		for _, selector := range strings.Split(selectors, ",") {
+			selector = strings.TrimSpace(selector)
+			m := cssSelector.FindStringSubmatch(selector)
+			if selector == "" || m == nil {
+				return nil, fmt.Errorf("InlineCSS: unsupported selector %q", selector)
+			}
+
+			var classes []string
+			if m[2] != "" {
+				classes = strings.Split(m[2][1:], ".")
+			}
+			rules = append(rules, cssRule{tag: m[1], classes: classes, declarations: declarations})
+		}
+	}
+
+	return rules, nil
+}
+
+// splitDeclarations splits the body of a rule or style attribute into its declarations
+func splitDeclarations(body string) []string {
+	var declarations []string
+	for _, d := range strings.Split(body, ";") {
+		if d = strings.Join(strings.Fields(d), " "); d != "" {
+			declarations = append(declarations, d)
+		}
+	}
+	return declarations
+}
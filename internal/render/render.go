@@ -7,24 +7,84 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/images"
 	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/pricing"
 	"github.com/justinas/nosurf"
 )
 
 var app *config.AppConfig
 var pathToTemplates = "./templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":  HumanDate,
+	"iterate":    Iterate,
+	"add":        Add,
+	"formatDate": FormatDate,
+	"thumbnail":  images.ThumbnailURL,
+	"money":      Money,
+	"percent":    Percent,
+	"status":     StatusLabel,
+}
 
 // NewRenderer sets the config for the template package
 func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+// HumanDate returns time in YYYY-MM-DD format
+func HumanDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// FormatDate returns time in the given layout
+func FormatDate(t time.Time, layout string) string {
+	return t.Format(layout)
+}
+
+// Iterate returns a slice of ints, starting at 0, of the given length
+func Iterate(count int) []int {
+	var items []int
+	for i := 0; i < count; i++ {
+		items = append(items, i)
+	}
+	return items
+}
+
+// Add returns the sum of a and b
+func Add(a, b int) int {
+	return a + b
+}
+
+// Money formats an amount in cents as dollars, such as "$1,234.50"
+func Money(cents int) string {
+	return "$" + pricing.FormatAmount(cents)
+}
+
+// Percent formats a rate in basis points as a percentage, such as "13%" or "12.5%"
+func Percent(basisPoints int) string {
+	return strconv.FormatFloat(float64(basisPoints)/100, 'f', -1, 64) + "%"
+}
+
+// StatusLabel turns a reservation status such as "checked_in" into a label such as "Checked in"
+func StatusLabel(status string) string {
+	if status == "" {
+		return ""
+	}
+	s := strings.ReplaceAll(status, "_", " ")
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
 // addDefaultData adds data that should be present on every page
 func addDefaultData(data *models.TemplateData, r *http.Request) *models.TemplateData {
 	data.CSRFToken = nosurf.Token(r)
+	if app.Session.Exists(r.Context(), "user_id") {
+		data.IsAuthenticated = 1
+	}
 	data.Flash = app.Session.PopString(r.Context(), "flash")
 	data.Warning = app.Session.PopString(r.Context(), "warning")
 	data.Error = app.Session.PopString(r.Context(), "error")
@@ -87,7 +147,7 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 	// iterate through each page, also parsing all layouts with each page
 	for _, page := range pages {
 		name := filepath.Base(page)
-		parsedTemplate, err := template.New(name).ParseFiles(page)
+		parsedTemplate, err := template.New(name).Funcs(functions).ParseFiles(page)
 		if err != nil {
 			return templateCache, err
 		}
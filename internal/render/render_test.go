@@ -2,7 +2,9 @@ package render
 
 import (
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jeremydelacruz/go-bookings/internal/models"
 )
@@ -71,3 +73,128 @@ func getRequestWithSession() (*http.Request, error) {
 
 	return r, nil
 }
+
+func TestMoney(t *testing.T) {
+	if s := Money(123450); s != "$1,234.50" {
+		t.Errorf("expected $1,234.50, got %s", s)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	var percentTests = []struct {
+		basisPoints int
+		expected    string
+	}{
+		{1300, "13%"},
+		{1250, "12.5%"},
+		{0, "0%"},
+	}
+
+	for _, test := range percentTests {
+		if s := Percent(test.basisPoints); s != test.expected {
+			t.Errorf("for %d, expected %s, got %s", test.basisPoints, test.expected, s)
+		}
+	}
+}
+
+func TestStatusLabel(t *testing.T) {
+	if s := StatusLabel("checked_in"); s != "Checked in" {
+		t.Errorf("expected Checked in, got %s", s)
+	}
+}
+
+func TestEmail(t *testing.T) {
+	pathToTemplates = "./../../templates"
+	ec, err := CreateEmailTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.EmailCache = ec
+	app.UseCache = true
+	defer func() { app.UseCache = false }()
+
+	names, err := EmailTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("expected some email templates")
+	}
+
+	res := models.Reservation{
+		FirstName:        "Jane",
+		LastName:         "O'Hara & Sons",
+		Email:            "jane@doe.com",
+		ConfirmationCode: "ABCDEFGHJKLM",
+		Room:             models.Room{RoomName: "General's Quarters"},
+		StartDate:        time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:          time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Price: models.Quote{
+			Nights: []models.NightPrice{
+				{Date: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), Rate: 10000, Name: "Standard"},
+				{Date: time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC), Rate: 10000, Name: "Standard"},
+			},
+			Subtotal: 20000,
+			Total:    20000,
+		},
+	}
+	data := &models.TemplateData{
+		Data: map[string]interface{}{"reservation": res},
+		StringMap: map[string]string{
+			"base_url":   "https://bookings.example.com",
+			"manage_url": "https://bookings.example.com/manage/ABCDEFGHJKLM/sig",
+			"admin_url":  "https://bookings.example.com/admin/reservations/new/1",
+		},
+	}
+
+	for _, name := range names {
+		m, err := Email(name, data)
+		if err != nil {
+			t.Errorf("for %s, unexpected error %v", name, err)
+			continue
+		}
+		if !strings.Contains(m.Subject, "ABCDEFGHJKLM") || strings.Contains(m.Subject, "\n") {
+			t.Errorf("for %s, unexpected subject %q", name, m.Subject)
+		}
+		if strings.Contains(m.Content, "&amp;") || strings.Contains(m.Content, "&#39;") || !strings.Contains(m.Content, "General's Quarters") {
+			t.Errorf("for %s, expected the text part not to be HTML escaped, got %q", name, m.Content)
+		}
+		if !strings.Contains(m.HTML, "O&#39;Hara &amp; Sons") && !strings.Contains(m.HTML, "General&#39;s Quarters") {
+			t.Errorf("for %s, expected the html part to be HTML escaped, got %q", name, m.HTML)
+		}
+		if strings.Contains(m.HTML, "<style") || !strings.Contains(m.HTML, `<body style="`) {
+			t.Errorf("for %s, expected the CSS to be inlined, got %q", name, m.HTML)
+		}
+	}
+
+	if _, err = Email("non-existent.email.tmpl", data); err == nil {
+		t.Error("rendered an email template that does not exist")
+	}
+}
+
+func TestInlineCSS(t *testing.T) {
+	page := `<html><head><style>
+		/* a comment */
+		td { padding: 4px; color: black }
+		.amount, p { text-align: right; }
+		td.amount { color: red; }
+	</style></head><body><table><tr><td>a</td><td class="x amount" style="font-weight: bold">b</td></tr></table><p>c<br/></p></body></html>`
+
+	inlined, err := InlineCSS(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<html><head></head><body><table><tr><td style="padding: 4px; color: black">a</td>` +
+		`<td class="x amount" style="padding: 4px; color: black; text-align: right; color: red; font-weight: bold">b</td></tr></table>` +
+		`<p style="text-align: right">c<br/></p></body></html>`
+	if inlined != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, inlined)
+	}
+
+	for _, css := range []string{"div > p { color: red }", "#id { color: red }", "p { color: red", "p:hover { color: red }"} {
+		if _, err := InlineCSS("<style>" + css + "</style><p>a</p>"); err == nil {
+			t.Errorf("expected an error for %q", css)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package dbrepo
 
 import (
 	"database/sql"
+	"sync"
 
 	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/models"
 	"github.com/jeremydelacruz/go-bookings/internal/repository"
 )
 
@@ -12,9 +14,25 @@ type postgresDBRepo struct {
 	DB  *sql.DB
 }
 
-type testDBRepo struct {
+type memoryDBRepo struct {
 	App *config.AppConfig
-	DB  *sql.DB
+
+	mu               sync.RWMutex
+	users            map[int]models.User
+	rooms            map[int]models.Room
+	restrictions     map[int]models.Restriction
+	reservations     map[int]models.Reservation
+	roomRestrictions map[int]models.RoomRestriction
+	rateOverrides    map[int]models.RateOverride
+	stayDiscounts    map[int]models.StayDiscount
+	promoCodes       map[int]models.PromoCode
+	paymentIntents   map[int]models.PaymentIntent
+	statusChanges    map[int]models.StatusChange
+	sentMessages     map[int]models.SentMessage
+	apiKeys          map[int]models.APIKey
+	locks            map[string]*sync.Mutex
+	nextID           map[string]int
+	failures         map[string]error
 }
 
 func NewPostgresRepo(conn *sql.DB, app *config.AppConfig) repository.DatabaseRepo {
@@ -24,8 +42,32 @@ func NewPostgresRepo(conn *sql.DB, app *config.AppConfig) repository.DatabaseRep
 	}
 }
 
-func NewTestingRepo(app *config.AppConfig) repository.DatabaseRepo {
-	return &testDBRepo{
-		App: app,
+// NewMemoryRepo returns a DatabaseRepo that keeps everything in memory, seeded with the same
+// rooms and restriction types as the migrations; the result also implements FailureInjector
+func NewMemoryRepo(app *config.AppConfig) repository.DatabaseRepo {
+	m := &memoryDBRepo{
+		App:              app,
+		users:            make(map[int]models.User),
+		rooms:            make(map[int]models.Room),
+		restrictions:     make(map[int]models.Restriction),
+		reservations:     make(map[int]models.Reservation),
+		roomRestrictions: make(map[int]models.RoomRestriction),
+		rateOverrides:    make(map[int]models.RateOverride),
+		stayDiscounts:    make(map[int]models.StayDiscount),
+		promoCodes:       make(map[int]models.PromoCode),
+		paymentIntents:   make(map[int]models.PaymentIntent),
+		statusChanges:    make(map[int]models.StatusChange),
+		sentMessages:     make(map[int]models.SentMessage),
+		apiKeys:          make(map[int]models.APIKey),
+		locks:            make(map[string]*sync.Mutex),
+		nextID:           make(map[string]int),
+		failures:         make(map[string]error),
 	}
+	m.seed()
+	return m
+}
+
+// NewTestingRepo returns an in-memory DatabaseRepo for tests
+func NewTestingRepo(app *config.AppConfig) repository.DatabaseRepo {
+	return NewMemoryRepo(app)
 }
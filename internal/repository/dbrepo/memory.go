@@ -0,0 +1,1402 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/lifecycle"
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/repository"
+	"github.com/jeremydelacruz/go-bookings/internal/seed"
+	"github.com/jeremydelacruz/go-bookings/internal/tokens"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// FailureInjector is implemented by repositories that can be told to fail, so tests can exercise error paths
+type FailureInjector interface {
+	// FailOn makes every call to the named DatabaseRepo method return err until failures are cleared
+	FailOn(method string, err error)
+	// ClearFailures removes all injected failures
+	ClearFailures()
+}
+
+// FailOn makes every call to the named method return err until failures are cleared
+func (m *memoryDBRepo) FailOn(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[method] = err
+}
+
+// ClearFailures removes all injected failures
+func (m *memoryDBRepo) ClearFailures() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures = make(map[string]error)
+}
+
+// check returns the context error or the failure injected for method, if any; callers must hold mu
+func (m *memoryDBRepo) check(ctx context.Context, method string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.failures[method]
+}
+
+// seed adds the restriction types and rooms that the migrations seed into Postgres
+func (m *memoryDBRepo) seed() {
+	now := time.Now()
+
+	for _, name := range seed.Restrictions {
+		m.nextID["restrictions"]++
+		id := m.nextID["restrictions"]
+		m.restrictions[id] = models.Restriction{ID: id, RestrictionName: name, CreatedAt: now, UpdatedAt: now}
+	}
+
+	for _, rm := range seed.Rooms {
+		m.nextID["rooms"]++
+		rm.ID = m.nextID["rooms"]
+		rm.Active = true
+		rm.SortOrder = rm.ID
+		rm.CreatedAt = now
+		rm.UpdatedAt = now
+		m.rooms[rm.ID] = cloneRoom(rm)
+	}
+}
+
+// cloneRoom copies the lists of rm, so rooms handed out never share memory with the stored ones
+func cloneRoom(rm models.Room) models.Room {
+	rm.Amenities = append([]string(nil), rm.Amenities...)
+	rm.Images = append([]string(nil), rm.Images...)
+	return rm
+}
+
+// cloneReservation copies the price lines of res, for the same reason as cloneRoom
+func cloneReservation(res models.Reservation) models.Reservation {
+	res.Price.Nights = append([]models.NightPrice(nil), res.Price.Nights...)
+	return res
+}
+
+// dateOnly truncates t to a UTC date, matching how Postgres stores date columns
+func dateOnly(t time.Time) time.Time {
+	y, mo, d := t.Date()
+	return time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+}
+
+// overlaps reports whether the half-open range [start, end) overlaps r, using the same
+// comparison as the SQL queries: start < end_date and end > start_date
+func overlaps(r models.RoomRestriction, start, end time.Time) bool {
+	return dateOnly(start).Before(r.EndDate) && dateOnly(end).After(r.StartDate)
+}
+
+// isAvailable reports whether no restriction for roomID overlaps [start, end), ignoring that of
+// reservation excludeReservationID; callers must hold mu
+func (m *memoryDBRepo) isAvailable(roomID int, start, end time.Time, excludeReservationID int) bool {
+	for _, r := range m.roomRestrictions {
+		if r.RoomID == roomID && overlaps(r, start, end) && (r.ReservationID == 0 || r.ReservationID != excludeReservationID) {
+			return false
+		}
+	}
+	return true
+}
+
+// insertReservation stores res and returns its new ID; callers must hold mu
+func (m *memoryDBRepo) insertReservation(res models.Reservation) (int, error) {
+	room, ok := m.rooms[res.RoomID]
+	if !ok {
+		return 0, fmt.Errorf("reservations: room %d does not exist", res.RoomID)
+	}
+	if _, ok = m.apiKeys[res.APIKeyID]; res.APIKeyID != 0 && !ok {
+		return 0, fmt.Errorf("reservations: api key %d does not exist", res.APIKeyID)
+	}
+
+	if res.ConfirmationCode == "" {
+		code, err := tokens.NewConfirmationCode()
+		if err != nil {
+			return 0, err
+		}
+		res.ConfirmationCode = code
+	}
+	// mirror the unique index on confirmation_code
+	for _, x := range m.reservations {
+		if x.ConfirmationCode == res.ConfirmationCode {
+			return 0, fmt.Errorf("reservations: confirmation code %s already exists", res.ConfirmationCode)
+		}
+	}
+
+	if res.Status == "" {
+		res.Status = models.ReservationPending
+	}
+
+	m.nextID["reservations"]++
+	res.ID = m.nextID["reservations"]
+	res.StartDate = dateOnly(res.StartDate)
+	res.EndDate = dateOnly(res.EndDate)
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	res.Room = models.Room{ID: room.ID, RoomName: room.RoomName}
+	// payments are kept apart from their reservation, as in the payment_intents table
+	res.Payment = models.PaymentIntent{}
+	m.reservations[res.ID] = cloneReservation(res)
+
+	return res.ID, nil
+}
+
+// insertRoomRestriction stores r, enforcing the foreign keys and the overlap constraint; callers must hold mu
+func (m *memoryDBRepo) insertRoomRestriction(r models.RoomRestriction) error {
+	if _, ok := m.rooms[r.RoomID]; !ok {
+		return fmt.Errorf("room_restrictions: room %d does not exist", r.RoomID)
+	}
+	if _, ok := m.restrictions[r.RestrictionID]; !ok {
+		return fmt.Errorf("room_restrictions: restriction %d does not exist", r.RestrictionID)
+	}
+	if _, ok := m.reservations[r.ReservationID]; r.ReservationID != 0 && !ok {
+		return fmt.Errorf("room_restrictions: reservation %d does not exist", r.ReservationID)
+	}
+	if !m.isAvailable(r.RoomID, r.StartDate, r.EndDate, 0) {
+		return repository.ErrRoomUnavailable
+	}
+
+	m.nextID["room_restrictions"]++
+	r.ID = m.nextID["room_restrictions"]
+	r.StartDate = dateOnly(r.StartDate)
+	r.EndDate = dateOnly(r.EndDate)
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.roomRestrictions[r.ID] = r
+
+	return nil
+}
+
+// InsertUser inserts a new user, whose password must already be a bcrypt hash
+func (m *memoryDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertUser"); err != nil {
+		return 0, err
+	}
+
+	for _, x := range m.users {
+		if x.Email == u.Email {
+			return 0, fmt.Errorf("users: email %s already exists", u.Email)
+		}
+	}
+
+	m.nextID["users"]++
+	u.ID = m.nextID["users"]
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = time.Now()
+	m.users[u.ID] = u
+
+	return u.ID, nil
+}
+
+// GetUserByID retrieves a user given an ID
+func (m *memoryDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetUserByID"); err != nil {
+		return models.User{}, err
+	}
+
+	u, ok := m.users[id]
+	if !ok {
+		return u, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+// Authenticate checks a password against the bcrypt hash stored for email
+func (m *memoryDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "Authenticate"); err != nil {
+		return 0, "", err
+	}
+
+	for _, u := range m.users {
+		if u.Email != email {
+			continue
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(testPassword))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return 0, "", repository.ErrInvalidCredentials
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		return u.ID, u.Password, nil
+	}
+
+	return 0, "", repository.ErrInvalidCredentials
+}
+
+// InsertReservation inserts a new reservation
+func (m *memoryDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertReservation"); err != nil {
+		return 0, err
+	}
+
+	return m.insertReservation(res)
+}
+
+// InsertRoomRestriction inserts a new room restriction
+func (m *memoryDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertRoomRestriction"); err != nil {
+		return err
+	}
+
+	return m.insertRoomRestriction(r)
+}
+
+// CreateReservation inserts a reservation and its room restriction atomically, redeeming its
+// promo code and recording its deposit if it has them. Inactive rooms cannot be booked.
+func (m *memoryDBRepo) CreateReservation(ctx context.Context, res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "CreateReservation"); err != nil {
+		return 0, err
+	}
+
+	if rm, ok := m.rooms[res.RoomID]; !ok || !rm.Active {
+		return 0, sql.ErrNoRows
+	}
+	if !m.isAvailable(res.RoomID, res.StartDate, res.EndDate, 0) {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	var promo models.PromoCode
+	if res.PromoCode != "" {
+		var ok bool
+		promo, ok = m.promoCodeByCode(res.PromoCode)
+		if !ok || (promo.MaxUses > 0 && promo.Uses >= promo.MaxUses) {
+			return 0, repository.ErrPromoCodeUnavailable
+		}
+	}
+
+	id, err := m.insertReservation(res)
+	if err != nil {
+		return 0, err
+	}
+
+	// restriction_id 1 is the "Reservation" restriction type
+	err = m.insertRoomRestriction(models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: id,
+		RestrictionID: 1,
+	})
+	if err != nil {
+		// roll back the reservation so no booking is left without its restriction
+		delete(m.reservations, id)
+		return 0, err
+	}
+
+	// nothing can fail from here, so the code is only used up by a booking that was made
+	if promo.ID != 0 {
+		promo.Uses++
+		promo.UpdatedAt = time.Now()
+		m.promoCodes[promo.ID] = promo
+	}
+
+	if p := res.Payment; p.ProviderRef != "" {
+		m.nextID["payment_intents"]++
+		p.ID = m.nextID["payment_intents"]
+		p.ReservationID = id
+		p.CreatedAt = time.Now()
+		p.UpdatedAt = time.Now()
+		m.paymentIntents[p.ID] = p
+	}
+
+	return id, nil
+}
+
+// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, ignoring
+// the restriction of reservation excludeReservationID; pass 0 to exclude nothing
+func (m *memoryDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID, excludeReservationID int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "SearchAvailabilityByDatesByRoomID"); err != nil {
+		return false, err
+	}
+
+	return m.isAvailable(roomID, start, end, excludeReservationID), nil
+}
+
+// SearchAvailabilityForAllRooms returns a slice of available rooms for given date range
+func (m *memoryDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "SearchAvailabilityForAllRooms"); err != nil {
+		return nil, err
+	}
+
+	var rooms []models.Room
+	for _, rm := range m.rooms {
+		if rm.Active && m.isAvailable(rm.ID, start, end, 0) {
+			rooms = append(rooms, cloneRoom(rm))
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+
+	return rooms, nil
+}
+
+// GetRoomByID retrieves a room given an ID
+func (m *memoryDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetRoomByID"); err != nil {
+		return models.Room{}, err
+	}
+
+	rm, ok := m.rooms[id]
+	if !ok {
+		return rm, sql.ErrNoRows
+	}
+	return cloneRoom(rm), nil
+}
+
+// GetRoomBySlug retrieves a room given its URL slug
+func (m *memoryDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetRoomBySlug"); err != nil {
+		return models.Room{}, err
+	}
+
+	for _, rm := range m.rooms {
+		if rm.Slug == slug {
+			return cloneRoom(rm), nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// reservationsWhere returns the reservations matching keep, ordered by start date; callers must hold mu
+func (m *memoryDBRepo) reservationsWhere(keep func(models.Reservation) bool) []models.Reservation {
+	var reservations []models.Reservation
+	for _, res := range m.reservations {
+		if keep(res) {
+			res.Room = models.Room{ID: res.RoomID, RoomName: m.rooms[res.RoomID].RoomName}
+			reservations = append(reservations, cloneReservation(res))
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		if reservations[i].StartDate.Equal(reservations[j].StartDate) {
+			return reservations[i].ID < reservations[j].ID
+		}
+		return reservations[i].StartDate.Before(reservations[j].StartDate)
+	})
+	return reservations
+}
+
+// AllReservations returns a slice of all reservations
+func (m *memoryDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "AllReservations"); err != nil {
+		return nil, err
+	}
+
+	return m.reservationsWhere(func(models.Reservation) bool { return true }), nil
+}
+
+// AllNewReservations returns a slice of all reservations that have not been processed
+func (m *memoryDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "AllNewReservations"); err != nil {
+		return nil, err
+	}
+
+	return m.reservationsWhere(func(res models.Reservation) bool { return res.Processed == 0 }), nil
+}
+
+// GetReservationByID retrieves a reservation, including its room, given an ID
+func (m *memoryDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetReservationByID"); err != nil {
+		return models.Reservation{}, err
+	}
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return res, sql.ErrNoRows
+	}
+	res.Room = models.Room{ID: res.RoomID, RoomName: m.rooms[res.RoomID].RoomName}
+	return cloneReservation(res), nil
+}
+
+// GetReservationByCode returns the reservation with the given confirmation code
+func (m *memoryDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetReservationByCode"); err != nil {
+		return models.Reservation{}, err
+	}
+
+	for _, res := range m.reservations {
+		if res.ConfirmationCode == code {
+			res.Room = models.Room{ID: res.RoomID, RoomName: m.rooms[res.RoomID].RoomName}
+			return cloneReservation(res), nil
+		}
+	}
+
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+// UpdateReservationStatus moves a reservation to change.ToStatus and records the change. If the
+// new status no longer holds the room its restriction is deleted, though the reservation is
+// kept. It returns repository.ErrStatusConflict unless the reservation is in change.FromStatus.
+func (m *memoryDBRepo) UpdateReservationStatus(ctx context.Context, change models.StatusChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "UpdateReservationStatus"); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[change.ReservationID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if res.Status != change.FromStatus {
+		return repository.ErrStatusConflict
+	}
+	if change.UserID != 0 {
+		if _, ok := m.users[change.UserID]; !ok {
+			return fmt.Errorf("reservation_status_changes: user %d does not exist", change.UserID)
+		}
+	}
+
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = time.Now()
+	}
+	res.Status = change.ToStatus
+	res.UpdatedAt = change.CreatedAt
+	if change.ToStatus == models.ReservationCancelled {
+		res.CancelledAt = change.CreatedAt
+	}
+	m.reservations[res.ID] = res
+
+	if !lifecycle.Blocking(change.ToStatus) {
+		for rid, r := range m.roomRestrictions {
+			if r.ReservationID == res.ID {
+				delete(m.roomRestrictions, rid)
+			}
+		}
+	}
+
+	m.nextID["reservation_status_changes"]++
+	change.ID = m.nextID["reservation_status_changes"]
+	m.statusChanges[change.ID] = change
+
+	return nil
+}
+
+// GetStatusChangesForReservation returns the status changes of a reservation, oldest first
+func (m *memoryDBRepo) GetStatusChangesForReservation(ctx context.Context, reservationID int) ([]models.StatusChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetStatusChangesForReservation"); err != nil {
+		return nil, err
+	}
+
+	var changes []models.StatusChange
+	for _, c := range m.statusChanges {
+		if c.ReservationID == reservationID {
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+
+	return changes, nil
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to new dates, saving the
+// stay's new price, or returns repository.ErrRoomUnavailable if other bookings are in the way or
+// the room is no longer active.
+// Only pending and confirmed reservations can be moved; others give repository.ErrStatusConflict.
+func (m *memoryDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, price models.Quote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "ChangeReservationDates"); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if !lifecycle.Changeable(res.Status) {
+		return repository.ErrStatusConflict
+	}
+	if rm := m.rooms[res.RoomID]; !rm.Active || !m.isAvailable(res.RoomID, start, end, id) {
+		return repository.ErrRoomUnavailable
+	}
+
+	res.StartDate = dateOnly(start)
+	res.EndDate = dateOnly(end)
+	res.Price = price
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = cloneReservation(res)
+	for rid, r := range m.roomRestrictions {
+		if r.ReservationID == id {
+			r.StartDate = res.StartDate
+			r.EndDate = res.EndDate
+			r.UpdatedAt = res.UpdatedAt
+			m.roomRestrictions[rid] = r
+		}
+	}
+
+	return nil
+}
+
+// UpdateReservation updates the guest details of a reservation
+func (m *memoryDBRepo) UpdateReservation(ctx context.Context, res models.Reservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "UpdateReservation"); err != nil {
+		return err
+	}
+
+	// like an SQL update, a missing row is not an error
+	existing, ok := m.reservations[res.ID]
+	if !ok {
+		return nil
+	}
+	existing.FirstName = res.FirstName
+	existing.LastName = res.LastName
+	existing.Email = res.Email
+	existing.Phone = res.Phone
+	existing.UpdatedAt = time.Now()
+	m.reservations[res.ID] = existing
+
+	return nil
+}
+
+// DeleteReservation deletes a reservation, and its room restriction, given an ID
+func (m *memoryDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "DeleteReservation"); err != nil {
+		return err
+	}
+
+	delete(m.reservations, id)
+	// mirror the reservation_id foreign key cascades
+	for rid, r := range m.roomRestrictions {
+		if r.ReservationID == id {
+			delete(m.roomRestrictions, rid)
+		}
+	}
+	for pid, p := range m.paymentIntents {
+		if p.ReservationID == id {
+			delete(m.paymentIntents, pid)
+		}
+	}
+	for cid, c := range m.statusChanges {
+		if c.ReservationID == id {
+			delete(m.statusChanges, cid)
+		}
+	}
+	for sid, msg := range m.sentMessages {
+		if msg.ReservationID == id {
+			delete(m.sentMessages, sid)
+		}
+	}
+
+	return nil
+}
+
+// UpdateProcessedForReservation sets the processed flag of a reservation
+func (m *memoryDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "UpdateProcessedForReservation"); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return nil
+	}
+	res.Processed = processed
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+
+	return nil
+}
+
+// GetReservationsArrivingBetween returns the reservations still holding their room that start
+// on or after start and before end, ordered by start date
+func (m *memoryDBRepo) GetReservationsArrivingBetween(ctx context.Context, start, end time.Time) ([]models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetReservationsArrivingBetween"); err != nil {
+		return nil, err
+	}
+
+	start, end = dateOnly(start), dateOnly(end)
+	return m.reservationsWhere(func(res models.Reservation) bool {
+		return !res.StartDate.Before(start) && res.StartDate.Before(end) && lifecycle.Blocking(res.Status)
+	}), nil
+}
+
+// GetReservationsDepartingBetween returns the reservations still holding their room that end on
+// or after start and before end, ordered by start date
+func (m *memoryDBRepo) GetReservationsDepartingBetween(ctx context.Context, start, end time.Time) ([]models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetReservationsDepartingBetween"); err != nil {
+		return nil, err
+	}
+
+	start, end = dateOnly(start), dateOnly(end)
+	return m.reservationsWhere(func(res models.Reservation) bool {
+		return !res.EndDate.Before(start) && res.EndDate.Before(end) && lifecycle.Blocking(res.Status)
+	}), nil
+}
+
+// RecordSentMessage records that the message kind is being sent about a reservation, returning
+// false if it already was
+func (m *memoryDBRepo) RecordSentMessage(ctx context.Context, reservationID int, kind string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "RecordSentMessage"); err != nil {
+		return false, err
+	}
+
+	// mirror the foreign key and the unique index on reservation and kind
+	if _, ok := m.reservations[reservationID]; !ok {
+		return false, fmt.Errorf("sent_messages: reservation %d does not exist", reservationID)
+	}
+	for _, msg := range m.sentMessages {
+		if msg.ReservationID == reservationID && msg.Kind == kind {
+			return false, nil
+		}
+	}
+
+	m.nextID["sent_messages"]++
+	id := m.nextID["sent_messages"]
+	m.sentMessages[id] = models.SentMessage{ID: id, ReservationID: reservationID, Kind: kind, CreatedAt: time.Now()}
+
+	return true, nil
+}
+
+// DeleteSentMessage forgets that the message kind was sent about a reservation, so it can be
+// sent again
+func (m *memoryDBRepo) DeleteSentMessage(ctx context.Context, reservationID int, kind string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "DeleteSentMessage"); err != nil {
+		return err
+	}
+
+	for id, msg := range m.sentMessages {
+		if msg.ReservationID == reservationID && msg.Kind == kind {
+			delete(m.sentMessages, id)
+		}
+	}
+
+	return nil
+}
+
+// GetSentMessagesForReservation returns the scheduled messages sent about a reservation, oldest first
+func (m *memoryDBRepo) GetSentMessagesForReservation(ctx context.Context, reservationID int) ([]models.SentMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetSentMessagesForReservation"); err != nil {
+		return nil, err
+	}
+
+	var messages []models.SentMessage
+	for _, msg := range m.sentMessages {
+		if msg.ReservationID == reservationID {
+			messages = append(messages, msg)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+
+	return messages, nil
+}
+
+// sortRooms orders rooms for display, as the Postgres queries do
+func sortRooms(rooms []models.Room) {
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].SortOrder != rooms[j].SortOrder {
+			return rooms[i].SortOrder < rooms[j].SortOrder
+		}
+		return rooms[i].ID < rooms[j].ID
+	})
+}
+
+// roomsWhere returns copies of the rooms for which keep returns true, in display order; callers must hold mu
+func (m *memoryDBRepo) roomsWhere(keep func(models.Room) bool) []models.Room {
+	var rooms []models.Room
+	for _, rm := range m.rooms {
+		if keep(rm) {
+			rooms = append(rooms, cloneRoom(rm))
+		}
+	}
+	sortRooms(rooms)
+	return rooms
+}
+
+// slugTaken reports whether a room other than id uses slug, mirroring the unique index; callers must hold mu
+func (m *memoryDBRepo) slugTaken(slug string, id int) bool {
+	for _, x := range m.rooms {
+		if x.Slug == slug && x.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// AllRooms returns a slice of all rooms, active or not, in their display order
+func (m *memoryDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "AllRooms"); err != nil {
+		return nil, err
+	}
+
+	return m.roomsWhere(func(models.Room) bool { return true }), nil
+}
+
+// AllActiveRooms returns a slice of the rooms guests can book, in their display order
+func (m *memoryDBRepo) AllActiveRooms(ctx context.Context) ([]models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "AllActiveRooms"); err != nil {
+		return nil, err
+	}
+
+	return m.roomsWhere(func(rm models.Room) bool { return rm.Active }), nil
+}
+
+// InsertRoom inserts a new room, which starts out active and is listed after the existing rooms
+func (m *memoryDBRepo) InsertRoom(ctx context.Context, rm models.Room) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertRoom"); err != nil {
+		return 0, err
+	}
+
+	if m.slugTaken(rm.Slug, 0) {
+		return 0, repository.ErrDuplicateSlug
+	}
+
+	rm.SortOrder = 0
+	for _, x := range m.rooms {
+		if x.SortOrder > rm.SortOrder {
+			rm.SortOrder = x.SortOrder
+		}
+	}
+	rm.SortOrder++
+
+	m.nextID["rooms"]++
+	rm.ID = m.nextID["rooms"]
+	rm.Active = true
+	rm.CreatedAt = time.Now()
+	rm.UpdatedAt = time.Now()
+	m.rooms[rm.ID] = cloneRoom(rm)
+
+	return rm.ID, nil
+}
+
+// UpdateRoom saves the details and images of a room; its active flag and order are changed separately
+func (m *memoryDBRepo) UpdateRoom(ctx context.Context, rm models.Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "UpdateRoom"); err != nil {
+		return err
+	}
+
+	existing, ok := m.rooms[rm.ID]
+	if !ok {
+		return nil
+	}
+	if m.slugTaken(rm.Slug, rm.ID) {
+		return repository.ErrDuplicateSlug
+	}
+
+	existing.RoomName = rm.RoomName
+	existing.Slug = rm.Slug
+	existing.Description = rm.Description
+	existing.Capacity = rm.Capacity
+	existing.Amenities = rm.Amenities
+	existing.Images = rm.Images
+	existing.NightlyRate = rm.NightlyRate
+	existing.UpdatedAt = time.Now()
+	m.rooms[rm.ID] = cloneRoom(existing)
+
+	return nil
+}
+
+// UpdateActiveForRoom activates or deactivates a room
+func (m *memoryDBRepo) UpdateActiveForRoom(ctx context.Context, id int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "UpdateActiveForRoom"); err != nil {
+		return err
+	}
+
+	rm, ok := m.rooms[id]
+	if !ok {
+		return nil
+	}
+	rm.Active = active
+	rm.UpdatedAt = time.Now()
+	m.rooms[id] = rm
+
+	return nil
+}
+
+// UpdateRoomOrder sets the display order of rooms to the order of ids; rooms left out keep their place after them
+func (m *memoryDBRepo) UpdateRoomOrder(ctx context.Context, ids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "UpdateRoomOrder"); err != nil {
+		return err
+	}
+
+	for id, rm := range m.rooms {
+		rm.SortOrder += len(ids)
+		m.rooms[id] = rm
+	}
+	for i, id := range ids {
+		rm, ok := m.rooms[id]
+		if !ok {
+			continue
+		}
+		rm.SortOrder = i + 1
+		rm.UpdatedAt = time.Now()
+		m.rooms[id] = rm
+	}
+
+	return nil
+}
+
+// cloneRateOverride copies the weekdays of o, for the same reason as cloneRoom
+func cloneRateOverride(o models.RateOverride) models.RateOverride {
+	o.Weekdays = append([]time.Weekday(nil), o.Weekdays...)
+	return o
+}
+
+// rateOverridesWhere returns copies of the overrides for which keep returns true, ordered by
+// room and then start date; callers must hold mu
+func (m *memoryDBRepo) rateOverridesWhere(keep func(models.RateOverride) bool) []models.RateOverride {
+	var overrides []models.RateOverride
+	for _, o := range m.rateOverrides {
+		if keep(o) {
+			o.Room = models.Room{ID: o.RoomID, RoomName: m.rooms[o.RoomID].RoomName}
+			overrides = append(overrides, cloneRateOverride(o))
+		}
+	}
+	sort.Slice(overrides, func(i, j int) bool {
+		a, b := overrides[i], overrides[j]
+		if a.RoomID != b.RoomID {
+			return a.RoomID < b.RoomID
+		}
+		if !a.StartDate.Equal(b.StartDate) {
+			return a.StartDate.Before(b.StartDate)
+		}
+		return a.ID < b.ID
+	})
+	return overrides
+}
+
+// AllRateOverrides returns every rate override, ordered by room and then start date
+func (m *memoryDBRepo) AllRateOverrides(ctx context.Context) ([]models.RateOverride, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "AllRateOverrides"); err != nil {
+		return nil, err
+	}
+
+	return m.rateOverridesWhere(func(models.RateOverride) bool { return true }), nil
+}
+
+// GetRateOverridesForRoom returns the overrides of roomID that cover any night from start up to end
+func (m *memoryDBRepo) GetRateOverridesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.RateOverride, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetRateOverridesForRoom"); err != nil {
+		return nil, err
+	}
+
+	start, end = dateOnly(start), dateOnly(end)
+	return m.rateOverridesWhere(func(o models.RateOverride) bool {
+		return o.RoomID == roomID && start.Before(o.EndDate) && end.After(o.StartDate)
+	}), nil
+}
+
+// InsertRateOverride inserts a new rate override
+func (m *memoryDBRepo) InsertRateOverride(ctx context.Context, o models.RateOverride) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertRateOverride"); err != nil {
+		return 0, err
+	}
+
+	// mirror the foreign key and check constraints
+	if _, ok := m.rooms[o.RoomID]; !ok {
+		return 0, fmt.Errorf("rate_overrides: room %d does not exist", o.RoomID)
+	}
+	o.StartDate = dateOnly(o.StartDate)
+	o.EndDate = dateOnly(o.EndDate)
+	if !o.EndDate.After(o.StartDate) || o.NightlyRate < 0 {
+		return 0, fmt.Errorf("rate_overrides: invalid override %+v", o)
+	}
+
+	m.nextID["rate_overrides"]++
+	o.ID = m.nextID["rate_overrides"]
+	o.Room = models.Room{}
+	o.CreatedAt = time.Now()
+	o.UpdatedAt = time.Now()
+	m.rateOverrides[o.ID] = cloneRateOverride(o)
+
+	return o.ID, nil
+}
+
+// DeleteRateOverride deletes a rate override
+func (m *memoryDBRepo) DeleteRateOverride(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "DeleteRateOverride"); err != nil {
+		return err
+	}
+
+	delete(m.rateOverrides, id)
+	return nil
+}
+
+// AllStayDiscounts returns every length-of-stay discount, shortest minimum stay first
+func (m *memoryDBRepo) AllStayDiscounts(ctx context.Context) ([]models.StayDiscount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "AllStayDiscounts"); err != nil {
+		return nil, err
+	}
+
+	var discounts []models.StayDiscount
+	for _, d := range m.stayDiscounts {
+		discounts = append(discounts, d)
+	}
+	sort.Slice(discounts, func(i, j int) bool {
+		if discounts[i].MinNights != discounts[j].MinNights {
+			return discounts[i].MinNights < discounts[j].MinNights
+		}
+		return discounts[i].ID < discounts[j].ID
+	})
+
+	return discounts, nil
+}
+
+// InsertStayDiscount inserts a new length-of-stay discount
+func (m *memoryDBRepo) InsertStayDiscount(ctx context.Context, d models.StayDiscount) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertStayDiscount"); err != nil {
+		return 0, err
+	}
+
+	// mirror the check constraints
+	if d.MinNights < 1 || d.Percent < 1 || d.Percent > 100 {
+		return 0, fmt.Errorf("stay_discounts: invalid discount %+v", d)
+	}
+
+	m.nextID["stay_discounts"]++
+	d.ID = m.nextID["stay_discounts"]
+	d.CreatedAt = time.Now()
+	d.UpdatedAt = time.Now()
+	m.stayDiscounts[d.ID] = d
+
+	return d.ID, nil
+}
+
+// DeleteStayDiscount deletes a length-of-stay discount
+func (m *memoryDBRepo) DeleteStayDiscount(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "DeleteStayDiscount"); err != nil {
+		return err
+	}
+
+	delete(m.stayDiscounts, id)
+	return nil
+}
+
+// clonePromoCode copies the room IDs of p, for the same reason as cloneRoom
+func clonePromoCode(p models.PromoCode) models.PromoCode {
+	p.RoomIDs = append([]int(nil), p.RoomIDs...)
+	if len(p.RoomIDs) == 0 {
+		p.RoomIDs = nil
+	}
+	return p
+}
+
+// promoCodeByCode returns the promo code with the given code; callers must hold mu
+func (m *memoryDBRepo) promoCodeByCode(code string) (models.PromoCode, bool) {
+	for _, p := range m.promoCodes {
+		if p.Code == code {
+			return p, true
+		}
+	}
+	return models.PromoCode{}, false
+}
+
+// AllPromoCodes returns every promo code, ordered by code
+func (m *memoryDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "AllPromoCodes"); err != nil {
+		return nil, err
+	}
+
+	var codes []models.PromoCode
+	for _, p := range m.promoCodes {
+		codes = append(codes, clonePromoCode(p))
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+
+	return codes, nil
+}
+
+// GetPromoCodeByCode returns the promo code with the given code, which must already be normalized
+func (m *memoryDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetPromoCodeByCode"); err != nil {
+		return models.PromoCode{}, err
+	}
+
+	p, ok := m.promoCodeByCode(code)
+	if !ok {
+		return p, sql.ErrNoRows
+	}
+
+	return clonePromoCode(p), nil
+}
+
+// InsertPromoCode inserts a new promo code, returning repository.ErrDuplicateCode if the code is taken
+func (m *memoryDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertPromoCode"); err != nil {
+		return 0, err
+	}
+
+	if _, taken := m.promoCodeByCode(p.Code); taken {
+		return 0, repository.ErrDuplicateCode
+	}
+
+	// mirror the check constraints
+	p.ValidFrom = dateOnly(p.ValidFrom)
+	p.ValidUntil = dateOnly(p.ValidUntil)
+	validKind := p.Kind == models.PromoFixed || (p.Kind == models.PromoPercent && p.Amount <= 100)
+	if !validKind || p.Amount <= 0 || p.ValidUntil.Before(p.ValidFrom) || p.MaxUses < 0 || p.MinNights < 0 {
+		return 0, fmt.Errorf("promo_codes: invalid promo code %+v", p)
+	}
+
+	m.nextID["promo_codes"]++
+	p.ID = m.nextID["promo_codes"]
+	p.Uses = 0
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.promoCodes[p.ID] = clonePromoCode(p)
+
+	return p.ID, nil
+}
+
+// DeletePromoCode deletes a promo code; reservations that used it keep its code and discount
+func (m *memoryDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "DeletePromoCode"); err != nil {
+		return err
+	}
+
+	delete(m.promoCodes, id)
+	return nil
+}
+
+func cloneAPIKey(k models.APIKey) models.APIKey {
+	k.Scopes = append([]string{}, k.Scopes...)
+	return k
+}
+
+// AllAPIKeys returns every API key, newest first
+func (m *memoryDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "AllAPIKeys"); err != nil {
+		return nil, err
+	}
+
+	var keys []models.APIKey
+	for _, k := range m.apiKeys {
+		keys = append(keys, cloneAPIKey(k))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the API key whose hash is hash
+func (m *memoryDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetAPIKeyByHash"); err != nil {
+		return models.APIKey{}, err
+	}
+
+	for _, k := range m.apiKeys {
+		if k.Hash == hash {
+			return cloneAPIKey(k), nil
+		}
+	}
+
+	return models.APIKey{}, sql.ErrNoRows
+}
+
+// InsertAPIKey inserts a new API key
+func (m *memoryDBRepo) InsertAPIKey(ctx context.Context, k models.APIKey) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertAPIKey"); err != nil {
+		return 0, err
+	}
+
+	// mirror the unique index and foreign key
+	for _, other := range m.apiKeys {
+		if other.Hash == k.Hash {
+			return 0, fmt.Errorf("api_keys: duplicate key hash")
+		}
+	}
+	if k.UserID != 0 {
+		if _, ok := m.users[k.UserID]; !ok {
+			return 0, fmt.Errorf("api_keys: user %d does not exist", k.UserID)
+		}
+	}
+
+	m.nextID["api_keys"]++
+	k.ID = m.nextID["api_keys"]
+	k.LastUsedAt = time.Time{}
+	k.CreatedAt = time.Now()
+	k.UpdatedAt = time.Now()
+	m.apiKeys[k.ID] = cloneAPIKey(k)
+
+	return k.ID, nil
+}
+
+// UpdateLastUsedForAPIKey records that an API key was last used at at
+func (m *memoryDBRepo) UpdateLastUsedForAPIKey(ctx context.Context, id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "UpdateLastUsedForAPIKey"); err != nil {
+		return err
+	}
+
+	if k, ok := m.apiKeys[id]; ok {
+		k.LastUsedAt = at
+		m.apiKeys[id] = k
+	}
+	return nil
+}
+
+// DeleteAPIKey deletes an API key, which stops working at once
+func (m *memoryDBRepo) DeleteAPIKey(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "DeleteAPIKey"); err != nil {
+		return err
+	}
+
+	delete(m.apiKeys, id)
+	// mirror the api_key_id foreign key on reservations
+	for rid, res := range m.reservations {
+		if res.APIKeyID == id {
+			res.APIKeyID = 0
+			m.reservations[rid] = res
+		}
+	}
+	return nil
+}
+
+// GetPaymentIntentsForReservation returns the payments taken for a reservation, oldest first
+func (m *memoryDBRepo) GetPaymentIntentsForReservation(ctx context.Context, reservationID int) ([]models.PaymentIntent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetPaymentIntentsForReservation"); err != nil {
+		return nil, err
+	}
+
+	var intents []models.PaymentIntent
+	for _, p := range m.paymentIntents {
+		if p.ReservationID == reservationID {
+			intents = append(intents, p)
+		}
+	}
+	sort.Slice(intents, func(i, j int) bool { return intents[i].ID < intents[j].ID })
+
+	return intents, nil
+}
+
+// GetPaymentIntentByRef returns the payment with the given provider's reference
+func (m *memoryDBRepo) GetPaymentIntentByRef(ctx context.Context, provider, ref string) (models.PaymentIntent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetPaymentIntentByRef"); err != nil {
+		return models.PaymentIntent{}, err
+	}
+
+	for _, p := range m.paymentIntents {
+		if p.Provider == provider && p.ProviderRef == ref {
+			return p, nil
+		}
+	}
+
+	return models.PaymentIntent{}, sql.ErrNoRows
+}
+
+// UpdatePaymentIntent saves the status and amounts of a payment, as reported by its provider
+func (m *memoryDBRepo) UpdatePaymentIntent(ctx context.Context, p models.PaymentIntent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "UpdatePaymentIntent"); err != nil {
+		return err
+	}
+
+	x, ok := m.paymentIntents[p.ID]
+	if !ok {
+		return nil
+	}
+	x.Status = p.Status
+	x.Captured = p.Captured
+	x.Refunded = p.Refunded
+	x.UpdatedAt = time.Now()
+	m.paymentIntents[p.ID] = x
+
+	return nil
+}
+
+// AllRestrictions returns a slice of all restriction types, ordered by ID
+func (m *memoryDBRepo) AllRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "AllRestrictions"); err != nil {
+		return nil, err
+	}
+
+	var restrictions []models.Restriction
+	for _, r := range m.restrictions {
+		restrictions = append(restrictions, r)
+	}
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].ID < restrictions[j].ID })
+
+	return restrictions, nil
+}
+
+// InsertRestriction inserts a new restriction type
+func (m *memoryDBRepo) InsertRestriction(ctx context.Context, r models.Restriction) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertRestriction"); err != nil {
+		return 0, err
+	}
+
+	m.nextID["restrictions"]++
+	r.ID = m.nextID["restrictions"]
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.restrictions[r.ID] = r
+
+	return r.ID, nil
+}
+
+// GetRestrictionsForRoomByDate returns the restrictions for a room overlapping a date range
+func (m *memoryDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(ctx, "GetRestrictionsForRoomByDate"); err != nil {
+		return nil, err
+	}
+
+	var restrictions []models.RoomRestriction
+	for _, r := range m.roomRestrictions {
+		if r.RoomID == roomID && overlaps(r, start, end) {
+			restrictions = append(restrictions, r)
+		}
+	}
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].StartDate.Before(restrictions[j].StartDate) })
+
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts a one night owner block for a room
+func (m *memoryDBRepo) InsertBlockForRoom(ctx context.Context, roomID int, startDate time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "InsertBlockForRoom"); err != nil {
+		return err
+	}
+
+	// restriction_id 2 is the "Owner Block" restriction type
+	return m.insertRoomRestriction(models.RoomRestriction{
+		StartDate:     startDate,
+		EndDate:       startDate.AddDate(0, 0, 1),
+		RoomID:        roomID,
+		RestrictionID: 2,
+	})
+}
+
+// DeleteBlockByID deletes an owner block given its room restriction ID, when it is the block of
+// roomID starting on startDate
+func (m *memoryDBRepo) DeleteBlockByID(ctx context.Context, id, roomID int, startDate time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(ctx, "DeleteBlockByID"); err != nil {
+		return err
+	}
+
+	r, ok := m.roomRestrictions[id]
+	if ok && r.RestrictionID == 2 && r.RoomID == roomID && r.StartDate.Equal(dateOnly(startDate)) {
+		delete(m.roomRestrictions, id)
+	}
+
+	return nil
+}
+
+// TryLock takes the mutex for name, creating it the first time the name is used. The memory
+// database is only ever used by a single process, so this is all that stops a job overlapping
+// with itself.
+func (m *memoryDBRepo) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	m.mu.Lock()
+	if err := m.check(ctx, "TryLock"); err != nil {
+		m.mu.Unlock()
+		return nil, false, err
+	}
+	l, ok := m.locks[name]
+	if !ok {
+		l = &sync.Mutex{}
+		m.locks[name] = l
+	}
+	m.mu.Unlock()
+
+	if !l.TryLock() {
+		return nil, false, nil
+	}
+	return l.Unlock, true, nil
+}
@@ -0,0 +1,36 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/repository"
+	"github.com/jeremydelacruz/go-bookings/internal/repository/repotest"
+)
+
+func TestMemoryDBRepo_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		return NewMemoryRepo(&config.AppConfig{})
+	})
+}
+
+func TestMemoryDBRepo_FailOn(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	injector := repo.(FailureInjector)
+	errBoom := errors.New("boom")
+
+	injector.FailOn("AllRooms", errBoom)
+	if _, err := repo.AllRooms(context.Background()); !errors.Is(err, errBoom) {
+		t.Errorf("expected injected error, got %v", err)
+	}
+	if _, err := repo.GetRoomByID(context.Background(), 1); err != nil {
+		t.Errorf("expected other methods to keep working, got %v", err)
+	}
+
+	injector.ClearFailures()
+	if _, err := repo.AllRooms(context.Background()); err != nil {
+		t.Errorf("expected failure to be cleared, got %v", err)
+	}
+}
@@ -2,25 +2,202 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jeremydelacruz/go-bookings/internal/lifecycle"
 	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/repository"
+	"github.com/jeremydelacruz/go-bookings/internal/tokens"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
+// exclusionViolation is the Postgres error code raised by the room_restrictions overlap constraint
+const exclusionViolation = "23P01"
+
+// uniqueViolation is the Postgres error code raised by unique indexes such as rooms_slug_idx
+const uniqueViolation = "23505"
+
+// defaultQueryTimeout bounds each query when the app config does not set a timeout
+const defaultQueryTimeout = 3 * time.Second
+
+// withTimeout derives a context for a single query from the caller's context
+func (m *postgresDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.App.DBQueryTimeout
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// translateError maps Postgres errors onto the errors exposed by the repository package
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch {
+	case pgErr.Code == exclusionViolation:
+		return repository.ErrRoomUnavailable
+	case pgErr.Code == uniqueViolation && pgErr.ConstraintName == "rooms_slug_idx":
+		return repository.ErrDuplicateSlug
+	case pgErr.Code == uniqueViolation && pgErr.ConstraintName == "promo_codes_code_idx":
+		return repository.ErrDuplicateCode
+	}
+	return err
+}
+
+// roomColumns are the rooms columns read by scanRoom, in order
+const roomColumns = `id, room_name, slug, description, capacity, amenities, images, active, sort_order, nightly_rate, created_at, updated_at`
+
+// scanRoom scans a row of roomColumns, decoding the jsonb lists
+func scanRoom(row interface{ Scan(...any) error }) (models.Room, error) {
+	var rm models.Room
+	var amenities, images []byte
+
+	err := row.Scan(
+		&rm.ID,
+		&rm.RoomName,
+		&rm.Slug,
+		&rm.Description,
+		&rm.Capacity,
+		&amenities,
+		&images,
+		&rm.Active,
+		&rm.SortOrder,
+		&rm.NightlyRate,
+		&rm.CreatedAt,
+		&rm.UpdatedAt,
+	)
+	if err != nil {
+		return rm, err
+	}
+
+	if err = json.Unmarshal(amenities, &rm.Amenities); err != nil {
+		return rm, err
+	}
+	if err = json.Unmarshal(images, &rm.Images); err != nil {
+		return rm, err
+	}
+
+	return rm, nil
+}
+
+// jsonList encodes l for a jsonb column, writing an empty array rather than null
+func jsonList(l []string) string {
+	if l == nil {
+		return "[]"
+	}
+	b, _ := json.Marshal(l)
+	return string(b)
+}
+
+// nullID is id as a nullable foreign key, where 0 stands for none
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// reservationColumns are the columns read by scanReservation, from reservations r joined to rooms rm
+const reservationColumns = `r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.price,
+			r.promo_code, r.confirmation_code, r.status, r.cancelled_at, r.api_key_id, rm.id, rm.room_name`
+
+// scanReservation scans a row of reservationColumns, decoding the price snapshot
+func scanReservation(row interface{ Scan(...any) error }) (models.Reservation, error) {
+	var res models.Reservation
+	var price []byte
+	var cancelledAt sql.NullTime
+	var apiKeyID sql.NullInt64
+
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Processed,
+		&price,
+		&res.PromoCode,
+		&res.ConfirmationCode,
+		&res.Status,
+		&cancelledAt,
+		&apiKeyID,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+	if err != nil {
+		return res, err
+	}
+	res.CancelledAt = cancelledAt.Time
+	res.APIKeyID = int(apiKeyID.Int64)
+
+	if err = json.Unmarshal(price, &res.Price); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// queryRooms runs a query selecting roomColumns and scans every row
+func (m *postgresDBRepo) queryRooms(ctx context.Context, query string, args ...any) ([]models.Room, error) {
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rm, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
 }
 
 // InsertReservation inserts a new reservation into the database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
+	price, err := json.Marshal(res.Price)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.ConfirmationCode == "" {
+		if res.ConfirmationCode, err = tokens.NewConfirmationCode(); err != nil {
+			return 0, err
+		}
+	}
+	if res.Status == "" {
+		res.Status = models.ReservationPending
+	}
+
 	var newID int
 
 	stmt := `insert into reservations
-			(first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
-			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+			(first_name, last_name, email, phone, start_date, end_date, room_id, price, promo_code,
+			confirmation_code, status, api_key_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) returning id`
 
 	newRow := m.DB.QueryRowContext(ctx, stmt,
 		res.FirstName,
@@ -30,10 +207,15 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		price,
+		res.PromoCode,
+		res.ConfirmationCode,
+		res.Status,
+		nullID(res.APIKeyID),
 		time.Now(),
 		time.Now(),
 	)
-	err := newRow.Scan(&newID)
+	err = newRow.Scan(&newID)
 	if err != nil {
 		return 0, err
 	}
@@ -42,8 +224,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 }
 
 // InsertRoomRestriction inserts a new room restriction into the database
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
@@ -58,20 +240,158 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 		r.RestrictionID,
 	)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	return nil
 }
 
-// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// CreateReservation inserts a reservation and its room restriction in a single transaction,
+// re-checking availability while holding a lock on the room. A promo code on res is redeemed in
+// the same transaction, failing with repository.ErrPromoCodeUnavailable once it is used up, and
+// the deposit authorised for it, if any, is recorded along with it. An inactive room cannot be
+// booked, failing with sql.ErrNoRows as an unknown room does.
+func (m *postgresDBRepo) CreateReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
+	price, err := json.Marshal(res.Price)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.ConfirmationCode == "" {
+		if res.ConfirmationCode, err = tokens.NewConfirmationCode(); err != nil {
+			return 0, err
+		}
+	}
+	if res.Status == "" {
+		res.Status = models.ReservationPending
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// lock the room row so concurrent bookings for the same room are serialized
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 and active for update`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
 	var numRows int
 	query := `select count(id) from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date`
-	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	// redeem the promo code in the same transaction so a failed booking does not use it up; the
+	// row lock taken by the update makes concurrent bookings wait, and the limit is checked again
+	// against the committed count once they may go ahead
+	if res.PromoCode != "" {
+		stmt := `update promo_codes set uses = uses + 1, updated_at = $2
+				where code = $1 and (max_uses = 0 or uses < max_uses)`
+		result, err := tx.ExecContext(ctx, stmt, res.PromoCode, time.Now())
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, repository.ErrPromoCodeUnavailable
+		}
+	}
+
+	var newID int
+	stmt := `insert into reservations
+			(first_name, last_name, email, phone, start_date, end_date, room_id, price, promo_code,
+			confirmation_code, status, api_key_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		price,
+		res.PromoCode,
+		res.ConfirmationCode,
+		res.Status,
+		nullID(res.APIKeyID),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	// restriction_id 1 is the "Reservation" restriction type
+	stmt = `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+			values ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		1,
+	)
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	if p := res.Payment; p.ProviderRef != "" {
+		stmt = `insert into payment_intents (reservation_id, provider, provider_ref, status, amount, captured,
+				refunded, currency, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+		_, err = tx.ExecContext(ctx, stmt,
+			newID,
+			p.Provider,
+			p.ProviderRef,
+			p.Status,
+			p.Amount,
+			p.Captured,
+			p.Refunded,
+			p.Currency,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, ignoring
+// the restriction of reservation excludeReservationID so a booking can be moved onto its own
+// dates; pass 0 to exclude nothing
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID, excludeReservationID int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var numRows int
+	query := `select count(id) from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date
+			and (reservation_id is null or reservation_id <> $4)`
+	row := m.DB.QueryRowContext(ctx, query, roomID, start, end, excludeReservationID)
 	err := row.Scan(&numRows)
 	if err != nil {
 		return false, err
@@ -80,49 +400,1344 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms for given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var rooms []models.Room
-	query := `select r.id, r.room_name from rooms r
-			where r.id not in
-				(select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date)`
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	query := `select ` + roomColumns + ` from rooms
+			where active and id not in
+				(select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date)
+			order by id`
+
+	return m.queryRooms(ctx, query, start, end)
+}
+
+// GetRoomByID retrieves a room given an ID
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms where id = $1`
+
+	return scanRoom(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetRoomBySlug retrieves a room given its URL slug
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms where slug = $1`
+
+	return scanRoom(m.DB.QueryRowContext(ctx, query, slug))
+}
+
+// InsertUser inserts a new user, whose password must already be a bcrypt hash
+func (m *postgresDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into users (first_name, last_name, email, password, access_level, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Password,
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetUserByID retrieves a user given an ID
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var u models.User
+
+	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
+			from users where id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// dummyPasswordHash is a bcrypt hash at the cost passwords are stored with, checked against
+// when no user has the email given
+var dummyPasswordHash = []byte("$2a$10$orQ0AZ7iQ0YcwFSVDtNUPulJB16.6SdImIeuYgoK78gelfM8eTpde")
+
+// Authenticate checks a password against the bcrypt hash stored for email,
+// returning the user ID and hash on success
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, `select id, password from users where email = $1`, email)
+	err := row.Scan(&id, &hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		// compare anyway, so an unknown email takes as long to refuse as a wrong password
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(testPassword))
+		return 0, "", repository.ErrInvalidCredentials
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return 0, "", repository.ErrInvalidCredentials
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, hashedPassword, nil
+}
+
+// AllReservations returns a slice of all reservations
+func (m *postgresDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + reservationColumns + `
+			from reservations r
+			left join rooms rm on (r.room_id = rm.id)
+			order by r.start_date asc`
+
+	return m.queryReservations(ctx, query)
+}
+
+// AllNewReservations returns a slice of all reservations that have not been processed
+func (m *postgresDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + reservationColumns + `
+			from reservations r
+			left join rooms rm on (r.room_id = rm.id)
+			where r.processed = 0
+			order by r.start_date asc`
+
+	return m.queryReservations(ctx, query)
+}
+
+// queryReservations scans the rows of a reservations query joined with rooms
+func (m *postgresDBRepo) queryReservations(ctx context.Context, query string, args ...interface{}) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return []models.Room{}, nil
+		return reservations, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var room models.Room
-		err = rows.Scan(&room.ID, &room.RoomName)
+		res, err := scanReservation(rows)
 		if err != nil {
-			return rooms, err
+			return reservations, err
 		}
-		rooms = append(rooms, room)
+		reservations = append(reservations, res)
 	}
 
 	if err = rows.Err(); err != nil {
-		return rooms, err
+		return reservations, err
 	}
 
-	return rooms, nil
+	return reservations, nil
 }
 
-// GetRoomByID retrieves a room given an ID
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetReservationByID retrieves a reservation, including its room, given an ID
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var room models.Room
+	query := `select ` + reservationColumns + `
+			from reservations r
+			left join rooms rm on (r.room_id = rm.id)
+			where r.id = $1`
 
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1`
+	return scanReservation(m.DB.QueryRowContext(ctx, query, id))
+}
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
+// GetReservationByCode returns the reservation with the given confirmation code
+func (m *postgresDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + reservationColumns + `
+			from reservations r
+			left join rooms rm on (r.room_id = rm.id)
+			where r.confirmation_code = $1`
+
+	return scanReservation(m.DB.QueryRowContext(ctx, query, code))
+}
+
+// UpdateReservationStatus moves a reservation to change.ToStatus and records the change. If the
+// new status no longer holds the room its restriction is deleted, though the reservation is
+// kept. It returns repository.ErrStatusConflict unless the reservation is in change.FromStatus.
+func (m *postgresDBRepo) UpdateReservationStatus(ctx context.Context, change models.StatusChange) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = time.Now()
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `select status from reservations where id = $1 for update`, change.ReservationID).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status != change.FromStatus {
+		return repository.ErrStatusConflict
+	}
+
+	stmt := `update reservations set status = $1, updated_at = $2 where id = $3`
+	if change.ToStatus == models.ReservationCancelled {
+		stmt = `update reservations set status = $1, updated_at = $2, cancelled_at = $2 where id = $3`
+	}
+	_, err = tx.ExecContext(ctx, stmt, change.ToStatus, change.CreatedAt, change.ReservationID)
+	if err != nil {
+		return err
+	}
+
+	if !lifecycle.Blocking(change.ToStatus) {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, change.ReservationID)
+		if err != nil {
+			return err
+		}
+	}
+
+	var userID sql.NullInt64
+	if change.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(change.UserID), Valid: true}
+	}
+	stmt = `insert into reservation_status_changes
+			(reservation_id, from_status, to_status, actor, user_id, created_at)
+			values ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, stmt,
+		change.ReservationID,
+		change.FromStatus,
+		change.ToStatus,
+		change.Actor,
+		userID,
+		change.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetStatusChangesForReservation returns the status changes of a reservation, oldest first
+func (m *postgresDBRepo) GetStatusChangesForReservation(ctx context.Context, reservationID int) ([]models.StatusChange, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var changes []models.StatusChange
+
+	query := `select id, reservation_id, from_status, to_status, actor, user_id, created_at
+			from reservation_status_changes where reservation_id = $1 order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.StatusChange
+		var userID sql.NullInt64
+		err = rows.Scan(&c.ID, &c.ReservationID, &c.FromStatus, &c.ToStatus, &c.Actor, &userID, &c.CreatedAt)
+		if err != nil {
+			return changes, err
+		}
+		c.UserID = int(userID.Int64)
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+
+	return changes, nil
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to new dates, saving the
+// stay's new price. Availability is checked again while holding a lock on the room, ignoring the
+// reservation's own restriction, and repository.ErrRoomUnavailable returned if the room is taken
+// or no longer active. Only pending and confirmed reservations can be moved; others give repository.ErrStatusConflict.
+func (m *postgresDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, price models.Quote) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	priceJSON, err := json.Marshal(price)
 	if err != nil {
-		return room, err
+		return err
 	}
 
-	return room, nil
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select room_id from reservations where id = $1`, id).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	// lock the room first, in the same order as CreateReservation, then the reservation
+	var active bool
+	err = tx.QueryRowContext(ctx, `select active from rooms where id = $1 for update`, roomID).Scan(&active)
+	if err != nil {
+		return err
+	}
+	var status string
+	err = tx.QueryRowContext(ctx, `select status from reservations where id = $1 for update`, id).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if !lifecycle.Changeable(status) {
+		return repository.ErrStatusConflict
+	}
+	if !active {
+		return repository.ErrRoomUnavailable
+	}
+
+	var numRows int
+	query := `select count(id) from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date
+			and (reservation_id is null or reservation_id <> $4)`
+	err = tx.QueryRowContext(ctx, query, roomID, start, end, id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+
+	stmt := `update reservations set start_date = $1, end_date = $2, price = $3, updated_at = $4 where id = $5`
+	_, err = tx.ExecContext(ctx, stmt, start, end, priceJSON, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3 where reservation_id = $4`
+	_, err = tx.ExecContext(ctx, stmt, start, end, time.Now(), id)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return tx.Commit()
+}
+
+// UpdateReservation updates the guest details of a reservation
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5
+			where id = $6`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		time.Now(),
+		res.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteReservation deletes a reservation, and its room restriction, given an ID
+func (m *postgresDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	// room_restrictions rows are removed by the reservation_id foreign key cascade
+	_, err := m.DB.ExecContext(ctx, `delete from reservations where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateProcessedForReservation sets the processed flag of a reservation
+func (m *postgresDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `update reservations set processed = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, processed, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetReservationsArrivingBetween returns the reservations still holding their room that start
+// on or after start and before end, ordered by start date
+func (m *postgresDBRepo) GetReservationsArrivingBetween(ctx context.Context, start, end time.Time) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + reservationColumns + `
+			from reservations r
+			left join rooms rm on (r.room_id = rm.id)
+			where r.start_date >= $1 and r.start_date < $2 and r.status not in ($3, $4)
+			order by r.start_date asc, r.id asc`
+
+	return m.queryReservations(ctx, query, start, end, models.ReservationCancelled, models.ReservationNoShow)
+}
+
+// GetReservationsDepartingBetween returns the reservations still holding their room that end on
+// or after start and before end, ordered by start date
+func (m *postgresDBRepo) GetReservationsDepartingBetween(ctx context.Context, start, end time.Time) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + reservationColumns + `
+			from reservations r
+			left join rooms rm on (r.room_id = rm.id)
+			where r.end_date >= $1 and r.end_date < $2 and r.status not in ($3, $4)
+			order by r.start_date asc, r.id asc`
+
+	return m.queryReservations(ctx, query, start, end, models.ReservationCancelled, models.ReservationNoShow)
+}
+
+// RecordSentMessage records that the message kind is being sent about a reservation, returning
+// false if it already was. Recording before sending means two instances never both send it.
+func (m *postgresDBRepo) RecordSentMessage(ctx context.Context, reservationID int, kind string) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into sent_messages (reservation_id, kind, created_at) values ($1, $2, $3)
+			on conflict (reservation_id, kind) do nothing`
+
+	result, err := m.DB.ExecContext(ctx, stmt, reservationID, kind, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// DeleteSentMessage forgets that the message kind was sent about a reservation, so it can be
+// sent again
+func (m *postgresDBRepo) DeleteSentMessage(ctx context.Context, reservationID int, kind string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `delete from sent_messages where reservation_id = $1 and kind = $2`
+
+	_, err := m.DB.ExecContext(ctx, stmt, reservationID, kind)
+	return err
+}
+
+// GetSentMessagesForReservation returns the scheduled messages sent about a reservation, oldest first
+func (m *postgresDBRepo) GetSentMessagesForReservation(ctx context.Context, reservationID int) ([]models.SentMessage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var messages []models.SentMessage
+
+	query := `select id, reservation_id, kind, created_at from sent_messages where reservation_id = $1 order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.SentMessage
+		err = rows.Scan(&msg.ID, &msg.ReservationID, &msg.Kind, &msg.CreatedAt)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+
+	return messages, nil
+}
+
+// AllRooms returns a slice of all rooms, active or not, in their display order
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.queryRooms(ctx, `select `+roomColumns+` from rooms order by sort_order, id`)
+}
+
+// AllActiveRooms returns a slice of the rooms guests can book, in their display order
+func (m *postgresDBRepo) AllActiveRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.queryRooms(ctx, `select `+roomColumns+` from rooms where active order by sort_order, id`)
+}
+
+// InsertRoom inserts a new room, which starts out active and is listed after the existing rooms
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, rm models.Room) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into rooms (room_name, slug, description, capacity, amenities, images, nightly_rate,
+			active, sort_order, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, true, (select coalesce(max(sort_order), 0) + 1 from rooms), $8, $9)
+			returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rm.RoomName,
+		rm.Slug,
+		rm.Description,
+		rm.Capacity,
+		jsonList(rm.Amenities),
+		jsonList(rm.Images),
+		rm.NightlyRate,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom saves the details and images of a room; its active flag and order are changed separately
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, rm models.Room) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update rooms set room_name = $1, slug = $2, description = $3, capacity = $4,
+			amenities = $5, images = $6, nightly_rate = $7, updated_at = $8
+			where id = $9`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		rm.RoomName,
+		rm.Slug,
+		rm.Description,
+		rm.Capacity,
+		jsonList(rm.Amenities),
+		jsonList(rm.Images),
+		rm.NightlyRate,
+		time.Now(),
+		rm.ID,
+	)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// UpdateActiveForRoom activates or deactivates a room
+func (m *postgresDBRepo) UpdateActiveForRoom(ctx context.Context, id int, active bool) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `update rooms set active = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, active, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateRoomOrder sets the display order of rooms to the order of ids; rooms left out keep their place after them
+func (m *postgresDBRepo) UpdateRoomOrder(ctx context.Context, ids []int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// rooms that were not listed move behind the listed ones, keeping their relative order
+	_, err = tx.ExecContext(ctx, `update rooms set sort_order = sort_order + $1`, len(ids))
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, `update rooms set sort_order = $1, updated_at = $2 where id = $3`, i+1, time.Now(), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// weekdayMask packs days into the bits of the weekdays column, bit 0 being Sunday
+func weekdayMask(days []time.Weekday) int {
+	var mask int
+	for _, d := range days {
+		mask |= 1 << d
+	}
+	return mask
+}
+
+// maskWeekdays unpacks the weekdays column, returning nil when no day is set
+func maskWeekdays(mask int) []time.Weekday {
+	var days []time.Weekday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if mask&(1<<d) != 0 {
+			days = append(days, d)
+		}
+	}
+	return days
+}
+
+// queryRateOverrides runs a query selecting rate overrides joined to their room and scans every row
+func (m *postgresDBRepo) queryRateOverrides(ctx context.Context, query string, args ...any) ([]models.RateOverride, error) {
+	var overrides []models.RateOverride
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return overrides, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.RateOverride
+		var mask int
+		err = rows.Scan(
+			&o.ID,
+			&o.RoomID,
+			&o.Name,
+			&o.StartDate,
+			&o.EndDate,
+			&mask,
+			&o.NightlyRate,
+			&o.CreatedAt,
+			&o.UpdatedAt,
+			&o.Room.ID,
+			&o.Room.RoomName,
+		)
+		if err != nil {
+			return overrides, err
+		}
+		o.Weekdays = maskWeekdays(mask)
+		overrides = append(overrides, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return overrides, err
+	}
+
+	return overrides, nil
+}
+
+// AllRateOverrides returns every rate override, ordered by room and then start date
+func (m *postgresDBRepo) AllRateOverrides(ctx context.Context) ([]models.RateOverride, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select o.id, o.room_id, o.name, o.start_date, o.end_date, o.weekdays, o.nightly_rate,
+			o.created_at, o.updated_at, rm.id, rm.room_name
+			from rate_overrides o
+			left join rooms rm on (o.room_id = rm.id)
+			order by o.room_id, o.start_date, o.id`
+
+	return m.queryRateOverrides(ctx, query)
+}
+
+// GetRateOverridesForRoom returns the overrides of roomID that cover any night from start up to end
+func (m *postgresDBRepo) GetRateOverridesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.RateOverride, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select o.id, o.room_id, o.name, o.start_date, o.end_date, o.weekdays, o.nightly_rate,
+			o.created_at, o.updated_at, rm.id, rm.room_name
+			from rate_overrides o
+			left join rooms rm on (o.room_id = rm.id)
+			where o.room_id = $1 and $2 < o.end_date and $3 > o.start_date
+			order by o.start_date, o.id`
+
+	return m.queryRateOverrides(ctx, query, roomID, start, end)
+}
+
+// InsertRateOverride inserts a new rate override
+func (m *postgresDBRepo) InsertRateOverride(ctx context.Context, o models.RateOverride) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into rate_overrides (room_id, name, start_date, end_date, weekdays, nightly_rate, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		o.RoomID,
+		o.Name,
+		o.StartDate,
+		o.EndDate,
+		weekdayMask(o.Weekdays),
+		o.NightlyRate,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteRateOverride deletes a rate override
+func (m *postgresDBRepo) DeleteRateOverride(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from rate_overrides where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllStayDiscounts returns every length-of-stay discount, shortest minimum stay first
+func (m *postgresDBRepo) AllStayDiscounts(ctx context.Context) ([]models.StayDiscount, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var discounts []models.StayDiscount
+
+	query := `select id, name, min_nights, percent, created_at, updated_at
+			from stay_discounts order by min_nights, id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return discounts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.StayDiscount
+		err = rows.Scan(&d.ID, &d.Name, &d.MinNights, &d.Percent, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return discounts, err
+		}
+		discounts = append(discounts, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return discounts, err
+	}
+
+	return discounts, nil
+}
+
+// InsertStayDiscount inserts a new length-of-stay discount
+func (m *postgresDBRepo) InsertStayDiscount(ctx context.Context, d models.StayDiscount) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into stay_discounts (name, min_nights, percent, created_at, updated_at)
+			values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, d.Name, d.MinNights, d.Percent, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteStayDiscount deletes a length-of-stay discount
+func (m *postgresDBRepo) DeleteStayDiscount(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from stay_discounts where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// promoCodeColumns are the promo_codes columns read by scanPromoCode, in order
+const promoCodeColumns = `id, code, kind, amount, valid_from, valid_until, max_uses, uses, room_ids,
+			min_nights, created_at, updated_at`
+
+// scanPromoCode scans a row of promoCodeColumns, decoding the jsonb room list
+func scanPromoCode(row interface{ Scan(...any) error }) (models.PromoCode, error) {
+	var p models.PromoCode
+	var roomIDs []byte
+
+	err := row.Scan(
+		&p.ID,
+		&p.Code,
+		&p.Kind,
+		&p.Amount,
+		&p.ValidFrom,
+		&p.ValidUntil,
+		&p.MaxUses,
+		&p.Uses,
+		&roomIDs,
+		&p.MinNights,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return p, err
+	}
+
+	if err = json.Unmarshal(roomIDs, &p.RoomIDs); err != nil {
+		return p, err
+	}
+	if len(p.RoomIDs) == 0 {
+		p.RoomIDs = nil
+	}
+
+	return p, nil
+}
+
+// AllPromoCodes returns every promo code, ordered by code
+func (m *postgresDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var codes []models.PromoCode
+
+	rows, err := m.DB.QueryContext(ctx, `select `+promoCodeColumns+` from promo_codes order by code`)
+	if err != nil {
+		return codes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPromoCode(rows)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+
+	return codes, nil
+}
+
+// GetPromoCodeByCode returns the promo code with the given code, which must already be normalized
+func (m *postgresDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `select `+promoCodeColumns+` from promo_codes where code = $1`, code)
+
+	return scanPromoCode(row)
+}
+
+// InsertPromoCode inserts a new promo code, returning repository.ErrDuplicateCode if the code is taken
+func (m *postgresDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	roomIDs, err := json.Marshal(p.RoomIDs)
+	if err != nil {
+		return 0, err
+	}
+	if p.RoomIDs == nil {
+		roomIDs = []byte("[]")
+	}
+
+	var newID int
+
+	stmt := `insert into promo_codes (code, kind, amount, valid_from, valid_until, max_uses, room_ids,
+			min_nights, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		p.Code,
+		p.Kind,
+		p.Amount,
+		p.ValidFrom,
+		p.ValidUntil,
+		p.MaxUses,
+		string(roomIDs),
+		p.MinNights,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	return newID, nil
+}
+
+// DeletePromoCode deletes a promo code; reservations that used it keep its code and discount
+func (m *postgresDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from promo_codes where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// apiKeyColumns are the api_keys columns read by scanAPIKey, in order
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, expires_at, last_used_at, user_id, created_at, updated_at`
+
+// scanAPIKey scans a row of apiKeyColumns, decoding the jsonb scope list
+func scanAPIKey(row interface{ Scan(...any) error }) (models.APIKey, error) {
+	var k models.APIKey
+	var scopes []byte
+	var expiresAt, lastUsedAt sql.NullTime
+	var userID sql.NullInt64
+
+	err := row.Scan(
+		&k.ID,
+		&k.Name,
+		&k.Prefix,
+		&k.Hash,
+		&scopes,
+		&expiresAt,
+		&lastUsedAt,
+		&userID,
+		&k.CreatedAt,
+		&k.UpdatedAt,
+	)
+	if err != nil {
+		return k, err
+	}
+
+	if err = json.Unmarshal(scopes, &k.Scopes); err != nil {
+		return k, err
+	}
+	k.ExpiresAt = expiresAt.Time
+	k.LastUsedAt = lastUsedAt.Time
+	k.UserID = int(userID.Int64)
+
+	return k, nil
+}
+
+// AllAPIKeys returns every API key, newest first
+func (m *postgresDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var keys []models.APIKey
+
+	rows, err := m.DB.QueryContext(ctx, `select `+apiKeyColumns+` from api_keys order by created_at desc, id desc`)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the API key whose hash is hash
+func (m *postgresDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `select `+apiKeyColumns+` from api_keys where key_hash = $1`, hash)
+
+	return scanAPIKey(row)
+}
+
+// InsertAPIKey inserts a new API key
+func (m *postgresDBRepo) InsertAPIKey(ctx context.Context, k models.APIKey) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	scopes, err := json.Marshal(k.Scopes)
+	if err != nil {
+		return 0, err
+	}
+	if k.Scopes == nil {
+		scopes = []byte("[]")
+	}
+
+	var expiresAt sql.NullTime
+	if !k.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: k.ExpiresAt, Valid: true}
+	}
+	var userID sql.NullInt64
+	if k.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(k.UserID), Valid: true}
+	}
+
+	var newID int
+
+	stmt := `insert into api_keys (name, prefix, key_hash, scopes, expires_at, user_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		k.Name,
+		k.Prefix,
+		k.Hash,
+		string(scopes),
+		expiresAt,
+		userID,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateLastUsedForAPIKey records that an API key was last used at at
+func (m *postgresDBRepo) UpdateLastUsedForAPIKey(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update api_keys set last_used_at = $1 where id = $2`, at, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteAPIKey deletes an API key, which stops working at once
+func (m *postgresDBRepo) DeleteAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from api_keys where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// paymentIntentColumns are the payment_intents columns read by scanPaymentIntent, in order
+const paymentIntentColumns = `id, reservation_id, provider, provider_ref, status, amount, captured, refunded,
+			currency, created_at, updated_at`
+
+// scanPaymentIntent scans a row of paymentIntentColumns
+func scanPaymentIntent(row interface{ Scan(...any) error }) (models.PaymentIntent, error) {
+	var p models.PaymentIntent
+
+	err := row.Scan(
+		&p.ID,
+		&p.ReservationID,
+		&p.Provider,
+		&p.ProviderRef,
+		&p.Status,
+		&p.Amount,
+		&p.Captured,
+		&p.Refunded,
+		&p.Currency,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	return p, err
+}
+
+// GetPaymentIntentsForReservation returns the payments taken for a reservation, oldest first
+func (m *postgresDBRepo) GetPaymentIntentsForReservation(ctx context.Context, reservationID int) ([]models.PaymentIntent, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var intents []models.PaymentIntent
+
+	query := `select ` + paymentIntentColumns + ` from payment_intents where reservation_id = $1 order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return intents, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPaymentIntent(rows)
+		if err != nil {
+			return intents, err
+		}
+		intents = append(intents, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return intents, err
+	}
+
+	return intents, nil
+}
+
+// GetPaymentIntentByRef returns the payment with the given provider's reference
+func (m *postgresDBRepo) GetPaymentIntentByRef(ctx context.Context, provider, ref string) (models.PaymentIntent, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + paymentIntentColumns + ` from payment_intents where provider = $1 and provider_ref = $2`
+	row := m.DB.QueryRowContext(ctx, query, provider, ref)
+
+	return scanPaymentIntent(row)
+}
+
+// UpdatePaymentIntent saves the status and amounts of a payment, as reported by its provider
+func (m *postgresDBRepo) UpdatePaymentIntent(ctx context.Context, p models.PaymentIntent) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update payment_intents set status = $1, captured = $2, refunded = $3, updated_at = $4
+			where id = $5`
+
+	_, err := m.DB.ExecContext(ctx, stmt, p.Status, p.Captured, p.Refunded, time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllRestrictions returns a slice of all restriction types, ordered by ID
+func (m *postgresDBRepo) AllRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var restrictions []models.Restriction
+
+	query := `select id, restriction_name, created_at, updated_at from restrictions order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Restriction
+		err = rows.Scan(&r.ID, &r.RestrictionName, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertRestriction inserts a new restriction type
+func (m *postgresDBRepo) InsertRestriction(ctx context.Context, r models.Restriction) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into restrictions (restriction_name, created_at, updated_at) values ($1, $2, $3) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, r.RestrictionName, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetRestrictionsForRoomByDate returns the restrictions for a room overlapping a date range
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	// owner blocks have no reservation, so reservation_id may be null
+	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
+			from room_restrictions
+			where $1 < end_date and $2 > start_date and room_id = $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err = rows.Scan(
+			&r.ID,
+			&r.ReservationID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts a one night owner block for a room
+func (m *postgresDBRepo) InsertBlockForRoom(ctx context.Context, roomID int, startDate time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	// restriction_id 2 is the "Owner Block" restriction type
+	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6)`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		startDate,
+		startDate.AddDate(0, 0, 1),
+		roomID,
+		2,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// DeleteBlockByID deletes an owner block given its room restriction ID, when it is the block of
+// roomID starting on startDate
+func (m *postgresDBRepo) DeleteBlockByID(ctx context.Context, id, roomID int, startDate time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	// only owner blocks may be removed this way, never reservations
+	_, err := m.DB.ExecContext(ctx, `delete from room_restrictions
+		where id = $1 and room_id = $2 and start_date = $3 and restriction_id = 2`, id, roomID, startDate)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TryLock takes a session level advisory lock on a connection of its own, which the lock is
+// released with. Postgres keys advisory locks by number, so name is hashed into one.
+func (m *postgresDBRepo) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	key := advisoryLockKey(name)
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	qctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var ok bool
+	err = conn.QueryRowContext(qctx, `select pg_try_advisory_lock($1)`, key).Scan(&ok)
+	if err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+
+	unlock := func() {
+		ctx, cancel := m.withTimeout(context.Background())
+		defer cancel()
+		if _, err := conn.ExecContext(ctx, `select pg_advisory_unlock($1)`, key); err != nil {
+			// closing the session releases its locks, so throw the connection away rather than
+			// returning it to the pool still holding the lock
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
+
+	return unlock, true, nil
+}
+
+// advisoryLockKey turns a lock name into the number Postgres advisory locks are keyed by
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
 }
@@ -0,0 +1,54 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/repository"
+	"github.com/jeremydelacruz/go-bookings/internal/repository/repotest"
+	"github.com/jeremydelacruz/go-bookings/internal/seed"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// openTestDB connects to the database in TEST_DATABASE_URL, skipping the test when it is not set
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping postgres tests")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// TestPostgresDBRepo_Conformance empties the TEST_DATABASE_URL database and reseeds its
+// reference data before every subtest, so never point it at real data
+func TestPostgresDBRepo_Conformance(t *testing.T) {
+	db := openTestDB(t)
+
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		_, err := db.Exec(`truncate room_restrictions, reservations, users, rate_overrides, stay_discounts, promo_codes, payment_intents, reservation_status_changes, sent_messages, api_keys, rooms, restrictions restart identity cascade`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo := NewPostgresRepo(db, &config.AppConfig{})
+		if _, _, err = seed.ReferenceData(context.Background(), repo); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
@@ -0,0 +1,24 @@
+package repository
+
+import "errors"
+
+// ErrRoomUnavailable is returned when a room is already restricted for some of the requested dates
+var ErrRoomUnavailable = errors.New("room is unavailable for the requested dates")
+
+// ErrDuplicateSlug is returned when a room is saved with a slug another room already uses
+var ErrDuplicateSlug = errors.New("room slug is already in use")
+
+// ErrInvalidCredentials is returned when an email and password do not match a user
+var ErrInvalidCredentials = errors.New("invalid login credentials")
+
+// ErrDuplicateCode is returned when a promo code is saved with a code another one already uses
+var ErrDuplicateCode = errors.New("promo code is already in use")
+
+// ErrPromoCodeUnavailable is returned when a reservation names a promo code that no longer
+// exists or has reached its usage limit
+var ErrPromoCodeUnavailable = errors.New("promo code is no longer available")
+
+// ErrStatusConflict is returned when a reservation is not in the status a change expects, such
+// as when it was moved on by someone else in the meantime, or when the dates of a reservation
+// that is no longer pending or confirmed are changed
+var ErrStatusConflict = errors.New("reservation status does not allow this change")
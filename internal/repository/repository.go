@@ -1,17 +1,80 @@
 package repository
 
 import (
+	"context"
 	"time"
 
 	"github.com/jeremydelacruz/go-bookings/internal/models"
 )
 
+// DatabaseRepo is the storage used by the handlers; every method takes the request context
+// so that cancelled requests also cancel their queries
 type DatabaseRepo interface {
-	AllUsers() bool
+	InsertUser(ctx context.Context, u models.User) (int, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
 
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	CreateReservation(ctx context.Context, res models.Reservation) (int, error)
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID, excludeReservationID int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+
+	AllReservations(ctx context.Context) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	GetReservationByCode(ctx context.Context, code string) (models.Reservation, error)
+	UpdateReservationStatus(ctx context.Context, change models.StatusChange) error
+	GetStatusChangesForReservation(ctx context.Context, reservationID int) ([]models.StatusChange, error)
+	ChangeReservationDates(ctx context.Context, id int, start, end time.Time, price models.Quote) error
+	UpdateReservation(ctx context.Context, res models.Reservation) error
+	DeleteReservation(ctx context.Context, id int) error
+	UpdateProcessedForReservation(ctx context.Context, id, processed int) error
+	GetReservationsArrivingBetween(ctx context.Context, start, end time.Time) ([]models.Reservation, error)
+	GetReservationsDepartingBetween(ctx context.Context, start, end time.Time) ([]models.Reservation, error)
+	RecordSentMessage(ctx context.Context, reservationID int, kind string) (bool, error)
+	DeleteSentMessage(ctx context.Context, reservationID int, kind string) error
+	GetSentMessagesForReservation(ctx context.Context, reservationID int) ([]models.SentMessage, error)
+
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	AllActiveRooms(ctx context.Context) ([]models.Room, error)
+	InsertRoom(ctx context.Context, rm models.Room) (int, error)
+	UpdateRoom(ctx context.Context, rm models.Room) error
+	UpdateActiveForRoom(ctx context.Context, id int, active bool) error
+	UpdateRoomOrder(ctx context.Context, ids []int) error
+
+	AllRateOverrides(ctx context.Context) ([]models.RateOverride, error)
+	GetRateOverridesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.RateOverride, error)
+	InsertRateOverride(ctx context.Context, o models.RateOverride) (int, error)
+	DeleteRateOverride(ctx context.Context, id int) error
+	AllStayDiscounts(ctx context.Context) ([]models.StayDiscount, error)
+	InsertStayDiscount(ctx context.Context, d models.StayDiscount) (int, error)
+	DeleteStayDiscount(ctx context.Context, id int) error
+
+	AllPromoCodes(ctx context.Context) ([]models.PromoCode, error)
+	GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error)
+	InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error)
+	DeletePromoCode(ctx context.Context, id int) error
+
+	AllAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	InsertAPIKey(ctx context.Context, k models.APIKey) (int, error)
+	UpdateLastUsedForAPIKey(ctx context.Context, id int, at time.Time) error
+	DeleteAPIKey(ctx context.Context, id int) error
+
+	GetPaymentIntentsForReservation(ctx context.Context, reservationID int) ([]models.PaymentIntent, error)
+	GetPaymentIntentByRef(ctx context.Context, provider, ref string) (models.PaymentIntent, error)
+	UpdatePaymentIntent(ctx context.Context, p models.PaymentIntent) error
+
+	AllRestrictions(ctx context.Context) ([]models.Restriction, error)
+	InsertRestriction(ctx context.Context, r models.Restriction) (int, error)
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(ctx context.Context, roomID int, startDate time.Time) error
+	DeleteBlockByID(ctx context.Context, id, roomID int, startDate time.Time) error
+
+	// TryLock takes the lock name shared by every instance using the database, returning
+	// false without waiting if it is already held
+	TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error)
 }
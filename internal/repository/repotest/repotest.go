@@ -0,0 +1,1784 @@
+// Package repotest is a conformance suite that every repository.DatabaseRepo implementation should pass
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Factory returns a repository for a single test. It must hold at least two rooms and the
+// Reservation (1) and Owner Block (2) restriction types, and no users, reservations or room restrictions.
+type Factory func(t *testing.T) repository.DatabaseRepo
+
+// Run runs the conformance suite, calling newRepo once per subtest
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, repository.DatabaseRepo)
+	}{
+		{"Rooms", testRooms},
+		{"ReferenceData", testReferenceData},
+		{"RoomManagement", testRoomManagement},
+		{"InactiveRooms", testInactiveRooms},
+		{"IDGeneration", testIDGeneration},
+		{"Overlaps", testOverlaps},
+		{"BackToBackStays", testBackToBackStays},
+		{"SameDayTurnover", testSameDayTurnover},
+		{"OwnerBlocks", testOwnerBlocks},
+		{"UpdateAndProcess", testUpdateAndProcess},
+		{"PriceSnapshot", testPriceSnapshot},
+		{"Pricing", testPricing},
+		{"PromoCodes", testPromoCodes},
+		{"PromoRedemption", testPromoRedemption},
+		{"PaymentIntents", testPaymentIntents},
+		{"ConfirmationCodes", testConfirmationCodes},
+		{"ReservationStatus", testReservationStatus},
+		{"ChangeDates", testChangeDates},
+		{"ScheduledMessages", testScheduledMessages},
+		{"Locks", testLocks},
+		{"DeleteReservation", testDeleteReservation},
+		{"Users", testUsers},
+		{"APIKeys", testAPIKeys},
+		{"Errors", testErrors},
+		{"ConcurrentBookings", testConcurrentBookings},
+		{"ConcurrentPromoRedemption", testConcurrentPromoRedemption},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newRepo(t))
+		})
+	}
+}
+
+// day returns the nth day after a base date far enough out that nothing else is booked
+func day(n int) time.Time {
+	return time.Date(2090, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, n)
+}
+
+// sameDate reports whether a and b fall on the same calendar date
+func sameDate(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+// twoRooms returns the IDs of two rooms from the repository
+func twoRooms(t *testing.T, repo repository.DatabaseRepo) (int, int) {
+	t.Helper()
+
+	rooms, err := repo.AllRooms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) < 2 {
+		t.Fatalf("expected at least two rooms, got %d", len(rooms))
+	}
+	return rooms[0].ID, rooms[1].ID
+}
+
+// roomIDs returns the IDs of rooms in order
+func roomIDs(rooms []models.Room) []int {
+	var ids []int
+	for _, rm := range rooms {
+		ids = append(ids, rm.ID)
+	}
+	return ids
+}
+
+// containsID reports whether ids holds id
+func containsID(ids []int, id int) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// book creates a reservation for roomID from day start to day end, failing the test on error
+func book(t *testing.T, repo repository.DatabaseRepo, roomID, start, end int) int {
+	t.Helper()
+
+	id, err := repo.CreateReservation(context.Background(), models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		Phone:     "1234567890",
+		StartDate: day(start),
+		EndDate:   day(end),
+		RoomID:    roomID,
+	})
+	if err != nil {
+		t.Fatalf("booking room %d from day %d to %d: %v", roomID, start, end, err)
+	}
+	return id
+}
+
+func testRooms(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	rooms, err := repo.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, rm := range rooms {
+		if i > 0 && rooms[i-1].SortOrder > rm.SortOrder {
+			t.Errorf("expected rooms in display order, got %s before %s", rooms[i-1].RoomName, rm.RoomName)
+		}
+		if !rm.Active {
+			t.Errorf("expected room %s to start out active", rm.RoomName)
+		}
+
+		got, err := repo.GetRoomByID(ctx, rm.ID)
+		if err != nil {
+			t.Errorf("GetRoomByID(%d): %v", rm.ID, err)
+			continue
+		}
+		if got.RoomName != rm.RoomName {
+			t.Errorf("GetRoomByID(%d): expected %s, got %s", rm.ID, rm.RoomName, got.RoomName)
+		}
+	}
+}
+
+func testReferenceData(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	restrictions, err := repo.AllRestrictions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) < 2 || restrictions[0].ID != 1 || restrictions[1].ID != 2 {
+		t.Fatalf("expected restriction types 1 and 2, got %+v", restrictions)
+	}
+
+	id, err := repo.InsertRestriction(ctx, models.Restriction{RestrictionName: "Maintenance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= restrictions[len(restrictions)-1].ID {
+		t.Errorf("expected a new restriction ID after %d, got %d", restrictions[len(restrictions)-1].ID, id)
+	}
+
+	cabin := models.Room{
+		RoomName:    "Colonel's Cabin",
+		Slug:        "colonels-cabin",
+		Description: "A cabin in the woods.",
+		Capacity:    3,
+		Amenities:   []string{"Wood stove", "Porch"},
+		Images:      []string{"/static/images/cabin.png", "/static/images/porch.png"},
+	}
+	roomID, err := repo.InsertRoom(ctx, cabin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm, err := repo.GetRoomByID(ctx, roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rm.RoomName != cabin.RoomName || rm.Slug != cabin.Slug || rm.Description != cabin.Description || rm.Capacity != cabin.Capacity {
+		t.Errorf("expected the inserted room, got %+v", rm)
+	}
+	if strings.Join(rm.Amenities, ",") != "Wood stove,Porch" || strings.Join(rm.Images, ",") != "/static/images/cabin.png,/static/images/porch.png" {
+		t.Errorf("expected amenities and images in order, got %v and %v", rm.Amenities, rm.Images)
+	}
+
+	bySlug, err := repo.GetRoomBySlug(ctx, "colonels-cabin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bySlug.ID != roomID {
+		t.Errorf("expected GetRoomBySlug to find room %d, got %d", roomID, bySlug.ID)
+	}
+
+	if _, err = repo.InsertRoom(ctx, models.Room{RoomName: "Another Cabin", Slug: "colonels-cabin"}); !errors.Is(err, repository.ErrDuplicateSlug) {
+		t.Errorf("expected inserting a duplicate slug to fail with ErrDuplicateSlug, got %v", err)
+	}
+
+	// a room without lists reads back with empty ones
+	bareID, err := repo.InsertRoom(ctx, models.Room{RoomName: "Bare Room", Slug: "bare-room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bare, err := repo.GetRoomByID(ctx, bareID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bare.Amenities) != 0 || len(bare.Images) != 0 {
+		t.Errorf("expected no amenities or images, got %v and %v", bare.Amenities, bare.Images)
+	}
+
+	// a new room is free on any date
+	rooms, err := repo.SearchAvailabilityForAllRooms(ctx, day(1), day(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, r := range rooms {
+		found = found || r.ID == roomID
+	}
+	if !found {
+		t.Error("expected the inserted room to be available")
+	}
+}
+
+func testRoomManagement(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	before, err := repo.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin", Slug: "colonels-cabin", Capacity: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new room is active and listed last
+	rooms, err := repo.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != len(before)+1 || rooms[len(rooms)-1].ID != id {
+		t.Fatalf("expected the new room to be listed last, got %v", roomIDs(rooms))
+	}
+	if !rooms[len(rooms)-1].Active {
+		t.Error("expected the new room to be active")
+	}
+
+	err = repo.UpdateRoom(ctx, models.Room{
+		ID:          id,
+		RoomName:    "Colonel's Lodge",
+		Slug:        "colonels-lodge",
+		Description: "Bigger than it looks.",
+		Capacity:    5,
+		Amenities:   []string{"Sauna"},
+		Images:      []string{"/uploads/lodge.jpg"},
+		NightlyRate: 25000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm, err := repo.GetRoomByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rm.RoomName != "Colonel's Lodge" || rm.Slug != "colonels-lodge" || rm.Description != "Bigger than it looks." || rm.Capacity != 5 || rm.NightlyRate != 25000 {
+		t.Errorf("expected the updated details, got %+v", rm)
+	}
+	if strings.Join(rm.Amenities, ",") != "Sauna" || strings.Join(rm.Images, ",") != "/uploads/lodge.jpg" {
+		t.Errorf("expected the updated lists, got %v and %v", rm.Amenities, rm.Images)
+	}
+	if !rm.Active {
+		t.Error("expected UpdateRoom to leave the room active")
+	}
+
+	// keeping its own slug is fine, taking another room's is not
+	rm.Description = "Cosy."
+	if err = repo.UpdateRoom(ctx, rm); err != nil {
+		t.Errorf("expected saving a room with its own slug to succeed, got %v", err)
+	}
+	rm.Slug = before[0].Slug
+	if err = repo.UpdateRoom(ctx, rm); !errors.Is(err, repository.ErrDuplicateSlug) {
+		t.Errorf("expected taking another room's slug to fail with ErrDuplicateSlug, got %v", err)
+	}
+
+	// a deactivated room keeps its page data but cannot be found by a search
+	if err = repo.UpdateActiveForRoom(ctx, id, false); err != nil {
+		t.Fatal(err)
+	}
+	active, err := repo.AllActiveRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsID(roomIDs(active), id) {
+		t.Error("expected AllActiveRooms to leave out the deactivated room")
+	}
+	available, err := repo.SearchAvailabilityForAllRooms(ctx, day(1), day(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsID(roomIDs(available), id) {
+		t.Error("expected SearchAvailabilityForAllRooms to leave out the deactivated room")
+	}
+	if len(available) != len(before) {
+		t.Errorf("expected the %d active rooms to be available, got %d", len(before), len(available))
+	}
+	rooms, err = repo.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsID(roomIDs(rooms), id) {
+		t.Error("expected AllRooms to include the deactivated room")
+	}
+	if rm, err = repo.GetRoomByID(ctx, id); err != nil || rm.Active {
+		t.Errorf("expected the room to read back inactive, got %+v, %v", rm, err)
+	}
+
+	if err = repo.UpdateActiveForRoom(ctx, id, true); err != nil {
+		t.Fatal(err)
+	}
+	available, err = repo.SearchAvailabilityForAllRooms(ctx, day(1), day(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsID(roomIDs(available), id) {
+		t.Error("expected a reactivated room to be available again")
+	}
+
+	// reordering puts the listed rooms first, and the rest after them in their old order
+	ids := roomIDs(rooms)
+	if err = repo.UpdateRoomOrder(ctx, []int{id, ids[1]}); err != nil {
+		t.Fatal(err)
+	}
+	rooms, err = repo.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{id, ids[1], ids[0]}
+	for _, x := range ids[2:] {
+		if x != id && x != ids[1] {
+			want = append(want, x)
+		}
+	}
+	if got := roomIDs(rooms); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected rooms in order %v, got %v", want, got)
+	}
+
+	// updates to missing rooms do nothing
+	if err = repo.UpdateRoom(ctx, models.Room{ID: 999999, RoomName: "Nowhere", Slug: "nowhere"}); err != nil {
+		t.Errorf("UpdateRoom for a missing room: %v", err)
+	}
+	if err = repo.UpdateActiveForRoom(ctx, 999999, false); err != nil {
+		t.Errorf("UpdateActiveForRoom for a missing room: %v", err)
+	}
+}
+
+func testInactiveRooms(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertRoom(ctx, models.Room{RoomName: "Closed Cabin", Slug: "closed-cabin", Capacity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.UpdateActiveForRoom(ctx, id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// an inactive room cannot be booked, however the request reaches the repository
+	res := models.Reservation{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", StartDate: day(1), EndDate: day(3), RoomID: id}
+	if _, err = repo.CreateReservation(ctx, res); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows booking an inactive room, got %v", err)
+	}
+
+	if err = repo.UpdateActiveForRoom(ctx, id, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.CreateReservation(ctx, res); err != nil {
+		t.Errorf("expected the reactivated room to be booked, got %v", err)
+	}
+}
+
+func testIDGeneration(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, roomB := twoRooms(t, repo)
+
+	first := book(t, repo, roomA, 1, 3)
+	second := book(t, repo, roomB, 1, 3)
+	third := book(t, repo, roomA, 5, 7)
+
+	if first <= 0 || second <= first || third <= second {
+		t.Errorf("expected positive, increasing reservation IDs, got %d, %d, %d", first, second, third)
+	}
+
+	res, err := repo.GetReservationByID(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != second || res.RoomID != roomB || res.FirstName != "Jane" || res.Email != "jane@doe.com" {
+		t.Errorf("unexpected reservation %+v", res)
+	}
+	if !sameDate(res.StartDate, day(1)) || !sameDate(res.EndDate, day(3)) {
+		t.Errorf("expected dates %s to %s, got %s to %s", day(1), day(3), res.StartDate, res.EndDate)
+	}
+	if res.Room.ID != roomB || res.Room.RoomName == "" {
+		t.Errorf("expected reservation to include its room, got %+v", res.Room)
+	}
+	if res.Processed != 0 {
+		t.Errorf("expected new reservation to be unprocessed, got %d", res.Processed)
+	}
+
+	all, err := repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("expected 3 reservations, got %d", len(all))
+	}
+}
+
+func testOverlaps(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, roomB := twoRooms(t, repo)
+
+	// roomA is booked for the nights of days 10, 11 and 12, checking out on day 13
+	book(t, repo, roomA, 10, 13)
+
+	var overlapTests = []struct {
+		name      string
+		start     int
+		end       int
+		available bool
+	}{
+		{"same dates", 10, 13, false},
+		{"starts on first night", 10, 11, false},
+		{"last night only", 12, 13, false},
+		{"starts inside", 11, 15, false},
+		{"ends inside", 8, 11, false},
+		{"encloses", 8, 15, false},
+		{"inside", 11, 12, false},
+		{"ends on check-in day", 8, 10, true},
+		{"starts on check-out day", 13, 15, true},
+		{"well before", 1, 5, true},
+		{"well after", 20, 25, true},
+	}
+
+	for _, test := range overlapTests {
+		available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, day(test.start), day(test.end), roomA, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available != test.available {
+			t.Errorf("for %s, expected room available %t but got %t", test.name, test.available, available)
+		}
+
+		rooms, err := repo.SearchAvailabilityForAllRooms(ctx, day(test.start), day(test.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var foundA, foundB bool
+		for _, rm := range rooms {
+			foundA = foundA || rm.ID == roomA
+			foundB = foundB || rm.ID == roomB
+		}
+		if foundA != test.available {
+			t.Errorf("for %s, expected room in all-rooms search %t but got %t", test.name, test.available, foundA)
+		}
+		if !foundB {
+			t.Errorf("for %s, expected the other room to be available", test.name)
+		}
+
+		restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomA, day(test.start), day(test.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(restrictions) == 0) != test.available {
+			t.Errorf("for %s, expected %t availability from restrictions, got %d restrictions", test.name, test.available, len(restrictions))
+		}
+
+		if !test.available {
+			_, err = repo.CreateReservation(ctx, models.Reservation{
+				FirstName: "John",
+				LastName:  "Smith",
+				Email:     "john@smith.com",
+				StartDate: day(test.start),
+				EndDate:   day(test.end),
+				RoomID:    roomA,
+			})
+			if !errors.Is(err, repository.ErrRoomUnavailable) {
+				t.Errorf("for %s, expected ErrRoomUnavailable but got %v", test.name, err)
+			}
+		}
+	}
+
+	all, err := repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Errorf("expected rejected bookings to leave no reservation behind, got %d reservations", len(all))
+	}
+}
+
+func testBackToBackStays(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	book(t, repo, roomA, 3, 5)
+	book(t, repo, roomA, 1, 3)
+	book(t, repo, roomA, 5, 8)
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomA, day(0), day(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 3 {
+		t.Errorf("expected 3 restrictions, got %d", len(restrictions))
+	}
+
+	available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, day(1), day(8), roomA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Error("expected room to be unavailable across the back-to-back stays")
+	}
+}
+
+func testSameDayTurnover(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	book(t, repo, roomA, 1, 3)
+
+	// an owner block takes a single night, so it can start on the check-out day but not the night before
+	if err := repo.InsertBlockForRoom(ctx, roomA, day(3)); err != nil {
+		t.Errorf("expected block on check-out day to succeed, got %v", err)
+	}
+	if err := repo.InsertBlockForRoom(ctx, roomA, day(2)); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected block on a booked night to fail with ErrRoomUnavailable, got %v", err)
+	}
+	if err := repo.InsertBlockForRoom(ctx, roomA, day(0)); err != nil {
+		t.Errorf("expected block ending on check-in day to succeed, got %v", err)
+	}
+
+	// the next guest can arrive the day after the block
+	book(t, repo, roomA, 4, 6)
+}
+
+func testOwnerBlocks(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, roomB := twoRooms(t, repo)
+
+	resID := book(t, repo, roomA, 1, 3)
+	if err := repo.InsertBlockForRoom(ctx, roomA, day(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomA, day(0), day(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 2 {
+		t.Fatalf("expected 2 restrictions, got %d", len(restrictions))
+	}
+
+	var reservationRestriction, block models.RoomRestriction
+	for _, r := range restrictions {
+		if r.RestrictionID == 2 {
+			block = r
+		} else {
+			reservationRestriction = r
+		}
+	}
+	if block.ID == 0 || block.ReservationID != 0 || block.RoomID != roomA {
+		t.Errorf("unexpected owner block %+v", block)
+	}
+	if !sameDate(block.StartDate, day(5)) || !sameDate(block.EndDate, day(6)) {
+		t.Errorf("expected block to cover one night, got %s to %s", block.StartDate, block.EndDate)
+	}
+	if reservationRestriction.RestrictionID != 1 || reservationRestriction.ReservationID != resID {
+		t.Errorf("unexpected reservation restriction %+v", reservationRestriction)
+	}
+
+	// DeleteBlockByID only removes owner blocks, and only of the room and day given
+	if err = repo.DeleteBlockByID(ctx, reservationRestriction.ID, roomA, reservationRestriction.StartDate); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeleteBlockByID(ctx, block.ID, roomB, day(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeleteBlockByID(ctx, block.ID, roomA, day(6)); err != nil {
+		t.Fatal(err)
+	}
+	restrictions, err = repo.GetRestrictionsForRoomByDate(ctx, roomA, day(0), day(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 2 {
+		t.Errorf("expected a block of another room or day to be kept, got %+v", restrictions)
+	}
+	if err = repo.DeleteBlockByID(ctx, block.ID, roomA, day(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, err = repo.GetRestrictionsForRoomByDate(ctx, roomA, day(0), day(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 || restrictions[0].ID != reservationRestriction.ID {
+		t.Errorf("expected only the reservation restriction to remain, got %+v", restrictions)
+	}
+}
+
+func testUpdateAndProcess(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	id := book(t, repo, roomA, 1, 3)
+	other := book(t, repo, roomA, 5, 7)
+
+	err := repo.UpdateReservation(ctx, models.Reservation{
+		ID:        id,
+		FirstName: "Janet",
+		LastName:  "Doe-Smith",
+		Email:     "janet@doe.com",
+		Phone:     "555",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FirstName != "Janet" || res.LastName != "Doe-Smith" || res.Email != "janet@doe.com" || res.Phone != "555" {
+		t.Errorf("update not applied: %+v", res)
+	}
+	if res.RoomID != roomA || !sameDate(res.StartDate, day(1)) {
+		t.Errorf("update must not change the room or dates: %+v", res)
+	}
+
+	if err = repo.UpdateProcessedForReservation(ctx, id, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	newRes, err := repo.AllNewReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newRes) != 1 || newRes[0].ID != other {
+		t.Errorf("expected only reservation %d to be new, got %+v", other, newRes)
+	}
+
+	all, err := repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected 2 reservations, got %d", len(all))
+	}
+	if all[0].ID != id || all[0].Processed != 1 || all[0].Room.RoomName == "" {
+		t.Errorf("expected reservations ordered by start date with their rooms, got %+v", all[0])
+	}
+}
+
+func testPriceSnapshot(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	price := models.Quote{
+		Nights: []models.NightPrice{
+			{Date: day(1), Rate: 12000, Name: "Standard rate"},
+			{Date: day(2), Rate: 15000, Name: "Weekend"},
+		},
+		Subtotal:        27000,
+		DiscountName:    "Long stay",
+		DiscountPercent: 10,
+		Discount:        2700,
+		TaxRate:         1300,
+		Taxes:           3510,
+		Total:           27810,
+	}
+
+	id, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: day(1),
+		EndDate:   day(3),
+		RoomID:    roomA,
+		Price:     price,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := res.Price
+	if got.Subtotal != price.Subtotal || got.DiscountName != price.DiscountName || got.DiscountPercent != price.DiscountPercent ||
+		got.Discount != price.Discount || got.TaxRate != price.TaxRate || got.Taxes != price.Taxes || got.Total != price.Total {
+		t.Errorf("expected the stored price %+v, got %+v", price, got)
+	}
+	if len(got.Nights) != 2 || !sameDate(got.Nights[1].Date, day(2)) || got.Nights[1].Rate != 15000 || got.Nights[1].Name != "Weekend" {
+		t.Errorf("expected the stored nightly lines %+v, got %+v", price.Nights, got.Nights)
+	}
+
+	// editing the guest details keeps the price the guest was quoted
+	res.FirstName = "Janet"
+	if err = repo.UpdateReservation(ctx, res); err != nil {
+		t.Fatal(err)
+	}
+	all, err := repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Price.Total != price.Total || len(all[0].Price.Nights) != 2 {
+		t.Errorf("expected the price to survive an update, got %+v", all)
+	}
+
+	// a reservation made without a quote reads back with an empty price
+	other := book(t, repo, roomA, 5, 7)
+	res, err = repo.GetReservationByID(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Price.Total != 0 || len(res.Price.Nights) != 0 {
+		t.Errorf("expected an empty price, got %+v", res.Price)
+	}
+}
+
+func testPricing(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, roomB := twoRooms(t, repo)
+
+	summer, err := repo.InsertRateOverride(ctx, models.RateOverride{
+		RoomID:      roomA,
+		Name:        "Summer",
+		StartDate:   day(10),
+		EndDate:     day(20),
+		NightlyRate: 20000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weekend, err := repo.InsertRateOverride(ctx, models.RateOverride{
+		RoomID:      roomA,
+		Name:        "Weekend",
+		StartDate:   day(1),
+		EndDate:     day(30),
+		Weekdays:    []time.Weekday{time.Friday, time.Saturday},
+		NightlyRate: 22000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertRateOverride(ctx, models.RateOverride{
+		RoomID:      roomB,
+		Name:        "Summer",
+		StartDate:   day(10),
+		EndDate:     day(20),
+		NightlyRate: 30000,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := repo.AllRateOverrides(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].ID != weekend || all[1].ID != summer || all[2].RoomID != roomB {
+		t.Fatalf("expected the overrides ordered by room and start date, got %+v", all)
+	}
+	if all[0].Room.RoomName == "" {
+		t.Error("expected the overrides to carry their room name")
+	}
+	if len(all[0].Weekdays) != 2 || all[0].Weekdays[0] != time.Friday || all[0].Weekdays[1] != time.Saturday {
+		t.Errorf("expected the weekend override to apply on Friday and Saturday, got %v", all[0].Weekdays)
+	}
+	if len(all[1].Weekdays) != 0 {
+		t.Errorf("expected the summer override to apply every day, got %v", all[1].Weekdays)
+	}
+	if !sameDate(all[1].StartDate, day(10)) || !sameDate(all[1].EndDate, day(20)) || all[1].NightlyRate != 20000 || all[1].Name != "Summer" {
+		t.Errorf("expected the summer override as inserted, got %+v", all[1])
+	}
+
+	// the end date is exclusive, like a reservation's
+	tests := []struct {
+		name       string
+		start, end int
+		want       []int
+	}{
+		{"before the season", 3, 5, []int{weekend}},
+		{"checking out on the first night", 8, 10, []int{weekend}},
+		{"spanning the season", 8, 22, []int{weekend, summer}},
+		{"arriving on the day it ends", 20, 25, []int{weekend}},
+		{"after both", 30, 32, nil},
+	}
+	for _, tt := range tests {
+		got, err := repo.GetRateOverridesForRoom(ctx, roomA, day(tt.start), day(tt.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, o := range got {
+			ids = append(ids, o.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: expected overrides %v, got %v", tt.name, tt.want, ids)
+		}
+	}
+
+	if err = repo.DeleteRateOverride(ctx, summer); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetRateOverridesForRoom(ctx, roomA, day(8), day(22))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != weekend {
+		t.Errorf("expected only the weekend override after deleting the season, got %+v", got)
+	}
+
+	if _, err = repo.InsertRateOverride(ctx, models.RateOverride{RoomID: roomA, Name: "Backwards", StartDate: day(5), EndDate: day(5)}); err == nil {
+		t.Error("expected an override that ends on its first day to be rejected")
+	}
+
+	week, err := repo.InsertStayDiscount(ctx, models.StayDiscount{Name: "Week", MinNights: 7, Percent: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	longWeekend, err := repo.InsertStayDiscount(ctx, models.StayDiscount{Name: "Long weekend", MinNights: 3, Percent: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	discounts, err := repo.AllStayDiscounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discounts) != 2 || discounts[0].ID != longWeekend || discounts[1].ID != week {
+		t.Fatalf("expected the discounts ordered by minimum stay, got %+v", discounts)
+	}
+	if discounts[1].Name != "Week" || discounts[1].MinNights != 7 || discounts[1].Percent != 10 {
+		t.Errorf("expected the week discount as inserted, got %+v", discounts[1])
+	}
+	if _, err = repo.InsertStayDiscount(ctx, models.StayDiscount{Name: "Free", MinNights: 1, Percent: 101}); err == nil {
+		t.Error("expected a discount over 100 percent to be rejected")
+	}
+
+	if err = repo.DeleteStayDiscount(ctx, week); err != nil {
+		t.Fatal(err)
+	}
+	discounts, err = repo.AllStayDiscounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discounts) != 1 || discounts[0].ID != longWeekend {
+		t.Errorf("expected only the long weekend discount to remain, got %+v", discounts)
+	}
+}
+
+func testPromoCodes(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, roomB := twoRooms(t, repo)
+
+	summer := models.PromoCode{
+		Code:       "SUMMER10",
+		Kind:       models.PromoPercent,
+		Amount:     10,
+		ValidFrom:  day(1),
+		ValidUntil: day(90),
+		MaxUses:    100,
+		RoomIDs:    []int{roomA, roomB},
+		MinNights:  2,
+	}
+	id, err := repo.InsertPromoCode(ctx, summer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertPromoCode(ctx, models.PromoCode{Code: "FIVEOFF", Kind: models.PromoFixed, Amount: 500, ValidFrom: day(1), ValidUntil: day(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := repo.GetPromoCodeByCode(ctx, "SUMMER10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != id || p.Kind != models.PromoPercent || p.Amount != 10 || p.MaxUses != 100 || p.Uses != 0 || p.MinNights != 2 {
+		t.Errorf("expected the promo code as inserted, got %+v", p)
+	}
+	if !sameDate(p.ValidFrom, day(1)) || !sameDate(p.ValidUntil, day(90)) {
+		t.Errorf("expected the validity window as inserted, got %s to %s", p.ValidFrom, p.ValidUntil)
+	}
+	if fmt.Sprint(p.RoomIDs) != fmt.Sprint([]int{roomA, roomB}) {
+		t.Errorf("expected rooms %v, got %v", summer.RoomIDs, p.RoomIDs)
+	}
+
+	all, err := repo.AllPromoCodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Code != "FIVEOFF" || all[1].Code != "SUMMER10" {
+		t.Fatalf("expected the promo codes ordered by code, got %+v", all)
+	}
+	if len(all[0].RoomIDs) != 0 {
+		t.Errorf("expected a code for every room to have no room list, got %v", all[0].RoomIDs)
+	}
+
+	if _, err = repo.InsertPromoCode(ctx, summer); !errors.Is(err, repository.ErrDuplicateCode) {
+		t.Errorf("expected reusing a code to fail with ErrDuplicateCode, got %v", err)
+	}
+	if _, err = repo.InsertPromoCode(ctx, models.PromoCode{Code: "HALFPLUS", Kind: models.PromoPercent, Amount: 150, ValidFrom: day(1), ValidUntil: day(2)}); err == nil {
+		t.Error("expected a discount over 100 percent to be rejected")
+	}
+	if _, err = repo.InsertPromoCode(ctx, models.PromoCode{Code: "BACKWARDS", Kind: models.PromoFixed, Amount: 100, ValidFrom: day(2), ValidUntil: day(1)}); err == nil {
+		t.Error("expected a code that expires before it starts to be rejected")
+	}
+
+	if err = repo.DeletePromoCode(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.GetPromoCodeByCode(ctx, "SUMMER10"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted code, got %v", err)
+	}
+}
+
+func testPromoRedemption(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	if _, err := repo.InsertPromoCode(ctx, models.PromoCode{
+		Code:       "TWICE",
+		Kind:       models.PromoFixed,
+		Amount:     1000,
+		ValidFrom:  day(1),
+		ValidUntil: day(90),
+		MaxUses:    2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	withCode := func(start, end int) models.Reservation {
+		return models.Reservation{
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     "jane@doe.com",
+			StartDate: day(start),
+			EndDate:   day(end),
+			RoomID:    roomA,
+			PromoCode: "TWICE",
+		}
+	}
+
+	id, err := repo.CreateReservation(ctx, withCode(1, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PromoCode != "TWICE" {
+		t.Errorf("expected the reservation to record its promo code, got %q", res.PromoCode)
+	}
+
+	// a booking that fails for its dates must not use up the code
+	if _, err = repo.CreateReservation(ctx, withCode(2, 4)); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Fatalf("expected ErrRoomUnavailable, got %v", err)
+	}
+	p, err := repo.GetPromoCodeByCode(ctx, "TWICE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Uses != 1 {
+		t.Errorf("expected 1 use after a failed booking, got %d", p.Uses)
+	}
+
+	if _, err = repo.CreateReservation(ctx, withCode(5, 7)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.CreateReservation(ctx, withCode(10, 12)); !errors.Is(err, repository.ErrPromoCodeUnavailable) {
+		t.Errorf("expected a third use to fail with ErrPromoCodeUnavailable, got %v", err)
+	}
+	available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, day(10), day(12), roomA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("expected a booking refused for its promo code to leave the room available")
+	}
+
+	res = withCode(10, 12)
+	res.PromoCode = "NOSUCHCODE"
+	if _, err = repo.CreateReservation(ctx, res); !errors.Is(err, repository.ErrPromoCodeUnavailable) {
+		t.Errorf("expected an unknown code to fail with ErrPromoCodeUnavailable, got %v", err)
+	}
+}
+
+func testPaymentIntents(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	withDeposit := func(start, end int, ref string) models.Reservation {
+		return models.Reservation{
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     "jane@doe.com",
+			StartDate: day(start),
+			EndDate:   day(end),
+			RoomID:    roomA,
+			Payment: models.PaymentIntent{
+				Provider:    "fake",
+				ProviderRef: ref,
+				Status:      models.PaymentAuthorized,
+				Amount:      5000,
+				Currency:    "usd",
+			},
+		}
+	}
+
+	id, err := repo.CreateReservation(ctx, withDeposit(1, 3, "pi_1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intents, err := repo.GetPaymentIntentsForReservation(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 1 {
+		t.Fatalf("expected 1 payment for the reservation, got %d", len(intents))
+	}
+	p := intents[0]
+	if p.ID == 0 || p.ReservationID != id || p.ProviderRef != "pi_1" || p.Status != models.PaymentAuthorized || p.Amount != 5000 || p.Currency != "usd" {
+		t.Errorf("unexpected payment %+v", p)
+	}
+
+	p.Status = models.PaymentCaptured
+	p.Captured = 5000
+	if err = repo.UpdatePaymentIntent(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetPaymentIntentByRef(ctx, "fake", "pi_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != p.ID || got.Status != models.PaymentCaptured || got.Captured != 5000 {
+		t.Errorf("expected the update to be saved, got %+v", got)
+	}
+
+	if _, err = repo.GetPaymentIntentByRef(ctx, "other", "pi_1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for another provider's reference, got %v", err)
+	}
+
+	// a booking that fails records no payment, so the caller can release the hold
+	if _, err = repo.CreateReservation(ctx, withDeposit(2, 4, "pi_2")); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Fatalf("expected ErrRoomUnavailable, got %v", err)
+	}
+	if _, err = repo.GetPaymentIntentByRef(ctx, "fake", "pi_2"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no payment for a failed booking, got %v", err)
+	}
+
+	// bookings without a deposit have no payments
+	other := book(t, repo, roomA, 5, 7)
+	intents, err = repo.GetPaymentIntentsForReservation(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 0 {
+		t.Errorf("expected no payments, got %+v", intents)
+	}
+
+	if err = repo.DeleteReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.GetPaymentIntentByRef(ctx, "fake", "pi_1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected payments to be deleted with their reservation, got %v", err)
+	}
+}
+
+func testConfirmationCodes(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, roomB := twoRooms(t, repo)
+
+	// a code is made for reservations saved without one
+	first, err := repo.GetReservationByID(ctx, book(t, repo, roomA, 1, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.GetReservationByID(ctx, book(t, repo, roomB, 1, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ConfirmationCode == "" || first.ConfirmationCode == second.ConfirmationCode {
+		t.Errorf("expected distinct confirmation codes, got %q and %q", first.ConfirmationCode, second.ConfirmationCode)
+	}
+
+	res, err := repo.GetReservationByCode(ctx, second.ConfirmationCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != second.ID || res.Room.ID != roomB || res.Room.RoomName == "" {
+		t.Errorf("expected reservation %d with its room, got %+v", second.ID, res)
+	}
+
+	// a code chosen by the caller is kept, and must be unique
+	id, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName:        "Jane",
+		LastName:         "Doe",
+		Email:            "jane@doe.com",
+		StartDate:        day(5),
+		EndDate:          day(7),
+		RoomID:           roomA,
+		ConfirmationCode: "ABCDEFGHJKLM",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _ = repo.GetReservationByCode(ctx, "ABCDEFGHJKLM"); res.ID != id {
+		t.Errorf("expected the given code to be saved, got reservation %d", res.ID)
+	}
+	if _, err = repo.InsertReservation(ctx, models.Reservation{
+		FirstName:        "John",
+		LastName:         "Smith",
+		Email:            "john@smith.com",
+		StartDate:        day(10),
+		EndDate:          day(12),
+		RoomID:           roomA,
+		ConfirmationCode: "ABCDEFGHJKLM",
+	}); err == nil {
+		t.Error("expected a duplicate confirmation code to be refused")
+	}
+
+	if _, err = repo.GetReservationByCode(ctx, "NOSUCHCODE"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown code, got %v", err)
+	}
+}
+
+func testReservationStatus(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	userID, err := repo.InsertUser(ctx, models.User{FirstName: "Admin", LastName: "User", Email: "status@here.ca", Password: "x", AccessLevel: models.AccessLevelAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := book(t, repo, roomA, 1, 3)
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != models.ReservationPending {
+		t.Errorf("expected a new reservation to be pending, got %q", res.Status)
+	}
+
+	confirm := models.StatusChange{
+		ReservationID: id,
+		FromStatus:    models.ReservationPending,
+		ToStatus:      models.ReservationConfirmed,
+		Actor:         "staff",
+		UserID:        userID,
+		CreatedAt:     time.Date(2089, 12, 1, 10, 0, 0, 0, time.UTC),
+	}
+	if err = repo.UpdateReservationStatus(ctx, confirm); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ = repo.GetReservationByID(ctx, id); res.Status != models.ReservationConfirmed || !res.CancelledAt.IsZero() {
+		t.Errorf("expected the reservation to be confirmed, got %q", res.Status)
+	}
+	available, _ := repo.SearchAvailabilityByDatesByRoomID(ctx, day(1), day(3), roomA, 0)
+	if available {
+		t.Error("expected a confirmed reservation to hold its room")
+	}
+
+	// a change made from a status the reservation has already left is refused
+	if err = repo.UpdateReservationStatus(ctx, confirm); !errors.Is(err, repository.ErrStatusConflict) {
+		t.Errorf("expected ErrStatusConflict for a stale change, got %v", err)
+	}
+
+	cancel := models.StatusChange{
+		ReservationID: id,
+		FromStatus:    models.ReservationConfirmed,
+		ToStatus:      models.ReservationCancelled,
+		Actor:         "guest",
+	}
+	if err = repo.UpdateReservationStatus(ctx, cancel); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reservation is kept, marked as cancelled, and its dates can be booked again
+	if res, err = repo.GetReservationByID(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != models.ReservationCancelled || res.CancelledAt.IsZero() {
+		t.Errorf("expected the cancellation to be recorded, got %q at %s", res.Status, res.CancelledAt)
+	}
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomA, day(1), day(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 0 {
+		t.Errorf("expected the room restriction to be deleted, got %+v", restrictions)
+	}
+	book(t, repo, roomA, 1, 3)
+
+	changes, err := repo.GetStatusChangesForReservation(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 status changes, got %+v", changes)
+	}
+	first := changes[0]
+	if first.ID == 0 || first.FromStatus != models.ReservationPending || first.ToStatus != models.ReservationConfirmed ||
+		first.Actor != "staff" || first.UserID != userID || !first.CreatedAt.Equal(confirm.CreatedAt) {
+		t.Errorf("expected the confirmation to be recorded as made, got %+v", first)
+	}
+	if changes[1].ToStatus != models.ReservationCancelled || changes[1].UserID != 0 || changes[1].CreatedAt.IsZero() {
+		t.Errorf("expected the guest's cancellation to be recorded, got %+v", changes[1])
+	}
+
+	if err = repo.ChangeReservationDates(ctx, id, day(20), day(22), models.Quote{}); !errors.Is(err, repository.ErrStatusConflict) {
+		t.Errorf("expected ErrStatusConflict when moving a cancelled reservation, got %v", err)
+	}
+	cancel.ReservationID = 9999
+	if err = repo.UpdateReservationStatus(ctx, cancel); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing reservation, got %v", err)
+	}
+
+	// deleting the reservation deletes its history
+	if err = repo.DeleteReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if changes, _ = repo.GetStatusChangesForReservation(ctx, id); len(changes) != 0 {
+		t.Errorf("expected the status changes to be deleted with the reservation, got %+v", changes)
+	}
+}
+
+func testChangeDates(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	id := book(t, repo, roomA, 1, 4)
+	book(t, repo, roomA, 6, 8)
+
+	// the reservation's own restriction only counts when it is not excluded
+	available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, day(2), day(5), roomA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Error("expected the room to be unavailable over the existing booking")
+	}
+	available, err = repo.SearchAvailabilityByDatesByRoomID(ctx, day(2), day(5), roomA, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("expected the room to be available once the booking's own restriction is excluded")
+	}
+
+	// moving onto dates overlapping the old ones
+	price := models.Quote{Subtotal: 30000, Total: 30000}
+	if err = repo.ChangeReservationDates(ctx, id, day(2), day(5), price); err != nil {
+		t.Fatal(err)
+	}
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameDate(res.StartDate, day(2)) || !sameDate(res.EndDate, day(5)) || res.Price.Total != 30000 {
+		t.Errorf("expected the new dates and price to be saved, got %s to %s at %+v", res.StartDate, res.EndDate, res.Price)
+	}
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomA, day(0), day(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 || !sameDate(restrictions[0].StartDate, day(2)) || !sameDate(restrictions[0].EndDate, day(5)) {
+		t.Errorf("expected the restriction to move with the reservation, got %+v", restrictions)
+	}
+
+	// another booking is in the way, so nothing changes
+	if err = repo.ChangeReservationDates(ctx, id, day(5), day(7), models.Quote{}); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Fatalf("expected ErrRoomUnavailable, got %v", err)
+	}
+	if res, _ = repo.GetReservationByID(ctx, id); !sameDate(res.StartDate, day(2)) || res.Price.Total != 30000 {
+		t.Errorf("expected a refused change to leave the reservation alone, got %+v", res)
+	}
+
+	// a room taken out of use cannot take the stay on new dates, free as they are
+	if err = repo.UpdateActiveForRoom(ctx, roomA, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.ChangeReservationDates(ctx, id, day(10), day(12), models.Quote{}); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected ErrRoomUnavailable for an inactive room, got %v", err)
+	}
+	if err = repo.UpdateActiveForRoom(ctx, roomA, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.ChangeReservationDates(ctx, 9999, day(20), day(22), models.Quote{}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing reservation, got %v", err)
+	}
+}
+
+func testScheduledMessages(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, roomB := twoRooms(t, repo)
+
+	early := book(t, repo, roomA, 1, 3)
+	late := book(t, repo, roomB, 2, 5)
+	cancelled := book(t, repo, roomA, 3, 4)
+	if err := repo.UpdateReservationStatus(ctx, models.StatusChange{
+		ReservationID: cancelled,
+		FromStatus:    models.ReservationPending,
+		ToStatus:      models.ReservationCancelled,
+		Actor:         "guest",
+		CreatedAt:     time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(reservations []models.Reservation) []int {
+		var ids []int
+		for _, res := range reservations {
+			ids = append(ids, res.ID)
+		}
+		return ids
+	}
+
+	// cancelled reservations are never found
+	var windowTests = []struct {
+		name       string
+		query      func(context.Context, time.Time, time.Time) ([]models.Reservation, error)
+		start, end int
+		expected   []int
+	}{
+		{"arriving on day 1", repo.GetReservationsArrivingBetween, 1, 2, []int{early}},
+		{"arriving days 1 to 3", repo.GetReservationsArrivingBetween, 1, 4, []int{early, late}},
+		{"arriving later", repo.GetReservationsArrivingBetween, 4, 10, nil},
+		{"departing days 3 to 4", repo.GetReservationsDepartingBetween, 3, 5, []int{early}},
+		{"departing days 0 to 5", repo.GetReservationsDepartingBetween, 0, 6, []int{early, late}},
+	}
+
+	for _, test := range windowTests {
+		reservations, err := test.query(ctx, day(test.start), day(test.end))
+		if err != nil {
+			t.Errorf("for %s, unexpected error %v", test.name, err)
+			continue
+		}
+		if fmt.Sprint(ids(reservations)) != fmt.Sprint(test.expected) {
+			t.Errorf("for %s, expected reservations %v but got %v", test.name, test.expected, ids(reservations))
+		}
+		for _, res := range reservations {
+			if res.Room.RoomName == "" {
+				t.Errorf("for %s, expected reservation %d to come with its room", test.name, res.ID)
+			}
+		}
+	}
+
+	var recordTests = []struct {
+		name          string
+		reservationID int
+		kind          string
+		expected      bool
+	}{
+		{"first reminder", early, models.MessageArrivalReminder, true},
+		{"reminder again", early, models.MessageArrivalReminder, false},
+		{"other kind", early, models.MessageFollowUp, true},
+		{"other reservation", late, models.MessageArrivalReminder, true},
+	}
+
+	for _, test := range recordTests {
+		recorded, err := repo.RecordSentMessage(ctx, test.reservationID, test.kind)
+		if err != nil {
+			t.Errorf("for %s, unexpected error %v", test.name, err)
+			continue
+		}
+		if recorded != test.expected {
+			t.Errorf("for %s, expected %t but got %t", test.name, test.expected, recorded)
+		}
+	}
+
+	if _, err := repo.RecordSentMessage(ctx, 99999, models.MessageFollowUp); err == nil {
+		t.Error("expected an error recording a message about a missing reservation")
+	}
+
+	sent, err := repo.GetSentMessagesForReservation(ctx, early)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || sent[0].Kind != models.MessageArrivalReminder || sent[1].Kind != models.MessageFollowUp || sent[0].CreatedAt.IsZero() {
+		t.Errorf("unexpected sent messages %+v", sent)
+	}
+
+	// a message that never went out can be recorded again
+	if err = repo.DeleteSentMessage(ctx, late, models.MessageArrivalReminder); err != nil {
+		t.Fatal(err)
+	}
+	if recorded, err := repo.RecordSentMessage(ctx, late, models.MessageArrivalReminder); err != nil || !recorded {
+		t.Errorf("expected the deleted message to be recorded again, got %t (%v)", recorded, err)
+	}
+
+	// the records go with the reservation
+	if err = repo.DeleteReservation(ctx, early); err != nil {
+		t.Fatal(err)
+	}
+	if sent, _ = repo.GetSentMessagesForReservation(ctx, early); len(sent) != 0 {
+		t.Errorf("expected the sent messages to be deleted with the reservation, got %+v", sent)
+	}
+}
+
+func testLocks(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	unlock, ok, err := repo.TryLock(ctx, "job")
+	if err != nil || !ok {
+		t.Fatalf("expected to take a free lock, got %t and %v", ok, err)
+	}
+
+	if _, ok, err = repo.TryLock(ctx, "job"); err != nil || ok {
+		t.Errorf("expected a held lock to be refused, got %t and %v", ok, err)
+	}
+
+	otherUnlock, ok, err := repo.TryLock(ctx, "other job")
+	if err != nil || !ok {
+		t.Errorf("expected locks with other names to be free, got %t and %v", ok, err)
+	} else {
+		otherUnlock()
+	}
+
+	unlock()
+	unlock, ok, err = repo.TryLock(ctx, "job")
+	if err != nil || !ok {
+		t.Fatalf("expected to take the lock again once released, got %t and %v", ok, err)
+	}
+	unlock()
+}
+
+func testDeleteReservation(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	id := book(t, repo, roomA, 1, 3)
+	if err := repo.DeleteReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GetReservationByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted reservation, got %v", err)
+	}
+
+	// deleting a reservation also removes its restriction, so the dates can be booked again
+	available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, day(1), day(3), roomA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("expected dates of a deleted reservation to be available")
+	}
+	book(t, repo, roomA, 1, 3)
+}
+
+func testAPIKeys(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	userID, err := repo.InsertUser(ctx, models.User{FirstName: "Admin", LastName: "User", Email: "keys@here.ca", Password: "x", AccessLevel: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Date(2090, 1, 1, 12, 0, 0, 0, time.UTC)
+	partner := models.APIKey{
+		Name:      "Partner",
+		Prefix:    "bk_partner",
+		Hash:      strings.Repeat("a", 64),
+		Scopes:    []string{models.ScopeReadAvailability, models.ScopeWriteReservations},
+		ExpiresAt: expires,
+		UserID:    userID,
+	}
+	partnerID, err := repo.InsertAPIKey(ctx, partner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appID, err := repo.InsertAPIKey(ctx, models.APIKey{Name: "App", Prefix: "bk_app", Hash: strings.Repeat("b", 64)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = repo.InsertAPIKey(ctx, models.APIKey{Name: "Copy", Prefix: "bk_copy", Hash: partner.Hash}); err == nil {
+		t.Error("expected inserting a duplicate key hash to fail")
+	}
+
+	got, err := repo.GetAPIKeyByHash(ctx, partner.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != partnerID || got.Name != "Partner" || got.Prefix != "bk_partner" || got.UserID != userID ||
+		!got.ExpiresAt.Equal(expires) || !got.LastUsedAt.IsZero() || got.CreatedAt.IsZero() {
+		t.Errorf("unexpected API key %+v", got)
+	}
+	if len(got.Scopes) != 2 || got.Scopes[0] != models.ScopeReadAvailability || got.Scopes[1] != models.ScopeWriteReservations {
+		t.Errorf("expected the key's scopes to be kept in order, got %v", got.Scopes)
+	}
+	if _, err = repo.GetAPIKeyByHash(ctx, strings.Repeat("c", 64)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown hash, got %v", err)
+	}
+
+	used := time.Date(2089, 6, 1, 8, 30, 0, 0, time.UTC)
+	if err = repo.UpdateLastUsedForAPIKey(ctx, appID, used); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := repo.AllAPIKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != appID || keys[1].ID != partnerID {
+		t.Fatalf("expected the keys newest first, got %+v", keys)
+	}
+	if !keys[0].LastUsedAt.Equal(used) || !keys[0].ExpiresAt.IsZero() || keys[0].UserID != 0 || len(keys[0].Scopes) != 0 {
+		t.Errorf("unexpected API key %+v", keys[0])
+	}
+
+	// reservations remember the key that made them, until it is deleted
+	roomA, _ := twoRooms(t, repo)
+	resID, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: day(1),
+		EndDate:   day(3),
+		RoomID:    roomA,
+		APIKeyID:  partnerID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := repo.GetReservationByID(ctx, resID); res.APIKeyID != partnerID {
+		t.Errorf("expected the reservation to record key %d, got %d", partnerID, res.APIKeyID)
+	}
+
+	if err = repo.DeleteAPIKey(ctx, partnerID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.GetAPIKeyByHash(ctx, partner.Hash); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a deleted key to be gone, got %v", err)
+	}
+	if res, _ := repo.GetReservationByID(ctx, resID); res.APIKeyID != 0 {
+		t.Errorf("expected the deleted key to be cleared from its reservation, got %d", res.APIKeyID)
+	}
+}
+
+func testUsers(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{
+		FirstName:   "Admin",
+		LastName:    "User",
+		Email:       "admin@here.ca",
+		Password:    string(hash),
+		AccessLevel: 3,
+	}
+	id, err := repo.InsertUser(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= 0 {
+		t.Errorf("expected a positive user ID, got %d", id)
+	}
+
+	got, err := repo.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != user.Email || got.AccessLevel != user.AccessLevel {
+		t.Errorf("unexpected user %+v", got)
+	}
+
+	if _, err = repo.InsertUser(ctx, user); err == nil {
+		t.Error("expected inserting a duplicate email to fail")
+	}
+
+	authID, _, err := repo.Authenticate(ctx, user.Email, "password")
+	if err != nil || authID != id {
+		t.Errorf("expected to authenticate as %d, got %d, %v", id, authID, err)
+	}
+
+	var authTests = []struct {
+		name     string
+		email    string
+		password string
+	}{
+		{"wrong password", user.Email, "wrong"},
+		{"unknown email", "nobody@here.ca", "password"},
+	}
+	for _, test := range authTests {
+		_, _, err = repo.Authenticate(ctx, test.email, test.password)
+		if !errors.Is(err, repository.ErrInvalidCredentials) {
+			t.Errorf("for %s, expected ErrInvalidCredentials but got %v", test.name, err)
+		}
+	}
+}
+
+func testErrors(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	roomA, _ := twoRooms(t, repo)
+
+	const missingID = 999999
+
+	if _, err := repo.GetReservationByID(ctx, missingID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetReservationByID: expected sql.ErrNoRows, got %v", err)
+	}
+	if _, err := repo.GetRoomByID(ctx, missingID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetRoomByID: expected sql.ErrNoRows, got %v", err)
+	}
+	if _, err := repo.GetRoomBySlug(ctx, "no-such-room"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetRoomBySlug: expected sql.ErrNoRows, got %v", err)
+	}
+	if _, err := repo.GetUserByID(ctx, missingID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByID: expected sql.ErrNoRows, got %v", err)
+	}
+
+	_, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: day(1),
+		EndDate:   day(3),
+		RoomID:    missingID,
+	})
+	if err == nil || errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("CreateReservation for a missing room: expected a non-availability error, got %v", err)
+	}
+	if err = repo.InsertBlockForRoom(ctx, missingID, day(1)); err == nil {
+		t.Error("InsertBlockForRoom for a missing room: expected an error")
+	}
+
+	all, err := repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 0 {
+		t.Errorf("expected failed writes to leave no reservations, got %d", len(all))
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err = repo.SearchAvailabilityForAllRooms(cancelled, day(1), day(3)); err == nil {
+		t.Error("SearchAvailabilityForAllRooms: expected an error for a cancelled context")
+	}
+	if _, err = repo.CreateReservation(cancelled, models.Reservation{StartDate: day(1), EndDate: day(3), RoomID: roomA}); err == nil {
+		t.Error("CreateReservation: expected an error for a cancelled context")
+	}
+}
+
+func testConcurrentBookings(t *testing.T, repo repository.DatabaseRepo) {
+	roomA, _ := twoRooms(t, repo)
+
+	const attempts = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.CreateReservation(context.Background(), models.Reservation{
+				FirstName: "Jane",
+				LastName:  "Doe",
+				Email:     "jane@doe.com",
+				StartDate: day(1),
+				EndDate:   day(4),
+				RoomID:    roomA,
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var won int
+	for err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case errors.Is(err, repository.ErrRoomUnavailable):
+			// expected for every booking but one
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	if won != 1 {
+		t.Errorf("expected exactly one booking to succeed, got %d", won)
+	}
+}
+
+func testConcurrentPromoRedemption(t *testing.T, repo repository.DatabaseRepo) {
+	roomA, roomB := twoRooms(t, repo)
+
+	const maxUses = 3
+	if _, err := repo.InsertPromoCode(context.Background(), models.PromoCode{
+		Code:       "FIRST3",
+		Kind:       models.PromoPercent,
+		Amount:     50,
+		ValidFrom:  day(1),
+		ValidUntil: day(90),
+		MaxUses:    maxUses,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every booking is for different dates, so only the code can make them fail
+	const attempts = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			roomID := roomA
+			if i%2 == 1 {
+				roomID = roomB
+			}
+			_, err := repo.CreateReservation(context.Background(), models.Reservation{
+				FirstName: "Jane",
+				LastName:  "Doe",
+				Email:     "jane@doe.com",
+				StartDate: day(i * 3),
+				EndDate:   day(i*3 + 2),
+				RoomID:    roomID,
+				PromoCode: "FIRST3",
+			})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	var won int
+	for err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case errors.Is(err, repository.ErrPromoCodeUnavailable):
+			// expected once the code is used up
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	if won != maxUses {
+		t.Errorf("expected exactly %d bookings to use the code, got %d", maxUses, won)
+	}
+
+	p, err := repo.GetPromoCodeByCode(context.Background(), "FIRST3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Uses != maxUses {
+		t.Errorf("expected the code to record %d uses, got %d", maxUses, p.Uses)
+	}
+}
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_Errors(t *testing.T) {
+	var badSpecs = []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@often",
+	}
+
+	for _, spec := range badSpecs {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2050, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	var nextTests = []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2050, 6, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2050, 6, 15, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2050, 6, 16, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2050, 6, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2050, 6, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2050, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon", time.Date(2050, 6, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2050, 6, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2050, 6, 16, 0, 0, 0, 0, time.UTC)},
+		{"15,45 8 * jan,dec *", time.Date(2050, 12, 1, 8, 15, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2052, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either day field matching is enough when both are restricted
+		{"0 0 1 * fri", time.Date(2050, 6, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */10 * *", time.Date(2050, 6, 21, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2050, 6, 15, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2050, 6, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range nextTests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("for %q, unexpected error %v", test.spec, err)
+			continue
+		}
+		if next := s.Next(from); !next.Equal(test.expected) {
+			t.Errorf("for %q, expected %s but got %s", test.spec, test.expected, next)
+		}
+	}
+}
+
+func TestSchedule_NextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	// the clocks go forward at 2:00 on 13 March 2050, so 2:30 only happens the next day
+	s, err := Parse("30 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := s.Next(time.Date(2050, 3, 13, 0, 0, 0, 0, loc))
+	if expected := time.Date(2050, 3, 14, 2, 30, 0, 0, loc); !next.Equal(expected) {
+		t.Errorf("expected %s but got %s", expected, next)
+	}
+}
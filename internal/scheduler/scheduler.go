@@ -0,0 +1,127 @@
+// Package scheduler runs background jobs inside the application on cron schedules. When several
+// instances share a database only one of them runs each job at a time, the others skipping it.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Job is work run on a schedule; it should stop when ctx is done
+type Job func(ctx context.Context) error
+
+// Locker takes named locks shared by every instance of the application, such as the
+// repository's, returning ok false without waiting when another holder has the lock
+type Locker interface {
+	TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error)
+}
+
+// entry is a job added to a scheduler, with the time it is next due
+type entry struct {
+	name     string
+	schedule *Schedule
+	job      Job
+	next     time.Time
+}
+
+// Scheduler runs jobs when their schedules say they are due
+type Scheduler struct {
+	locker   Locker
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	entries  []*entry
+
+	// now and after are the clock, replaced in tests
+	now   func() time.Time
+	after func(d time.Duration) <-chan time.Time
+}
+
+// New returns a scheduler without jobs, taking a lock from locker for each run of a job
+func New(locker Locker, infoLog, errorLog *log.Logger) *Scheduler {
+	return &Scheduler{
+		locker:   locker,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		now:      time.Now,
+		after:    time.After,
+	}
+}
+
+// Add schedules job, which must be given a name unique to the application, to run on the cron
+// expression spec, as understood by Parse
+func (s *Scheduler) Add(name, spec string, job Job) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	if schedule.Next(s.now()).IsZero() {
+		return fmt.Errorf("scheduler: %q never runs", spec)
+	}
+
+	s.entries = append(s.entries, &entry{name: name, schedule: schedule, job: job})
+	return nil
+}
+
+// Run runs the jobs as they fall due until ctx is done, then returns once any job running at
+// the time has stopped. Jobs due together are run one after the other.
+func (s *Scheduler) Run(ctx context.Context) {
+	if len(s.entries) == 0 {
+		<-ctx.Done()
+		return
+	}
+
+	now := s.now()
+	for _, e := range s.entries {
+		e.next = e.schedule.Next(now)
+	}
+
+	for {
+		next := s.entries[0].next
+		for _, e := range s.entries[1:] {
+			if e.next.Before(next) {
+				next = e.next
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.after(next.Sub(s.now())):
+		}
+
+		now = s.now()
+		for _, e := range s.entries {
+			if e.next.After(now) {
+				continue
+			}
+			s.run(ctx, e)
+			if ctx.Err() != nil {
+				return
+			}
+			e.next = e.schedule.Next(s.now())
+		}
+	}
+}
+
+// run runs the job of e unless another instance holds its lock
+func (s *Scheduler) run(ctx context.Context, e *entry) {
+	unlock, ok, err := s.locker.TryLock(ctx, "scheduler:"+e.name)
+	if err != nil {
+		s.errorLog.Printf("job %s: taking lock: %v", e.name, err)
+		return
+	}
+	if !ok {
+		s.infoLog.Printf("job %s: running on another instance, skipped", e.name)
+		return
+	}
+	defer unlock()
+
+	start := s.now()
+	if err = e.job(ctx); err != nil {
+		s.errorLog.Printf("job %s: %v", e.name, err)
+		return
+	}
+	s.infoLog.Printf("job %s: done in %s", e.name, s.now().Sub(start).Round(time.Millisecond))
+}
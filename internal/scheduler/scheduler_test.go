@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLocker is a Locker whose lock can be held by the test, as another instance would
+type testLocker struct {
+	mu   sync.Mutex
+	held bool
+	err  error
+}
+
+func (l *testLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return nil, false, l.err
+	}
+	if l.held {
+		return nil, false, nil
+	}
+	l.held = true
+	return func() {
+		l.mu.Lock()
+		l.held = false
+		l.mu.Unlock()
+	}, true, nil
+}
+
+// fakeClock jumps straight to the time a scheduler waits for, cancelling the run once it has
+// woken the scheduler wakeups times
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	wakeups int
+	cancel  context.CancelFunc
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.wakeups == 0 {
+		c.cancel()
+		return nil
+	}
+	c.wakeups--
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// newTestScheduler returns a scheduler on a fake clock, and a context cancelled after wakeups
+func newTestScheduler(locker Locker, wakeups int) (*Scheduler, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := &fakeClock{now: time.Date(2050, 6, 15, 10, 30, 0, 0, time.UTC), wakeups: wakeups, cancel: cancel}
+
+	discard := log.New(io.Discard, "", 0)
+	s := New(locker, discard, discard)
+	s.now = clock.Now
+	s.after = clock.After
+	return s, ctx
+}
+
+func TestScheduler_Run(t *testing.T) {
+	s, ctx := newTestScheduler(&testLocker{}, 30)
+
+	var hourly, daily []time.Time
+	if err := s.Add("hourly", "0 * * * *", func(ctx context.Context) error {
+		hourly = append(hourly, s.now())
+		return errors.New("failures are logged and the job runs again next time")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("daily", "0 9 * * *", func(ctx context.Context) error {
+		daily = append(daily, s.now())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Run(ctx)
+
+	// 9:00 is due for both jobs, so the 30 wakeups run the hourly job 30 times
+	if len(hourly) != 30 || !hourly[0].Equal(time.Date(2050, 6, 15, 11, 0, 0, 0, time.UTC)) || !hourly[29].Equal(time.Date(2050, 6, 16, 16, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected hourly runs %v", hourly)
+	}
+	if len(daily) != 1 || !daily[0].Equal(time.Date(2050, 6, 16, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected daily runs %v", daily)
+	}
+}
+
+func TestScheduler_Locking(t *testing.T) {
+	var lockTests = []struct {
+		name     string
+		locker   *testLocker
+		expected int
+	}{
+		{"free", &testLocker{}, 1},
+		{"held by another instance", &testLocker{held: true}, 0},
+		{"lock error", &testLocker{err: errors.New("database down")}, 0},
+	}
+
+	for _, test := range lockTests {
+		s, ctx := newTestScheduler(test.locker, 1)
+
+		runs := 0
+		if err := s.Add("job", "* * * * *", func(ctx context.Context) error {
+			runs++
+			if !test.locker.held {
+				t.Errorf("for %s, expected the lock to be held while the job runs", test.name)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		s.Run(ctx)
+
+		if runs != test.expected {
+			t.Errorf("for %s, expected %d runs but got %d", test.name, test.expected, runs)
+		}
+		if test.expected == 1 && test.locker.held {
+			t.Errorf("for %s, expected the lock to be released after the job", test.name)
+		}
+	}
+}
+
+func TestScheduler_Add(t *testing.T) {
+	s, _ := newTestScheduler(&testLocker{}, 0)
+	job := func(ctx context.Context) error { return nil }
+
+	if err := s.Add("bad", "every day", job); err == nil {
+		t.Error("expected an error for a bad expression")
+	}
+	if err := s.Add("never", "0 0 31 2 *", job); err == nil {
+		t.Error("expected an error for a schedule that never runs")
+	}
+}
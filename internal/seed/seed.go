@@ -0,0 +1,140 @@
+// Package seed fills a database with the reference data the app needs and, for demos, sample bookings
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/models"
+	"github.com/jeremydelacruz/go-bookings/internal/repository"
+)
+
+// Restrictions are the restriction types in the IDs the code relies on: reservations use 1 and owner blocks 2
+var Restrictions = []string{"Reservation", "Owner Block"}
+
+// Rooms are the rooms a fresh install starts with
+var Rooms = []models.Room{
+	{
+		RoomName:    "General's Quarters",
+		Slug:        "generals-quarters",
+		Description: "Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember. The General's Quarters has a king bed, a sitting area by the window and a view over the harbour.",
+		Capacity:    2,
+		Amenities:   []string{"King bed", "Ocean view", "Private bathroom", "Free Wi-Fi"},
+		Images:      []string{"/static/images/generals-quarters.png"},
+		NightlyRate: 12000,
+	},
+	{
+		RoomName:    "Major's Suite",
+		Slug:        "majors-suite",
+		Description: "Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember. The Major's Suite has two queen beds and a separate lounge, with room for the whole family.",
+		Capacity:    4,
+		Amenities:   []string{"Two queen beds", "Separate lounge", "Private bathroom", "Free Wi-Fi"},
+		Images:      []string{"/static/images/majors-suite.png"},
+		NightlyRate: 18000,
+	},
+}
+
+// demoGuest is a sample booking, made relative to the day the demo data is seeded
+type demoGuest struct {
+	firstName string
+	lastName  string
+	room      int
+	inDays    int
+	nights    int
+}
+
+var demoGuests = []demoGuest{
+	{"Ada", "Lovelace", 0, 2, 3},
+	{"Alan", "Turing", 1, 4, 2},
+	{"Grace", "Hopper", 0, 9, 4},
+	{"Katherine", "Johnson", 1, 12, 5},
+	{"Edsger", "Dijkstra", 0, 20, 2},
+}
+
+// ReferenceData inserts any missing restriction types and rooms, returning how many of each it added
+func ReferenceData(ctx context.Context, repo repository.DatabaseRepo) (int, int, error) {
+	restrictions, err := repo.AllRestrictions(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	existing := make(map[string]int)
+	for _, r := range restrictions {
+		existing[r.RestrictionName] = r.ID
+	}
+
+	var addedRestrictions int
+	for i, name := range Restrictions {
+		id, ok := existing[name]
+		if !ok {
+			id, err = repo.InsertRestriction(ctx, models.Restriction{RestrictionName: name})
+			if err != nil {
+				return addedRestrictions, 0, err
+			}
+			addedRestrictions++
+		}
+		if id != i+1 {
+			return addedRestrictions, 0, fmt.Errorf("seed: restriction %q has id %d, but the app expects %d", name, id, i+1)
+		}
+	}
+
+	rooms, err := repo.AllRooms(ctx)
+	if err != nil {
+		return addedRestrictions, 0, err
+	}
+
+	haveRoom := make(map[string]bool)
+	for _, rm := range rooms {
+		haveRoom[rm.Slug] = true
+	}
+
+	var addedRooms int
+	for _, rm := range Rooms {
+		if haveRoom[rm.Slug] {
+			continue
+		}
+		if _, err = repo.InsertRoom(ctx, rm); err != nil {
+			return addedRestrictions, addedRooms, err
+		}
+		addedRooms++
+	}
+
+	return addedRestrictions, addedRooms, nil
+}
+
+// Demo books sample reservations in the weeks after today, skipping any whose dates are
+// already taken so it can be run more than once, and returns how many it booked
+func Demo(ctx context.Context, repo repository.DatabaseRepo, today time.Time) (int, error) {
+	rooms, err := repo.AllRooms(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(rooms) == 0 {
+		return 0, errors.New("seed: no rooms to book, seed the reference data first")
+	}
+
+	var booked int
+	for _, g := range demoGuests {
+		start := today.AddDate(0, 0, g.inDays)
+		_, err = repo.CreateReservation(ctx, models.Reservation{
+			FirstName: g.firstName,
+			LastName:  g.lastName,
+			Email:     fmt.Sprintf("%s.%s@example.com", g.firstName, g.lastName),
+			Phone:     "555-0100",
+			StartDate: start,
+			EndDate:   start.AddDate(0, 0, g.nights),
+			RoomID:    rooms[g.room%len(rooms)].ID,
+		})
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			continue
+		}
+		if err != nil {
+			return booked, err
+		}
+		booked++
+	}
+
+	return booked, nil
+}
@@ -0,0 +1,46 @@
+package seed_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeremydelacruz/go-bookings/internal/config"
+	"github.com/jeremydelacruz/go-bookings/internal/repository/dbrepo"
+	"github.com/jeremydelacruz/go-bookings/internal/seed"
+)
+
+func TestReferenceData(t *testing.T) {
+	repo := dbrepo.NewMemoryRepo(&config.AppConfig{})
+
+	// the memory repo starts with the same rows the seed migrations insert
+	restrictions, rooms, err := seed.ReferenceData(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restrictions != 0 || rooms != 0 {
+		t.Errorf("expected nothing to be added, got %d restrictions and %d rooms", restrictions, rooms)
+	}
+}
+
+func TestDemo(t *testing.T) {
+	repo := dbrepo.NewMemoryRepo(&config.AppConfig{})
+	today := time.Date(2050, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	booked, err := seed.Demo(context.Background(), repo, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if booked == 0 {
+		t.Fatal("expected demo reservations to be booked")
+	}
+
+	// a second run finds every date taken
+	booked, err = seed.Demo(context.Background(), repo, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if booked != 0 {
+		t.Errorf("expected a second run to book nothing, got %d", booked)
+	}
+}
@@ -0,0 +1,97 @@
+// Package tokens makes the codes and signatures that let guests reach their booking without
+// an account, and the keys programs use the API with
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// codeAlphabet leaves out 0, O, 1 and I, which are easily mistaken for each other when a code
+// is read out over the phone
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// CodeLength is the length of confirmation codes; each character carries 5 bits, so a code
+// holds 60 random bits
+const CodeLength = 12
+
+// NewConfirmationCode returns a random confirmation code of CodeLength characters
+func NewConfirmationCode() (string, error) {
+	b := make([]byte, CodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	// the alphabet has 32 characters, so taking each byte modulo 32 is unbiased
+	for i := range b {
+		b[i] = codeAlphabet[int(b[i])%len(codeAlphabet)]
+	}
+
+	return string(b), nil
+}
+
+// NewKey returns a random key for a Signer
+func NewKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// APIKeyPrefix starts every API key, so keys are easy to spot in configuration and logs
+const APIKeyPrefix = "bk_"
+
+// APIKeyDisplayLength is how many of the first characters of an API key are kept to tell keys
+// apart; the rest of the key is only ever stored hashed
+const APIKeyDisplayLength = 12
+
+// NewAPIKey returns a random API key: APIKeyPrefix followed by 256 random bits
+func NewAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashAPIKey returns the hash an API key is stored and looked up by. The keys are random, so a
+// fast hash is enough to keep them secret should the database leak.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Signer signs values with HMAC-SHA256, so links built from them cannot be forged or altered
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a Signer using key
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign returns the URL-safe signature of value
+func (s *Signer) Sign(value string) string {
+	return base64.RawURLEncoding.EncodeToString(s.mac(value))
+}
+
+// Verify reports whether sig is the signature of value
+func (s *Signer) Verify(value, sig string) bool {
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, s.mac(value))
+}
+
+func (s *Signer) mac(value string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
@@ -0,0 +1,85 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewConfirmationCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		code, err := NewConfirmationCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != CodeLength {
+			t.Fatalf("expected a code of %d characters, got %q", CodeLength, code)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(codeAlphabet, c) {
+				t.Fatalf("unexpected character %q in %q", c, code)
+			}
+		}
+		if seen[code] {
+			t.Fatalf("code %q was made twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) || len(key) != len(APIKeyPrefix)+43 {
+		t.Errorf("unexpected API key %q", key)
+	}
+
+	other, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key {
+		t.Error("expected a different key each time")
+	}
+
+	hash := HashAPIKey(key)
+	if len(hash) != 64 || hash != HashAPIKey(" "+key+"\n") || hash == HashAPIKey(other) {
+		t.Errorf("unexpected hash %q", hash)
+	}
+}
+
+func TestSigner(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	sig := s.Sign("ABCDEFGHJKLM")
+
+	var verifyTests = []struct {
+		name     string
+		signer   *Signer
+		value    string
+		sig      string
+		expected bool
+	}{
+		{"valid", s, "ABCDEFGHJKLM", sig, true},
+		{"other value", s, "ABCDEFGHJKLN", sig, false},
+		{"other key", NewSigner([]byte("other")), "ABCDEFGHJKLM", sig, false},
+		{"truncated", s, "ABCDEFGHJKLM", sig[:len(sig)-1], false},
+		{"not base64", s, "ABCDEFGHJKLM", "!!!", false},
+		{"empty", s, "ABCDEFGHJKLM", "", false},
+	}
+
+	for _, test := range verifyTests {
+		if got := test.signer.Verify(test.value, test.sig); got != test.expected {
+			t.Errorf("for %s, expected %v but got %v", test.name, test.expected, got)
+		}
+	}
+
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 32 {
+		t.Errorf("expected a 32 byte key, got %d bytes", len(key))
+	}
+}
@@ -0,0 +1,5 @@
+ALTER TABLE "room_restrictions" ADD CONSTRAINT "room_restrictions_rooms_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE cascade ON UPDATE cascade;
+
+ALTER TABLE "room_restrictions" ADD CONSTRAINT "room_restrictions_restrictions_id_fk" FOREIGN KEY ("restriction_id") REFERENCES "restrictions" ("id") ON DELETE cascade ON UPDATE cascade;
+
+ALTER TABLE "room_restrictions" ADD CONSTRAINT "room_restrictions_reservations_id_fk" FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE cascade ON UPDATE cascade;
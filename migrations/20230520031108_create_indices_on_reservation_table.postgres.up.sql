@@ -0,0 +1,2 @@
+CREATE INDEX "reservations_email_idx" ON "reservations" (email);
+CREATE INDEX "reservations_last_name_idx" ON "reservations" (last_name);
@@ -0,0 +1 @@
+ALTER TABLE public.room_restrictions DROP CONSTRAINT room_restrictions_no_overlap;
\ No newline at end of file
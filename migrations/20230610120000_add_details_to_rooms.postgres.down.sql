@@ -0,0 +1,8 @@
+DROP INDEX "rooms_slug_idx";
+
+ALTER TABLE "rooms"
+	DROP COLUMN "slug",
+	DROP COLUMN "description",
+	DROP COLUMN "capacity",
+	DROP COLUMN "amenities",
+	DROP COLUMN "images";
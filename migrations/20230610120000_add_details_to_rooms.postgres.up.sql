@@ -0,0 +1,26 @@
+ALTER TABLE "rooms"
+	ADD COLUMN "slug" VARCHAR (255) NOT NULL DEFAULT '',
+	ADD COLUMN "description" text NOT NULL DEFAULT '',
+	ADD COLUMN "capacity" integer NOT NULL DEFAULT 2,
+	ADD COLUMN "amenities" jsonb NOT NULL DEFAULT '[]',
+	ADD COLUMN "images" jsonb NOT NULL DEFAULT '[]';
+
+UPDATE "rooms" SET
+	slug = 'generals-quarters',
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember. The General''s Quarters has a king bed, a sitting area by the window and a view over the harbour.',
+	capacity = 2,
+	amenities = '["King bed", "Ocean view", "Private bathroom", "Free Wi-Fi"]',
+	images = '["/static/images/generals-quarters.png"]'
+WHERE room_name = 'General''s Quarters';
+
+UPDATE "rooms" SET
+	slug = 'majors-suite',
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember. The Major''s Suite has two queen beds and a separate lounge, with room for the whole family.',
+	capacity = 4,
+	amenities = '["Two queen beds", "Separate lounge", "Private bathroom", "Free Wi-Fi"]',
+	images = '["/static/images/majors-suite.png"]'
+WHERE room_name = 'Major''s Suite';
+
+UPDATE "rooms" SET slug = 'room-' || id WHERE slug = '';
+
+CREATE UNIQUE INDEX "rooms_slug_idx" ON "rooms" (slug);
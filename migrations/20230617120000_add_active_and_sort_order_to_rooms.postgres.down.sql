@@ -0,0 +1,3 @@
+ALTER TABLE "rooms"
+	DROP COLUMN "active",
+	DROP COLUMN "sort_order";
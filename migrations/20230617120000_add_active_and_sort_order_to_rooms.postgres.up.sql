@@ -0,0 +1,5 @@
+ALTER TABLE "rooms"
+	ADD COLUMN "active" boolean NOT NULL DEFAULT true,
+	ADD COLUMN "sort_order" integer NOT NULL DEFAULT 0;
+
+UPDATE "rooms" SET sort_order = id;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "stay_discounts";
+DROP TABLE IF EXISTS "rate_overrides";
+
+ALTER TABLE "reservations" DROP COLUMN "price";
+ALTER TABLE "rooms" DROP COLUMN "nightly_rate";
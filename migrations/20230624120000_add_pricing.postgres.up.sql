@@ -0,0 +1,37 @@
+ALTER TABLE "rooms" ADD COLUMN "nightly_rate" integer NOT NULL DEFAULT 0;
+
+UPDATE "rooms" SET nightly_rate = 12000 WHERE slug = 'generals-quarters';
+UPDATE "rooms" SET nightly_rate = 18000 WHERE slug = 'majors-suite';
+
+ALTER TABLE "reservations" ADD COLUMN "price" jsonb NOT NULL DEFAULT '{}';
+
+CREATE TABLE "rate_overrides" (
+	"id" SERIAL NOT NULL,
+	PRIMARY KEY("id"),
+	"room_id" integer NOT NULL,
+	"name" VARCHAR (255) NOT NULL,
+	"start_date" date NOT NULL,
+	"end_date" date NOT NULL,
+	"weekdays" smallint NOT NULL DEFAULT 0,
+	"nightly_rate" integer NOT NULL,
+	"created_at" timestamp NOT NULL,
+	"updated_at" timestamp NOT NULL,
+	CONSTRAINT "rate_overrides_dates_check" CHECK (end_date > start_date),
+	CONSTRAINT "rate_overrides_nightly_rate_check" CHECK (nightly_rate >= 0)
+);
+
+ALTER TABLE "rate_overrides" ADD CONSTRAINT "rate_overrides_rooms_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE cascade ON UPDATE cascade;
+
+CREATE INDEX "rate_overrides_room_id_start_date_end_date_idx" ON "rate_overrides" (room_id, start_date, end_date);
+
+CREATE TABLE "stay_discounts" (
+	"id" SERIAL NOT NULL,
+	PRIMARY KEY("id"),
+	"name" VARCHAR (255) NOT NULL,
+	"min_nights" integer NOT NULL,
+	"percent" integer NOT NULL,
+	"created_at" timestamp NOT NULL,
+	"updated_at" timestamp NOT NULL,
+	CONSTRAINT "stay_discounts_min_nights_check" CHECK (min_nights > 0),
+	CONSTRAINT "stay_discounts_percent_check" CHECK (percent BETWEEN 1 AND 100)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE "reservations" DROP COLUMN "promo_code";
+
+DROP TABLE IF EXISTS "promo_codes";
@@ -0,0 +1,24 @@
+CREATE TABLE "promo_codes" (
+	"id" SERIAL NOT NULL,
+	PRIMARY KEY("id"),
+	"code" VARCHAR (255) NOT NULL,
+	"kind" VARCHAR (16) NOT NULL,
+	"amount" integer NOT NULL,
+	"valid_from" date NOT NULL,
+	"valid_until" date NOT NULL,
+	"max_uses" integer NOT NULL DEFAULT 0,
+	"uses" integer NOT NULL DEFAULT 0,
+	"room_ids" jsonb NOT NULL DEFAULT '[]',
+	"min_nights" integer NOT NULL DEFAULT 0,
+	"created_at" timestamp NOT NULL,
+	"updated_at" timestamp NOT NULL,
+	CONSTRAINT "promo_codes_kind_check" CHECK (kind IN ('percent', 'fixed')),
+	CONSTRAINT "promo_codes_amount_check" CHECK (amount > 0 AND (kind <> 'percent' OR amount <= 100)),
+	CONSTRAINT "promo_codes_dates_check" CHECK (valid_until >= valid_from),
+	CONSTRAINT "promo_codes_uses_check" CHECK (uses >= 0 AND max_uses >= 0 AND (max_uses = 0 OR uses <= max_uses)),
+	CONSTRAINT "promo_codes_min_nights_check" CHECK (min_nights >= 0)
+);
+
+CREATE UNIQUE INDEX "promo_codes_code_idx" ON "promo_codes" (code);
+
+ALTER TABLE "reservations" ADD COLUMN "promo_code" VARCHAR (255) NOT NULL DEFAULT '';
@@ -0,0 +1,22 @@
+CREATE TABLE "payment_intents" (
+	"id" SERIAL NOT NULL,
+	PRIMARY KEY("id"),
+	"reservation_id" integer NOT NULL,
+	"provider" VARCHAR (32) NOT NULL,
+	"provider_ref" VARCHAR (255) NOT NULL,
+	"status" VARCHAR (16) NOT NULL,
+	"amount" integer NOT NULL,
+	"captured" integer NOT NULL DEFAULT 0,
+	"refunded" integer NOT NULL DEFAULT 0,
+	"currency" VARCHAR (3) NOT NULL,
+	"created_at" timestamp NOT NULL,
+	"updated_at" timestamp NOT NULL,
+	CONSTRAINT "payment_intents_status_check" CHECK (status IN ('authorized', 'captured', 'refunded', 'voided', 'failed')),
+	CONSTRAINT "payment_intents_amounts_check" CHECK (amount > 0 AND captured BETWEEN 0 AND amount AND refunded BETWEEN 0 AND captured)
+);
+
+ALTER TABLE "payment_intents" ADD CONSTRAINT "payment_intents_reservations_id_fk" FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE cascade ON UPDATE cascade;
+
+CREATE UNIQUE INDEX "payment_intents_provider_provider_ref_idx" ON "payment_intents" (provider, provider_ref);
+
+CREATE INDEX "payment_intents_reservation_id_idx" ON "payment_intents" (reservation_id);
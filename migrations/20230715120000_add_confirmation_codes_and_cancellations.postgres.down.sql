@@ -0,0 +1,5 @@
+ALTER TABLE "reservations" DROP COLUMN "cancelled_at";
+
+DROP INDEX IF EXISTS "reservations_confirmation_code_idx";
+
+ALTER TABLE "reservations" DROP COLUMN "confirmation_code";
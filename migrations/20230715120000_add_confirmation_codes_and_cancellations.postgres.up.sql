@@ -0,0 +1,19 @@
+ALTER TABLE "reservations" ADD COLUMN "confirmation_code" VARCHAR (32);
+
+-- codes are made as tokens.NewConfirmationCode makes them: 12 strongly random bytes, each
+-- taken modulo 32 into the same alphabet. Byte 6 of a version 4 UUID holds its version and
+-- byte 8 its variant, so both are skipped.
+UPDATE "reservations" r SET confirmation_code = c.code
+FROM (
+    SELECT u.id, string_agg(substr('ABCDEFGHJKLMNPQRSTUVWXYZ23456789', get_byte(u.b, i) % 32 + 1, 1), '' ORDER BY i) AS code
+    FROM (SELECT id, decode(replace(gen_random_uuid()::text, '-', ''), 'hex') AS b FROM "reservations") u
+    CROSS JOIN unnest(ARRAY[0, 1, 2, 3, 4, 5, 7, 9, 10, 11, 12, 13]) AS i
+    GROUP BY u.id
+) c
+WHERE r.id = c.id;
+
+ALTER TABLE "reservations" ALTER COLUMN "confirmation_code" SET NOT NULL;
+
+CREATE UNIQUE INDEX "reservations_confirmation_code_idx" ON "reservations" (confirmation_code);
+
+ALTER TABLE "reservations" ADD COLUMN "cancelled_at" timestamp;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "reservation_status_changes";
+
+ALTER TABLE "reservations" DROP CONSTRAINT IF EXISTS "reservations_status_check";
+
+ALTER TABLE "reservations" DROP COLUMN "status";
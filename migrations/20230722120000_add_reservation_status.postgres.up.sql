@@ -0,0 +1,23 @@
+ALTER TABLE "reservations" ADD COLUMN "status" VARCHAR (16) NOT NULL DEFAULT 'pending';
+
+UPDATE "reservations" SET status = 'confirmed' WHERE processed = 1;
+UPDATE "reservations" SET status = 'cancelled' WHERE cancelled_at IS NOT NULL;
+
+ALTER TABLE "reservations" ADD CONSTRAINT "reservations_status_check" CHECK (status IN ('pending', 'confirmed', 'cancelled', 'checked_in', 'checked_out', 'no_show'));
+
+CREATE TABLE "reservation_status_changes" (
+	"id" SERIAL NOT NULL,
+	PRIMARY KEY("id"),
+	"reservation_id" integer NOT NULL,
+	"from_status" VARCHAR (16) NOT NULL,
+	"to_status" VARCHAR (16) NOT NULL,
+	"actor" VARCHAR (16) NOT NULL,
+	"user_id" integer,
+	"created_at" timestamp NOT NULL
+);
+
+ALTER TABLE "reservation_status_changes" ADD CONSTRAINT "reservation_status_changes_reservations_id_fk" FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE cascade ON UPDATE cascade;
+
+ALTER TABLE "reservation_status_changes" ADD CONSTRAINT "reservation_status_changes_users_id_fk" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE set null ON UPDATE cascade;
+
+CREATE INDEX "reservation_status_changes_reservation_id_idx" ON "reservation_status_changes" (reservation_id);
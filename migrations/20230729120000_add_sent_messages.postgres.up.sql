@@ -0,0 +1,11 @@
+CREATE TABLE "sent_messages" (
+	"id" SERIAL NOT NULL,
+	PRIMARY KEY("id"),
+	"reservation_id" integer NOT NULL,
+	"kind" VARCHAR (32) NOT NULL,
+	"created_at" timestamp NOT NULL
+);
+
+ALTER TABLE "sent_messages" ADD CONSTRAINT "sent_messages_reservations_id_fk" FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE cascade ON UPDATE cascade;
+
+CREATE UNIQUE INDEX "sent_messages_reservation_id_kind_idx" ON "sent_messages" (reservation_id, kind);
@@ -0,0 +1,17 @@
+CREATE TABLE "api_keys" (
+	"id" SERIAL NOT NULL,
+	PRIMARY KEY("id"),
+	"name" VARCHAR (255) NOT NULL,
+	"prefix" VARCHAR (16) NOT NULL,
+	"key_hash" VARCHAR (64) NOT NULL,
+	"scopes" jsonb NOT NULL DEFAULT '[]',
+	"expires_at" timestamp,
+	"last_used_at" timestamp,
+	"user_id" integer,
+	"created_at" timestamp NOT NULL,
+	"updated_at" timestamp NOT NULL
+);
+
+ALTER TABLE "api_keys" ADD CONSTRAINT "api_keys_users_id_fk" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE set null ON UPDATE cascade;
+
+CREATE UNIQUE INDEX "api_keys_key_hash_idx" ON "api_keys" (key_hash);
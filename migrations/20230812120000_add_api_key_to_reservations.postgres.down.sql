@@ -0,0 +1,3 @@
+ALTER TABLE "reservations" DROP CONSTRAINT IF EXISTS "reservations_api_keys_id_fk";
+
+ALTER TABLE "reservations" DROP COLUMN "api_key_id";
@@ -0,0 +1,3 @@
+ALTER TABLE "reservations" ADD COLUMN "api_key_id" integer;
+
+ALTER TABLE "reservations" ADD CONSTRAINT "reservations_api_keys_id_fk" FOREIGN KEY ("api_key_id") REFERENCES "api_keys" ("id") ON DELETE set null ON UPDATE cascade;
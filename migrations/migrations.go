@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migrations so the binary can apply them without soda
+package migrations
+
+import "embed"
+
+// FS holds every migration in this directory
+//
+//go:embed *.sql
+var FS embed.FS